@@ -15,9 +15,18 @@ import (
 )
 
 var (
+	apiServerProxyConfig          string
 	apiServerProxyTraefikConfig   string
 	apiServerProxyKubeconfig      string
 	apiServerProxyRefreshInterval time.Duration
+	apiServerProxyWatchEndpoints  bool
+	apiServerProxyHealthCheck     proxy.HealthCheckConfig
+	apiServerProxyDrainTimeout    time.Duration
+	apiServerProxyConnections     proxy.ConnectionConfig
+	apiServerProxyLoadBalancing   string
+	apiServerProxyMetricsAddress  string
+	apiServerProxyEndpointCache   string
+	apiServerProxyL7              proxy.L7Config
 
 	apiServerProxyCmd = &cobra.Command{
 		Use:   "apiserver-proxy",
@@ -35,10 +44,36 @@ var (
 				refreshCh = time.NewTicker(apiServerProxyRefreshInterval).C
 			}
 
+			if apiServerProxyHealthCheck.Interval > 0 && (apiServerProxyHealthCheck.Rise < 1 || apiServerProxyHealthCheck.Fall < 1) {
+				return fmt.Errorf("--health-check-rise and --health-check-fall must be at least 1")
+			}
+
+			if apiServerProxyL7.Retries < 0 {
+				return fmt.Errorf("--l7-retries must not be negative")
+			}
+
+			if apiServerProxyConnections.MaxConnections < 0 {
+				return fmt.Errorf("--max-connections must not be negative")
+			}
+
+			strategy, err := proxy.ParseStrategy(apiServerProxyLoadBalancing)
+			if err != nil {
+				return err
+			}
+
 			p := &proxy.APIServerProxy{
+				ConfigFile:        apiServerProxyConfig,
 				TraefikConfigFile: apiServerProxyTraefikConfig,
 				KubeconfigFile:    apiServerProxyKubeconfig,
 				RefreshCh:         refreshCh,
+				WatchEndpoints:    apiServerProxyWatchEndpoints,
+				HealthCheck:       apiServerProxyHealthCheck,
+				DrainTimeout:      apiServerProxyDrainTimeout,
+				Connections:       apiServerProxyConnections,
+				Strategy:          strategy,
+				MetricsAddress:    apiServerProxyMetricsAddress,
+				EndpointCacheFile: apiServerProxyEndpointCache,
+				L7:                apiServerProxyL7,
 			}
 
 			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -53,9 +88,32 @@ var (
 )
 
 func init() {
-	apiServerProxyCmd.Flags().StringVar(&apiServerProxyTraefikConfig, "traefik-config", filepath.Join(os.Getenv("SNAP_DATA"), "args", "traefik", "traefik.yaml"), "path to apiserver proxy config file")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyConfig, "config", filepath.Join(os.Getenv("SNAP_DATA"), "args", "apiserver-proxy.yaml"), "path to apiserver proxy config file, created from the traefik config if missing (use the traefik config directly if empty)")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyTraefikConfig, "traefik-config", filepath.Join(os.Getenv("SNAP_DATA"), "args", "traefik", "traefik.yaml"), "path to legacy traefik config file of the apiserver proxy")
 	apiServerProxyCmd.Flags().StringVar(&apiServerProxyKubeconfig, "kubeconfig", filepath.Join(os.Getenv("SNAP_DATA"), "credentials", "kubelet.config"), "path to kubeconfig file to use for updating list of known control plane nodes")
-	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyRefreshInterval, "refresh-interval", 30*time.Second, "refresh interval")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyRefreshInterval, "refresh-interval", 30*time.Second, "refresh interval (0 to disable updating the list of control plane nodes)")
+	apiServerProxyCmd.Flags().BoolVar(&apiServerProxyWatchEndpoints, "watch-endpoints", true, "watch the list of control plane nodes for changes, and only poll every refresh interval if the watch fails")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyEndpointCache, "endpoint-cache", filepath.Join(os.Getenv("SNAP_DATA"), "args", "traefik", "endpoints-cache.yaml"), "path to cache of known control plane endpoints, tried on startup if no configured endpoint is reachable (disabled if empty)")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyMetricsAddress, "metrics-address", "", "address (host:port) to serve Prometheus metrics on /metrics and the proxy status on /status (disabled if empty)")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyLoadBalancing, "load-balancing", string(proxy.StrategyRoundRobin), fmt.Sprintf("load-balancing strategy for new connections, one of %v", proxy.Strategies))
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyDrainTimeout, "drain-timeout", 30*time.Second, "how long existing connections to removed control plane endpoints are kept open")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyConnections.DialTimeout, "dial-timeout", 10*time.Second, "timeout for connecting to a control plane endpoint (0 for no timeout)")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyConnections.IdleTimeout, "idle-timeout", 0, "close connections without traffic for this duration (0 to keep idle connections open)")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyConnections.HalfCloseTimeout, "half-close-timeout", 0, "how long a connection is kept open after one side closes its write half (0 to close immediately)")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyConnections.KeepAlive, "tcp-keepalive", 15*time.Second, "TCP keepalive period of proxied connections (negative to disable)")
+	apiServerProxyCmd.Flags().IntVar(&apiServerProxyConnections.MaxConnections, "max-connections", 0, "maximum number of concurrent client connections (0 for no limit)")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyConnections.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for active connections to close on shutdown")
+	apiServerProxyCmd.Flags().BoolVar(&apiServerProxyL7.Enabled, "l7", false, "terminate TLS and forward HTTP requests to the control plane endpoints, retrying GET requests on connection failures (forward TCP connections if disabled). Requests with the kubelet client certificate are forwarded with the kubelet credentials, and other client certificates with the front proxy certificate. Not enabled if a kubeconfig of --l7-client-kubeconfigs uses another client certificate and no front proxy certificate is set. Clients of the proxy must trust the serving certificate")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyL7.ServingCertFile, "l7-serving-cert", filepath.Join(os.Getenv("SNAP_DATA"), "certs", "apiserver-proxy.crt"), "path to serving certificate of the TLS-terminating mode, issued by a local CA if missing")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyL7.ServingKeyFile, "l7-serving-key", filepath.Join(os.Getenv("SNAP_DATA"), "certs", "apiserver-proxy.key"), "path to serving key of the TLS-terminating mode, issued by a local CA if missing")
+	apiServerProxyCmd.Flags().IntVar(&apiServerProxyL7.Retries, "l7-retries", 2, "number of retries of GET requests after a connection failure in the TLS-terminating mode")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyL7.FrontProxyCertFile, "l7-front-proxy-cert", "", "path to front proxy client certificate (e.g. front-proxy-client.crt), used to forward requests with other client certificates than the kubelet's in the TLS-terminating mode")
+	apiServerProxyCmd.Flags().StringVar(&apiServerProxyL7.FrontProxyKeyFile, "l7-front-proxy-key", "", "path to front proxy client key (e.g. front-proxy-client.key)")
+	apiServerProxyCmd.Flags().StringSliceVar(&apiServerProxyL7.ClientKubeconfigFiles, "l7-client-kubeconfigs", []string{filepath.Join(os.Getenv("SNAP_DATA"), "credentials", "proxy.config")}, "kubeconfig files of other clients of the proxy. The TLS-terminating mode is not enabled if any of them uses a client certificate and no front proxy certificate is set")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyHealthCheck.Interval, "health-check-interval", 10*time.Second, "interval between /readyz health checks of each control plane endpoint (0 to disable)")
+	apiServerProxyCmd.Flags().DurationVar(&apiServerProxyHealthCheck.Timeout, "health-check-timeout", 5*time.Second, "timeout of each health check")
+	apiServerProxyCmd.Flags().IntVar(&apiServerProxyHealthCheck.Rise, "health-check-rise", 2, "number of consecutive successful health checks before an ejected endpoint is added back")
+	apiServerProxyCmd.Flags().IntVar(&apiServerProxyHealthCheck.Fall, "health-check-fall", 3, "number of consecutive failed health checks before an endpoint is ejected")
 
 	rootCmd.AddCommand(apiServerProxyCmd)
 }
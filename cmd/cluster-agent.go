@@ -11,6 +11,8 @@ import (
 
 	v1 "github.com/canonical/microk8s-cluster-agent/pkg/api/v1"
 	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	v3 "github.com/canonical/microk8s-cluster-agent/pkg/api/v3"
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
 	"github.com/canonical/microk8s-cluster-agent/pkg/k8sinit"
 	"github.com/canonical/microk8s-cluster-agent/pkg/server"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
@@ -27,6 +29,10 @@ var (
 	launchConfigurationsEnable   bool
 	launchConfigurationsInterval time.Duration
 	minTLSVersion                string
+	joinApproval                 bool
+	joinApprovalWait             time.Duration
+	joinApprovalTimeout          time.Duration
+	joinAutoApproveCIDRs         []string
 )
 
 // clusterAgentCmd represents the base command when called without any subcommands
@@ -96,18 +102,63 @@ lifecycle of a MicroK8s cluster.`,
 			}()
 		}
 
+		// Setup join approval queue
+		var joinQueue *approval.Queue
+		if joinApproval {
+			opts := approval.Options{
+				Timeout: joinApprovalTimeout,
+				Wait:    joinApprovalWait,
+			}
+			for _, cidr := range joinAutoApproveCIDRs {
+				_, ipNet, err := net.ParseCIDR(cidr)
+				if err != nil {
+					log.Fatalf("Invalid CIDR %q to auto-approve join requests: %s", cidr, err)
+				}
+				opts.AutoApproveCIDRs = append(opts.AutoApproveCIDRs, ipNet)
+			}
+			opts.AutoApproveScopes = func(token string) []approval.Scope {
+				policy, err := s.GetJoinPolicy(token)
+				if err != nil {
+					log.Printf("WARNING: failed to retrieve join policy: %q", err)
+					return nil
+				}
+				var scopes []approval.Scope
+				for _, scope := range policy.AutoApprove {
+					parsed, err := approval.ParseScope(scope)
+					if err != nil {
+						log.Printf("WARNING: invalid scope to auto-approve join requests: %q", err)
+						continue
+					}
+					scopes = append(scopes, parsed)
+				}
+				return scopes
+			}
+			joinQueue = approval.NewQueue(opts)
+			if _, err := s.GetOrCreateAdminToken(); err != nil {
+				log.Fatalf("Failed to create admin token: %s", err)
+			}
+			log.Printf("Join requests require approval")
+		}
+
 		// Setup HTTP server
 		apiv1 := &v1.API{
-			Snap:     s,
-			LookupIP: net.LookupIP,
+			Snap:      s,
+			LookupIP:  net.LookupIP,
+			JoinQueue: joinQueue,
 		}
 		apiv2 := &v2.API{
 			Snap:                    s,
 			LookupIP:                net.LookupIP,
 			InterfaceAddrs:          net.InterfaceAddrs,
 			ListControlPlaneNodeIPs: snaputil.ListControlPlaneNodeIPs,
+			NewDqliteClient:         snaputil.NewDqliteClient,
+			JoinQueue:               joinQueue,
+			ServingCertificateFile:  certfile,
+		}
+		apiv3 := &v3.API{
+			V2: apiv2,
 		}
-		mux := server.NewServeMux(time.Duration(timeout)*time.Second, enableMetrics, apiv1, apiv2)
+		mux := server.NewServeMux(time.Duration(timeout)*time.Second, enableMetrics, apiv1, apiv2, apiv3)
 		srv := &http.Server{
 			Addr:    bind,
 			Handler: mux,
@@ -151,5 +202,10 @@ func init() {
 	clusterAgentCmd.Flags().DurationVar(&launchConfigurationsInterval, "launch-configurations-interval", 5*time.Second, "Interval between checks for launch configurations")
 	clusterAgentCmd.Flags().StringVar(&minTLSVersion, "min-tls-version", "tls12", "Minimum TLS version required (tls10|tls11|tls12|tls13). Default is tls12")
 
+	clusterAgentCmd.Flags().BoolVar(&joinApproval, "join-approval", false, "Hold join requests until they are approved with 'cluster-agent join-requests approve'")
+	clusterAgentCmd.Flags().DurationVar(&joinApprovalWait, "join-approval-wait", 30*time.Second, "How long join requests wait for approval before the joining node has to retry")
+	clusterAgentCmd.Flags().DurationVar(&joinApprovalTimeout, "join-approval-timeout", 15*time.Minute, "How long undecided join requests are kept")
+	clusterAgentCmd.Flags().StringSliceVar(&joinAutoApproveCIDRs, "join-auto-approve-cidrs", nil, "Approve join requests from these networks automatically")
+
 	rootCmd.AddCommand(clusterAgentCmd)
 }
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/spf13/cobra"
+)
+
+var (
+	joinRequestsEndpoint string
+
+	joinRequestsCmd = &cobra.Command{
+		Use:   "join-requests",
+		Short: "Manage join requests waiting for approval",
+		Long: `List, approve or deny join requests held by the local cluster agent.
+Join requests are only held if the cluster agent runs with --join-approval.
+Join requests using a cluster token may be approved automatically with the "auto_approve" field of its join policy.
+These commands must run as root, as they authenticate with the admin token of the local cluster agent.`,
+	}
+
+	joinRequestsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List join requests",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var resp v2.ListJoinRequestsResponse
+			if err := callLocalClusterAgent(http.MethodGet, "/join/requests", nil, &resp); err != nil {
+				return err
+			}
+			fmt.Printf("%-10s %-30s %-40s %-15s %-10s %s\n", "ID", "HOSTNAME", "ADDRESS", "TYPE", "STATUS", "AGE")
+			for _, req := range resp.Requests {
+				nodeType := "control-plane"
+				if req.WorkerOnly {
+					nodeType = "worker"
+				}
+				fmt.Printf("%-10s %-30s %-40s %-15s %-10s %s\n", req.ID, req.Hostname, req.RemoteIP, nodeType, req.Status, time.Since(req.CreatedAt).Round(time.Second))
+			}
+			return nil
+		},
+	}
+
+	joinRequestsApproveCmd = &cobra.Command{
+		Use:   "approve <id>",
+		Short: "Approve a join request",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return callLocalClusterAgent(http.MethodPost, "/join/requests", v2.DecideJoinRequestRequest{ID: args[0], Approve: true}, nil)
+		},
+	}
+
+	joinRequestsDenyCmd = &cobra.Command{
+		Use:   "deny <id>",
+		Short: "Deny a join request",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return callLocalClusterAgent(http.MethodPost, "/join/requests", v2.DecideJoinRequestRequest{ID: args[0], Approve: false}, nil)
+		},
+	}
+)
+
+// callLocalClusterAgent sends a request to the v2 API of the local cluster agent, authenticated with the local admin token.
+func callLocalClusterAgent(method string, path string, req interface{}, resp interface{}) error {
+	s := snap.NewSnap(
+		os.Getenv("SNAP"),
+		os.Getenv("SNAP_DATA"),
+		os.Getenv("SNAP_COMMON"),
+	)
+
+	token, err := s.GetOrCreateAdminToken()
+	if err != nil {
+		return fmt.Errorf("failed to read admin token: %w", err)
+	}
+
+	endpoint := joinRequestsEndpoint
+	if endpoint == "" {
+		endpoint = snap.GetServiceArgument(s, "cluster-agent", "--bind")
+		if endpoint == "" {
+			endpoint = "0.0.0.0:25000"
+		}
+		if host, port, err := net.SplitHostPort(endpoint); err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+			endpoint = net.JoinHostPort("127.0.0.1", port)
+		}
+	}
+
+	var body io.Reader
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	httpReq, err := http.NewRequest(method, fmt.Sprintf("https://%s%s%s", endpoint, v2.HTTPPrefix, path), body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(v2.AdminTokenHeader, token)
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to contact cluster agent at %s: %w", endpoint, err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(httpResp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
+			return fmt.Errorf("request failed: %s", errResp.Error)
+		}
+		return fmt.Errorf("request failed: status %d", httpResp.StatusCode)
+	}
+	if resp != nil {
+		if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	joinRequestsCmd.PersistentFlags().StringVar(&joinRequestsEndpoint, "endpoint", "", "address (host:port) of the cluster agent (default is the local cluster agent)")
+
+	joinRequestsCmd.AddCommand(joinRequestsListCmd)
+	joinRequestsCmd.AddCommand(joinRequestsApproveCmd)
+	joinRequestsCmd.AddCommand(joinRequestsDenyCmd)
+	rootCmd.AddCommand(joinRequestsCmd)
+}
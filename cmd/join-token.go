@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var joinTokenCmd = &cobra.Command{
+	Use:   "join-token <token>",
+	Short: "Pin a cluster token to the CA fingerprint",
+	Long: `Print a cluster token pinned to the fingerprint of the cluster CA, in <token>@sha256:<hex> format.
+Joining nodes use the fingerprint to verify the cluster agent before sending the token, see v2/join/discovery.
+For tokens in <id>.<secret> format, joining nodes may instead verify the signature of the v2/join/discovery response.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s := snap.NewSnap(
+			os.Getenv("SNAP"),
+			os.Getenv("SNAP_DATA"),
+			os.Getenv("SNAP_COMMON"),
+		)
+		ca, err := s.ReadCA()
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		fingerprint, err := util.PublicKeyFingerprint([]byte(ca))
+		if err != nil {
+			return fmt.Errorf("failed to compute CA fingerprint: %w", err)
+		}
+		fmt.Println(util.PinnedToken(args[0], fingerprint))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(joinTokenCmd)
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	leaveControlPlane string
+	leaveToken        string
+	leaveForce        bool
+	leaveDrainTimeout time.Duration
+
+	leaveCmd = &cobra.Command{
+		Use:   "leave",
+		Short: "Leave the MicroK8s cluster",
+		Long: `Request a control plane node to remove this node from the cluster.
+The node is drained, removed from the dqlite cluster and its Kubernetes Node is deleted.
+Any tokens issued to the node when it joined the cluster are revoked. Certificates issued to the node remain
+valid until they expire or the cluster CA is rotated.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s := snap.NewSnap(
+				os.Getenv("SNAP"),
+				os.Getenv("SNAP_DATA"),
+				os.Getenv("SNAP_COMMON"),
+			)
+
+			if leaveControlPlane == "" {
+				return fmt.Errorf("no control plane node specified")
+			}
+			if leaveToken == "" {
+				token, err := util.ReadFile(s.GetSnapDataPath("credentials", "callback-token.txt"))
+				if err != nil {
+					return fmt.Errorf("failed to read callback token: %w", err)
+				}
+				leaveToken = strings.TrimSpace(token)
+			}
+
+			hostname, err := os.Hostname()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve hostname: %w", err)
+			}
+			req := v2.LeaveRequest{
+				NodeName:            hostname,
+				ClusterAgentPort:    "25000",
+				DrainTimeoutSeconds: int(leaveDrainTimeout.Seconds()),
+				Force:               leaveForce,
+			}
+			if _, port, err := net.SplitHostPort(snap.GetServiceArgument(s, "cluster-agent", "--bind")); err == nil {
+				req.ClusterAgentPort = port
+			}
+			if infoYaml, err := s.ReadDqliteInfoYaml(); err == nil {
+				var node snaputil.DqliteClusterNode
+				if err := yaml.Unmarshal([]byte(infoYaml), &node); err == nil {
+					req.DqliteAddress = node.Address
+				}
+			}
+
+			b, err := json.Marshal(req)
+			if err != nil {
+				return fmt.Errorf("failed to marshal leave request: %w", err)
+			}
+			httpReq, err := http.NewRequestWithContext(cmd.Context(), http.MethodPost, fmt.Sprintf("https://%s%s/leave", leaveControlPlane, v2.HTTPPrefix), bytes.NewReader(b))
+			if err != nil {
+				return fmt.Errorf("failed to create leave request: %w", err)
+			}
+			httpReq.Header.Set("Content-Type", "application/json")
+			httpReq.Header.Set(v2.CallbackTokenHeader, leaveToken)
+
+			client := &http.Client{
+				// The drain timeout is enforced by the control plane node.
+				Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+			}
+			httpResp, err := client.Do(httpReq)
+			if err != nil {
+				return fmt.Errorf("failed to send leave request: %w", err)
+			}
+			defer httpResp.Body.Close()
+
+			var resp v2.LeaveResponse
+			if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+				return fmt.Errorf("failed to parse leave response (status %d): %w", httpResp.StatusCode, err)
+			}
+			for _, step := range resp.Steps {
+				if step.Message != "" {
+					fmt.Printf("%-35s %-8s %s\n", step.Name, step.Status, step.Message)
+				} else {
+					fmt.Printf("%-35s %s\n", step.Name, step.Status)
+				}
+			}
+			if httpResp.StatusCode != http.StatusOK {
+				if resp.Error != "" {
+					return fmt.Errorf("failed to leave the cluster: %s", resp.Error)
+				}
+				return fmt.Errorf("failed to leave the cluster: status %d", httpResp.StatusCode)
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	leaveCmd.Flags().StringVar(&leaveControlPlane, "control-plane", "", "address (host:port) of the cluster agent of a control plane node")
+	leaveCmd.Flags().StringVar(&leaveToken, "token", "", "callback token to authenticate with the control plane node (default is the local callback token)")
+	leaveCmd.Flags().BoolVar(&leaveForce, "force", false, "remove the node even if it cannot be drained")
+	leaveCmd.Flags().DurationVar(&leaveDrainTimeout, "drain-timeout", 5*time.Minute, "timeout for draining the node")
+
+	rootCmd.AddCommand(leaveCmd)
+}
@@ -3,6 +3,7 @@ package v1
 import (
 	"net"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 )
 
@@ -13,4 +14,8 @@ type API struct {
 
 	// LookupIP is net.LookupIP.
 	LookupIP func(string) ([]net.IP, error)
+
+	// JoinQueue holds join requests until they are approved by an operator.
+	// If nil, join requests with a valid token are accepted immediately.
+	JoinQueue *approval.Queue
 }
@@ -3,8 +3,10 @@ package v1
 import (
 	"context"
 	"fmt"
+	"log"
 	"net"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/metrics"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 )
@@ -23,6 +25,9 @@ type JoinRequest struct {
 	RemoteAddress string `json:"-"`
 	// CanHandleCertificateAuth is set by worker nodes that know how to generate x509 certificates for cluster authentication instead of using auth tokens.
 	CanHandleCertificateAuth bool `json:"can_handle_x509_auth"`
+	// KubeletProfile is the name of a kubelet profile to apply on top of the kubelet arguments of this node.
+	// If empty, the joining node uses the same kubelet arguments as this node.
+	KubeletProfile string `json:"kubelet_profile,omitempty"`
 }
 
 // APIServerAuthMode is used to define which mode should be used for authenticating to the kube-apiserver.
@@ -59,31 +64,78 @@ type JoinResponse struct {
 }
 
 // Join implements "POST /CLUSTER_API_V1/join".
-func (a *API) Join(ctx context.Context, request JoinRequest) (*JoinResponse, error) {
+func (a *API) Join(ctx context.Context, request JoinRequest) (_ *JoinResponse, err error) {
+	// nodes joining through v1 are always worker nodes
+	tracker := metrics.NewJoinTracker("v1", metrics.JoinModeWorker)
+	defer func() { tracker.Done(err) }()
+
+	// older nodes send tokens pinned to a CA fingerprint unmodified, the pinned CA is verified like in v2/join
+	var caFingerprint string
+	request.ClusterToken, caFingerprint = util.SplitPinnedToken(request.ClusterToken)
+	if caFingerprint != "" {
+		tracker.Phase("ca-fingerprint")
+		ca, err := a.Snap.ReadCA()
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve cluster CA: %w", err)
+		}
+		if err := util.VerifyPublicKeyFingerprint([]byte(ca), caFingerprint); err != nil {
+			return nil, fmt.Errorf("the cluster token is pinned to a different CA: %w", err)
+		}
+	}
+
 	response := &JoinResponse{
 		EtcdEndpoint:  snap.GetServiceArgument(a.Snap, "etcd", "--listen-client-urls"),
 		APIServerPort: snap.GetServiceArgument(a.Snap, "kube-apiserver", "--secure-port"),
 		ClusterCIDR:   snap.GetServiceArgument(a.Snap, "kube-proxy", "--cluster-cidr"),
 	}
 
+	if a.JoinQueue != nil {
+		// Hold the request before consuming the cluster token, so that the node can retry until it is approved.
+		tracker.Phase("approval")
+		if !a.Snap.IsValidClusterToken(request.ClusterToken) {
+			return nil, fmt.Errorf("invalid token")
+		}
+		if _, err := a.JoinQueue.Request(ctx, request.ClusterToken, request.HostName, request.RemoteAddress, true); err != nil {
+			return nil, err
+		}
+	}
+
+	var kubeletProfile snap.KubeletProfile
+	if request.KubeletProfile != "" {
+		tracker.Phase("kubelet-profile")
+		if kubeletProfile, err = a.Snap.GetKubeletProfile(request.KubeletProfile); err != nil {
+			return nil, fmt.Errorf("failed to retrieve kubelet profile: %w", err)
+		}
+	}
+
+	tracker.Phase("consume-token")
 	if !a.Snap.ConsumeClusterToken(request.ClusterToken) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	tracker.Phase("ha-lock")
 	if a.Snap.HasDqliteLock() {
 		return nil, fmt.Errorf("failed to join the cluster. This is an HA MicroK8s cluster.\nPlease retry after enabling HA on this joining node with 'microk8s enable ha-cluster'")
 	}
 
+	tracker.Phase("certificate-request-tokens")
 	if err := a.Snap.AddCertificateRequestToken(request.ClusterToken); err != nil {
 		return nil, fmt.Errorf("failed to add certificate request token: %w", err)
 	}
 	hostname := util.GetRemoteHost(a.LookupIP, request.HostName, request.RemoteAddress)
+	nodeName := util.NodeName(hostname)
+	remoteIP := util.RemoteIP(request.RemoteAddress)
+	if err := a.Snap.TrackCertificateRequestTokens(nodeName, remoteIP, request.ClusterToken); err != nil {
+		log.Printf("WARNING: failed to record certificate request tokens of node %s: %q", nodeName, err)
+	}
 	clusterAgentEndpoint := net.JoinHostPort(hostname, request.ClusterAgentPort)
 
+	tracker.Phase("callback-token")
 	if err := a.Snap.AddCallbackToken(clusterAgentEndpoint, request.CallbackToken); err != nil {
 		return nil, fmt.Errorf("failed to add callback token for %s: %w", clusterAgentEndpoint, err)
 	}
 
+	tracker.Phase("prepare-response")
 	ca, err := a.Snap.ReadCA()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
@@ -102,6 +154,9 @@ func (a *API) Join(ctx context.Context, request JoinRequest) (*JoinResponse, err
 		if err := a.Snap.AddCertificateRequestToken(fmt.Sprintf("%s-kubelet", request.ClusterToken)); err != nil {
 			return nil, fmt.Errorf("failed adding certificate request token for kubelet: %w", err)
 		}
+		if err := a.Snap.TrackCertificateRequestTokens(nodeName, remoteIP, fmt.Sprintf("%s-proxy", request.ClusterToken), fmt.Sprintf("%s-kubelet", request.ClusterToken)); err != nil {
+			log.Printf("WARNING: failed to record certificate request tokens of node %s: %q", nodeName, err)
+		}
 	case snap.GetServiceArgument(a.Snap, "kube-apiserver", "--token-auth-file") != "":
 		// client does not know how to handle certificate auth, but we have a tokens file
 		response.APIServerAuthMode = APIServerAuthModeToken
@@ -109,10 +164,11 @@ func (a *API) Join(ctx context.Context, request JoinRequest) (*JoinResponse, err
 		if err != nil {
 			return nil, fmt.Errorf("failed to retrieve kube-proxy token: %w", err)
 		}
-		response.KubeletToken, err = a.Snap.GetOrCreateKubeletToken(hostname)
+		response.KubeletToken, err = a.Snap.GetOrCreateKubeletToken(nodeName)
 		if err != nil {
 			return nil, fmt.Errorf("failed to retrieve kubelet token: %w", err)
 		}
+		tracker.Phase("restart-apiserver")
 		if err := a.Snap.RestartService(ctx, "apiserver"); err != nil {
 			return nil, fmt.Errorf("failed to restart apiserver service: %w", err)
 		}
@@ -121,10 +177,12 @@ func (a *API) Join(ctx context.Context, request JoinRequest) (*JoinResponse, err
 		return nil, fmt.Errorf("joining this MicroK8s cluster requires x509 authentication. update MicroK8s to version 1.28 or newer and retry the join operation")
 	}
 
+	tracker.Phase("kubelet-args")
 	response.KubeletArgs, err = a.Snap.ReadServiceArguments("kubelet")
 	if err != nil {
 		return nil, fmt.Errorf("failed to read arguments of kubelet service: %w", err)
 	}
+	response.KubeletArgs = kubeletProfile.Apply(response.KubeletArgs)
 	if hostname != request.HostName {
 		response.KubeletArgs = fmt.Sprintf("%s\n--hostname-override=%s", response.KubeletArgs, hostname)
 	}
@@ -2,11 +2,23 @@ package v1_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
 	"net"
 	"testing"
+	"time"
 
 	v1 "github.com/canonical/microk8s-cluster-agent/pkg/api/v1"
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 	. "github.com/onsi/gomega"
 )
 
@@ -19,7 +31,7 @@ func TestJoin(t *testing.T) {
 			"kube-proxy":     "--cluster-cidr 10.1.0.0/16",
 			"kubelet":        "kubelet arguments\n",
 		},
-		ClusterTokens: []string{"valid-cluster-token-cert", "valid-cluster-token-auth", "valid-other-token", "valid-token-for-auth-test"},
+		ClusterTokens: []string{"valid-cluster-token-cert", "valid-cluster-token-auth", "valid-other-token", "valid-token-for-auth-test", "valid-token-for-approval-test", "valid-token-for-profile-test", "valid-token-for-pinned-test"},
 		KnownTokens: map[string]string{
 			"admin":             "admin-token",
 			"system:kube-proxy": "kube-proxy-token",
@@ -63,6 +75,102 @@ func TestJoin(t *testing.T) {
 		s.ServiceArguments["kube-apiserver"] = saveArgs
 	})
 
+	t.Run("KubeletProfile", func(t *testing.T) {
+		g := NewWithT(t)
+		s.KubeletProfiles = map[string]snap.KubeletProfile{
+			"arm64": {Arguments: map[string]string{"--system-reserved": "cpu=250m"}},
+		}
+		defer func() { s.KubeletProfiles = nil }()
+
+		_, err := apiv1.Join(context.Background(), v1.JoinRequest{
+			ClusterToken:   "valid-token-for-profile-test",
+			KubeletProfile: "unknown",
+		})
+		g.Expect(err).To(HaveOccurred())
+
+		resp, err := apiv1.Join(context.Background(), v1.JoinRequest{
+			ClusterToken:             "valid-token-for-profile-test",
+			HostName:                 "10.10.10.10",
+			ClusterAgentPort:         "25000",
+			RemoteAddress:            "10.10.10.10:41422",
+			CanHandleCertificateAuth: true,
+			KubeletProfile:           "arm64",
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.KubeletArgs).To(Equal("kubelet arguments\n--system-reserved=cpu=250m\n"))
+	})
+
+	t.Run("PendingApproval", func(t *testing.T) {
+		g := NewWithT(t)
+		s.ConsumeClusterTokenCalledWith = nil
+		apiv1.JoinQueue = approval.NewQueue(approval.Options{})
+		defer func() { apiv1.JoinQueue = nil }()
+
+		resp, err := apiv1.Join(context.Background(), v1.JoinRequest{
+			ClusterToken:  "valid-token-for-approval-test",
+			HostName:      "my-hostname",
+			RemoteAddress: "10.10.10.10:41422",
+		})
+		g.Expect(resp).To(BeNil())
+		g.Expect(err).To(MatchError(approval.ErrPending))
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+		g.Expect(apiv1.JoinQueue.List()).To(ConsistOf(HaveField("Hostname", "my-hostname")))
+	})
+
+	t.Run("PinnedToken", func(t *testing.T) {
+		g := NewWithT(t)
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		g.Expect(err).ToNot(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "10.152.183.1"},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		g.Expect(err).ToNot(HaveOccurred())
+		ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+		caFingerprint, err := util.PublicKeyFingerprint([]byte(ca))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		saveCA := s.CA
+		s.CA = ca
+		defer func() { s.CA = saveCA }()
+
+		for _, tc := range []struct {
+			name        string
+			fingerprint string
+			expectErr   bool
+		}{
+			{name: "MatchingCA", fingerprint: caFingerprint},
+			{name: "WrongCA", fingerprint: "sha256:" + hex.EncodeToString(make([]byte, 32)), expectErr: true},
+			{name: "InvalidFingerprint", fingerprint: "sha256:0123456789abcdef", expectErr: true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+				s.ConsumeClusterTokenCalledWith = nil
+
+				_, err := apiv1.Join(context.Background(), v1.JoinRequest{
+					ClusterToken:             util.PinnedToken("valid-token-for-pinned-test", tc.fingerprint),
+					HostName:                 "my-hostname",
+					ClusterAgentPort:         "25000",
+					RemoteAddress:            "10.10.10.10:41422",
+					CallbackToken:            "callback-token",
+					CanHandleCertificateAuth: true,
+				})
+				if tc.expectErr {
+					g.Expect(err).To(HaveOccurred())
+					g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+					return
+				}
+				g.Expect(err).To(BeNil())
+				g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("valid-token-for-pinned-test"))
+			})
+		}
+	})
+
 	t.Run("Success", func(t *testing.T) {
 		t.Run("CertAuth", func(t *testing.T) {
 			g := NewWithT(t)
@@ -1,9 +1,11 @@
 package v1
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
 	"github.com/canonical/microk8s-cluster-agent/pkg/httputil"
 )
 
@@ -28,7 +30,14 @@ func (a *API) RegisterServer(server *http.ServeMux, middleware func(f http.Handl
 		req.RemoteAddress = r.RemoteAddr
 
 		resp, err := a.Join(r.Context(), req)
-		if err != nil {
+		switch {
+		case errors.Is(err, approval.ErrPending):
+			httputil.Error(w, http.StatusAccepted, err)
+			return
+		case errors.Is(err, approval.ErrDenied):
+			httputil.Error(w, http.StatusForbidden, err)
+			return
+		case err != nil:
 			httputil.Error(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -48,6 +57,9 @@ func (a *API) RegisterServer(server *http.ServeMux, middleware func(f http.Handl
 			return
 		}
 
+		// Set remote address from request object.
+		req.RemoteAddress = r.RemoteAddr
+
 		resp, err := a.SignCert(r.Context(), req)
 		if err != nil {
 			httputil.Error(w, http.StatusInternalServerError, err)
@@ -3,6 +3,9 @@ package v1
 import (
 	"context"
 	"fmt"
+	"log"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 )
 
 // SignCertRequest is the request message for the sign-cert endpoint.
@@ -11,6 +14,8 @@ type SignCertRequest struct {
 	Token string `json:"token"`
 	// CertificateSigningRequest is the signing request file contents.
 	CertificateSigningRequest string `json:"request"`
+	// RemoteAddress is the remote address from which the request originates. This is retrieved directly from the *http.Request object.
+	RemoteAddress string `json:"-"`
 }
 
 // SignCertResponse is the response message for the sign-cert endpoint.
@@ -29,5 +34,12 @@ func (a *API) SignCert(ctx context.Context, req SignCertRequest) (*SignCertRespo
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign certificate: %w", err)
 	}
+
+	// Keep track of the certificates issued to each node, so that they can be reported when the node is removed.
+	if serial, err := util.CertificateSerial(cert); err != nil {
+		log.Printf("WARNING: failed to retrieve serial number of signed certificate: %q", err)
+	} else if err := a.Snap.TrackIssuedCertificate(req.Token, util.RemoteIP(req.RemoteAddress), serial); err != nil {
+		log.Printf("WARNING: failed to record signed certificate %s: %q", serial, err)
+	}
 	return &SignCertResponse{Certificate: string(cert)}, nil
 }
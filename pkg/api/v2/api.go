@@ -1,10 +1,14 @@
 package v2
 
 import (
+	"context"
 	"net"
 	"sync"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
 )
 
 // API implements the v2 API.
@@ -22,9 +26,46 @@ type API struct {
 	// InterfaceAddrs is net.InterfaceAddrs.
 	InterfaceAddrs func() ([]net.Addr, error)
 
+	// NewDqliteClient is used to create clients for managing the dqlite cluster.
+	// If nil, snaputil.NewDqliteClient is used.
+	NewDqliteClient NewDqliteClientFunc
+
+	// LabelNode is used in v2/join to set labels on the Node of the joining node once it registers.
+	// If nil, snaputil.LabelNode is used.
+	LabelNode LabelNodeFunc
+
+	// GetNodeNameByIP is used in v2/leave to identify the leaving node from the remote address of the request.
+	// If nil, snaputil.GetNodeNameByIP is used.
+	GetNodeNameByIP GetNodeNameByIPFunc
+
+	// ServingCertificateFile is the path to the certificate served by the cluster agent, which is returned by v2/join/discovery.
+	// The file is read for every request, so that refreshed certificates are picked up.
+	// If empty, $SNAP_DATA/certs/server.crt is used.
+	ServingCertificateFile string
+
+	// JoinQueue holds join requests until they are approved by an operator.
+	// If nil, join requests with a valid token are accepted immediately.
+	JoinQueue *approval.Queue
+
 	// dqliteMu protects changes involving the dqlite service.
 	dqliteMu sync.Mutex
 
 	// calicoMu protects changes involving the calico CNI.
 	calicoMu sync.Mutex
 }
+
+// getNodeNameByIP returns the name of the Node with the IP address ip.
+func (a *API) getNodeNameByIP(ctx context.Context, ip string) (string, error) {
+	if a.GetNodeNameByIP == nil {
+		return snaputil.GetNodeNameByIP(ctx, a.Snap, ip)
+	}
+	return a.GetNodeNameByIP(ctx, a.Snap, ip)
+}
+
+// dqliteClient returns a client for managing the dqlite cluster.
+func (a *API) dqliteClient() dqlite.Client {
+	if a.NewDqliteClient == nil {
+		return snaputil.NewDqliteClient(a.Snap)
+	}
+	return a.NewDqliteClient(a.Snap)
+}
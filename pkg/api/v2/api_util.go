@@ -9,10 +9,24 @@ import (
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 )
 
+// splitHostIP returns the host of a "host:port" address. If the host is an IP address, it is returned in canonical
+// form, so that it can be compared with other addresses. IPv4-mapped IPv6 addresses (e.g. "::ffff:10.0.0.1", as seen
+// by dual-stack listeners) are returned in IPv4 form. If hostPort has no port, it is parsed as a host.
+func splitHostIP(hostPort string) string {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = strings.Trim(hostPort, "[]")
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
 // findMatchingBindAddress attempts to find the bind address for dqlite from the 'host:port' of the join request.
 // in case of system errors, the request host is returned, to preserve backwards-compatibility.
 func (a *API) findMatchingBindAddress(hostPort string) (string, error) {
-	hostIP, _, _ := net.SplitHostPort(hostPort)
+	hostIP := splitHostIP(hostPort)
 
 	hostNetIP := net.ParseIP(hostIP)
 	if hostNetIP == nil {
@@ -49,7 +63,8 @@ nextAddr:
 			if !isVirtualIP {
 				return hostIP, nil
 			}
-		} else if subnet.Contains(hostNetIP) && subnetHostBits > 0 {
+		} else if subnet.Contains(hostNetIP) && subnetHostBits > 0 && !ip.IsLinkLocalUnicast() {
+			// link-local addresses cannot be used without a zone, so they are never used as bind address
 			// we found the IP address of the interface
 			matchingInterfaceIP = ip
 		}
@@ -81,3 +96,8 @@ func (a *API) kubeAPIServerPrefersInternalIPForKubelet() bool {
 
 	return false
 }
+
+// KubeAPIServerUsesDqlite checks whether kube-apiserver uses the local dqlite cluster as datastore.
+func (a *API) KubeAPIServerUsesDqlite() bool {
+	return strings.Contains(snap.GetServiceArgument(a.Snap, "kube-apiserver", "--etcd-servers"), "/var/kubernetes/backend/kine.sock:12379")
+}
@@ -117,4 +117,85 @@ func TestFindMatchingBindAddress(t *testing.T) {
 			g.Expect(addr).To(Equal("192.168.100.100"))
 		})
 	})
+	t.Run("IPv6", func(t *testing.T) {
+		a := API{
+			InterfaceAddrs: func() ([]net.Addr, error) {
+				return []net.Addr{
+					&utiltest.MockCIDR{CIDR: "127.0.0.1/8"},
+					&utiltest.MockCIDR{CIDR: "::1/128"},
+					&utiltest.MockCIDR{CIDR: "10.0.0.10/16"},
+					&utiltest.MockCIDR{CIDR: "fe80::10/64"},
+					&utiltest.MockCIDR{CIDR: "fd00::10/64"},
+					&utiltest.MockCIDR{CIDR: "fd00::100/128"},
+				}, nil
+			},
+		}
+		for _, tc := range []struct {
+			name     string
+			hostPort string
+			expected string
+		}{
+			{name: "InterfaceIP", hostPort: "[fd00::10]:25000", expected: "fd00::10"},
+			{name: "NonCanonicalInterfaceIP", hostPort: "[fd00:0:0::0010]:25000", expected: "fd00::10"},
+			{name: "VirtualIP", hostPort: "[fd00::100]:25000", expected: "fd00::10"},
+			{name: "DualStackIPv4", hostPort: "10.0.0.10:25000", expected: "10.0.0.10"},
+			{name: "IPv4Mapped", hostPort: "[::ffff:10.0.0.10]:25000", expected: "10.0.0.10"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+
+				addr, err := a.findMatchingBindAddress(tc.hostPort)
+				g.Expect(err).To(BeNil())
+				g.Expect(addr).To(Equal(tc.expected))
+			})
+		}
+
+		t.Run("FailOnMissing", func(t *testing.T) {
+			g := NewWithT(t)
+
+			addr, err := a.findMatchingBindAddress("[2001:db8::1]:25000")
+			g.Expect(err).ToNot(BeNil())
+			g.Expect(addr).To(BeEmpty())
+		})
+	})
+}
+
+func TestSplitHostIP(t *testing.T) {
+	for _, tc := range []struct {
+		hostPort string
+		expected string
+	}{
+		{hostPort: "10.0.0.1:25000", expected: "10.0.0.1"},
+		{hostPort: "10.0.0.1", expected: "10.0.0.1"},
+		{hostPort: "[fd00::1]:25000", expected: "fd00::1"},
+		{hostPort: "[fd00:0:0:0::1]:25000", expected: "fd00::1"},
+		{hostPort: "[::ffff:10.0.0.1]:25000", expected: "10.0.0.1"},
+		{hostPort: "fd00::1", expected: "fd00::1"},
+		{hostPort: "node-1:25000", expected: "node-1"},
+	} {
+		t.Run(tc.hostPort, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(splitHostIP(tc.hostPort)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestJoinNodeName(t *testing.T) {
+	for _, tc := range []struct {
+		hostname string
+		remoteIP string
+		expect   string
+	}{
+		{hostname: "node-1", remoteIP: "10.0.0.1", expect: "node-1"},
+		{hostname: "Node-1.Example.com", remoteIP: "10.0.0.1", expect: "node-1.example.com"},
+		{hostname: "", remoteIP: "10.0.0.1", expect: "ip-10-0-0-1"},
+		{hostname: "node_1", remoteIP: "10.0.0.1", expect: "ip-10-0-0-1"},
+		{hostname: "", remoteIP: "fd00::11", expect: "ip-fd000000000000000000000000000011"},
+		{hostname: "fd00::11", remoteIP: "fd00::11", expect: "ip-fd000000000000000000000000000011"},
+	} {
+		t.Run(fmt.Sprintf("%s/%s", tc.hostname, tc.remoteIP), func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(joinNodeName(tc.hostname, tc.remoteIP)).To(Equal(tc.expect))
+		})
+	}
 }
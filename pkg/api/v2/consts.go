@@ -3,4 +3,8 @@ package v2
 const (
 	// CAPIAuthTokenHeader is the header used to pass the CAPI auth token.
 	CAPIAuthTokenHeader = "capi-auth-token"
+	// CallbackTokenHeader is the header used to pass the callback token.
+	CallbackTokenHeader = "x-microk8s-callback-token"
+	// AdminTokenHeader is the header used to pass the admin token of the local cluster agent.
+	AdminTokenHeader = "x-microk8s-admin-token"
 )
@@ -3,8 +3,18 @@ package v2
 import (
 	"context"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 )
 
 // ListControlPlaneNodeIPsFunc returns a list of the known control plane nodes of a MicroK8s cluster.
 type ListControlPlaneNodeIPsFunc func(ctx context.Context, _ snap.Snap) ([]string, error)
+
+// NewDqliteClientFunc returns a client for managing the dqlite cluster of a MicroK8s node.
+type NewDqliteClientFunc func(snap.Snap) dqlite.Client
+
+// LabelNodeFunc sets labels on a Node of a MicroK8s cluster.
+type LabelNodeFunc func(ctx context.Context, s snap.Snap, nodeName string, labels map[string]string) error
+
+// GetNodeNameByIPFunc returns the name of the Node of a MicroK8s cluster with an IP address.
+type GetNodeNameByIPFunc func(ctx context.Context, s snap.Snap, ip string) (string, error)
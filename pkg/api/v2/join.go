@@ -5,10 +5,10 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"strings"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/metrics"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
 	"github.com/canonical/microk8s-cluster-agent/pkg/util"
@@ -27,7 +27,11 @@ func (v *WorkerOnlyField) UnmarshalJSON(b []byte) error {
 // JoinRequest is the request message for the v2/join API endpoint.
 type JoinRequest struct {
 	// ClusterToken is the token generated during "microk8s add-node".
+	// Tokens pinned to a CA fingerprint (see util.PinnedToken) are also accepted.
 	ClusterToken string `json:"token"`
+	// CAFingerprint is the fingerprint of the cluster CA that the joining node expects, in "sha256:<hex>" format.
+	// If empty, the fingerprint of a pinned cluster token is used.
+	CAFingerprint string `json:"ca_fingerprint,omitempty"`
 	// RemoteHostName is the hostname of the joining host.
 	RemoteHostName string `json:"hostname"`
 	// ClusterAgentPort is the port number where the cluster-agent is listening on the joining node.
@@ -42,6 +46,28 @@ type JoinRequest struct {
 	RemoteAddress string `json:"-"`
 	// CanHandleCertificateAuth is set by joining nodes that know how to generate x509 certificates for cluster authentication instead of using auth tokens.
 	CanHandleCertificateAuth bool `json:"can_handle_x509_auth"`
+	// NodeAddresses are the IP addresses of the joining node. Dual-stack nodes should report one address of each family,
+	// so that the control plane can configure both. The address the request originates from is always used.
+	NodeAddresses []string `json:"addresses,omitempty"`
+	// KubeletProfile is the name of a kubelet profile to apply on top of the kubelet arguments of this node.
+	// If empty, the joining node uses the same kubelet arguments as this node.
+	KubeletProfile string `json:"kubelet_profile,omitempty"`
+	// NodeLabels are labels to set on the Node of the joining node. They must be allowed by the join policy of the cluster token.
+	NodeLabels map[string]string `json:"node_labels,omitempty"`
+	// NodeTaints are taints to register the joining node with, in "key=value:Effect" format. They must be allowed by the join policy of the cluster token.
+	NodeTaints []string `json:"node_taints,omitempty"`
+	// NodeRole is a role for the joining node, set as a "node-role.kubernetes.io/<role>" label once the node registers.
+	// It must be allowed by the join policy of the cluster token.
+	NodeRole string `json:"node_role,omitempty"`
+	// CertificateSigningRequests are PEM-encoded certificate signing requests for the certificates of a joining control
+	// plane node, keyed by certificate name. If set, the CA key is not shared with the joining node. Instead, the
+	// certificates are signed by this node, and secrets are encrypted to EncryptionKey.
+	// This is negotiated through the v3/join API endpoint, and is not part of the v2 protocol.
+	CertificateSigningRequests map[string]string `json:"-"`
+	// EncryptionKey is an ephemeral X25519 public key generated by the joining node. If set, secrets in the join response
+	// are encrypted to this key, and returned in the *_enc fields instead. Older clients do not set it, and receive
+	// secrets in plain text.
+	EncryptionKey []byte `json:"encryption_key,omitempty"`
 }
 
 // JoinResponse is the response message for the v2/join API endpoint.
@@ -81,7 +107,18 @@ type JoinResponse struct {
 	// This is only included in the response when joining worker-only nodes.
 	ControlPlaneNodes []string `json:"control_plane_nodes"`
 	// ClusterCIDR is the cidr that is used by the cluster, defined in kube-proxy args.
+	// For dual-stack clusters, this is a comma-separated list. See also ClusterCIDRs.
 	ClusterCIDR string `json:"cluster_cidr,omitempty"`
+	// ClusterCIDRs are the pod CIDRs of the cluster, one for each address family.
+	ClusterCIDRs []string `json:"cluster_cidrs,omitempty"`
+	// ServiceCIDRs are the service CIDRs of the cluster, one for each address family, defined in kube-apiserver args.
+	ServiceCIDRs []string `json:"service_cidrs,omitempty"`
+	// NodeIPs are the IP addresses the joining node should use, at most one for each address family.
+	// The first address is the primary address of the node. This is intended for the kubelet '--node-ip' argument.
+	NodeIPs []string `json:"node_ips,omitempty"`
+	// NodeName is the name the joining node will be known as in the cluster. Unlike HostNameOverride, which is the
+	// IP address of the joining node, NodeName is always a valid node name, even for IPv6 nodes.
+	NodeName string `json:"node_name,omitempty"`
 	// EtcdServers is the value of the kube-apiserver '--etcd-servers' argument, containing the list of etcd endpoints to use.
 	// This is only included in the response when a custom data store is configured.
 	EtcdServers string `json:"etcd_servers,omitempty"`
@@ -94,59 +131,119 @@ type JoinResponse struct {
 	// EtcdClientKey is the contents of the file from the kube-apiserver '--etcd-keyfile' argument, containing a private key for connecting to the etcd servers. Will be empty if not using TLS.
 	// This is only included in the response when a custom data store is configured.
 	EtcdClientKey string `json:"etcd_key,omitempty"`
+	// CertificateAuthorityKeyEnc is CertificateAuthorityKey, encrypted to the encryption key of the joining node and base64-encoded.
+	CertificateAuthorityKeyEnc string `json:"ca_key_enc,omitempty"`
+	// Certificates are the signed certificates for the certificate signing requests of the joining node, keyed by certificate name.
+	// This is only included in the response when joining control plane nodes with certificate signing requests.
+	Certificates map[string]string `json:"certificates,omitempty"`
+	// ServiceAccountKeyEnc is ServiceAccountKey, encrypted to the encryption key of the joining node and base64-encoded.
+	ServiceAccountKeyEnc string `json:"service_account_key_enc,omitempty"`
+	// AdminTokenEnc is AdminToken, encrypted to the encryption key of the joining node and base64-encoded.
+	AdminTokenEnc string `json:"admin_token_enc,omitempty"`
+	// DqliteClusterKeyEnc is DqliteClusterKey, encrypted to the encryption key of the joining node and base64-encoded.
+	DqliteClusterKeyEnc string `json:"cluster_key_enc,omitempty"`
+	// EtcdClientKeyEnc is EtcdClientKey, encrypted to the encryption key of the joining node and base64-encoded.
+	EtcdClientKeyEnc string `json:"etcd_key_enc,omitempty"`
 }
 
-// Join implements "POST v2/join".
-// Join returns the join response on success, otherwise an error and the HTTP status code.
-func (a *API) Join(ctx context.Context, req JoinRequest) (*JoinResponse, int, error) {
-	if !a.Snap.ConsumeClusterToken(req.ClusterToken) {
-		return nil, http.StatusInternalServerError, fmt.Errorf("invalid token")
-	}
-	if !a.Snap.HasDqliteLock() {
-		return nil, http.StatusNotImplemented, fmt.Errorf("not possible to join. This is not an HA MicroK8s cluster")
+// splitPinnedToken moves the CA fingerprint of a pinned cluster token to CAFingerprint, unless it is already set.
+func (req *JoinRequest) splitPinnedToken() {
+	token, fingerprint := util.SplitPinnedToken(req.ClusterToken)
+	req.ClusterToken = token
+	if req.CAFingerprint == "" {
+		req.CAFingerprint = fingerprint
 	}
+}
 
-	// Check cluster agent ports.
-	clusterAgentBind := snap.GetServiceArgument(a.Snap, "cluster-agent", "--bind")
-	_, port, _ := net.SplitHostPort(clusterAgentBind)
-	if port != req.ClusterAgentPort {
-		return nil, http.StatusBadGateway, fmt.Errorf("the port of the cluster agent port has to be set to %s", port)
-	}
+// joinPlan is the outcome of the validation phase of a join request.
+type joinPlan struct {
+	// remoteIP is the IP address of the joining node.
+	remoteIP string
+	// nodeName is the name the joining node will be known as in the cluster. See joinNodeName.
+	nodeName string
+	// nodeIPs are the IP addresses of the joining node, at most one for each address family.
+	nodeIPs []string
+	// kubeAPIServerUsesDqlite is true if the cluster uses dqlite as datastore.
+	kubeAPIServerUsesDqlite bool
+	// response is the join response. Fields that depend on changes made during the commit phase are not yet set.
+	response *JoinResponse
+	// nodeLabels are labels to set on the Node of the joining node once it registers.
+	nodeLabels map[string]string
+}
 
-	// Prevent joins in the same node.
-	remoteIP, _, _ := net.SplitHostPort(req.RemoteAddress)
-	if hostIP, _, _ := net.SplitHostPort(req.HostPort); remoteIP == hostIP {
-		return nil, http.StatusServiceUnavailable, fmt.Errorf("the joining node has the same IP (%s) as the node we contact", hostIP)
-	}
+// joinRollback is a list of compensating actions for changes made while committing a join request.
+type joinRollback []func(ctx context.Context) error
+
+// add records a compensating action.
+func (r *joinRollback) add(undo func(ctx context.Context) error) {
+	*r = append(*r, undo)
+}
 
-	// Check that hostname resolves to the expected IP address
-	// The check is only required if 'Hostname' is preferred over 'InternalIP' to communicate with the Kubelet
-	if !a.kubeAPIServerPrefersInternalIPForKubelet() && util.GetRemoteHost(a.LookupIP, req.RemoteHostName, req.RemoteAddress) != req.RemoteHostName {
-		return nil, http.StatusBadRequest, fmt.Errorf("the hostname (%s) of the joining node does not resolve to the IP %q. Refusing join", req.RemoteHostName, remoteIP)
+// run executes all compensating actions in reverse order. Failures are logged, and do not stop the rollback.
+func (r joinRollback) run(ctx context.Context) {
+	for i := len(r) - 1; i >= 0; i-- {
+		if err := r[i](ctx); err != nil {
+			log.Printf("WARNING: failed to revert changes of rejected join: %q", err)
+		}
 	}
+}
 
-	kubeAPIServerUsesDqlite := strings.Contains(snap.GetServiceArgument(a.Snap, "kube-apiserver", "--etcd-servers"), "/var/kubernetes/backend/kine.sock:12379")
+// Join implements "POST v2/join".
+// Join returns the join response on success, otherwise an error and the HTTP status code.
+// Join first validates the request without making any changes. If join approval is enabled, the request is then
+// held until an operator approves it. If the join fails afterwards, any changes are reverted.
+// The duration of each phase and the outcome of the join are recorded in the join metrics.
+func (a *API) Join(ctx context.Context, req JoinRequest) (resp *JoinResponse, rc int, err error) {
+	req.splitPinnedToken()
+	tracker := metrics.NewJoinTracker("v2", a.joinMode(req))
+	defer func() { tracker.Done(err) }()
 
-	// Handle datastore updates
-	switch {
-	case kubeAPIServerUsesDqlite:
-		a.dqliteMu.Lock()
-		err := snaputil.MaybeUpdateDqliteBindAddress(ctx, a.Snap, req.HostPort, remoteIP, a.findMatchingBindAddress)
-		a.dqliteMu.Unlock()
-		if err != nil {
-			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err)
+	plan, rc, err := a.validateJoin(ctx, req, tracker)
+	if err != nil {
+		return nil, rc, err
+	}
+	if a.JoinQueue != nil {
+		tracker.Phase("approval")
+		if _, err := a.JoinQueue.Request(ctx, req.ClusterToken, req.RemoteHostName, req.RemoteAddress, bool(req.WorkerOnly)); err != nil {
+			return nil, approvalStatusCode(err), err
 		}
-	case req.CanHandleCustomEtcd:
-		// no-op
+	}
+	return a.commitJoin(ctx, req, plan, tracker)
+}
 
+// joinMode returns the kind of node that joins the cluster.
+func (a *API) joinMode(req JoinRequest) metrics.JoinMode {
+	switch {
+	case bool(req.WorkerOnly):
+		return metrics.JoinModeWorker
+	case !a.KubeAPIServerUsesDqlite():
+		return metrics.JoinModeCustomEtcd
 	default:
-		// fail since this node is using a custom datastore and the client cannot handle it properly.
-		return nil, http.StatusInternalServerError, fmt.Errorf("this MicroK8s cluster uses a custom etcd endpoint. update MicroK8s to version 1.28 or newer and retry the join operation")
+		return metrics.JoinModeControlPlane
 	}
+}
 
-	callbackToken, err := a.Snap.GetOrCreateSelfCallbackToken()
-	if err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("could not retrieve self callback token: %w", err)
+// validateJoin checks that the node can join the cluster and prepares the join response.
+// validateJoin does not have any side effects, and does not consume the cluster token.
+func (a *API) validateJoin(ctx context.Context, req JoinRequest, tracker *metrics.JoinTracker) (*joinPlan, int, error) {
+	tracker.Phase("token")
+	if !a.Snap.IsValidClusterToken(req.ClusterToken) {
+		return nil, http.StatusInternalServerError, fmt.Errorf("invalid token")
+	}
+	for _, c := range a.joinChecks() {
+		tracker.Phase(c.name)
+		if rc, err := c.check(ctx, req); err != nil {
+			return nil, rc, err
+		}
+	}
+
+	tracker.Phase("prepare-response")
+	remoteIP := splitHostIP(req.RemoteAddress)
+	plan := &joinPlan{
+		remoteIP:                remoteIP,
+		nodeName:                joinNodeName(req.RemoteHostName, remoteIP),
+		nodeIPs:                 nodeIPs(remoteIP, req.NodeAddresses),
+		kubeAPIServerUsesDqlite: a.KubeAPIServerUsesDqlite(),
 	}
 
 	ca, err := a.Snap.ReadCA()
@@ -157,76 +254,181 @@ func (a *API) Join(ctx context.Context, req JoinRequest) (*JoinResponse, int, er
 	if err != nil {
 		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read arguments of kubelet service: %w", err)
 	}
-
-	a.calicoMu.Lock()
-	if err := snaputil.MaybePatchCalicoAutoDetectionMethod(ctx, a.Snap, remoteIP, true); err != nil {
-		log.Printf("WARNING: failed to update cni configuration: %q", err)
+	if req.KubeletProfile != "" {
+		profile, err := a.Snap.GetKubeletProfile(req.KubeletProfile)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve kubelet profile: %w", err)
+		}
+		kubeletArgs = profile.Apply(kubeletArgs)
 	}
-	a.calicoMu.Unlock()
-
-	if err := a.Snap.CreateNoCertsReissueLock(); err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("failed to create lock file to disable certificate reissuing: %w", err)
+	kubeletArgs, plan.nodeLabels = nodeRegistration(kubeletArgs, req)
+	if snap.GetServiceArgumentFromString(kubeletArgs, "--node-ip") != "" {
+		// the node IPs of the control plane node must not be copied to the joining node
+		kubeletArgs, _ = snap.MergeServiceArguments(kubeletArgs, []map[string]string{{"--node-ip": strings.Join(plan.nodeIPs, ",")}}, nil)
 	}
 	response := &JoinResponse{
 		CertificateAuthority:       ca,
-		CallbackToken:              callbackToken,
 		APIServerPort:              snap.GetServiceArgument(a.Snap, "kube-apiserver", "--secure-port"),
 		APIServerAuthorizationMode: snap.GetServiceArgument(a.Snap, "kube-apiserver", "--authorization-mode"),
-		HostNameOverride:           remoteIP,
+		HostNameOverride:           plan.remoteIP,
 		KubeletArgs:                kubeletArgs,
 		ClusterCIDR:                snap.GetServiceArgument(a.Snap, "kube-proxy", "--cluster-cidr"),
+		ServiceCIDRs:               splitCIDRs(snap.GetServiceArgument(a.Snap, "kube-apiserver", "--service-cluster-ip-range")),
+		NodeIPs:                    plan.nodeIPs,
+		NodeName:                   plan.nodeName,
 	}
+	response.ClusterCIDRs = splitCIDRs(response.ClusterCIDR)
+	plan.response = response
 
 	if req.WorkerOnly {
-		if err := a.Snap.AddCertificateRequestToken(fmt.Sprintf("%s-kubelet", req.ClusterToken)); err != nil {
-			return nil, http.StatusInternalServerError, fmt.Errorf("failed adding certificate request token for kubelet: %w", err)
-		}
-		if err := a.Snap.AddCertificateRequestToken(fmt.Sprintf("%s-proxy", req.ClusterToken)); err != nil {
-			return nil, http.StatusInternalServerError, fmt.Errorf("failed adding certificate request token for kube-proxy: %w", err)
-		}
-
+		tracker.Phase("list-control-plane-nodes")
 		controlPlaneNodes, err := a.ListControlPlaneNodeIPs(ctx, a.Snap)
 		if err != nil {
 			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve list of control plane nodes: %w", err)
 		}
 		response.ControlPlaneNodes = controlPlaneNodes
-	} else {
+		return plan, http.StatusOK, nil
+	}
+
+	// joining nodes that send certificate signing requests do not receive the CA key
+	if len(req.CertificateSigningRequests) == 0 {
 		caKey, err := a.Snap.ReadCAKey()
 		if err != nil {
 			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve cluster CA key: %w", err)
 		}
 		response.CertificateAuthorityKey = &caKey
-		response.ServiceAccountKey, err = a.Snap.ReadServiceAccountKey()
+	}
+	response.ServiceAccountKey, err = a.Snap.ReadServiceAccountKey()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve service account key: %w", err)
+	}
+
+	if snap.GetServiceArgument(a.Snap, "kube-apiserver", "--token-auth-file") != "" {
+		response.AdminToken, err = a.Snap.GetKnownToken("admin")
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve token for admin user: %w", err)
+		}
+	}
+
+	// add datastore arguments
+	if plan.kubeAPIServerUsesDqlite {
+		response.DqliteClusterCertificate, err = a.Snap.ReadDqliteCert()
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster certificate: %w", err)
+		}
+		response.DqliteClusterKey, err = a.Snap.ReadDqliteKey()
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster key: %w", err)
+		}
+	} else {
+		response.EtcdServers = snap.GetServiceArgument(a.Snap, "kube-apiserver", "--etcd-servers")
+		response.EtcdCertificateAuthority, response.EtcdClientCertificate, response.EtcdClientKey, err = a.Snap.ReadEtcdCertificates()
 		if err != nil {
-			return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve service account key: %w", err)
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to read etcd certificates: %w", err)
 		}
+	}
+
+	if len(req.EncryptionKey) > 0 {
+		if err := sealJoinSecrets(response, req.EncryptionKey); err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to encrypt secrets: %w", err)
+		}
+	}
+
+	return plan, http.StatusOK, nil
+}
+
+// commitJoin makes the changes required for the node to join the cluster and completes the join response.
+// If any step fails, the changes made by previous steps are reverted, and the cluster token is not consumed.
+func (a *API) commitJoin(ctx context.Context, req JoinRequest, plan *joinPlan, tracker *metrics.JoinTracker) (*JoinResponse, int, error) {
+	var rollback joinRollback
+	fail := func(rc int, err error) (*JoinResponse, int, error) {
+		// revert changes even if the request context is cancelled
+		rollback.run(context.WithoutCancel(ctx))
+		return nil, rc, err
+	}
+	response := plan.response
+
+	tracker.Phase("callback-token")
+	// NOTE: the self callback token is created once and shared with all nodes, so it is not reverted.
+	callbackToken, err := a.Snap.GetOrCreateSelfCallbackToken()
+	if err != nil {
+		return fail(http.StatusInternalServerError, fmt.Errorf("could not retrieve self callback token: %w", err))
+	}
+	response.CallbackToken = callbackToken
 
-		switch {
-		case snap.GetServiceArgument(a.Snap, "kube-apiserver", "--token-auth-file") != "":
-			response.AdminToken, err = a.Snap.GetKnownToken("admin")
+	var issuedCertificateSerials []string
+	if len(req.CertificateSigningRequests) > 0 {
+		tracker.Phase("sign-certificates")
+		response.Certificates = make(map[string]string, len(req.CertificateSigningRequests))
+		for name, csrPEM := range req.CertificateSigningRequests {
+			cert, err := a.signJoinCertificate(ctx, name, csrPEM)
 			if err != nil {
-				return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve token for admin user: %w", err)
+				return fail(http.StatusInternalServerError, fmt.Errorf("failed to sign certificate %s: %w", name, err))
 			}
-		case !req.CanHandleCertificateAuth:
-			return nil, http.StatusInternalServerError, fmt.Errorf("joining this MicroK8s cluster requires x509 authentication. update MicroK8s to version 1.28 or newer and retry the join operation")
+			response.Certificates[name] = string(cert)
+			if serial, err := util.CertificateSerial(cert); err != nil {
+				log.Printf("WARNING: failed to retrieve serial number of signed certificate %s: %q", name, err)
+			} else {
+				issuedCertificateSerials = append(issuedCertificateSerials, serial)
+			}
+		}
+	}
+
+	var certificateRequestTokens []string
+	if req.WorkerOnly {
+		tracker.Phase("certificate-request-tokens")
+		for _, token := range []string{fmt.Sprintf("%s-kubelet", req.ClusterToken), fmt.Sprintf("%s-proxy", req.ClusterToken)} {
+			if err := a.Snap.AddCertificateRequestToken(token); err != nil {
+				return fail(http.StatusInternalServerError, fmt.Errorf("failed adding certificate request token %s: %w", token, err))
+			}
+			rollback.add(func(context.Context) error {
+				a.Snap.ConsumeCertificateRequestToken(token)
+				return nil
+			})
+			certificateRequestTokens = append(certificateRequestTokens, token)
+		}
+	}
+
+	tracker.Phase("no-certs-reissue-lock")
+	hadNoCertsReissueLock := a.Snap.HasNoCertsReissueLock()
+	if err := a.Snap.CreateNoCertsReissueLock(); err != nil {
+		return fail(http.StatusInternalServerError, fmt.Errorf("failed to create lock file to disable certificate reissuing: %w", err))
+	}
+	if !hadNoCertsReissueLock {
+		rollback.add(func(context.Context) error {
+			return a.Snap.RemoveNoCertsReissueLock()
+		})
+	}
+
+	if plan.kubeAPIServerUsesDqlite {
+		tracker.Phase("dqlite-bind-address")
+		a.dqliteMu.Lock()
+		updated, err := snaputil.MaybeUpdateDqliteBindAddress(ctx, a.Snap, req.HostPort, plan.remoteIP, a.findMatchingBindAddress)
+		if updated {
+			// Hold the lock until the join is committed or rolled back, so that no other request uses the new
+			// bind address before it is reverted. The rollback runs before the deferred unlock.
+			defer a.dqliteMu.Unlock()
+			rollback.add(func(ctx context.Context) error {
+				// do not break the cluster if other nodes have joined using the new bind address
+				if cluster, err := snaputil.GetDqliteCluster(a.Snap); err == nil && len(cluster) > 1 {
+					return fmt.Errorf("not reverting dqlite bind address, cluster already has %d nodes", len(cluster))
+				}
+				return snaputil.UpdateDqliteIP(ctx, a.Snap, "127.0.0.1")
+			})
+		} else {
+			a.dqliteMu.Unlock()
+		}
+		if err != nil {
+			return fail(http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err))
 		}
 
-		// add datastore arguments
-		switch {
-		case kubeAPIServerUsesDqlite:
+		if !req.WorkerOnly {
+			tracker.Phase("dqlite-wait")
 			dqliteCluster, err := snaputil.WaitForDqliteCluster(ctx, a.Snap, func(c snaputil.DqliteCluster) (bool, error) {
 				return len(c) >= 1, nil
 			})
 			if err != nil {
-				return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err)
-			}
-			response.DqliteClusterCertificate, err = a.Snap.ReadDqliteCert()
-			if err != nil {
-				return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster certificate: %w", err)
-			}
-			response.DqliteClusterKey, err = a.Snap.ReadDqliteKey()
-			if err != nil {
-				return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster key: %w", err)
+				return fail(http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err))
 			}
 			voters := make([]string, 0, len(dqliteCluster))
 			for _, node := range dqliteCluster {
@@ -235,17 +437,51 @@ func (a *API) Join(ctx context.Context, req JoinRequest) (*JoinResponse, int, er
 				}
 			}
 			response.DqliteVoterNodes = voters
-		case req.CanHandleCustomEtcd:
-			response.EtcdServers = snap.GetServiceArgument(a.Snap, "kube-apiserver", "--etcd-servers")
-			response.EtcdCertificateAuthority, response.EtcdClientCertificate, response.EtcdClientKey, err = a.Snap.ReadEtcdCertificates()
-			if err != nil {
-				return nil, http.StatusInternalServerError, fmt.Errorf("failed to read etcd certificates: %w", err)
+		}
+	}
+
+	tracker.Phase("cni")
+	a.calicoMu.Lock()
+	if cniYaml, err := a.Snap.ReadCNIYaml(); err == nil {
+		rollback.add(func(ctx context.Context) error {
+			a.calicoMu.Lock()
+			defer a.calicoMu.Unlock()
+			if current, err := a.Snap.ReadCNIYaml(); err == nil && current == cniYaml {
+				return nil
 			}
-		default:
-			// fail since this node is using a custom datastore and the client cannot handle it properly.
-			return nil, http.StatusInternalServerError, fmt.Errorf("this MicroK8s cluster uses a custom etcd endpoint. update MicroK8s to version 1.28 or newer and retry the join operation")
+			if err := a.Snap.WriteCNIYaml([]byte(cniYaml)); err != nil {
+				return fmt.Errorf("failed to restore cni configuration: %w", err)
+			}
+			return a.Snap.ApplyCNI(ctx)
+		})
+	}
+	if err := snaputil.MaybePatchCalicoAutoDetectionMethods(ctx, a.Snap, plan.nodeIPs, true); err != nil {
+		log.Printf("WARNING: failed to update cni configuration: %q", err)
+	}
+	a.calicoMu.Unlock()
+
+	// Consume the cluster token last, so that it can be reused if the join fails.
+	tracker.Phase("consume-token")
+	if !a.Snap.ConsumeClusterToken(req.ClusterToken) {
+		return fail(http.StatusInternalServerError, fmt.Errorf("invalid token"))
+	}
+
+	if len(certificateRequestTokens) > 0 {
+		if err := a.Snap.TrackCertificateRequestTokens(plan.nodeName, plan.remoteIP, certificateRequestTokens...); err != nil {
+			log.Printf("WARNING: failed to record certificate request tokens of node %s: %q", plan.nodeName, err)
 		}
 	}
 
+	// Keep track of the certificates issued to the node, so that they can be reported when the node is removed.
+	if len(issuedCertificateSerials) > 0 {
+		if err := a.Snap.TrackNodeCertificates(plan.nodeName, issuedCertificateSerials...); err != nil {
+			log.Printf("WARNING: failed to record signed certificates of node %s: %q", plan.nodeName, err)
+		}
+	}
+
+	if len(plan.nodeLabels) > 0 {
+		go a.labelJoinedNode(plan.nodeName, plan.nodeLabels)
+	}
+
 	return response, http.StatusOK, nil
 }
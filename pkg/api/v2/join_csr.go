@@ -0,0 +1,212 @@
+package v2
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// joinCertificateSubject is the subject that the certificate signing request for a certificate must have.
+type joinCertificateSubject struct {
+	// commonName is the required common name. If empty, any common name is allowed.
+	// For the kubelet certificate, this is the prefix of the common name, followed by the name of the node.
+	commonName string
+	// organization is the required organization. If empty, no organization is allowed.
+	organization string
+}
+
+// joinCertificates are the certificates that joining control plane nodes may request, keyed by certificate name.
+var joinCertificates = map[string]joinCertificateSubject{
+	// server is the serving certificate of the kube-apiserver. Its subject alternative names and extended key usages
+	// are also validated, see validateServingCertificateRequest.
+	"server":     {commonName: "kube-apiserver"},
+	"kubelet":    {commonName: "system:node:", organization: "system:nodes"},
+	"proxy":      {commonName: "system:kube-proxy"},
+	"controller": {commonName: "system:kube-controller-manager"},
+	"scheduler":  {commonName: "system:kube-scheduler"},
+	"admin":      {commonName: "admin", organization: "system:masters"},
+}
+
+// servingCertificateValidity is the validity of serving certificates signed for joining nodes.
+const servingCertificateValidity = 365 * 24 * time.Hour
+
+// joinNodeNames are the names and addresses a joining node may use in its certificates.
+type joinNodeNames struct {
+	// nodeNames are the names the joining node may register as.
+	nodeNames []string
+	// ips are the IP addresses the serving certificate may be valid for.
+	ips []net.IP
+	// dnsNames are the DNS names the serving certificate may be valid for.
+	dnsNames []string
+}
+
+// oidExtKeyUsage is the object identifier of the extended key usage extension.
+var oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// oidExtKeyUsageServerAuth is the object identifier of the server authentication extended key usage.
+var oidExtKeyUsageServerAuth = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+
+// validateJoinCertificateRequest checks that a certificate signing request of a joining node is valid, and that its
+// subject matches the requested certificate.
+func validateJoinCertificateRequest(name string, csrPEM string, names joinNodeNames) error {
+	subject, ok := joinCertificates[name]
+	if !ok {
+		return fmt.Errorf("unknown certificate %q", name)
+	}
+	csr, err := util.ParseCertificateRequest([]byte(csrPEM))
+	if err != nil {
+		return fmt.Errorf("invalid certificate signing request for certificate %s: %w", name, err)
+	}
+
+	commonName := csr.Subject.CommonName
+	switch {
+	case name == "kubelet":
+		if !strings.HasPrefix(commonName, subject.commonName) || !slices.Contains(names.nodeNames, strings.TrimPrefix(commonName, subject.commonName)) {
+			return fmt.Errorf("certificate signing request for certificate %s must have common name %s<node name>", name, subject.commonName)
+		}
+	case commonName != subject.commonName:
+		return fmt.Errorf("certificate signing request for certificate %s must have common name %s", name, subject.commonName)
+	}
+
+	var organizations []string
+	if subject.organization != "" {
+		organizations = []string{subject.organization}
+	}
+	if !slices.Equal(csr.Subject.Organization, organizations) {
+		return fmt.Errorf("certificate signing request for certificate %s must have organization %q", name, subject.organization)
+	}
+
+	if name == "server" {
+		return validateServingCertificateRequest(csr, names)
+	}
+	return nil
+}
+
+// validateServingCertificateRequest checks that a certificate signing request for a serving certificate is only valid
+// for the addresses of the joining node and the kube-apiserver, and only requests server authentication.
+func validateServingCertificateRequest(csr *x509.CertificateRequest, names joinNodeNames) error {
+	if len(csr.URIs) > 0 || len(csr.EmailAddresses) > 0 {
+		return fmt.Errorf("certificate signing request for certificate server must not have URI or email subject alternative names")
+	}
+	for _, ip := range csr.IPAddresses {
+		if !slices.ContainsFunc(names.ips, ip.Equal) {
+			return fmt.Errorf("certificate signing request for certificate server has subject alternative name %s that is not an address of the joining node", ip)
+		}
+	}
+	for _, dnsName := range csr.DNSNames {
+		if !slices.Contains(names.dnsNames, dnsName) {
+			return fmt.Errorf("certificate signing request for certificate server has subject alternative name %s that is not a name of the joining node", dnsName)
+		}
+	}
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(oidExtKeyUsage) {
+			continue
+		}
+		var usages []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(ext.Value, &usages); err != nil {
+			return fmt.Errorf("invalid extended key usage in certificate signing request for certificate server: %w", err)
+		}
+		for _, usage := range usages {
+			if !usage.Equal(oidExtKeyUsageServerAuth) {
+				return fmt.Errorf("certificate signing request for certificate server may only request the serverAuth extended key usage")
+			}
+		}
+	}
+	return nil
+}
+
+// joinNodeNames returns the names and addresses a joining node may use in its certificates.
+func (a *API) joinNodeNames(req JoinRequest) joinNodeNames {
+	remoteIP := splitHostIP(req.RemoteAddress)
+	nodeName := joinNodeName(req.RemoteHostName, remoteIP)
+	names := joinNodeNames{
+		nodeNames: []string{nodeName, remoteIP},
+		ips:       []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		dnsNames:  []string{nodeName, "localhost", "kubernetes", "kubernetes.default", "kubernetes.default.svc", "kubernetes.default.svc.cluster", "kubernetes.default.svc.cluster.local"},
+	}
+	for _, ip := range nodeIPs(remoteIP, req.NodeAddresses) {
+		names.ips = append(names.ips, net.ParseIP(ip))
+	}
+	serviceCIDRs := splitCIDRs(snap.GetServiceArgument(a.Snap, "kube-apiserver", "--service-cluster-ip-range"))
+	if len(serviceCIDRs) == 0 {
+		serviceCIDRs = []string{"10.152.183.0/24"}
+	}
+	for _, cidr := range serviceCIDRs {
+		if ip := kubernetesServiceIP(cidr); ip != nil {
+			names.ips = append(names.ips, ip)
+		}
+	}
+	return names
+}
+
+// kubernetesServiceIP returns the IP of the kubernetes service, which is the first IP of the service CIDR.
+func kubernetesServiceIP(cidr string) net.IP {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil
+	}
+	ip := slices.Clone(ipNet.IP)
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			break
+		}
+	}
+	return ip
+}
+
+// signJoinCertificate signs a certificate signing request of a joining node.
+func (a *API) signJoinCertificate(ctx context.Context, name string, csrPEM string) ([]byte, error) {
+	if name != "server" {
+		return a.Snap.SignCertificate(ctx, []byte(csrPEM))
+	}
+	csr, err := util.ParseCertificateRequest([]byte(csrPEM))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := a.Snap.ReadCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	caKey, err := a.Snap.ReadCAKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA key: %w", err)
+	}
+	return util.SignServingCertificate(csr, []byte(ca), []byte(caKey), servingCertificateValidity)
+}
+
+// checkJoinCertificateSigningRequests verifies that joining control plane nodes send valid certificate signing requests
+// when required by the cluster policy.
+func (a *API) checkJoinCertificateSigningRequests(_ context.Context, req JoinRequest) (int, error) {
+	if req.WorkerOnly {
+		if len(req.CertificateSigningRequests) > 0 {
+			return http.StatusBadRequest, fmt.Errorf("certificate signing requests are only supported for control plane nodes")
+		}
+		return http.StatusOK, nil
+	}
+	if len(req.CertificateSigningRequests) == 0 {
+		if a.Snap.HasJoinWithCSRLock() {
+			return http.StatusForbidden, fmt.Errorf("this MicroK8s cluster does not share its CA key. joining control plane nodes must send certificate signing requests")
+		}
+		return http.StatusOK, nil
+	}
+	if len(req.EncryptionKey) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("joining with certificate signing requests requires an encryption key")
+	}
+	names := a.joinNodeNames(req)
+	for name, csrPEM := range req.CertificateSigningRequests {
+		if err := validateJoinCertificateRequest(name, csrPEM, names); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,256 @@
+package v2_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// generateCSR returns a PEM-encoded certificate signing request with the given subject.
+func generateCSR(g Gomega, commonName string, organization ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName, Organization: organization},
+	}, key)
+	g.Expect(err).ToNot(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// generateServingCSR returns a PEM-encoded certificate signing request for a serving certificate.
+func generateServingCSR(g Gomega, commonName string, ips []net.IP, dnsNames []string, usages ...asn1.ObjectIdentifier) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	template := &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: commonName},
+		IPAddresses: ips,
+		DNSNames:    dnsNames,
+	}
+	if len(usages) > 0 {
+		value, err := asn1.Marshal(usages)
+		g.Expect(err).ToNot(HaveOccurred())
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 37}, Value: value}}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	g.Expect(err).ToNot(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// generateCA returns a PEM-encoded self-signed CA certificate and its key.
+func generateCA(g Gomega) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "10.152.183.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	g.Expect(err).ToNot(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestJoinWithCertificateSigningRequests(t *testing.T) {
+	caCert, caKey := generateCA(NewWithT(t))
+	newSnap := func() *mock.Snap {
+		return &mock.Snap{
+			DqliteLock:      true,
+			JoinWithCSRLock: true,
+			DqliteCert:      "DQLITE CERTIFICATE DATA",
+			DqliteKey:       "DQLITE KEY DATA",
+			DqliteInfoYaml: `
+Address: 10.10.10.10:19001
+ID: 1238719276943521
+Role: 0
+`,
+			DqliteClusterYaml: `
+- Address: 10.10.10.10:19001
+  ID: 1238719276943521
+  Role: 0
+`,
+			CA:                caCert,
+			CAKey:             caKey,
+			ServiceAccountKey: "SERVICE ACCOUNT KEY DATA",
+			ServiceArguments: map[string]string{
+				"kubelet":        "kubelet arguments\n",
+				"kube-apiserver": "--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n--service-cluster-ip-range=10.152.183.0/24,fd98::/108\n",
+				"cluster-agent":  "--bind=0.0.0.0:25000",
+			},
+			ClusterTokens:     []string{"control-plane-token"},
+			SelfCallbackToken: "callback-token",
+			SignedCertificate: "SIGNED CERTIFICATE DATA",
+		}
+	}
+	newAPI := func(s *mock.Snap) *v2.API {
+		return &v2.API{
+			Snap: s,
+			LookupIP: func(string) ([]net.IP, error) {
+				return []net.IP{{10, 10, 10, 13}}, nil
+			},
+			ListControlPlaneNodeIPs: mockListControlPlaneNodes("10.10.10.10"),
+		}
+	}
+
+	g := NewWithT(t)
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	newRequest := func() v2.JoinRequest {
+		return v2.JoinRequest{
+			ClusterToken:             "control-plane-token",
+			RemoteHostName:           "Test-Control-Plane",
+			ClusterAgentPort:         "25000",
+			HostPort:                 "10.10.10.10:25000",
+			RemoteAddress:            "10.10.10.13:41532",
+			CanHandleCertificateAuth: true,
+			NodeAddresses:            []string{"10.10.10.13", "fd00::13"},
+			CertificateSigningRequests: map[string]string{
+				"server": generateServingCSR(g, "kube-apiserver",
+					[]net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("10.152.183.1"), net.ParseIP("fd98::1"), net.ParseIP("10.10.10.13"), net.ParseIP("fd00::13")},
+					[]string{"test-control-plane", "localhost", "kubernetes", "kubernetes.default.svc.cluster.local"},
+					asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1},
+				),
+				"kubelet": generateCSR(g, "system:node:test-control-plane", "system:nodes"),
+				"admin":   generateCSR(g, "admin", "system:masters"),
+			},
+			EncryptionKey: key.PublicKey().Bytes(),
+		}
+	}
+	decrypt := func(g Gomega, sealed string) string {
+		b, err := base64.StdEncoding.DecodeString(sealed)
+		g.Expect(err).ToNot(HaveOccurred())
+		message, err := util.Open(key, b)
+		g.Expect(err).ToNot(HaveOccurred())
+		return string(message)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		g := NewWithT(t)
+		s := newSnap()
+		req := newRequest()
+
+		resp, rc, err := newAPI(s).Join(context.Background(), req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.CertificateAuthorityKey).To(BeNil())
+		g.Expect(resp.Certificates).To(HaveKeyWithValue("kubelet", "SIGNED CERTIFICATE DATA"))
+		g.Expect(resp.Certificates).To(HaveKeyWithValue("admin", "SIGNED CERTIFICATE DATA"))
+		g.Expect(s.SignCertificateCalledWith).To(ConsistOf(
+			req.CertificateSigningRequests["kubelet"],
+			req.CertificateSigningRequests["admin"],
+		))
+
+		// the serving certificate is signed with its subject alternative names, and only for server authentication
+		block, _ := pem.Decode([]byte(resp.Certificates["server"]))
+		g.Expect(block).ToNot(BeNil())
+		cert, err := x509.ParseCertificate(block.Bytes)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cert.Subject.CommonName).To(Equal("kube-apiserver"))
+		g.Expect(cert.Subject.Organization).To(BeEmpty())
+		g.Expect(cert.ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}))
+		g.Expect(cert.DNSNames).To(ConsistOf("test-control-plane", "localhost", "kubernetes", "kubernetes.default.svc.cluster.local"))
+		g.Expect(cert.IPAddresses).To(HaveLen(5))
+		roots := x509.NewCertPool()
+		g.Expect(roots.AppendCertsFromPEM([]byte(caCert))).To(BeTrue())
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "kubernetes", KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(resp.ServiceAccountKey).To(BeEmpty())
+		g.Expect(resp.DqliteClusterKey).To(BeEmpty())
+		g.Expect(decrypt(g, resp.ServiceAccountKeyEnc)).To(Equal("SERVICE ACCOUNT KEY DATA"))
+		g.Expect(decrypt(g, resp.DqliteClusterKeyEnc)).To(Equal("DQLITE KEY DATA"))
+		g.Expect(resp.DqliteClusterCertificate).To(Equal("DQLITE CERTIFICATE DATA"))
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("control-plane-token"))
+	})
+
+	t.Run("RequiredByPolicy", func(t *testing.T) {
+		g := NewWithT(t)
+		s := newSnap()
+		req := newRequest()
+		req.CertificateSigningRequests = nil
+
+		resp, rc, err := newAPI(s).Join(context.Background(), req)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusForbidden))
+		g.Expect(resp).To(BeNil())
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+
+		s.JoinWithCSRLock = false
+		req.EncryptionKey = nil
+		resp, _, err = newAPI(s).Join(context.Background(), req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(*resp.CertificateAuthorityKey).To(Equal(caKey))
+		g.Expect(resp.ServiceAccountKey).To(Equal("SERVICE ACCOUNT KEY DATA"))
+		g.Expect(resp.ServiceAccountKeyEnc).To(BeEmpty())
+	})
+
+	for _, tc := range []struct {
+		name          string
+		certificate   string
+		csr           func(g Gomega) string
+		encryptionKey []byte
+		workerOnly    bool
+	}{
+		{name: "UnknownCertificate", certificate: "front-proxy-client", csr: func(g Gomega) string { return generateCSR(g, "front-proxy-client") }},
+		{name: "InvalidCSR", certificate: "server", csr: func(Gomega) string { return "CSR DATA" }},
+		{name: "ServerCommonName", certificate: "server", csr: func(g Gomega) string { return generateCSR(g, "system:kube-proxy") }},
+		{name: "ServerOrganization", certificate: "server", csr: func(g Gomega) string { return generateCSR(g, "kube-apiserver", "system:masters") }},
+		{name: "ServerOtherIP", certificate: "server", csr: func(g Gomega) string {
+			return generateServingCSR(g, "kube-apiserver", []net.IP{net.ParseIP("10.10.10.10")}, nil)
+		}},
+		{name: "ServerOtherName", certificate: "server", csr: func(g Gomega) string {
+			return generateServingCSR(g, "kube-apiserver", nil, []string{"other-node"})
+		}},
+		{name: "ServerClientAuth", certificate: "server", csr: func(g Gomega) string {
+			return generateServingCSR(g, "kube-apiserver", nil, nil, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}, asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2})
+		}},
+		{name: "WrongCommonName", certificate: "proxy", csr: func(g Gomega) string { return generateCSR(g, "system:kube-scheduler") }},
+		{name: "WrongNodeName", certificate: "kubelet", csr: func(g Gomega) string { return generateCSR(g, "system:node:other-node", "system:nodes") }},
+		{name: "WrongOrganization", certificate: "controller", csr: func(g Gomega) string {
+			return generateCSR(g, "system:kube-controller-manager", "system:masters")
+		}},
+		{name: "NoEncryptionKey", certificate: "server", csr: func(g Gomega) string { return generateCSR(g, "kube-apiserver") }, encryptionKey: []byte{}},
+		{name: "WorkerOnly", certificate: "server", csr: func(g Gomega) string { return generateCSR(g, "kube-apiserver") }, workerOnly: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			s := newSnap()
+			req := newRequest()
+			req.CertificateSigningRequests = map[string]string{tc.certificate: tc.csr(g)}
+			if tc.encryptionKey != nil {
+				req.EncryptionKey = tc.encryptionKey
+			}
+			req.WorkerOnly = v2.WorkerOnlyField(tc.workerOnly)
+
+			resp, rc, err := newAPI(s).Join(context.Background(), req)
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(rc).To(Equal(http.StatusBadRequest))
+			g.Expect(resp).To(BeNil())
+			g.Expect(s.SignCertificateCalledWith).To(BeEmpty())
+			g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// JoinDiscoveryRequest is the request message for the "POST v2/join/discovery" API endpoint.
+type JoinDiscoveryRequest struct {
+	// TokenID is the ID of the cluster token, for cluster tokens in "<id>.<secret>" format. See util.SplitClusterToken.
+	// Joining nodes must not send the token secret before they verify the JoinDiscoveryResponse.
+	TokenID string `json:"token_id"`
+}
+
+// JoinDiscoveryResponse is the response message for the "POST v2/join/discovery" API endpoint.
+type JoinDiscoveryResponse struct {
+	// CertificateAuthority is the CA certificate of the cluster, in PEM format.
+	CertificateAuthority string `json:"ca"`
+	// Certificate is the certificate currently served by the cluster agent, in PEM format.
+	Certificate string `json:"certificate"`
+	// Signature is a detached JWS of CertificateAuthority followed by Certificate, signed with the cluster token.
+	// See util.SignDetachedJWS.
+	Signature string `json:"signature"`
+}
+
+// joinDiscoveryPayload returns the payload signed in a JoinDiscoveryResponse.
+func joinDiscoveryPayload(resp *JoinDiscoveryResponse) []byte {
+	return []byte(resp.CertificateAuthority + resp.Certificate)
+}
+
+// JoinDiscovery implements "POST v2/join/discovery".
+// JoinDiscovery allows joining nodes to authenticate the cluster agent before they send the cluster token, similar to
+// the token discovery of "kubeadm join". Joining nodes send only the token ID, then verify the signature of the response
+// with the full token, or verify the returned CA against the fingerprint of a pinned token (see util.SplitPinnedToken).
+// Finally, they verify that the certificate of the TLS connection is the returned certificate and that it is signed by
+// the returned CA, and only then send the join request with the full token.
+// JoinDiscovery never consumes the cluster token.
+func (a *API) JoinDiscovery(_ context.Context, req JoinDiscoveryRequest) (*JoinDiscoveryResponse, int, error) {
+	token, ok := a.Snap.FindClusterToken(req.TokenID)
+	if !ok {
+		return nil, http.StatusUnauthorized, fmt.Errorf("invalid token")
+	}
+	ca, err := a.Snap.ReadCA()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	certFile := a.ServingCertificateFile
+	if certFile == "" {
+		certFile = a.Snap.GetSnapDataPath("certs", "server.crt")
+	}
+	cert, err := util.ReadFile(certFile)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read serving certificate: %w", err)
+	}
+	response := &JoinDiscoveryResponse{
+		CertificateAuthority: ca,
+		Certificate:          cert,
+	}
+	response.Signature, err = util.SignDetachedJWS(joinDiscoveryPayload(response), token)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to sign discovery response: %w", err)
+	}
+	return response, http.StatusOK, nil
+}
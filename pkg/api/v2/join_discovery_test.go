@@ -0,0 +1,78 @@
+package v2_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+func TestJoinDiscovery(t *testing.T) {
+	newAPI := func(t *testing.T) (*v2.API, string) {
+		certFile := filepath.Join(t.TempDir(), "server.crt")
+		NewWithT(t).Expect(os.WriteFile(certFile, []byte("SERVING CERTIFICATE DATA"), 0600)).To(Succeed())
+		return &v2.API{
+			Snap: &mock.Snap{
+				CA:            "CA CERTIFICATE DATA",
+				ClusterTokens: []string{"abcdef.0123456789abcdef", "legacy-token"},
+			},
+			ServingCertificateFile: certFile,
+		}, certFile
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2, certFile := newAPI(t)
+
+		resp, rc, err := apiv2.JoinDiscovery(context.Background(), v2.JoinDiscoveryRequest{TokenID: "abcdef"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.CertificateAuthority).To(Equal("CA CERTIFICATE DATA"))
+		g.Expect(resp.Certificate).To(Equal("SERVING CERTIFICATE DATA"))
+		g.Expect(util.VerifyDetachedJWS(resp.Signature, []byte("CA CERTIFICATE DATASERVING CERTIFICATE DATA"), "abcdef.0123456789abcdef")).To(Succeed())
+		g.Expect(apiv2.Snap.(*mock.Snap).ConsumeClusterTokenCalledWith).To(BeEmpty())
+
+		// refreshed serving certificates are picked up
+		g.Expect(os.WriteFile(certFile, []byte("REFRESHED CERTIFICATE DATA"), 0600)).To(Succeed())
+		resp, _, err = apiv2.JoinDiscovery(context.Background(), v2.JoinDiscoveryRequest{TokenID: "abcdef"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.Certificate).To(Equal("REFRESHED CERTIFICATE DATA"))
+		g.Expect(util.VerifyDetachedJWS(resp.Signature, []byte("CA CERTIFICATE DATAREFRESHED CERTIFICATE DATA"), "abcdef.0123456789abcdef")).To(Succeed())
+	})
+
+	for _, tc := range []struct {
+		name    string
+		tokenID string
+	}{
+		{name: "UnknownTokenID", tokenID: "ghijkl"},
+		{name: "LegacyToken", tokenID: "legacy-token"},
+		{name: "NoTokenID"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			apiv2, _ := newAPI(t)
+
+			resp, rc, err := apiv2.JoinDiscovery(context.Background(), v2.JoinDiscoveryRequest{TokenID: tc.tokenID})
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(rc).To(Equal(http.StatusUnauthorized))
+			g.Expect(resp).To(BeNil())
+		})
+	}
+
+	t.Run("NoServingCertificate", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2, certFile := newAPI(t)
+		g.Expect(os.Remove(certFile)).To(Succeed())
+
+		_, rc, err := apiv2.JoinDiscovery(context.Background(), v2.JoinDiscoveryRequest{TokenID: "abcdef"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusInternalServerError))
+	})
+}
@@ -0,0 +1,124 @@
+package v2_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+func TestJoinPinnedToken(t *testing.T) {
+	g := NewWithT(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "10.152.183.1"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).ToNot(HaveOccurred())
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	caFingerprint, err := util.PublicKeyFingerprint([]byte(ca))
+	g.Expect(err).ToNot(HaveOccurred())
+	otherFingerprint := "sha256:" + hex.EncodeToString(make([]byte, 32))
+
+	newAPI := func() (*v2.API, *mock.Snap) {
+		s := &mock.Snap{
+			DqliteLock: true,
+			CA:         ca,
+			ServiceArguments: map[string]string{
+				"kubelet":        "kubelet arguments\n",
+				"kube-apiserver": "--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n",
+				"cluster-agent":  "--bind=0.0.0.0:25000",
+			},
+			DqliteClusterYaml: `
+- Address: 10.10.10.10:19001
+  ID: 1238719276943521
+  Role: 0
+`,
+			ClusterTokens:     []string{"worker-token"},
+			SelfCallbackToken: "callback-token",
+		}
+		return &v2.API{
+			Snap: s,
+			LookupIP: func(string) ([]net.IP, error) {
+				return []net.IP{{10, 10, 10, 12}}, nil
+			},
+			ListControlPlaneNodeIPs: mockListControlPlaneNodes("10.10.10.10"),
+		}, s
+	}
+	newRequest := func(token string, caFingerprint string) v2.JoinRequest {
+		return v2.JoinRequest{
+			ClusterToken:     token,
+			CAFingerprint:    caFingerprint,
+			RemoteHostName:   "test-worker",
+			ClusterAgentPort: "25000",
+			HostPort:         "10.10.10.10:25000",
+			RemoteAddress:    "10.10.10.12:41532",
+			WorkerOnly:       true,
+		}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		token         string
+		caFingerprint string
+		expectRC      int
+	}{
+		{name: "NotPinned", token: "worker-token", expectRC: http.StatusOK},
+		{name: "PinnedToken", token: util.PinnedToken("worker-token", caFingerprint), expectRC: http.StatusOK},
+		{name: "CAFingerprint", token: "worker-token", caFingerprint: caFingerprint, expectRC: http.StatusOK},
+		{name: "WrongCA", token: util.PinnedToken("worker-token", otherFingerprint), expectRC: http.StatusForbidden},
+		{name: "WrongCAFingerprint", token: "worker-token", caFingerprint: otherFingerprint, expectRC: http.StatusForbidden},
+		{name: "InvalidFingerprint", token: "worker-token@md5:0123", expectRC: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			apiv2, s := newAPI()
+
+			resp, rc, err := apiv2.Join(context.Background(), newRequest(tc.token, tc.caFingerprint))
+			g.Expect(rc).To(Equal(tc.expectRC))
+			if tc.expectRC != http.StatusOK {
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(resp).To(BeNil())
+				g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(resp).ToNot(BeNil())
+			g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("worker-token"))
+		})
+	}
+
+	t.Run("Preflight", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2, _ := newAPI()
+
+		resp, _, err := apiv2.JoinPreflight(context.Background(), newRequest(util.PinnedToken("worker-token", otherFingerprint), ""))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.Passed).To(BeFalse())
+		g.Expect(resp.Checks).To(ContainElement(HaveField("Name", "ca-fingerprint")))
+		for _, check := range resp.Checks {
+			g.Expect(check.Passed).To(Equal(check.Name != "ca-fingerprint"), check.Name)
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package v2
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// checkJoinNodeAddresses verifies that the addresses reported by the joining node are valid IP addresses that can be
+// used by the kubelet and the CNI.
+func (a *API) checkJoinNodeAddresses(_ context.Context, req JoinRequest) (int, error) {
+	for _, address := range req.NodeAddresses {
+		ip := net.ParseIP(address)
+		switch {
+		case ip == nil:
+			return http.StatusBadRequest, fmt.Errorf("invalid node address %q", address)
+		case ip.IsLoopback(), ip.IsUnspecified(), ip.IsLinkLocalUnicast(), ip.IsMulticast():
+			return http.StatusBadRequest, fmt.Errorf("node address %s cannot be used to reach the joining node", address)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// isIPv4 returns true if address is an IPv4 address, or an IPv4-mapped IPv6 address.
+func isIPv4(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && ip.To4() != nil
+}
+
+// nodeIPs returns the IP addresses of the joining node, at most one of each family. The address the join request
+// originates from always comes first. For dual-stack nodes, the first reported address of the other family follows.
+func nodeIPs(remoteIP string, addresses []string) []string {
+	ips := []string{remoteIP}
+	for _, address := range addresses {
+		if ip := net.ParseIP(address); ip != nil && isIPv4(address) != isIPv4(remoteIP) {
+			return append(ips, ip.String())
+		}
+	}
+	return ips
+}
+
+// joinNodeName returns the name the joining node will be known as in the cluster, which must be a valid Kubernetes
+// node name. The lowercase hostname of the node is used if it is a valid DNS subdomain. Otherwise, the name is derived
+// from the remote IP, since IPv6 addresses are not valid node names, e.g. "ip-10-0-0-1" or "ip-fd000000000000000000000000000011".
+func joinNodeName(hostname string, remoteIP string) string {
+	if name := util.NodeName(hostname); len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+	ip := net.ParseIP(remoteIP)
+	switch {
+	case ip == nil:
+		return util.NodeName(hostname)
+	case ip.To4() != nil:
+		return "ip-" + strings.ReplaceAll(ip.To4().String(), ".", "-")
+	default:
+		return "ip-" + hex.EncodeToString(ip.To16())
+	}
+}
+
+// splitCIDRs splits the value of a comma-separated CIDRs argument, e.g. "10.1.0.0/16,fd01::/64" for dual-stack clusters.
+func splitCIDRs(value string) []string {
+	var cidrs []string
+	for _, cidr := range strings.Split(value, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
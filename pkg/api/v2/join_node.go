@@ -0,0 +1,208 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// nodeRoleLabelPrefix is the prefix of labels that define the roles of a node.
+	nodeRoleLabelPrefix = "node-role.kubernetes.io/"
+
+	// labelNodeTimeout is how long to wait for a joining node to register before giving up on setting its labels.
+	labelNodeTimeout = 10 * time.Minute
+	// labelNodeInterval is the interval between attempts to set the labels of a joining node.
+	labelNodeInterval = 10 * time.Second
+)
+
+// kubeletAllowedLabels are labels in the kubernetes.io and k8s.io namespaces that the kubelet may set on its own Node.
+// See https://kubernetes.io/docs/reference/access-authn-authz/admission-controllers/#noderestriction
+var kubeletAllowedLabels = map[string]struct{}{
+	"kubernetes.io/hostname":                   {},
+	"kubernetes.io/arch":                       {},
+	"kubernetes.io/os":                         {},
+	"beta.kubernetes.io/arch":                  {},
+	"beta.kubernetes.io/os":                    {},
+	"beta.kubernetes.io/instance-type":         {},
+	"node.kubernetes.io/instance-type":         {},
+	"failure-domain.beta.kubernetes.io/region": {},
+	"failure-domain.beta.kubernetes.io/zone":   {},
+	"topology.kubernetes.io/region":            {},
+	"topology.kubernetes.io/zone":              {},
+}
+
+// kubeletCanSetLabel returns true if the kubelet of the joining node is allowed to set a label through --node-labels.
+// Other labels have to be set on the Node after it registers.
+func kubeletCanSetLabel(key string) bool {
+	if _, ok := kubeletAllowedLabels[key]; ok {
+		return true
+	}
+	namespace, _, hasNamespace := strings.Cut(key, "/")
+	if !hasNamespace {
+		return true
+	}
+	for _, restricted := range []string{"kubernetes.io", "k8s.io"} {
+		if namespace == restricted || strings.HasSuffix(namespace, "."+restricted) {
+			for _, allowed := range []string{"kubelet.kubernetes.io", "node.kubernetes.io"} {
+				if namespace == allowed || strings.HasSuffix(namespace, "."+allowed) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// validateNodeLabel checks that a node label is well-formed.
+func validateNodeLabel(key, value string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("invalid node label key %q: %s", key, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return fmt.Errorf("invalid value %q for node label %s: %s", value, key, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// validateNodeTaint checks that a node taint is well-formed, in "key=value:Effect" format.
+func validateNodeTaint(taint string) error {
+	key, value, effect := snap.ParseTaint(taint)
+	if err := validateNodeLabel(key, value); err != nil {
+		return fmt.Errorf("invalid node taint %q: %w", taint, err)
+	}
+	switch effect {
+	case "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return fmt.Errorf("invalid node taint %q: effect must be one of NoSchedule, PreferNoSchedule or NoExecute", taint)
+	}
+	return nil
+}
+
+// checkJoinNodeRegistration verifies that the node labels, taints and role requested by the joining node are
+// well-formed and allowed by the join policy of the cluster token.
+func (a *API) checkJoinNodeRegistration(_ context.Context, req JoinRequest) (int, error) {
+	if len(req.NodeLabels) == 0 && len(req.NodeTaints) == 0 && req.NodeRole == "" {
+		return http.StatusOK, nil
+	}
+	policy, err := a.Snap.GetJoinPolicy(req.ClusterToken)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to retrieve join policy: %w", err)
+	}
+	for key, value := range req.NodeLabels {
+		if err := validateNodeLabel(key, value); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if strings.HasPrefix(key, nodeRoleLabelPrefix) {
+			return http.StatusBadRequest, fmt.Errorf("node label %s is not allowed, request a node role instead", key)
+		}
+		if !policy.AllowsLabel(key, value) {
+			return http.StatusForbidden, fmt.Errorf("node label %s=%s is not allowed for this token", key, value)
+		}
+	}
+	for _, taint := range req.NodeTaints {
+		if err := validateNodeTaint(taint); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if !policy.AllowsTaint(taint) {
+			return http.StatusForbidden, fmt.Errorf("node taint %s is not allowed for this token", taint)
+		}
+	}
+	if req.NodeRole != "" {
+		if err := validateNodeLabel(nodeRoleLabelPrefix+req.NodeRole, ""); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("invalid node role %q: %w", req.NodeRole, err)
+		}
+		if !policy.AllowsRole(req.NodeRole) {
+			return http.StatusForbidden, fmt.Errorf("node role %s is not allowed for this token", req.NodeRole)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// nodeRegistration returns the kubelet arguments for the node labels and taints requested by the joining node,
+// merged with the existing kubelet arguments. Labels that the kubelet is not allowed to set are returned separately,
+// and must be set on the Node after it registers.
+func nodeRegistration(kubeletArgs string, req JoinRequest) (string, map[string]string) {
+	var (
+		kubeletLabels = make(map[string]string)
+		patchLabels   = make(map[string]string)
+	)
+	for key, value := range req.NodeLabels {
+		if kubeletCanSetLabel(key) {
+			kubeletLabels[key] = value
+		} else {
+			patchLabels[key] = value
+		}
+	}
+	if req.NodeRole != "" {
+		patchLabels[nodeRoleLabelPrefix+req.NodeRole] = ""
+	}
+
+	update := make(map[string]string, 2)
+	if len(kubeletLabels) > 0 {
+		existing := strings.Trim(snap.GetServiceArgumentFromString(kubeletArgs, "--node-labels"), `"`)
+		for _, label := range strings.Split(existing, ",") {
+			if key, value, ok := strings.Cut(label, "="); ok {
+				if _, requested := kubeletLabels[key]; !requested {
+					kubeletLabels[key] = value
+				}
+			}
+		}
+		labels := make([]string, 0, len(kubeletLabels))
+		for key, value := range kubeletLabels {
+			labels = append(labels, fmt.Sprintf("%s=%s", key, value))
+		}
+		sort.Strings(labels)
+		update["--node-labels"] = strings.Join(labels, ",")
+	}
+	if len(req.NodeTaints) > 0 {
+		var taints []string
+		seen := make(map[string]struct{})
+		existing := strings.Trim(snap.GetServiceArgumentFromString(kubeletArgs, "--register-with-taints"), `"`)
+		for _, taint := range append(strings.Split(existing, ","), req.NodeTaints...) {
+			if _, ok := seen[taint]; ok || taint == "" {
+				continue
+			}
+			seen[taint] = struct{}{}
+			taints = append(taints, taint)
+		}
+		update["--register-with-taints"] = strings.Join(taints, ",")
+	}
+	if len(update) > 0 {
+		kubeletArgs, _ = snap.MergeServiceArguments(kubeletArgs, []map[string]string{update}, nil)
+	}
+	return kubeletArgs, patchLabels
+}
+
+// labelJoinedNode sets labels on the Node of a joining node. Since the node has not registered yet when the join
+// request completes, labelJoinedNode retries in the background until it succeeds or times out.
+func (a *API) labelJoinedNode(nodeName string, labels map[string]string) {
+	labelNode := a.LabelNode
+	if labelNode == nil {
+		labelNode = snaputil.LabelNode
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), labelNodeTimeout)
+	defer cancel()
+	for {
+		err := labelNode(ctx, a.Snap, nodeName, labels)
+		if err == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("WARNING: failed to set labels of node %s: %q", nodeName, err)
+			return
+		case <-time.After(labelNodeInterval):
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package v2_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+)
+
+func TestJoinNodeRegistration(t *testing.T) {
+	newSnap := func() *mock.Snap {
+		return &mock.Snap{
+			DqliteLock: true,
+			ServiceArguments: map[string]string{
+				"kubelet":        "--node-labels=microk8s.io/cluster=true\n--container-runtime-endpoint=unix:///containerd.sock\n",
+				"kube-apiserver": "--secure-port 16443\n--etcd-servers=https://10.0.0.1:2379\n",
+				"cluster-agent":  "--bind=0.0.0.0:25000",
+			},
+			ClusterTokens: []string{"worker-token"},
+			JoinPolicies: map[string]snap.JoinPolicy{
+				"worker-token": {
+					Labels: []string{"topology.kubernetes.io/zone", "pool=gpu-less", "example.kubernetes.io/tier"},
+					Taints: []string{"dedicated=batch:NoSchedule"},
+					Roles:  []string{"batch"},
+				},
+			},
+		}
+	}
+	newRequest := func() v2.JoinRequest {
+		return v2.JoinRequest{
+			ClusterToken:        "worker-token",
+			RemoteHostName:      "Test-Worker",
+			ClusterAgentPort:    "25000",
+			HostPort:            "10.0.0.1:25000",
+			RemoteAddress:       "10.0.0.12:41532",
+			WorkerOnly:          true,
+			CanHandleCustomEtcd: true,
+		}
+	}
+	newAPI := func(s *mock.Snap, labelNode v2.LabelNodeFunc) *v2.API {
+		return &v2.API{
+			Snap: s,
+			LookupIP: func(string) ([]net.IP, error) {
+				return []net.IP{{10, 0, 0, 12}}, nil
+			},
+			ListControlPlaneNodeIPs: mockListControlPlaneNodes("10.0.0.1"),
+			LabelNode:               labelNode,
+		}
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		g := NewWithT(t)
+		type labelNodeCall struct {
+			nodeName string
+			labels   map[string]string
+		}
+		calls := make(chan labelNodeCall, 1)
+		apiv2 := newAPI(newSnap(), func(_ context.Context, _ snap.Snap, nodeName string, labels map[string]string) error {
+			calls <- labelNodeCall{nodeName: nodeName, labels: labels}
+			return nil
+		})
+
+		req := newRequest()
+		req.NodeLabels = map[string]string{
+			"topology.kubernetes.io/zone": "zone-a",
+			"pool":                        "gpu-less",
+			"example.kubernetes.io/tier":  "batch",
+		}
+		req.NodeTaints = []string{"dedicated=batch:NoSchedule"}
+		req.NodeRole = "batch"
+
+		resp, rc, err := apiv2.Join(context.Background(), req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.KubeletArgs).To(Equal(`--node-labels=microk8s.io/cluster=true,pool=gpu-less,topology.kubernetes.io/zone=zone-a
+--container-runtime-endpoint=unix:///containerd.sock
+--register-with-taints=dedicated=batch:NoSchedule
+`))
+
+		var call labelNodeCall
+		g.Eventually(calls).WithTimeout(time.Second).Should(Receive(&call))
+		g.Expect(call).To(Equal(labelNodeCall{
+			nodeName: "test-worker",
+			labels: map[string]string{
+				"example.kubernetes.io/tier":    "batch",
+				"node-role.kubernetes.io/batch": "",
+			},
+		}))
+	})
+
+	t.Run("NotRequested", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := newAPI(newSnap(), func(context.Context, snap.Snap, string, map[string]string) error {
+			t.Error("unexpected call to label node")
+			return nil
+		})
+
+		resp, _, err := apiv2.Join(context.Background(), newRequest())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.KubeletArgs).To(Equal("--node-labels=microk8s.io/cluster=true\n--container-runtime-endpoint=unix:///containerd.sock\n"))
+	})
+
+	t.Run("KubeletProfile", func(t *testing.T) {
+		g := NewWithT(t)
+		s := newSnap()
+		s.KubeletProfiles = map[string]snap.KubeletProfile{
+			"crio": {
+				Arguments: map[string]string{"--container-runtime-endpoint": "unix:///run/crio.sock"},
+				Delete:    []string{"--node-labels"},
+			},
+		}
+		apiv2 := newAPI(s, nil)
+
+		req := newRequest()
+		req.KubeletProfile = "crio"
+		req.NodeTaints = []string{"dedicated=batch:NoSchedule"}
+		resp, _, err := apiv2.Join(context.Background(), req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.KubeletArgs).To(Equal("--container-runtime-endpoint=unix:///run/crio.sock\n--register-with-taints=dedicated=batch:NoSchedule\n"))
+
+		req.KubeletProfile = "unknown"
+		_, rc, err := apiv2.Join(context.Background(), req)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusBadRequest))
+	})
+
+	for _, tc := range []struct {
+		name       string
+		labels     map[string]string
+		taints     []string
+		role       string
+		expectedRC int
+	}{
+		{name: "LabelNotAllowed", labels: map[string]string{"pool": "gpu"}, expectedRC: http.StatusForbidden},
+		{name: "InvalidLabel", labels: map[string]string{"pool": "not a valid value"}, expectedRC: http.StatusBadRequest},
+		{name: "RoleLabel", labels: map[string]string{"node-role.kubernetes.io/batch": ""}, expectedRC: http.StatusBadRequest},
+		{name: "TaintNotAllowed", taints: []string{"dedicated=batch:NoExecute"}, expectedRC: http.StatusForbidden},
+		{name: "TaintWithoutEffect", taints: []string{"dedicated=batch"}, expectedRC: http.StatusBadRequest},
+		{name: "RoleNotAllowed", role: "control-plane", expectedRC: http.StatusForbidden},
+		{name: "InvalidRole", role: "not/valid", expectedRC: http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			s := newSnap()
+			apiv2 := newAPI(s, nil)
+
+			req := newRequest()
+			req.NodeLabels = tc.labels
+			req.NodeTaints = tc.taints
+			req.NodeRole = tc.role
+
+			resp, rc, err := apiv2.Join(context.Background(), req)
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(resp).To(BeNil())
+			g.Expect(rc).To(Equal(tc.expectedRC))
+			g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// JoinPreflightCheck is the result of a single check of the v2/join/preflight API endpoint.
+type JoinPreflightCheck struct {
+	// Name is the name of the check.
+	Name string `json:"name"`
+	// Passed is true if the check passed.
+	Passed bool `json:"passed"`
+	// Message describes why the check failed.
+	Message string `json:"message,omitempty"`
+}
+
+// JoinPreflightResponse is the response message for the v2/join/preflight API endpoint.
+type JoinPreflightResponse struct {
+	// Passed is true if all checks passed, i.e. the node is expected to join successfully.
+	Passed bool `json:"passed"`
+	// Checks are the results of all checks, in the order they were executed.
+	Checks []JoinPreflightCheck `json:"checks"`
+}
+
+// joinCheck is a check that must pass before a node is allowed to join the cluster.
+// Checks must not have any side effects. A failed check returns the HTTP status code that the join fails with.
+type joinCheck struct {
+	name  string
+	check func(ctx context.Context, req JoinRequest) (int, error)
+}
+
+// joinChecks returns the list of checks performed for join requests, apart from the cluster token check.
+func (a *API) joinChecks() []joinCheck {
+	return []joinCheck{
+		{name: "ha-lock", check: a.checkJoinHALock},
+		{name: "ca-fingerprint", check: a.checkJoinCAFingerprint},
+		{name: "cluster-agent-port", check: a.checkJoinClusterAgentPort},
+		{name: "same-ip", check: a.checkJoinSameIP},
+		{name: "hostname-resolution", check: a.checkJoinHostnameResolution},
+		{name: "datastore", check: a.checkJoinDatastore},
+		{name: "auth-mode", check: a.checkJoinAuthMode},
+		{name: "encryption-key", check: a.checkJoinEncryptionKey},
+		{name: "certificate-signing-requests", check: a.checkJoinCertificateSigningRequests},
+		{name: "dqlite-membership", check: a.checkJoinDqliteMembership},
+		{name: "node-addresses", check: a.checkJoinNodeAddresses},
+		{name: "kubelet-profile", check: a.checkJoinKubeletProfile},
+		{name: "node-registration", check: a.checkJoinNodeRegistration},
+	}
+}
+
+// checkJoinHALock verifies that this is an HA MicroK8s cluster.
+func (a *API) checkJoinHALock(_ context.Context, _ JoinRequest) (int, error) {
+	if !a.Snap.HasDqliteLock() {
+		return http.StatusNotImplemented, fmt.Errorf("not possible to join. This is not an HA MicroK8s cluster")
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinCAFingerprint verifies that the joining node expects the CA of this cluster.
+func (a *API) checkJoinCAFingerprint(_ context.Context, req JoinRequest) (int, error) {
+	if req.CAFingerprint == "" {
+		return http.StatusOK, nil
+	}
+	ca, err := a.Snap.ReadCA()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to retrieve cluster CA: %w", err)
+	}
+	if err := util.VerifyPublicKeyFingerprint([]byte(ca), req.CAFingerprint); err != nil {
+		switch {
+		case errors.Is(err, util.ErrInvalidFingerprint):
+			return http.StatusBadRequest, fmt.Errorf("invalid CA fingerprint: %w", err)
+		case errors.Is(err, util.ErrFingerprintMismatch):
+			return http.StatusForbidden, fmt.Errorf("the cluster token is pinned to a different CA: %w", err)
+		default:
+			return http.StatusInternalServerError, fmt.Errorf("failed to verify fingerprint of cluster CA: %w", err)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinClusterAgentPort verifies that the cluster agent on the joining node listens on the same port.
+func (a *API) checkJoinClusterAgentPort(_ context.Context, req JoinRequest) (int, error) {
+	clusterAgentBind := snap.GetServiceArgument(a.Snap, "cluster-agent", "--bind")
+	_, port, _ := net.SplitHostPort(clusterAgentBind)
+	if port != req.ClusterAgentPort {
+		return http.StatusBadGateway, fmt.Errorf("the port of the cluster agent port has to be set to %s", port)
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinSameIP prevents joins in the same node.
+func (a *API) checkJoinSameIP(_ context.Context, req JoinRequest) (int, error) {
+	remoteIP := splitHostIP(req.RemoteAddress)
+	if hostIP := splitHostIP(req.HostPort); remoteIP == hostIP {
+		return http.StatusServiceUnavailable, fmt.Errorf("the joining node has the same IP (%s) as the node we contact", hostIP)
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinHostnameResolution verifies that the hostname of the joining node resolves to the expected IP address.
+// The check is only required if 'Hostname' is preferred over 'InternalIP' to communicate with the Kubelet.
+func (a *API) checkJoinHostnameResolution(_ context.Context, req JoinRequest) (int, error) {
+	if !a.kubeAPIServerPrefersInternalIPForKubelet() && util.GetRemoteHost(a.LookupIP, req.RemoteHostName, req.RemoteAddress) != req.RemoteHostName {
+		remoteIP := splitHostIP(req.RemoteAddress)
+		return http.StatusBadRequest, fmt.Errorf("the hostname (%s) of the joining node does not resolve to the IP %q. Refusing join", req.RemoteHostName, remoteIP)
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinDatastore verifies that the joining node can handle the datastore used by the cluster.
+func (a *API) checkJoinDatastore(_ context.Context, req JoinRequest) (int, error) {
+	if !a.KubeAPIServerUsesDqlite() && !req.CanHandleCustomEtcd {
+		return http.StatusInternalServerError, fmt.Errorf("this MicroK8s cluster uses a custom etcd endpoint. update MicroK8s to version 1.28 or newer and retry the join operation")
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinAuthMode verifies that joining control plane nodes can handle the authentication mode of the cluster.
+func (a *API) checkJoinAuthMode(_ context.Context, req JoinRequest) (int, error) {
+	if !bool(req.WorkerOnly) && !req.CanHandleCertificateAuth && snap.GetServiceArgument(a.Snap, "kube-apiserver", "--token-auth-file") == "" {
+		return http.StatusInternalServerError, fmt.Errorf("joining this MicroK8s cluster requires x509 authentication. update MicroK8s to version 1.28 or newer and retry the join operation")
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinDqliteMembership verifies that the joining node is not already part of the dqlite cluster.
+func (a *API) checkJoinDqliteMembership(_ context.Context, req JoinRequest) (int, error) {
+	if !a.KubeAPIServerUsesDqlite() {
+		return http.StatusOK, nil
+	}
+	dqliteCluster, err := snaputil.GetDqliteCluster(a.Snap)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err)
+	}
+	remoteIP := splitHostIP(req.RemoteAddress)
+	for _, node := range dqliteCluster {
+		if splitHostIP(node.Address) == remoteIP {
+			return http.StatusInternalServerError, fmt.Errorf("the joining node (%s) is already known to dqlite", remoteIP)
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// checkJoinKubeletProfile verifies that the kubelet profile requested by the joining node exists.
+func (a *API) checkJoinKubeletProfile(_ context.Context, req JoinRequest) (int, error) {
+	if req.KubeletProfile == "" {
+		return http.StatusOK, nil
+	}
+	if _, err := a.Snap.GetKubeletProfile(req.KubeletProfile); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return http.StatusBadRequest, fmt.Errorf("unknown kubelet profile %q", req.KubeletProfile)
+		}
+		return http.StatusInternalServerError, fmt.Errorf("failed to retrieve kubelet profile: %w", err)
+	}
+	return http.StatusOK, nil
+}
+
+// JoinPreflight implements "POST v2/join/preflight".
+// JoinPreflight runs all checks of a join request, without consuming the cluster token or making any changes.
+// If the cluster token is not valid, no other checks are performed.
+func (a *API) JoinPreflight(ctx context.Context, req JoinRequest) (*JoinPreflightResponse, int, error) {
+	req.splitPinnedToken()
+	response := &JoinPreflightResponse{Passed: true}
+	if !a.Snap.IsValidClusterToken(req.ClusterToken) {
+		response.Passed = false
+		response.Checks = append(response.Checks, JoinPreflightCheck{Name: "token", Message: "invalid token"})
+		return response, http.StatusUnauthorized, fmt.Errorf("invalid token")
+	}
+	response.Checks = append(response.Checks, JoinPreflightCheck{Name: "token", Passed: true})
+
+	for _, c := range a.joinChecks() {
+		result := JoinPreflightCheck{Name: c.name, Passed: true}
+		if _, err := c.check(ctx, req); err != nil {
+			result.Passed = false
+			result.Message = err.Error()
+			response.Passed = false
+		}
+		response.Checks = append(response.Checks, result)
+	}
+	return response, http.StatusOK, nil
+}
@@ -0,0 +1,164 @@
+package v2_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+)
+
+func TestJoinPreflight(t *testing.T) {
+	newSnap := func() *mock.Snap {
+		return &mock.Snap{
+			DqliteLock: true,
+			DqliteClusterYaml: `
+- Address: 10.10.10.10:19001
+  ID: 1238719276943521
+  Role: 0
+- Address: 10.10.10.11:19001
+  ID: 12312648746587658
+  Role: 0
+`,
+			ServiceArguments: map[string]string{
+				"kube-apiserver": "--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n",
+				"cluster-agent":  "--bind=0.0.0.0:25000",
+			},
+			ClusterTokens: []string{"valid-token"},
+		}
+	}
+	newRequest := func() v2.JoinRequest {
+		return v2.JoinRequest{
+			ClusterToken:             "valid-token",
+			RemoteHostName:           "test-node",
+			ClusterAgentPort:         "25000",
+			HostPort:                 "10.10.10.10:25000",
+			RemoteAddress:            "10.10.10.13:41532",
+			CanHandleCertificateAuth: true,
+		}
+	}
+	lookupIP := func(hostname string) ([]net.IP, error) {
+		return map[string][]net.IP{"test-node": {{10, 10, 10, 13}}}[hostname], nil
+	}
+	failedChecks := func(resp *v2.JoinPreflightResponse) []string {
+		var failed []string
+		for _, check := range resp.Checks {
+			if !check.Passed {
+				failed = append(failed, check.Name)
+			}
+		}
+		return failed
+	}
+
+	t.Run("Passed", func(t *testing.T) {
+		g := NewWithT(t)
+		s := newSnap()
+		apiv2 := &v2.API{Snap: s, LookupIP: lookupIP}
+
+		resp, rc, err := apiv2.JoinPreflight(context.Background(), newRequest())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.Passed).To(BeTrue())
+		g.Expect(resp.Checks).To(HaveLen(14))
+		g.Expect(failedChecks(resp)).To(BeEmpty())
+
+		// no side effects
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+		g.Expect(s.CreateNoCertsReissueLockCalledWith).To(BeEmpty())
+		g.Expect(s.WriteDqliteUpdateYamlCalledWith).To(BeEmpty())
+		g.Expect(s.RestartServiceCalledWith).To(BeEmpty())
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := &v2.API{Snap: newSnap(), LookupIP: lookupIP}
+
+		req := newRequest()
+		req.ClusterToken = "invalid-token"
+		resp, rc, err := apiv2.JoinPreflight(context.Background(), req)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusUnauthorized))
+		g.Expect(resp.Passed).To(BeFalse())
+		g.Expect(resp.Checks).To(Equal([]v2.JoinPreflightCheck{{Name: "token", Message: "invalid token"}}))
+	})
+
+	for _, tc := range []struct {
+		name         string
+		modify       func(s *mock.Snap, req *v2.JoinRequest)
+		expectFailed []string
+	}{
+		{
+			name:         "NoHALock",
+			modify:       func(s *mock.Snap, _ *v2.JoinRequest) { s.DqliteLock = false },
+			expectFailed: []string{"ha-lock"},
+		},
+		{
+			name:         "ClusterAgentPort",
+			modify:       func(_ *mock.Snap, req *v2.JoinRequest) { req.ClusterAgentPort = "25001" },
+			expectFailed: []string{"cluster-agent-port"},
+		},
+		{
+			name: "SameIPAndDqliteMember",
+			modify: func(_ *mock.Snap, req *v2.JoinRequest) {
+				req.RemoteAddress = "10.10.10.10:41532"
+			},
+			expectFailed: []string{"same-ip", "hostname-resolution", "dqlite-membership"},
+		},
+		{
+			name:         "HostnameResolution",
+			modify:       func(_ *mock.Snap, req *v2.JoinRequest) { req.RemoteHostName = "unknown-node" },
+			expectFailed: []string{"hostname-resolution"},
+		},
+		{
+			name: "CustomEtcd",
+			modify: func(s *mock.Snap, _ *v2.JoinRequest) {
+				s.ServiceArguments["kube-apiserver"] = "--etcd-servers=https://10.0.0.1:2379\n"
+			},
+			expectFailed: []string{"datastore"},
+		},
+		{
+			name: "CustomEtcdSupported",
+			modify: func(s *mock.Snap, req *v2.JoinRequest) {
+				s.ServiceArguments["kube-apiserver"] = "--etcd-servers=https://10.0.0.1:2379\n"
+				req.CanHandleCustomEtcd = true
+			},
+		},
+		{
+			name:         "CertificateAuth",
+			modify:       func(_ *mock.Snap, req *v2.JoinRequest) { req.CanHandleCertificateAuth = false },
+			expectFailed: []string{"auth-mode"},
+		},
+		{
+			name: "CertificateAuthWorker",
+			modify: func(_ *mock.Snap, req *v2.JoinRequest) {
+				req.CanHandleCertificateAuth = false
+				req.WorkerOnly = true
+			},
+		},
+		{
+			name: "TokenAuth",
+			modify: func(s *mock.Snap, req *v2.JoinRequest) {
+				s.ServiceArguments["kube-apiserver"] += "--token-auth-file=${SNAP_DATA}/credentials/known_tokens.csv\n"
+				req.CanHandleCertificateAuth = false
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			s := newSnap()
+			req := newRequest()
+			tc.modify(s, &req)
+			apiv2 := &v2.API{Snap: s, LookupIP: lookupIP}
+
+			resp, rc, err := apiv2.JoinPreflight(context.Background(), req)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(rc).To(Equal(http.StatusOK))
+			g.Expect(resp.Passed).To(Equal(len(tc.expectFailed) == 0))
+			g.Expect(failedChecks(resp)).To(Equal(tc.expectFailed))
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
+)
+
+// ListJoinRequestsResponse is the response message for the "GET v2/join/requests" API endpoint.
+type ListJoinRequestsResponse struct {
+	// Requests are the join requests known to the cluster agent, oldest first.
+	Requests []approval.JoinRequest `json:"requests"`
+}
+
+// DecideJoinRequestRequest is the request message for the "POST v2/join/requests" API endpoint.
+type DecideJoinRequestRequest struct {
+	// AdminToken is the admin token of the cluster agent handling the request. This is retrieved from the request headers.
+	AdminToken string `json:"-"`
+	// ID is the ID of the join request.
+	ID string `json:"id"`
+	// Approve is true to approve the join request, false to deny it.
+	Approve bool `json:"approve"`
+}
+
+// approvalStatusCode returns the HTTP status code for join requests that are not approved.
+func approvalStatusCode(err error) int {
+	switch {
+	case errors.Is(err, approval.ErrPending):
+		return http.StatusAccepted
+	case errors.Is(err, approval.ErrDenied):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ListJoinRequests implements "GET v2/join/requests".
+// ListJoinRequests requires the admin token of the cluster agent, see snap.Snap.GetOrCreateAdminToken.
+func (a *API) ListJoinRequests(_ context.Context, adminToken string) (*ListJoinRequestsResponse, int, error) {
+	if !a.Snap.IsAdminTokenValid(adminToken) {
+		return nil, http.StatusUnauthorized, fmt.Errorf("invalid token")
+	}
+	if a.JoinQueue == nil {
+		return nil, http.StatusNotImplemented, fmt.Errorf("join approval is not enabled")
+	}
+	return &ListJoinRequestsResponse{Requests: a.JoinQueue.List()}, http.StatusOK, nil
+}
+
+// DecideJoinRequest implements "POST v2/join/requests".
+// DecideJoinRequest approves or denies a pending join request.
+// DecideJoinRequest requires the admin token of the cluster agent. The self callback token is not accepted, as it is
+// shared with all nodes of the cluster.
+func (a *API) DecideJoinRequest(_ context.Context, req DecideJoinRequestRequest) (int, error) {
+	if !a.Snap.IsAdminTokenValid(req.AdminToken) {
+		return http.StatusUnauthorized, fmt.Errorf("invalid token")
+	}
+	if a.JoinQueue == nil {
+		return http.StatusNotImplemented, fmt.Errorf("join approval is not enabled")
+	}
+	if err := a.JoinQueue.Decide(req.ID, req.Approve); err != nil {
+		if errors.Is(err, approval.ErrNotFound) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusConflict, err
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,88 @@
+package v2_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+)
+
+func TestJoinRequests(t *testing.T) {
+	t.Run("ApproveAndDeny", func(t *testing.T) {
+		g := NewWithT(t)
+		queue := approval.NewQueue(approval.Options{})
+		apiv2 := &v2.API{
+			Snap:      &mock.Snap{AdminToken: "admin-token", SelfCallbackTokens: []string{"callback-token"}},
+			JoinQueue: queue,
+		}
+
+		queue.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", true)
+		queue.Request(context.Background(), "token", "node-3", "10.0.0.3:41532", false)
+
+		resp, rc, err := apiv2.ListJoinRequests(context.Background(), "admin-token")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.Requests).To(HaveLen(2))
+
+		rc, err = apiv2.DecideJoinRequest(context.Background(), v2.DecideJoinRequestRequest{AdminToken: "admin-token", ID: resp.Requests[0].ID, Approve: true})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+
+		rc, err = apiv2.DecideJoinRequest(context.Background(), v2.DecideJoinRequestRequest{AdminToken: "admin-token", ID: resp.Requests[1].ID})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+
+		resp, _, _ = apiv2.ListJoinRequests(context.Background(), "admin-token")
+		g.Expect(resp.Requests).To(ConsistOf(
+			HaveField("Status", approval.StatusApproved),
+			HaveField("Status", approval.StatusDenied),
+		))
+
+		rc, err = apiv2.DecideJoinRequest(context.Background(), v2.DecideJoinRequestRequest{AdminToken: "admin-token", ID: resp.Requests[0].ID})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusConflict))
+
+		rc, err = apiv2.DecideJoinRequest(context.Background(), v2.DecideJoinRequestRequest{AdminToken: "admin-token", ID: "unknown"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusNotFound))
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := &v2.API{
+			Snap:      &mock.Snap{AdminToken: "admin-token", SelfCallbackTokens: []string{"callback-token"}},
+			JoinQueue: approval.NewQueue(approval.Options{}),
+		}
+
+		_, rc, err := apiv2.ListJoinRequests(context.Background(), "invalid")
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusUnauthorized))
+
+		rc, err = apiv2.DecideJoinRequest(context.Background(), v2.DecideJoinRequestRequest{AdminToken: "invalid", ID: "id"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusUnauthorized))
+
+		// the self callback token is shared with all nodes, so it must not be accepted
+		_, rc, err = apiv2.ListJoinRequests(context.Background(), "callback-token")
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusUnauthorized))
+
+		rc, err = apiv2.DecideJoinRequest(context.Background(), v2.DecideJoinRequestRequest{AdminToken: "callback-token", ID: "id"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusUnauthorized))
+	})
+
+	t.Run("NotEnabled", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := &v2.API{Snap: &mock.Snap{AdminToken: "admin-token", SelfCallbackTokens: []string{"callback-token"}}}
+
+		_, rc, err := apiv2.ListJoinRequests(context.Background(), "admin-token")
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusNotImplemented))
+	})
+}
@@ -0,0 +1,68 @@
+package v2
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// checkJoinEncryptionKey verifies that the encryption key of the joining node is a valid X25519 public key.
+// Keys of the wrong size, and low-order points that would result in an all-zero shared secret, are rejected.
+func (a *API) checkJoinEncryptionKey(_ context.Context, req JoinRequest) (int, error) {
+	if len(req.EncryptionKey) == 0 {
+		return http.StatusOK, nil
+	}
+	if len(req.EncryptionKey) != 32 {
+		return http.StatusBadRequest, fmt.Errorf("invalid encryption key: expected 32 bytes but got %d", len(req.EncryptionKey))
+	}
+	publicKey, err := ecdh.X25519().NewPublicKey(req.EncryptionKey)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to generate key: %w", err)
+	}
+	if _, err := privateKey.ECDH(publicKey); err != nil {
+		return http.StatusBadRequest, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return http.StatusOK, nil
+}
+
+// sealJoinSecrets encrypts the secrets of a join response to the encryption key of the joining node. The encrypted
+// secrets are base64-encoded and moved to the *_enc fields of the response.
+func sealJoinSecrets(response *JoinResponse, encryptionKey []byte) error {
+	if response.CertificateAuthorityKey != nil {
+		sealed, err := util.Seal(encryptionKey, []byte(*response.CertificateAuthorityKey))
+		if err != nil {
+			return err
+		}
+		response.CertificateAuthorityKeyEnc = base64.StdEncoding.EncodeToString(sealed)
+		response.CertificateAuthorityKey = nil
+	}
+	for _, secret := range []struct {
+		plain  *string
+		sealed *string
+	}{
+		{plain: &response.ServiceAccountKey, sealed: &response.ServiceAccountKeyEnc},
+		{plain: &response.AdminToken, sealed: &response.AdminTokenEnc},
+		{plain: &response.DqliteClusterKey, sealed: &response.DqliteClusterKeyEnc},
+		{plain: &response.EtcdClientKey, sealed: &response.EtcdClientKeyEnc},
+	} {
+		if *secret.plain == "" {
+			continue
+		}
+		sealed, err := util.Seal(encryptionKey, []byte(*secret.plain))
+		if err != nil {
+			return err
+		}
+		*secret.sealed = base64.StdEncoding.EncodeToString(sealed)
+		*secret.plain = ""
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package v2_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+func TestJoinEncryptedSecrets(t *testing.T) {
+	newAPI := func(apiServerArgs string) *v2.API {
+		return &v2.API{
+			Snap: &mock.Snap{
+				DqliteLock: true,
+				DqliteCert: "DQLITE CERTIFICATE DATA",
+				DqliteKey:  "DQLITE KEY DATA",
+				DqliteInfoYaml: `
+Address: 10.10.10.10:19001
+ID: 1238719276943521
+Role: 0
+`,
+				DqliteClusterYaml: `
+- Address: 10.10.10.10:19001
+  ID: 1238719276943521
+  Role: 0
+`,
+				CA:                "CA CERTIFICATE DATA",
+				CAKey:             "CA KEY DATA",
+				ServiceAccountKey: "SERVICE ACCOUNT KEY DATA",
+				EtcdCA:            "ETCD CA DATA",
+				EtcdCert:          "ETCD CERTIFICATE DATA",
+				EtcdKey:           "ETCD KEY DATA",
+				ServiceArguments: map[string]string{
+					"kubelet":        "kubelet arguments\n",
+					"kube-apiserver": apiServerArgs,
+					"cluster-agent":  "--bind=0.0.0.0:25000",
+				},
+				ClusterTokens:     []string{"control-plane-token"},
+				SelfCallbackToken: "callback-token",
+				KnownTokens:       map[string]string{"admin": "admin-token-123"},
+			},
+			LookupIP: func(string) ([]net.IP, error) {
+				return []net.IP{{10, 10, 10, 13}}, nil
+			},
+		}
+	}
+	newRequest := func(encryptionKey []byte) v2.JoinRequest {
+		return v2.JoinRequest{
+			ClusterToken:             "control-plane-token",
+			RemoteHostName:           "test-control-plane",
+			ClusterAgentPort:         "25000",
+			HostPort:                 "10.10.10.10:25000",
+			RemoteAddress:            "10.10.10.13:41532",
+			CanHandleCustomEtcd:      true,
+			CanHandleCertificateAuth: true,
+			EncryptionKey:            encryptionKey,
+		}
+	}
+
+	g := NewWithT(t)
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	decrypt := func(g Gomega, sealed string) string {
+		b, err := base64.StdEncoding.DecodeString(sealed)
+		g.Expect(err).ToNot(HaveOccurred())
+		message, err := util.Open(key, b)
+		g.Expect(err).ToNot(HaveOccurred())
+		return string(message)
+	}
+
+	t.Run("Dqlite", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := newAPI("--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n--token-auth-file=known_tokens.csv\n")
+
+		resp, rc, err := apiv2.Join(context.Background(), newRequest(key.PublicKey().Bytes()))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.CertificateAuthorityKey).To(BeNil())
+		g.Expect(resp.ServiceAccountKey).To(BeEmpty())
+		g.Expect(resp.AdminToken).To(BeEmpty())
+		g.Expect(resp.DqliteClusterKey).To(BeEmpty())
+		g.Expect(decrypt(g, resp.CertificateAuthorityKeyEnc)).To(Equal("CA KEY DATA"))
+		g.Expect(decrypt(g, resp.ServiceAccountKeyEnc)).To(Equal("SERVICE ACCOUNT KEY DATA"))
+		g.Expect(decrypt(g, resp.AdminTokenEnc)).To(Equal("admin-token-123"))
+		g.Expect(decrypt(g, resp.DqliteClusterKeyEnc)).To(Equal("DQLITE KEY DATA"))
+
+		b, err := json.Marshal(resp)
+		g.Expect(err).ToNot(HaveOccurred())
+		for _, secret := range []string{"CA KEY DATA", "SERVICE ACCOUNT KEY DATA", "admin-token-123", "DQLITE KEY DATA"} {
+			g.Expect(string(b)).ToNot(ContainSubstring(secret))
+		}
+	})
+
+	t.Run("CustomEtcd", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := newAPI("--secure-port 16443\n--etcd-servers=https://etcd1:2379\n")
+
+		resp, _, err := apiv2.Join(context.Background(), newRequest(key.PublicKey().Bytes()))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.EtcdClientKey).To(BeEmpty())
+		g.Expect(decrypt(g, resp.EtcdClientKeyEnc)).To(Equal("ETCD KEY DATA"))
+		g.Expect(resp.EtcdClientCertificate).To(Equal("ETCD CERTIFICATE DATA"))
+	})
+
+	t.Run("NotSupportedByClient", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := newAPI("--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n")
+
+		resp, _, err := apiv2.Join(context.Background(), newRequest(nil))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(*resp.CertificateAuthorityKey).To(Equal("CA KEY DATA"))
+		g.Expect(resp.CertificateAuthorityKeyEnc).To(BeEmpty())
+		g.Expect(resp.ServiceAccountKey).To(Equal("SERVICE ACCOUNT KEY DATA"))
+		g.Expect(resp.ServiceAccountKeyEnc).To(BeEmpty())
+	})
+
+	t.Run("EmptyKey", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := newAPI("--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n")
+
+		resp, _, err := apiv2.Join(context.Background(), newRequest([]byte{}))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(*resp.CertificateAuthorityKey).To(Equal("CA KEY DATA"))
+		g.Expect(resp.CertificateAuthorityKeyEnc).To(BeEmpty())
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		for _, tc := range []struct {
+			name string
+			key  []byte
+		}{
+			{name: "Short", key: []byte("invalid")},
+			{name: "Long", key: append(key.PublicKey().Bytes(), 0)},
+			{name: "Zero", key: make([]byte, 32)},
+			{name: "LowOrder", key: append([]byte{1}, make([]byte, 31)...)},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+				apiv2 := newAPI("--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n")
+
+				resp, rc, err := apiv2.Join(context.Background(), newRequest(tc.key))
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(rc).To(Equal(http.StatusBadRequest))
+				g.Expect(resp).To(BeNil())
+			})
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		g := NewWithT(t)
+		var req v2.JoinRequest
+		encoded := base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+		g.Expect(json.Unmarshal([]byte(`{"token":"control-plane-token","encryption_key":"`+encoded+`"}`), &req)).To(Succeed())
+		g.Expect(req.EncryptionKey).To(Equal(key.PublicKey().Bytes()))
+	})
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"testing"
 	"time"
 
 	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
 	utiltest "github.com/canonical/microk8s-cluster-agent/pkg/util/test"
 	. "github.com/onsi/gomega"
@@ -50,7 +52,7 @@ Role: 0
 			"kube-proxy":     "--cluster-cidr 10.1.0.0/16",
 			"cluster-agent":  "--bind=0.0.0.0:25000",
 		},
-		ClusterTokens:     []string{"worker-token", "control-plane-token", "valid-token-for-auth-test"},
+		ClusterTokens:     []string{"worker-token", "control-plane-token", "valid-token-for-auth-test", "approval-token"},
 		SelfCallbackToken: "callback-token",
 		CNIYaml:           cni,
 		KnownTokens: map[string]string{
@@ -79,7 +81,7 @@ Role: 0
 		resp, _, err := apiv2.Join(context.Background(), v2.JoinRequest{ClusterToken: "invalid-token"})
 		g.Expect(err).NotTo(BeNil())
 		g.Expect(resp).To(BeNil())
-		g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("invalid-token"))
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
 	})
 
 	t.Run("NoCertAuthNoTokensFile", func(t *testing.T) {
@@ -96,6 +98,11 @@ Role: 0
 		g.Expect(resp).To(BeNil())
 		g.Expect(err).NotTo(BeNil())
 		g.Expect(err.Error()).To(ContainSubstring("requires x509 authentication"))
+
+		// rejected joins must not make any changes
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+		g.Expect(s.CreateNoCertsReissueLockCalledWith).To(BeEmpty())
+		g.Expect(s.ApplyCNICalled).To(BeEmpty())
 		s.ServiceArguments["kube-apiserver"] = saveArgs
 	})
 
@@ -128,6 +135,9 @@ Role: 0
 			DqliteClusterCertificate:   "DQLITE CERTIFICATE DATA",
 			DqliteClusterKey:           "DQLITE KEY DATA",
 			ClusterCIDR:                "10.1.0.0/16",
+			ClusterCIDRs:               []string{"10.1.0.0/16"},
+			NodeIPs:                    []string{"10.10.10.13"},
+			NodeName:                   "test-control-plane",
 		}
 		g.Expect(resp).To(Equal(expectedResponse))
 		g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("control-plane-token"))
@@ -164,6 +174,9 @@ Role: 0
 			HostNameOverride:           "10.10.10.12",
 			ControlPlaneNodes:          []string{"10.0.0.1", "10.0.0.2"},
 			ClusterCIDR:                "10.1.0.0/16",
+			ClusterCIDRs:               []string{"10.1.0.0/16"},
+			NodeIPs:                    []string{"10.10.10.12"},
+			NodeName:                   "test-worker",
 		}
 
 		g.Expect(resp).To(Equal(expectedResponse))
@@ -172,6 +185,97 @@ Role: 0
 		g.Expect(s.CreateNoCertsReissueLockCalledWith).To(HaveLen(1))
 		g.Expect(s.AddCertificateRequestTokenCalledWith).To(ConsistOf("worker-token-kubelet", "worker-token-proxy"))
 	})
+
+	t.Run("DualStack", func(t *testing.T) {
+		g := NewWithT(t)
+
+		// Reset
+		s.ConsumeClusterTokenCalledWith = nil
+		s.ApplyCNICalled = nil
+		s.CNIYaml = cni
+		s.ClusterTokens = append(s.ClusterTokens, "dual-stack-token")
+		saveArgs := s.ServiceArguments
+		s.ServiceArguments = map[string]string{
+			"kubelet":        "kubelet arguments\n--node-ip=10.10.10.10,fd00::10\n",
+			"kube-apiserver": saveArgs["kube-apiserver"] + "--service-cluster-ip-range=10.152.183.0/24,fd98::/108\n",
+			"kube-proxy":     "--cluster-cidr 10.1.0.0/16,fd01::/64",
+			"cluster-agent":  saveArgs["cluster-agent"],
+		}
+		defer func() { s.ServiceArguments = saveArgs }()
+
+		resp, _, err := apiv2.Join(context.Background(), v2.JoinRequest{
+			ClusterToken:     "dual-stack-token",
+			RemoteHostName:   "test-worker",
+			RemoteAddress:    "10.10.10.12:31451",
+			NodeAddresses:    []string{"10.10.10.12", "fd00::12", "fd00::13"},
+			WorkerOnly:       true,
+			HostPort:         "10.10.10.10:25000",
+			ClusterAgentPort: "25000",
+		})
+		g.Expect(err).To(BeNil())
+		g.Expect(resp.ClusterCIDRs).To(Equal([]string{"10.1.0.0/16", "fd01::/64"}))
+		g.Expect(resp.ServiceCIDRs).To(Equal([]string{"10.152.183.0/24", "fd98::/108"}))
+		g.Expect(resp.NodeIPs).To(Equal([]string{"10.10.10.12", "fd00::12"}))
+		g.Expect(resp.KubeletArgs).To(ContainSubstring("--node-ip=10.10.10.12,fd00::12\n"))
+		g.Expect(resp.HostNameOverride).To(Equal("10.10.10.12"))
+		g.Expect(resp.NodeName).To(Equal("test-worker"))
+		g.Expect(s.CNIYaml).To(Equal(`
+- name: IP_AUTODETECTION_METHOD
+  value: "can-reach=10.10.10.12"
+- name: IP6_AUTODETECTION_METHOD
+  value: "can-reach=fd00::12"`))
+		g.Expect(s.ApplyCNICalled).To(HaveLen(1))
+
+		for _, address := range []string{"not-an-ip", "::1", "fe80::1"} {
+			_, rc, err := apiv2.Join(context.Background(), v2.JoinRequest{
+				ClusterToken:     "dual-stack-token",
+				RemoteHostName:   "test-worker",
+				RemoteAddress:    "10.10.10.12:31451",
+				NodeAddresses:    []string{address},
+				WorkerOnly:       true,
+				HostPort:         "10.10.10.10:25000",
+				ClusterAgentPort: "25000",
+			})
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(rc).To(Equal(http.StatusBadRequest))
+		}
+	})
+
+	t.Run("Approval", func(t *testing.T) {
+		g := NewWithT(t)
+
+		// Reset
+		s.ConsumeClusterTokenCalledWith = nil
+		s.AddCertificateRequestTokenCalledWith = nil
+		apiv2.JoinQueue = approval.NewQueue(approval.Options{})
+		defer func() { apiv2.JoinQueue = nil }()
+
+		req := v2.JoinRequest{
+			ClusterToken:     "approval-token",
+			RemoteHostName:   "test-worker",
+			RemoteAddress:    "10.10.10.12:31451",
+			WorkerOnly:       true,
+			HostPort:         "10.10.10.10:25000",
+			ClusterAgentPort: "25000",
+		}
+		resp, rc, err := apiv2.Join(context.Background(), req)
+		g.Expect(err).To(MatchError(approval.ErrPending))
+		g.Expect(rc).To(Equal(http.StatusAccepted))
+		g.Expect(resp).To(BeNil())
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+		g.Expect(s.AddCertificateRequestTokenCalledWith).To(BeEmpty())
+
+		requests := apiv2.JoinQueue.List()
+		g.Expect(requests).To(HaveLen(1))
+		g.Expect(requests[0].Hostname).To(Equal("test-worker"))
+		g.Expect(apiv2.JoinQueue.Decide(requests[0].ID, true)).To(Succeed())
+
+		resp, rc, err = apiv2.Join(context.Background(), req)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp).NotTo(BeNil())
+		g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("approval-token"))
+	})
 }
 
 // TestJoinFirstNode tests responses when joining a control plane node on a new cluster.
@@ -229,10 +333,10 @@ Role: 0
 	go func() {
 		// update cluster with new address
 		<-time.After(500 * time.Millisecond)
-		s.DqliteClusterYaml = `
+		s.SetDqliteClusterYaml(`
 - Address: 10.10.10.10:19001
   ID: 1238719276943521
-  Role: 0`
+  Role: 0`)
 	}()
 
 	resp, _, err := apiv2.Join(context.Background(), v2.JoinRequest{
@@ -260,6 +364,9 @@ Role: 0
 		DqliteClusterCertificate:   "DQLITE CERTIFICATE DATA",
 		DqliteClusterKey:           "DQLITE KEY DATA",
 		ClusterCIDR:                "10.1.0.0/16",
+		ClusterCIDRs:               []string{"10.1.0.0/16"},
+		NodeIPs:                    []string{"10.10.10.13"},
+		NodeName:                   "test-worker-nohostname",
 	}
 	g.Expect(resp).To(Equal(expectedResponse))
 	g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("control-plane-token"))
@@ -269,6 +376,116 @@ Role: 0
 	g.Expect(s.RestartServiceCalledWith).To(ConsistOf("k8s-dqlite"))
 }
 
+// consumedTokenSnap is a mock snap where cluster tokens are consumed by another join request after validation.
+type consumedTokenSnap struct {
+	*mock.Snap
+}
+
+func (s *consumedTokenSnap) ConsumeClusterToken(token string) bool {
+	s.Snap.ConsumeClusterToken(token)
+	return false
+}
+
+// TestJoinRollback tests that changes are reverted when a join fails after validation.
+func TestJoinRollback(t *testing.T) {
+	for _, tc := range []struct {
+		name                   string
+		updatedCluster         string
+		expectDqliteUpdateYaml []string
+	}{
+		{
+			name: "Default",
+			updatedCluster: `
+- Address: 10.10.10.10:19001
+  ID: 1238719276943521
+  Role: 0`,
+			expectDqliteUpdateYaml: []string{"Address: 10.10.10.10:19001\n", "Address: 127.0.0.1:19001\n"},
+		},
+		{
+			// another node joined using the new bind address, so it must not be reverted
+			name: "OtherNodeJoined",
+			updatedCluster: `
+- Address: 10.10.10.10:19001
+  ID: 1238719276943521
+  Role: 0
+- Address: 10.10.10.11:19001
+  ID: 1238719276943522
+  Role: 0`,
+			expectDqliteUpdateYaml: []string{"Address: 10.10.10.10:19001\n"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			cni := `
+- name: IP_AUTODETECTION_METHOD
+  value: "first-found"`
+			s := &mock.Snap{
+				DqliteLock: true,
+				DqliteInfoYaml: `
+Address: 127.0.0.1:19001
+ID: 1238719276943521
+Role: 0
+`,
+				DqliteClusterYaml: `
+- Address: 127.0.0.1:19001
+  ID: 1238719276943521
+  Role: 0
+`,
+				CA: "CA CERTIFICATE DATA",
+				ServiceArguments: map[string]string{
+					"kubelet":        "kubelet arguments\n",
+					"kube-apiserver": "--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n",
+					"cluster-agent":  "--bind=0.0.0.0:25000",
+				},
+				ClusterTokens: []string{"worker-token"},
+				CNIYaml:       cni,
+			}
+			apiv2 := &v2.API{
+				Snap: &consumedTokenSnap{Snap: s},
+				LookupIP: func(hostname string) ([]net.IP, error) {
+					return []net.IP{{10, 10, 10, 12}}, nil
+				},
+				InterfaceAddrs: func() ([]net.Addr, error) {
+					return []net.Addr{
+						&utiltest.MockCIDR{CIDR: "127.0.0.1/8"},
+						&utiltest.MockCIDR{CIDR: "10.10.10.10/16"},
+					}, nil
+				},
+				ListControlPlaneNodeIPs: mockListControlPlaneNodes("10.0.0.1"),
+			}
+
+			go func() {
+				// update cluster with new address
+				<-time.After(500 * time.Millisecond)
+				s.SetDqliteClusterYaml(tc.updatedCluster)
+			}()
+
+			resp, rc, err := apiv2.Join(context.Background(), v2.JoinRequest{
+				ClusterToken:     "worker-token",
+				RemoteHostName:   "test-worker",
+				RemoteAddress:    "10.10.10.12:31451",
+				WorkerOnly:       true,
+				HostPort:         "10.10.10.10:25000",
+				ClusterAgentPort: "25000",
+			})
+			g.Expect(err).To(MatchError("invalid token"))
+			g.Expect(rc).To(Equal(http.StatusInternalServerError))
+			g.Expect(resp).To(BeNil())
+
+			g.Expect(s.AddCertificateRequestTokenCalledWith).To(ConsistOf("worker-token-kubelet", "worker-token-proxy"))
+			g.Expect(s.ConsumeCertificateRequestTokenCalledWith).To(ConsistOf("worker-token-kubelet", "worker-token-proxy"))
+			g.Expect(s.TrackedCertificateRequestTokens).To(BeEmpty())
+			g.Expect(s.CreateNoCertsReissueLockCalledWith).To(HaveLen(1))
+			g.Expect(s.RemoveNoCertsReissueLockCalledWith).To(HaveLen(1))
+			g.Expect(s.NoCertsReissueLock).To(BeFalse())
+			g.Expect(s.WriteDqliteUpdateYamlCalledWith).To(Equal(tc.expectDqliteUpdateYaml))
+			g.Expect(s.CNIYaml).To(Equal(cni))
+			g.Expect(s.ApplyCNICalled).To(HaveLen(2))
+		})
+	}
+}
+
 // TestJoinWithoutDNSResolution tests that node joins are not rejected when the remote hostname does not resolve, but InternalIP is used for kubelet communication.
 func TestJoinWithoutDNSResolution(t *testing.T) {
 	g := NewWithT(t)
@@ -342,6 +559,9 @@ Role: 0`,
 		DqliteClusterCertificate:   "DQLITE CERTIFICATE DATA",
 		DqliteClusterKey:           "DQLITE KEY DATA",
 		ClusterCIDR:                "10.1.0.0/16",
+		ClusterCIDRs:               []string{"10.1.0.0/16"},
+		NodeIPs:                    []string{"10.10.10.13"},
+		NodeName:                   "test-worker-nohostname",
 	}
 	g.Expect(resp).To(Equal(expectedResponse))
 	g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("control-plane-token"))
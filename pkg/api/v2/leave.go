@@ -0,0 +1,229 @@
+package v2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// defaultDrainTimeout is the default timeout for draining a leaving node.
+const defaultDrainTimeout = 5 * time.Minute
+
+// LeaveRequest is the request message for the v2/leave API endpoint.
+type LeaveRequest struct {
+	// CallbackToken is the callback token of the cluster agent handling the request.
+	// Joining nodes receive it in the join response. This is retrieved from the request headers.
+	CallbackToken string `json:"-"`
+	// NodeName is the name of the leaving node. It must match the Node with the remote address of the request.
+	// If empty, the Node with the remote address of the request is removed.
+	NodeName string `json:"hostname"`
+	// ClusterAgentPort is the port number where the cluster-agent is listening on the leaving node.
+	ClusterAgentPort string `json:"port"`
+	// DqliteAddress is the address of the leaving node in the dqlite cluster. Its host must be the remote address of
+	// the request. If empty, the dqlite node matching the remote address of the request is removed, if any.
+	DqliteAddress string `json:"dqlite_address,omitempty"`
+	// DrainTimeoutSeconds is the timeout for draining the leaving node. Defaults to 5 minutes.
+	DrainTimeoutSeconds int `json:"drain_timeout_seconds,omitempty"`
+	// Force continues with removing the node even if it cannot be drained.
+	Force bool `json:"force"`
+	// HostPort is the hostname and port that accepted the request. This is retrieved directly from the *http.Request object.
+	HostPort string `json:"-"`
+	// RemoteAddress is the remote address from which the leave request originates. This is retrieved directly from the *http.Request object.
+	RemoteAddress string `json:"-"`
+}
+
+// LeaveStepStatus is the outcome of a single step of the leave operation.
+type LeaveStepStatus string
+
+const (
+	// LeaveStepOK means the step completed successfully.
+	LeaveStepOK LeaveStepStatus = "ok"
+	// LeaveStepSkipped means the step was not required for the leaving node.
+	LeaveStepSkipped LeaveStepStatus = "skipped"
+	// LeaveStepFailed means the step failed.
+	LeaveStepFailed LeaveStepStatus = "failed"
+)
+
+// LeaveStep is the result of a single step of the leave operation.
+type LeaveStep struct {
+	// Name is the name of the step.
+	Name string `json:"name"`
+	// Status is the outcome of the step.
+	Status LeaveStepStatus `json:"status"`
+	// Message is a human-readable description of the outcome.
+	Message string `json:"message,omitempty"`
+}
+
+// LeaveResponse is the response message for the v2/leave API endpoint.
+type LeaveResponse struct {
+	// Steps are the results of each step of the leave operation, in the order they were executed.
+	Steps []LeaveStep `json:"steps"`
+	// Error is set if the leave operation did not complete.
+	Error string `json:"error,omitempty"`
+}
+
+func (r *LeaveResponse) addStep(name string, status LeaveStepStatus, format string, args ...interface{}) {
+	r.Steps = append(r.Steps, LeaveStep{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+}
+
+// fail records a failed step and returns the error.
+func (r *LeaveResponse) fail(name string, err error) error {
+	r.addStep(name, LeaveStepFailed, "%v", err)
+	r.Error = err.Error()
+	return err
+}
+
+// checkDqliteRemoval verifies that removing a node from the dqlite cluster will not cause the cluster to lose quorum.
+func checkDqliteRemoval(nodes []dqlite.NodeInfo, address string) error {
+	node, err := dqlite.FindNode(nodes, address)
+	if err != nil {
+		return err
+	}
+	if node.Role != dqlite.Voter {
+		return nil
+	}
+	var voters int
+	for _, n := range nodes {
+		if n.Role == dqlite.Voter {
+			voters++
+		}
+	}
+	if voters <= 1 {
+		return fmt.Errorf("refusing to remove %s, as it is the last voter node of the dqlite cluster", address)
+	}
+	return nil
+}
+
+// findDqliteNodeByHost returns the address of the dqlite node running on host, if any.
+func findDqliteNodeByHost(nodes []dqlite.NodeInfo, host string) string {
+	for _, node := range nodes {
+		if splitHostIP(node.Address) == host {
+			return node.Address
+		}
+	}
+	return ""
+}
+
+// Leave implements "POST v2/leave".
+// Leave drains the leaving node, removes it from the dqlite cluster, deletes the Kubernetes Node and revokes any
+// credentials issued to it during join. Leave returns the result of each step, along with the HTTP status code.
+//
+// The callback token is shared by all nodes of the cluster, so a node may only remove itself. The leaving node is the
+// Node with the remote address of the request, and a different node name or dqlite address is rejected.
+func (a *API) Leave(ctx context.Context, req LeaveRequest) (*LeaveResponse, int, error) {
+	response := &LeaveResponse{}
+	if !a.Snap.ConsumeSelfCallbackToken(req.CallbackToken) {
+		return response, http.StatusUnauthorized, response.fail("authenticate", fmt.Errorf("invalid token"))
+	}
+
+	remoteIP := splitHostIP(req.RemoteAddress)
+	if hostIP := splitHostIP(req.HostPort); remoteIP == hostIP {
+		return response, http.StatusServiceUnavailable, response.fail("authenticate", fmt.Errorf("the leaving node has the same IP (%s) as the node we contact", hostIP))
+	}
+	nodeName, err := a.getNodeNameByIP(ctx, remoteIP)
+	if err != nil {
+		return response, http.StatusForbidden, response.fail("authenticate", fmt.Errorf("failed to find the leaving node: %w", err))
+	}
+	if req.NodeName != "" && util.NodeName(req.NodeName) != nodeName {
+		return response, http.StatusForbidden, response.fail("authenticate", fmt.Errorf("node %s with IP %s cannot remove node %s", nodeName, remoteIP, req.NodeName))
+	}
+	if req.DqliteAddress != "" && splitHostIP(req.DqliteAddress) != remoteIP {
+		return response, http.StatusForbidden, response.fail("authenticate", fmt.Errorf("node %s with IP %s cannot remove dqlite node %s", nodeName, remoteIP, req.DqliteAddress))
+	}
+	response.addStep("authenticate", LeaveStepOK, "node %s", nodeName)
+
+	// Check that the node can be removed from dqlite before making any changes.
+	var (
+		dqliteClient  dqlite.Client
+		dqliteAddress string
+	)
+	if a.Snap.HasDqliteLock() {
+		dqliteClient = a.dqliteClient()
+		nodes, err := dqliteClient.Cluster(ctx)
+		if err != nil {
+			return response, http.StatusInternalServerError, response.fail("check-quorum", fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err))
+		}
+		if _, err := dqliteClient.Leader(ctx); err != nil {
+			return response, http.StatusServiceUnavailable, response.fail("check-quorum", fmt.Errorf("dqlite cluster has no leader: %w", err))
+		}
+
+		dqliteAddress = req.DqliteAddress
+		if dqliteAddress == "" {
+			dqliteAddress = findDqliteNodeByHost(nodes, remoteIP)
+		}
+		switch err := checkDqliteRemoval(nodes, dqliteAddress); {
+		case dqliteAddress == "" || errors.Is(err, dqlite.ErrNodeNotFound):
+			dqliteAddress = ""
+			response.addStep("check-quorum", LeaveStepSkipped, "node is not part of the dqlite cluster")
+		case err != nil:
+			return response, http.StatusConflict, response.fail("check-quorum", err)
+		default:
+			response.addStep("check-quorum", LeaveStepOK, "")
+		}
+	} else {
+		response.addStep("check-quorum", LeaveStepSkipped, "cluster does not use dqlite")
+	}
+
+	drainTimeout := defaultDrainTimeout
+	if req.DrainTimeoutSeconds > 0 {
+		drainTimeout = time.Duration(req.DrainTimeoutSeconds) * time.Second
+	}
+	if err := snaputil.DrainNode(ctx, a.Snap, nodeName, drainTimeout); err != nil {
+		if !req.Force {
+			return response, http.StatusInternalServerError, response.fail("drain", err)
+		}
+		response.addStep("drain", LeaveStepFailed, "%v (ignored)", err)
+	} else {
+		response.addStep("drain", LeaveStepOK, "")
+	}
+
+	if dqliteAddress != "" {
+		a.dqliteMu.Lock()
+		err := dqliteClient.Remove(ctx, dqliteAddress)
+		a.dqliteMu.Unlock()
+		if err != nil {
+			return response, http.StatusInternalServerError, response.fail("remove-dqlite", fmt.Errorf("failed to remove %s from dqlite: %w", dqliteAddress, err))
+		}
+		response.addStep("remove-dqlite", LeaveStepOK, "removed %s", dqliteAddress)
+	} else {
+		response.addStep("remove-dqlite", LeaveStepSkipped, "node is not part of the dqlite cluster")
+	}
+
+	if err := snaputil.DeleteNode(ctx, a.Snap, nodeName); err != nil {
+		return response, http.StatusInternalServerError, response.fail("delete-node", err)
+	}
+	response.addStep("delete-node", LeaveStepOK, "")
+
+	// Callback tokens are keyed by the cluster agent endpoint of the node, see v1/join.
+	clusterAgentEndpoint := net.JoinHostPort(util.GetRemoteHost(a.LookupIP, nodeName, req.RemoteAddress), req.ClusterAgentPort)
+	if err := a.Snap.RemoveCallbackToken(clusterAgentEndpoint); err != nil {
+		return response, http.StatusInternalServerError, response.fail("revoke-callback-token", fmt.Errorf("failed to remove callback token for %s: %w", clusterAgentEndpoint, err))
+	}
+	response.addStep("revoke-callback-token", LeaveStepOK, "")
+
+	revoked, err := a.Snap.RevokeCertificateRequestTokens(nodeName)
+	if err != nil {
+		return response, http.StatusInternalServerError, response.fail("revoke-certificate-request-tokens", err)
+	}
+	response.addStep("revoke-certificate-request-tokens", LeaveStepOK, "revoked %d pending tokens", len(revoked))
+
+	if err := a.Snap.RevokeKubeletToken(nodeName); err != nil {
+		return response, http.StatusInternalServerError, response.fail("revoke-kubelet-token", err)
+	}
+	response.addStep("revoke-kubelet-token", LeaveStepOK, "")
+
+	certificates, err := a.Snap.ForgetNodeCertificates(nodeName)
+	if err != nil {
+		return response, http.StatusInternalServerError, response.fail("forget-certificates", err)
+	}
+	response.addStep("forget-certificates", LeaveStepOK, "%d certificates remain valid until they expire or the cluster CA is rotated", len(certificates))
+
+	return response, http.StatusOK, nil
+}
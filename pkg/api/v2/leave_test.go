@@ -0,0 +1,250 @@
+package v2_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
+	dqlitemock "github.com/canonical/microk8s-cluster-agent/pkg/dqlite/mock"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+)
+
+func TestLeave(t *testing.T) {
+	lookupIP := func(string) ([]net.IP, error) { return nil, errors.New("no such host") }
+	getNodeNameByIP := func(_ context.Context, _ snap.Snap, ip string) (string, error) {
+		if name, ok := map[string]string{"10.0.0.1": "node-1", "10.0.0.2": "node-2"}[ip]; ok {
+			return name, nil
+		}
+		return "", errors.New("no node with address " + ip)
+	}
+	newRequest := func() v2.LeaveRequest {
+		return v2.LeaveRequest{
+			CallbackToken:    "callback-token",
+			NodeName:         "node-2",
+			ClusterAgentPort: "25000",
+			HostPort:         "10.0.0.1:25000",
+			RemoteAddress:    "10.0.0.2:41532",
+		}
+	}
+	stepStatuses := func(resp *v2.LeaveResponse) map[string]v2.LeaveStepStatus {
+		statuses := make(map[string]v2.LeaveStepStatus, len(resp.Steps))
+		for _, step := range resp.Steps {
+			statuses[step.Name] = step.Status
+		}
+		return statuses
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{
+			SnapDir:                  "/snap",
+			DqliteLock:               true,
+			SelfCallbackTokens:       []string{"callback-token"},
+			CertificateRequestTokens: []string{"token-kubelet", "token-proxy"},
+			TrackedCertificateRequestTokens: map[string][]string{
+				"node-2": {"token-kubelet", "token-proxy"},
+			},
+		}
+		client := &dqlitemock.Client{
+			LeaderAddress: "10.0.0.1:19001",
+			Nodes: []dqlite.NodeInfo{
+				{ID: 1, Address: "10.0.0.1:19001", Role: dqlite.Voter},
+				{ID: 2, Address: "10.0.0.2:19001", Role: dqlite.Voter},
+			},
+		}
+		apiv2 := &v2.API{
+			Snap:            s,
+			LookupIP:        lookupIP,
+			GetNodeNameByIP: getNodeNameByIP,
+			NewDqliteClient: func(snap.Snap) dqlite.Client { return client },
+		}
+
+		resp, rc, err := apiv2.Leave(context.Background(), newRequest())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(stepStatuses(resp)).To(Equal(map[string]v2.LeaveStepStatus{
+			"authenticate":                      v2.LeaveStepOK,
+			"check-quorum":                      v2.LeaveStepOK,
+			"drain":                             v2.LeaveStepOK,
+			"remove-dqlite":                     v2.LeaveStepOK,
+			"delete-node":                       v2.LeaveStepOK,
+			"revoke-callback-token":             v2.LeaveStepOK,
+			"revoke-certificate-request-tokens": v2.LeaveStepOK,
+			"revoke-kubelet-token":              v2.LeaveStepOK,
+			"forget-certificates":               v2.LeaveStepOK,
+		}))
+
+		g.Expect(client.RemoveCalledWith).To(ConsistOf("10.0.0.2:19001"))
+		g.Expect(s.RunCommandCalledWith).To(Equal([]mock.RunCommandCall{
+			{Commands: []string{"/snap/microk8s-kubectl.wrapper", "drain", "node-2", "--ignore-daemonsets", "--delete-emptydir-data", "--force", "--timeout=5m0s"}},
+			{Commands: []string{"/snap/microk8s-kubectl.wrapper", "delete", "node", "node-2", "--ignore-not-found"}},
+		}))
+		g.Expect(s.RemoveCallbackTokenCalledWith).To(ConsistOf("10.0.0.2:25000"))
+		g.Expect(s.RevokeCertificateRequestTokensCalledWith).To(ConsistOf("node-2"))
+		g.Expect(s.TrackedCertificateRequestTokens).To(BeEmpty())
+		g.Expect(s.RevokeKubeletTokenCalledWith).To(ConsistOf("node-2"))
+		g.Expect(s.ForgetNodeCertificatesCalledWith).To(ConsistOf("node-2"))
+	})
+
+	t.Run("WorkerNode", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{
+			SelfCallbackTokens: []string{"callback-token"},
+		}
+		apiv2 := &v2.API{Snap: s, LookupIP: lookupIP, GetNodeNameByIP: getNodeNameByIP}
+
+		resp, rc, err := apiv2.Leave(context.Background(), newRequest())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(stepStatuses(resp)).To(HaveKeyWithValue("check-quorum", v2.LeaveStepSkipped))
+		g.Expect(stepStatuses(resp)).To(HaveKeyWithValue("remove-dqlite", v2.LeaveStepSkipped))
+	})
+
+	t.Run("LastVoter", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{
+			DqliteLock:         true,
+			SelfCallbackTokens: []string{"callback-token"},
+		}
+		client := &dqlitemock.Client{
+			LeaderAddress: "10.0.0.1:19001",
+			Nodes: []dqlite.NodeInfo{
+				{ID: 1, Address: "10.0.0.1:19001", Role: dqlite.Spare},
+				{ID: 2, Address: "10.0.0.2:19001", Role: dqlite.Voter},
+			},
+		}
+		apiv2 := &v2.API{
+			Snap:            s,
+			LookupIP:        lookupIP,
+			GetNodeNameByIP: getNodeNameByIP,
+			NewDqliteClient: func(snap.Snap) dqlite.Client { return client },
+		}
+
+		resp, rc, err := apiv2.Leave(context.Background(), newRequest())
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusConflict))
+		g.Expect(resp.Error).ToNot(BeEmpty())
+		g.Expect(stepStatuses(resp)).To(HaveKeyWithValue("check-quorum", v2.LeaveStepFailed))
+		g.Expect(client.RemoveCalledWith).To(BeEmpty())
+		g.Expect(s.RunCommandCalledWith).To(BeEmpty())
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		apiv2 := &v2.API{Snap: s, LookupIP: lookupIP, GetNodeNameByIP: getNodeNameByIP}
+
+		resp, rc, err := apiv2.Leave(context.Background(), newRequest())
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusUnauthorized))
+		g.Expect(stepStatuses(resp)).To(Equal(map[string]v2.LeaveStepStatus{"authenticate": v2.LeaveStepFailed}))
+		g.Expect(s.RunCommandCalledWith).To(BeEmpty())
+	})
+
+	t.Run("SameIP", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := &v2.API{Snap: &mock.Snap{SelfCallbackTokens: []string{"callback-token"}}, LookupIP: lookupIP, GetNodeNameByIP: getNodeNameByIP}
+
+		req := newRequest()
+		req.RemoteAddress = "10.0.0.1:41532"
+		_, rc, err := apiv2.Leave(context.Background(), req)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	t.Run("DrainFails", func(t *testing.T) {
+		for _, force := range []bool{false, true} {
+			t.Run(map[bool]string{false: "NoForce", true: "Force"}[force], func(t *testing.T) {
+				g := NewWithT(t)
+				s := &mock.Snap{
+					SelfCallbackTokens: []string{"callback-token"},
+					RunCommandErr:      errors.New("failed to run command"),
+				}
+				apiv2 := &v2.API{Snap: s, LookupIP: lookupIP, GetNodeNameByIP: getNodeNameByIP}
+
+				req := newRequest()
+				req.Force = force
+				resp, rc, err := apiv2.Leave(context.Background(), req)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(rc).To(Equal(http.StatusInternalServerError))
+				g.Expect(stepStatuses(resp)).To(HaveKeyWithValue("drain", v2.LeaveStepFailed))
+				if force {
+					// the mock fails all commands, so deleting the node fails as well
+					g.Expect(stepStatuses(resp)).To(HaveKeyWithValue("delete-node", v2.LeaveStepFailed))
+				} else {
+					g.Expect(stepStatuses(resp)).ToNot(HaveKey("delete-node"))
+				}
+			})
+		}
+	})
+
+	t.Run("OtherNode", func(t *testing.T) {
+		for _, tc := range []struct {
+			name          string
+			nodeName      string
+			dqliteAddress string
+			remoteAddress string
+		}{
+			{name: "NodeName", nodeName: "node-1"},
+			{name: "DqliteAddress", dqliteAddress: "10.0.0.1:19001"},
+			{name: "UnknownNode", remoteAddress: "10.0.0.3:41532"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+				s := &mock.Snap{DqliteLock: true, SelfCallbackTokens: []string{"callback-token"}}
+				client := &dqlitemock.Client{
+					LeaderAddress: "10.0.0.1:19001",
+					Nodes: []dqlite.NodeInfo{
+						{ID: 1, Address: "10.0.0.1:19001", Role: dqlite.Voter},
+						{ID: 2, Address: "10.0.0.2:19001", Role: dqlite.Voter},
+						{ID: 3, Address: "10.0.0.3:19001", Role: dqlite.Voter},
+					},
+				}
+				apiv2 := &v2.API{
+					Snap:            s,
+					LookupIP:        lookupIP,
+					GetNodeNameByIP: getNodeNameByIP,
+					NewDqliteClient: func(snap.Snap) dqlite.Client { return client },
+				}
+
+				req := newRequest()
+				if tc.nodeName != "" {
+					req.NodeName = tc.nodeName
+				}
+				if tc.remoteAddress != "" {
+					req.RemoteAddress = tc.remoteAddress
+				}
+				req.DqliteAddress = tc.dqliteAddress
+				resp, rc, err := apiv2.Leave(context.Background(), req)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(rc).To(Equal(http.StatusForbidden))
+				g.Expect(stepStatuses(resp)).To(Equal(map[string]v2.LeaveStepStatus{"authenticate": v2.LeaveStepFailed}))
+				g.Expect(client.RemoveCalledWith).To(BeEmpty())
+				g.Expect(s.RunCommandCalledWith).To(BeEmpty())
+			})
+		}
+	})
+
+	t.Run("NodeNameFromAddress", func(t *testing.T) {
+		for _, nodeName := range []string{"", "Node-2"} {
+			g := NewWithT(t)
+			s := &mock.Snap{SnapDir: "/snap", SelfCallbackTokens: []string{"callback-token"}}
+			apiv2 := &v2.API{Snap: s, LookupIP: lookupIP, GetNodeNameByIP: getNodeNameByIP}
+
+			req := newRequest()
+			req.NodeName = nodeName
+			_, rc, err := apiv2.Leave(context.Background(), req)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(rc).To(Equal(http.StatusOK))
+			g.Expect(s.RunCommandCalledWith).To(ContainElement(mock.RunCommandCall{Commands: []string{"/snap/microk8s-kubectl.wrapper", "delete", "node", "node-2", "--ignore-not-found"}}))
+			g.Expect(s.RevokeCertificateRequestTokensCalledWith).To(ConsistOf("node-2"))
+		}
+	})
+}
@@ -2,6 +2,7 @@ package v2
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/httputil"
@@ -35,6 +36,80 @@ func (a *API) RegisterServer(server *http.ServeMux, middleware func(f http.Handl
 		httputil.Response(w, response)
 	}))
 
+	// POST v2/join/preflight
+	server.HandleFunc(fmt.Sprintf("%s/join/preflight", HTTPPrefix), middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := JoinRequest{}
+		if err := httputil.UnmarshalJSON(r, &req); err != nil {
+			httputil.Error(w, http.StatusBadRequest, fmt.Errorf("failed to unmarshal JSON: %w", err))
+			return
+		}
+
+		req.HostPort = r.Host
+		req.RemoteAddress = r.RemoteAddr
+
+		response, rc, err := a.JoinPreflight(r.Context(), req)
+		if err != nil {
+			log.Printf("[ERROR %d] join preflight for %s failed: %q", rc, req.RemoteHostName, err)
+		}
+		w.WriteHeader(rc)
+		httputil.Response(w, response)
+	}))
+
+	// POST v2/join/discovery
+	server.HandleFunc(fmt.Sprintf("%s/join/discovery", HTTPPrefix), middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := JoinDiscoveryRequest{}
+		if err := httputil.UnmarshalJSON(r, &req); err != nil {
+			httputil.Error(w, http.StatusBadRequest, fmt.Errorf("failed to unmarshal JSON: %w", err))
+			return
+		}
+
+		response, rc, err := a.JoinDiscovery(r.Context(), req)
+		if err != nil {
+			httputil.Error(w, rc, err)
+			return
+		}
+		httputil.Response(w, response)
+	}))
+
+	// GET, POST v2/join/requests
+	server.HandleFunc(fmt.Sprintf("%s/join/requests", HTTPPrefix), middleware(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			response, rc, err := a.ListJoinRequests(r.Context(), r.Header.Get(AdminTokenHeader))
+			if err != nil {
+				httputil.Error(w, rc, err)
+				return
+			}
+			httputil.Response(w, response)
+		case http.MethodPost:
+			req := DecideJoinRequestRequest{}
+			if err := httputil.UnmarshalJSON(r, &req); err != nil {
+				httputil.Error(w, http.StatusBadRequest, fmt.Errorf("failed to unmarshal JSON: %w", err))
+				return
+			}
+
+			req.AdminToken = r.Header.Get(AdminTokenHeader)
+
+			if rc, err := a.DecideJoinRequest(r.Context(), req); err != nil {
+				httputil.Error(w, rc, err)
+				return
+			}
+			httputil.Response(w, map[string]string{"status": "OK"})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+
 	// POST v2/image/import
 	server.HandleFunc(fmt.Sprintf("%s/image/import", HTTPPrefix), middleware(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -43,7 +118,7 @@ func (a *API) RegisterServer(server *http.ServeMux, middleware func(f http.Handl
 		}
 
 		req := &ImageImportRequest{
-			Token:           r.Header.Get("x-microk8s-callback-token"),
+			Token:           r.Header.Get(CallbackTokenHeader),
 			ImageDataReader: r.Body,
 		}
 		rc, err := a.ImageImport(r.Context(), req)
@@ -76,4 +151,53 @@ func (a *API) RegisterServer(server *http.ServeMux, middleware func(f http.Handl
 
 		httputil.Response(w, nil)
 	}))
+
+	// POST v2/leave
+	server.HandleFunc(fmt.Sprintf("%s/leave", HTTPPrefix), middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := LeaveRequest{}
+		if err := httputil.UnmarshalJSON(r, &req); err != nil {
+			httputil.Error(w, http.StatusBadRequest, fmt.Errorf("failed to unmarshal JSON: %w", err))
+			return
+		}
+
+		req.CallbackToken = r.Header.Get(CallbackTokenHeader)
+		req.RemoteAddress = r.RemoteAddr
+		req.HostPort = r.Host
+
+		response, rc, err := a.Leave(r.Context(), req)
+		if err != nil {
+			log.Printf("[ERROR %d] failed to remove node %s: %q", rc, req.NodeName, err)
+		}
+		w.WriteHeader(rc)
+		httputil.Response(w, response)
+	}))
+
+	// POST v2/node/remove
+	server.HandleFunc(fmt.Sprintf("%s/node/remove", HTTPPrefix), middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := RemoveNodeRequest{}
+		if err := httputil.UnmarshalJSON(r, &req); err != nil {
+			httputil.Error(w, http.StatusBadRequest, fmt.Errorf("failed to unmarshal JSON: %w", err))
+			return
+		}
+
+		req.CallbackToken = r.Header.Get(CallbackTokenHeader)
+
+		response, rc, err := a.RemoveNode(r.Context(), req)
+		if err != nil {
+			httputil.Error(w, rc, fmt.Errorf("failed to remove node: %w", err))
+			return
+		}
+
+		httputil.Response(w, response)
+	}))
 }
@@ -25,7 +25,7 @@ func (a *API) RemoveFromDqlite(ctx context.Context, req RemoveFromDqliteRequest,
 		return http.StatusUnauthorized, fmt.Errorf("invalid CAPI auth token %q", token)
 	}
 
-	if err := snaputil.RemoveNodeFromDqlite(ctx, a.Snap, req.RemoveEndpoint); err != nil {
+	if err := snaputil.RemoveNodeFromDqliteWithClient(ctx, a.dqliteClient(), req.RemoveEndpoint); err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("failed to remove node from dqlite: %w", err)
 	}
 
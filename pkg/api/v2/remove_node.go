@@ -0,0 +1,61 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	snaputil "github.com/canonical/microk8s-cluster-agent/pkg/snap/util"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// RemoveNodeRequest is the request message for the v2/node/remove API endpoint.
+type RemoveNodeRequest struct {
+	// CallbackToken is the callback token of the cluster agent handling the request.
+	// This is retrieved from the request headers.
+	CallbackToken string `json:"-"`
+	// NodeName is the name of the node to remove.
+	NodeName string `json:"hostname"`
+}
+
+// RemoveNodeResponse is the response message for the v2/node/remove API endpoint.
+type RemoveNodeResponse struct {
+	// RevokedCertificateRequestTokens is the number of pending certificate request tokens of the node that were revoked.
+	RevokedCertificateRequestTokens int `json:"revoked_certificate_request_tokens"`
+	// ValidCertificates is the list of serial numbers of certificates issued to the node.
+	// Certificates cannot be revoked, they remain valid until they expire or the cluster CA is rotated.
+	ValidCertificates []string `json:"valid_certificates"`
+}
+
+// RemoveNode implements "POST v2/node/remove".
+// RemoveNode revokes the tokens issued to a worker node when it joined the cluster, and deletes the Kubernetes Node.
+// Control plane nodes should leave the cluster instead, see Leave.
+func (a *API) RemoveNode(ctx context.Context, req RemoveNodeRequest) (*RemoveNodeResponse, int, error) {
+	if !a.Snap.ConsumeSelfCallbackToken(req.CallbackToken) {
+		return nil, http.StatusUnauthorized, fmt.Errorf("invalid token")
+	}
+	if req.NodeName == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("missing name of node to remove")
+	}
+	nodeName := util.NodeName(req.NodeName)
+
+	if err := a.Snap.RevokeKubeletToken(nodeName); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to revoke kubelet token: %w", err)
+	}
+	revokedTokens, err := a.Snap.RevokeCertificateRequestTokens(nodeName)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to revoke certificate request tokens: %w", err)
+	}
+	validCertificates, err := a.Snap.ForgetNodeCertificates(nodeName)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to retrieve node certificates: %w", err)
+	}
+	if err := snaputil.DeleteNode(ctx, a.Snap, nodeName); err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return &RemoveNodeResponse{
+		RevokedCertificateRequestTokens: len(revokedTokens),
+		ValidCertificates:               validCertificates,
+	}, http.StatusOK, nil
+}
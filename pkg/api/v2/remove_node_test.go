@@ -0,0 +1,81 @@
+package v2_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+)
+
+func TestRemoveNode(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{
+			SnapDir:                  "/snap",
+			SelfCallbackTokens:       []string{"callback-token"},
+			KubeletTokens:            map[string]string{"node-2": "kubelet-token"},
+			CertificateRequestTokens: []string{"token-kubelet", "token-proxy"},
+			TrackedCertificateRequestTokens: map[string][]string{
+				"node-2": {"token-kubelet", "token-proxy"},
+			},
+			NodeCertificates: map[string][]string{
+				"node-2": {"1A2B", "3C4D"},
+			},
+		}
+		apiv2 := &v2.API{Snap: s}
+
+		resp, rc, err := apiv2.RemoveNode(context.Background(), v2.RemoveNodeRequest{CallbackToken: "callback-token", NodeName: "node-2"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp).To(Equal(&v2.RemoveNodeResponse{
+			RevokedCertificateRequestTokens: 2,
+			ValidCertificates:               []string{"1A2B", "3C4D"},
+		}))
+
+		g.Expect(s.KubeletTokens).To(BeEmpty())
+		g.Expect(s.TrackedCertificateRequestTokens).To(BeEmpty())
+		g.Expect(s.NodeCertificates).To(BeEmpty())
+		g.Expect(s.RunCommandCalledWith).To(Equal([]mock.RunCommandCall{
+			{Commands: []string{"/snap/microk8s-kubectl.wrapper", "delete", "node", "node-2", "--ignore-not-found"}},
+		}))
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		g := NewWithT(t)
+		s := &mock.Snap{}
+		apiv2 := &v2.API{Snap: s}
+
+		_, rc, err := apiv2.RemoveNode(context.Background(), v2.RemoveNodeRequest{CallbackToken: "invalid", NodeName: "node-2"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusUnauthorized))
+		g.Expect(s.RevokeKubeletTokenCalledWith).To(BeEmpty())
+		g.Expect(s.RunCommandCalledWith).To(BeEmpty())
+	})
+
+	t.Run("MissingNodeName", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv2 := &v2.API{Snap: &mock.Snap{SelfCallbackTokens: []string{"callback-token"}}}
+
+		_, rc, err := apiv2.RemoveNode(context.Background(), v2.RemoveNodeRequest{CallbackToken: "callback-token"})
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusBadRequest))
+	})
+
+	t.Run("DeleteNodeFails", func(t *testing.T) {
+		g := NewWithT(t)
+		cmdErr := errors.New("failed to run command")
+		apiv2 := &v2.API{Snap: &mock.Snap{
+			SelfCallbackTokens: []string{"callback-token"},
+			RunCommandErr:      cmdErr,
+		}}
+
+		_, rc, err := apiv2.RemoveNode(context.Background(), v2.RemoveNodeRequest{CallbackToken: "callback-token", NodeName: "node-2"})
+		g.Expect(err).To(MatchError(cmdErr))
+		g.Expect(rc).To(Equal(http.StatusInternalServerError))
+	})
+}
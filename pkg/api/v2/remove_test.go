@@ -9,6 +9,9 @@ import (
 	. "github.com/onsi/gomega"
 
 	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
+	dqlitemock "github.com/canonical/microk8s-cluster-agent/pkg/dqlite/mock"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
 )
 
@@ -71,4 +74,29 @@ func TestRemove(t *testing.T) {
 		g.Expect(err).ToNot(HaveOccurred())
 		g.Expect(rc).To(Equal(http.StatusOK))
 	})
+
+	t.Run("RemovesWithDqliteClient", func(t *testing.T) {
+		client := &dqlitemock.Client{
+			Nodes: []dqlite.NodeInfo{
+				{ID: 1, Address: "1.1.1.1:1234"},
+				{ID: 2, Address: "2.2.2.2:1234"},
+			},
+		}
+		s := &mock.Snap{
+			CAPIAuthTokenValid: true,
+		}
+		apiv2 := &v2.API{
+			Snap:            s,
+			NewDqliteClient: func(snap.Snap) dqlite.Client { return client },
+		}
+
+		rc, err := apiv2.RemoveFromDqlite(context.Background(), v2.RemoveFromDqliteRequest{RemoveEndpoint: "1.1.1.1:1234"}, "token")
+
+		g := NewWithT(t)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(client.RemoveCalledWith).To(ConsistOf("1.1.1.1:1234"))
+		g.Expect(client.Nodes).To(ConsistOf(dqlite.NodeInfo{ID: 2, Address: "2.2.2.2:1234"}))
+		g.Expect(s.RunCommandCalledWith).To(BeEmpty())
+	})
 }
@@ -0,0 +1,13 @@
+package v3
+
+import (
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+)
+
+// API implements the v3 API.
+// The v3 API negotiates the protocol version and capabilities with the joining node, and uses the v2 API to perform
+// the actual join.
+type API struct {
+	// V2 is the v2 API.
+	V2 *v2.API
+}
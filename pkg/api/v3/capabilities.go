@@ -0,0 +1,133 @@
+package v3
+
+import (
+	"fmt"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+)
+
+// ProtocolVersion is the latest join protocol version supported by this cluster agent.
+const ProtocolVersion = 3
+
+// SupportedProtocolVersions are the join protocol versions supported by this cluster agent, newest first.
+var SupportedProtocolVersions = []int{ProtocolVersion}
+
+// Capability is a feature of the join protocol that a node knows how to handle.
+type Capability string
+
+const (
+	// CapabilityCustomEtcd is set by nodes that can join clusters using an external etcd as datastore.
+	CapabilityCustomEtcd Capability = "custom-etcd"
+	// CapabilityCertificateAuth is set by nodes that can generate x509 certificates for cluster authentication instead
+	// of using auth tokens.
+	CapabilityCertificateAuth Capability = "x509-auth"
+	// CapabilityCertificateSigningRequests is set by nodes that can join as control plane nodes by sending certificate
+	// signing requests for their certificates, instead of receiving the CA key.
+	CapabilityCertificateSigningRequests Capability = "csr"
+	// CapabilityEncryptedSecrets is set by nodes that send an encryption key, and can decrypt the secrets of the join
+	// response from its *_enc fields.
+	CapabilityEncryptedSecrets Capability = "encrypted-secrets"
+)
+
+// SupportedCapabilities are the capabilities supported by this cluster agent.
+var SupportedCapabilities = []Capability{CapabilityCustomEtcd, CapabilityCertificateAuth, CapabilityCertificateSigningRequests, CapabilityEncryptedSecrets}
+
+// Mode is the role of the joining node in the cluster.
+type Mode string
+
+const (
+	// ModeControlPlane is used for nodes that join the cluster as control plane nodes.
+	ModeControlPlane Mode = "control-plane"
+	// ModeWorker is used for nodes that join the cluster as worker-only nodes.
+	ModeWorker Mode = "worker"
+)
+
+// negotiation is the result of a successful negotiation with a joining node.
+type negotiation struct {
+	// protocolVersion is the join protocol version that is used.
+	protocolVersion int
+	// mode is the role of the joining node.
+	mode Mode
+	// capabilities are the capabilities supported by both sides.
+	capabilities []Capability
+}
+
+// has returns true if a capability is supported by both sides.
+func (n negotiation) has(capability Capability) bool {
+	for _, c := range n.capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredCapabilities returns the capabilities that a node needs to join the cluster in a mode.
+func (a *API) requiredCapabilities(mode Mode) []Capability {
+	var required []Capability
+	if !a.V2.KubeAPIServerUsesDqlite() {
+		required = append(required, CapabilityCustomEtcd)
+	}
+	if mode == ModeControlPlane && snap.GetServiceArgument(a.V2.Snap, "kube-apiserver", "--token-auth-file") == "" {
+		required = append(required, CapabilityCertificateAuth)
+	}
+	if mode == ModeControlPlane && a.V2.Snap.HasJoinWithCSRLock() {
+		required = append(required, CapabilityCertificateSigningRequests)
+	}
+	return required
+}
+
+// negotiate picks the protocol version, capabilities and mode used to join a node.
+// The newest common protocol version is used. Capabilities unknown to this cluster agent are ignored. The first of the
+// requested modes for which the node has all required capabilities is picked.
+func (a *API) negotiate(req JoinRequest) (negotiation, *Error) {
+	var n negotiation
+	for _, supported := range SupportedProtocolVersions {
+		for _, version := range req.ProtocolVersions {
+			if version == supported && version > n.protocolVersion {
+				n.protocolVersion = version
+			}
+		}
+	}
+	if n.protocolVersion == 0 {
+		return negotiation{}, &Error{
+			Code:             ErrorCodeUnsupportedProtocolVersion,
+			Message:          fmt.Sprintf("none of the join protocol versions %v are supported", req.ProtocolVersions),
+			ProtocolVersions: SupportedProtocolVersions,
+		}
+	}
+
+	for _, supported := range SupportedCapabilities {
+		for _, capability := range req.Capabilities {
+			if capability == supported {
+				n.capabilities = append(n.capabilities, capability)
+				break
+			}
+		}
+	}
+
+	if len(req.Modes) == 0 {
+		return negotiation{}, &Error{Code: ErrorCodeInvalidRequest, Message: "no join modes requested"}
+	}
+	var missing []Capability
+	for _, mode := range req.Modes {
+		if mode != ModeControlPlane && mode != ModeWorker {
+			return negotiation{}, &Error{Code: ErrorCodeInvalidRequest, Message: fmt.Sprintf("unknown join mode %q", mode)}
+		}
+		missing = nil
+		for _, capability := range a.requiredCapabilities(mode) {
+			if !n.has(capability) {
+				missing = append(missing, capability)
+			}
+		}
+		if len(missing) == 0 {
+			n.mode = mode
+			return n, nil
+		}
+	}
+	return negotiation{}, &Error{
+		Code:         ErrorCodeMissingCapabilities,
+		Message:      fmt.Sprintf("joining this MicroK8s cluster requires capabilities %v", missing),
+		Capabilities: missing,
+	}
+}
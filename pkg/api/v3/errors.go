@@ -0,0 +1,75 @@
+package v3
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
+)
+
+// ErrorCode identifies why a v3 API request failed, so that clients do not have to parse error messages.
+type ErrorCode string
+
+const (
+	// ErrorCodeInvalidRequest means that the request is malformed.
+	ErrorCodeInvalidRequest ErrorCode = "invalid-request"
+	// ErrorCodeUnsupportedProtocolVersion means that none of the protocol versions of the joining node are supported.
+	// The supported protocol versions are included in the error.
+	ErrorCodeUnsupportedProtocolVersion ErrorCode = "unsupported-protocol-version"
+	// ErrorCodeMissingCapabilities means that the joining node lacks capabilities required to join the cluster in any
+	// of the requested modes. The missing capabilities are included in the error.
+	ErrorCodeMissingCapabilities ErrorCode = "missing-capabilities"
+	// ErrorCodeApprovalPending means that the join request is waiting for approval. The joining node should retry.
+	ErrorCodeApprovalPending ErrorCode = "approval-pending"
+	// ErrorCodeApprovalDenied means that the join request was denied by an operator.
+	ErrorCodeApprovalDenied ErrorCode = "approval-denied"
+	// ErrorCodeJoinFailed means that the join failed after negotiation, e.g. due to an invalid token.
+	ErrorCodeJoinFailed ErrorCode = "join-failed"
+)
+
+// Error is the response message of failed v3 API requests.
+type Error struct {
+	// Code identifies the type of error.
+	Code ErrorCode `json:"code"`
+	// Message describes the error.
+	Message string `json:"error"`
+	// ProtocolVersions are the protocol versions supported by the cluster agent.
+	// This is only included with ErrorCodeUnsupportedProtocolVersion.
+	ProtocolVersions []int `json:"protocol_versions,omitempty"`
+	// Capabilities are the capabilities the joining node is missing.
+	// This is only included with ErrorCodeMissingCapabilities.
+	Capabilities []Capability `json:"capabilities,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// statusCode returns the HTTP status code for the error.
+func (e *Error) statusCode() int {
+	switch e.Code {
+	case ErrorCodeInvalidRequest:
+		return http.StatusBadRequest
+	case ErrorCodeUnsupportedProtocolVersion, ErrorCodeMissingCapabilities:
+		return http.StatusUpgradeRequired
+	case ErrorCodeApprovalPending:
+		return http.StatusAccepted
+	case ErrorCodeApprovalDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// joinError wraps an error of the v2 join into an Error.
+func joinError(err error) *Error {
+	switch {
+	case errors.Is(err, approval.ErrPending):
+		return &Error{Code: ErrorCodeApprovalPending, Message: err.Error()}
+	case errors.Is(err, approval.ErrDenied):
+		return &Error{Code: ErrorCodeApprovalDenied, Message: err.Error()}
+	default:
+		return &Error{Code: ErrorCodeJoinFailed, Message: err.Error()}
+	}
+}
@@ -0,0 +1,119 @@
+package v3
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+)
+
+// JoinRequest is the request message for the v3/join API endpoint.
+type JoinRequest struct {
+	// ProtocolVersions are the join protocol versions supported by the joining node.
+	ProtocolVersions []int `json:"protocol_versions"`
+	// Capabilities are the capabilities of the joining node. Unknown capabilities are ignored.
+	Capabilities []Capability `json:"capabilities"`
+	// Modes are the modes the node is willing to join the cluster in, in order of preference.
+	Modes []Mode `json:"modes"`
+	// ClusterToken is the token generated during "microk8s add-node".
+	ClusterToken string `json:"token"`
+	// CAFingerprint is the fingerprint of the cluster CA that the joining node expects. See v2.JoinRequest.
+	CAFingerprint string `json:"ca_fingerprint,omitempty"`
+	// RemoteHostName is the hostname of the joining host.
+	RemoteHostName string `json:"hostname"`
+	// ClusterAgentPort is the port number where the cluster-agent is listening on the joining node.
+	ClusterAgentPort string `json:"port"`
+	// HostPort is the hostname and port that accepted the request. This is retrieved directly from the *http.Request object.
+	HostPort string `json:"-"`
+	// RemoteAddress is the remote address from which the join request originates. This is retrieved directly from the *http.Request object.
+	RemoteAddress string `json:"-"`
+	// NodeAddresses are the IP addresses of the joining node. See v2.JoinRequest.
+	NodeAddresses []string `json:"addresses,omitempty"`
+	// KubeletProfile is the name of a kubelet profile to apply on top of the kubelet arguments of this node.
+	KubeletProfile string `json:"kubelet_profile,omitempty"`
+	// NodeLabels are labels to set on the Node of the joining node. See v2.JoinRequest.
+	NodeLabels map[string]string `json:"node_labels,omitempty"`
+	// NodeTaints are taints to register the joining node with. See v2.JoinRequest.
+	NodeTaints []string `json:"node_taints,omitempty"`
+	// NodeRole is a role for the joining node. See v2.JoinRequest.
+	NodeRole string `json:"node_role,omitempty"`
+	// CertificateSigningRequests are PEM-encoded certificate signing requests for the certificates of a joining control
+	// plane node, keyed by certificate name. They are only used if the "csr" capability is negotiated.
+	CertificateSigningRequests map[string]string `json:"csrs,omitempty"`
+	// EncryptionKey is a base64-encoded ephemeral X25519 public key generated by the joining node. Secrets in the join
+	// response are encrypted to this key if the "encrypted-secrets" or "csr" capability is negotiated.
+	EncryptionKey string `json:"encryption_key,omitempty"`
+}
+
+// JoinResponse is the response message for the v3/join API endpoint.
+// It contains the negotiated protocol version, mode and capabilities, along with the fields of the v2 join response.
+type JoinResponse struct {
+	// ProtocolVersion is the join protocol version that was used.
+	ProtocolVersion int `json:"protocol_version"`
+	// Mode is the mode the node joins the cluster in.
+	Mode Mode `json:"mode"`
+	// Capabilities are the capabilities supported by both the joining node and this cluster agent.
+	Capabilities []Capability `json:"capabilities"`
+
+	*v2.JoinResponse
+}
+
+// v2Request returns the v2 join request for the negotiated mode and capabilities.
+func (req JoinRequest) v2Request(n negotiation) (v2.JoinRequest, *Error) {
+	v2req := v2.JoinRequest{
+		ClusterToken:             req.ClusterToken,
+		CAFingerprint:            req.CAFingerprint,
+		RemoteHostName:           req.RemoteHostName,
+		ClusterAgentPort:         req.ClusterAgentPort,
+		WorkerOnly:               n.mode == ModeWorker,
+		CanHandleCustomEtcd:      n.has(CapabilityCustomEtcd),
+		CanHandleCertificateAuth: n.has(CapabilityCertificateAuth),
+		HostPort:                 req.HostPort,
+		RemoteAddress:            req.RemoteAddress,
+		NodeAddresses:            req.NodeAddresses,
+		KubeletProfile:           req.KubeletProfile,
+		NodeLabels:               req.NodeLabels,
+		NodeTaints:               req.NodeTaints,
+		NodeRole:                 req.NodeRole,
+	}
+	useCSRs := n.mode == ModeControlPlane && n.has(CapabilityCertificateSigningRequests)
+	if useCSRs {
+		if len(req.CertificateSigningRequests) == 0 {
+			return v2.JoinRequest{}, &Error{Code: ErrorCodeInvalidRequest, Message: "no certificate signing requests in join request"}
+		}
+		v2req.CertificateSigningRequests = req.CertificateSigningRequests
+	}
+	if useCSRs || n.has(CapabilityEncryptedSecrets) {
+		encryptionKey, err := base64.StdEncoding.DecodeString(req.EncryptionKey)
+		if err != nil || len(encryptionKey) == 0 {
+			return v2.JoinRequest{}, &Error{Code: ErrorCodeInvalidRequest, Message: fmt.Sprintf("invalid encryption key %q", req.EncryptionKey)}
+		}
+		v2req.EncryptionKey = encryptionKey
+	}
+	return v2req, nil
+}
+
+// Join implements "POST v3/join".
+// Join negotiates the protocol version, mode and capabilities with the joining node, then joins the node using the
+// v2 API. Join returns the join response on success, otherwise an error and the HTTP status code.
+func (a *API) Join(ctx context.Context, req JoinRequest) (*JoinResponse, int, *Error) {
+	n, err := a.negotiate(req)
+	if err != nil {
+		return nil, err.statusCode(), err
+	}
+	v2req, err := req.v2Request(n)
+	if err != nil {
+		return nil, err.statusCode(), err
+	}
+	response, rc, joinErr := a.V2.Join(ctx, v2req)
+	if joinErr != nil {
+		return nil, rc, joinError(joinErr)
+	}
+	return &JoinResponse{
+		ProtocolVersion: n.protocolVersion,
+		Mode:            n.mode,
+		Capabilities:    n.capabilities,
+		JoinResponse:    response,
+	}, rc, nil
+}
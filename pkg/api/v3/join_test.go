@@ -0,0 +1,289 @@
+package v3_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	v3 "github.com/canonical/microk8s-cluster-agent/pkg/api/v3"
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap/mock"
+)
+
+func TestJoin(t *testing.T) {
+	const (
+		dqliteAPIServerArgs     = "--secure-port 16443\n--etcd-servers=${SNAP_DATA}/var/kubernetes/backend/kine.sock:12379\n"
+		customEtcdAPIServerArgs = "--secure-port 16443\n--etcd-servers=https://10.0.0.100:2379\n"
+		tokenAuthAPIServerArgs  = dqliteAPIServerArgs + "--token-auth-file=${SNAP_DATA}/credentials/known_tokens.csv\n"
+	)
+	newAPI := func(apiServerArgs string) (*v3.API, *mock.Snap) {
+		s := &mock.Snap{
+			DqliteLock: true,
+			DqliteInfoYaml: `
+Address: 10.10.10.10:19001
+ID: 1238719276943521
+Role: 0
+`,
+			DqliteClusterYaml: `
+- Address: 10.10.10.10:19001
+  ID: 1238719276943521
+  Role: 0
+`,
+			ServiceArguments: map[string]string{
+				"kubelet":        "kubelet arguments\n",
+				"kube-apiserver": apiServerArgs,
+				"cluster-agent":  "--bind=0.0.0.0:25000",
+			},
+			ClusterTokens:     []string{"token"},
+			SelfCallbackToken: "callback-token",
+			KnownTokens:       map[string]string{"admin": "admin-token"},
+		}
+		return &v3.API{
+			V2: &v2.API{
+				Snap: s,
+				LookupIP: func(string) ([]net.IP, error) {
+					return []net.IP{{10, 10, 10, 13}}, nil
+				},
+				ListControlPlaneNodeIPs: func(context.Context, snap.Snap) ([]string, error) {
+					return []string{"10.10.10.10"}, nil
+				},
+			},
+		}, s
+	}
+	newRequest := func() v3.JoinRequest {
+		return v3.JoinRequest{
+			ProtocolVersions: []int{3},
+			ClusterToken:     "token",
+			RemoteHostName:   "test-node",
+			ClusterAgentPort: "25000",
+			HostPort:         "10.10.10.10:25000",
+			RemoteAddress:    "10.10.10.13:41532",
+		}
+	}
+
+	for _, tc := range []struct {
+		name                 string
+		apiServerArgs        string
+		protocolVersions     []int
+		capabilities         []v3.Capability
+		modes                []v3.Mode
+		expectMode           v3.Mode
+		expectCapabilities   []v3.Capability
+		expectRC             int
+		expectErrorCode      v3.ErrorCode
+		expectMissing        []v3.Capability
+		expectServerVersions []int
+	}{
+		{
+			name:               "ControlPlane",
+			apiServerArgs:      dqliteAPIServerArgs,
+			capabilities:       []v3.Capability{v3.CapabilityCertificateAuth, "future-capability"},
+			modes:              []v3.Mode{v3.ModeControlPlane},
+			expectMode:         v3.ModeControlPlane,
+			expectCapabilities: []v3.Capability{v3.CapabilityCertificateAuth},
+			expectRC:           http.StatusOK,
+		},
+		{
+			name:          "ControlPlaneWithTokenAuth",
+			apiServerArgs: tokenAuthAPIServerArgs,
+			modes:         []v3.Mode{v3.ModeControlPlane},
+			expectMode:    v3.ModeControlPlane,
+			expectRC:      http.StatusOK,
+		},
+		{
+			name:          "FallbackToWorker",
+			apiServerArgs: dqliteAPIServerArgs,
+			modes:         []v3.Mode{v3.ModeControlPlane, v3.ModeWorker},
+			expectMode:    v3.ModeWorker,
+			expectRC:      http.StatusOK,
+		},
+		{
+			name:               "WorkerWithCustomEtcd",
+			apiServerArgs:      customEtcdAPIServerArgs,
+			capabilities:       []v3.Capability{v3.CapabilityCustomEtcd},
+			modes:              []v3.Mode{v3.ModeWorker},
+			expectMode:         v3.ModeWorker,
+			expectCapabilities: []v3.Capability{v3.CapabilityCustomEtcd},
+			expectRC:           http.StatusOK,
+		},
+		{
+			name:             "NewestProtocolVersion",
+			apiServerArgs:    dqliteAPIServerArgs,
+			protocolVersions: []int{4, 3, 2},
+			modes:            []v3.Mode{v3.ModeWorker},
+			expectMode:       v3.ModeWorker,
+			expectRC:         http.StatusOK,
+		},
+		{
+			name:                 "UnsupportedProtocolVersion",
+			apiServerArgs:        dqliteAPIServerArgs,
+			protocolVersions:     []int{4},
+			modes:                []v3.Mode{v3.ModeWorker},
+			expectRC:             http.StatusUpgradeRequired,
+			expectErrorCode:      v3.ErrorCodeUnsupportedProtocolVersion,
+			expectServerVersions: []int{3},
+		},
+		{
+			name:            "MissingCapabilities",
+			apiServerArgs:   customEtcdAPIServerArgs,
+			modes:           []v3.Mode{v3.ModeControlPlane},
+			expectRC:        http.StatusUpgradeRequired,
+			expectErrorCode: v3.ErrorCodeMissingCapabilities,
+			expectMissing:   []v3.Capability{v3.CapabilityCustomEtcd, v3.CapabilityCertificateAuth},
+		},
+		{
+			name:            "NoModes",
+			apiServerArgs:   dqliteAPIServerArgs,
+			expectRC:        http.StatusBadRequest,
+			expectErrorCode: v3.ErrorCodeInvalidRequest,
+		},
+		{
+			name:            "UnknownMode",
+			apiServerArgs:   dqliteAPIServerArgs,
+			modes:           []v3.Mode{"as-worker"},
+			expectRC:        http.StatusBadRequest,
+			expectErrorCode: v3.ErrorCodeInvalidRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			apiv3, s := newAPI(tc.apiServerArgs)
+
+			req := newRequest()
+			if tc.protocolVersions != nil {
+				req.ProtocolVersions = tc.protocolVersions
+			}
+			req.Capabilities = tc.capabilities
+			req.Modes = tc.modes
+
+			resp, rc, err := apiv3.Join(context.Background(), req)
+			g.Expect(rc).To(Equal(tc.expectRC))
+			if tc.expectErrorCode != "" {
+				g.Expect(resp).To(BeNil())
+				g.Expect(err).ToNot(BeNil())
+				g.Expect(err.Code).To(Equal(tc.expectErrorCode))
+				g.Expect(err.Capabilities).To(Equal(tc.expectMissing))
+				g.Expect(err.ProtocolVersions).To(Equal(tc.expectServerVersions))
+				g.Expect(s.ConsumeClusterTokenCalledWith).To(BeEmpty())
+				return
+			}
+
+			g.Expect(err).To(BeNil())
+			g.Expect(resp.ProtocolVersion).To(Equal(3))
+			g.Expect(resp.Mode).To(Equal(tc.expectMode))
+			g.Expect(resp.Capabilities).To(Equal(tc.expectCapabilities))
+			g.Expect(resp.CallbackToken).To(Equal("callback-token"))
+			g.Expect(s.ConsumeClusterTokenCalledWith).To(ConsistOf("token"))
+			if tc.expectMode == v3.ModeWorker {
+				g.Expect(resp.ControlPlaneNodes).To(ConsistOf("10.10.10.10"))
+			} else {
+				g.Expect(resp.ControlPlaneNodes).To(BeEmpty())
+			}
+		})
+	}
+
+	t.Run("CertificateSigningRequests", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv3, s := newAPI(dqliteAPIServerArgs)
+		s.JoinWithCSRLock = true
+		s.SignedCertificate = "SIGNED CERTIFICATE DATA"
+
+		req := newRequest()
+		req.Capabilities = []v3.Capability{v3.CapabilityCertificateAuth}
+		req.Modes = []v3.Mode{v3.ModeControlPlane}
+
+		_, rc, err := apiv3.Join(context.Background(), req)
+		g.Expect(rc).To(Equal(http.StatusUpgradeRequired))
+		g.Expect(err.Code).To(Equal(v3.ErrorCodeMissingCapabilities))
+		g.Expect(err.Capabilities).To(ConsistOf(v3.CapabilityCertificateSigningRequests))
+
+		req.Capabilities = append(req.Capabilities, v3.CapabilityCertificateSigningRequests)
+		_, rc, err = apiv3.Join(context.Background(), req)
+		g.Expect(rc).To(Equal(http.StatusBadRequest))
+		g.Expect(err.Code).To(Equal(v3.ErrorCodeInvalidRequest))
+
+		key, keyErr := ecdh.X25519().GenerateKey(rand.Reader)
+		g.Expect(keyErr).ToNot(HaveOccurred())
+		csrKey, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		g.Expect(keyErr).ToNot(HaveOccurred())
+		der, csrErr := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "system:kube-proxy"}}, csrKey)
+		g.Expect(csrErr).ToNot(HaveOccurred())
+		req.CertificateSigningRequests = map[string]string{
+			"proxy": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+		}
+		req.EncryptionKey = base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+
+		resp, rc, err := apiv3.Join(context.Background(), req)
+		g.Expect(err).To(BeNil())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.Capabilities).To(ConsistOf(v3.CapabilityCertificateAuth, v3.CapabilityCertificateSigningRequests))
+		g.Expect(resp.CertificateAuthorityKey).To(BeNil())
+		g.Expect(resp.Certificates).To(HaveKeyWithValue("proxy", "SIGNED CERTIFICATE DATA"))
+	})
+
+	t.Run("EncryptedSecrets", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv3, _ := newAPI(tokenAuthAPIServerArgs)
+
+		req := newRequest()
+		req.Capabilities = []v3.Capability{v3.CapabilityEncryptedSecrets}
+		req.Modes = []v3.Mode{v3.ModeControlPlane}
+
+		_, rc, err := apiv3.Join(context.Background(), req)
+		g.Expect(rc).To(Equal(http.StatusBadRequest))
+		g.Expect(err.Code).To(Equal(v3.ErrorCodeInvalidRequest))
+
+		key, keyErr := ecdh.X25519().GenerateKey(rand.Reader)
+		g.Expect(keyErr).ToNot(HaveOccurred())
+		req.EncryptionKey = base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+
+		resp, rc, err := apiv3.Join(context.Background(), req)
+		g.Expect(err).To(BeNil())
+		g.Expect(rc).To(Equal(http.StatusOK))
+		g.Expect(resp.CertificateAuthorityKey).To(BeNil())
+		g.Expect(resp.CertificateAuthorityKeyEnc).ToNot(BeEmpty())
+		g.Expect(resp.AdminToken).To(BeEmpty())
+		g.Expect(resp.AdminTokenEnc).ToNot(BeEmpty())
+	})
+
+	t.Run("JoinFailed", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv3, _ := newAPI(dqliteAPIServerArgs)
+
+		req := newRequest()
+		req.ClusterToken = "invalid-token"
+		req.Modes = []v3.Mode{v3.ModeWorker}
+
+		resp, rc, err := apiv3.Join(context.Background(), req)
+		g.Expect(resp).To(BeNil())
+		g.Expect(rc).To(Equal(http.StatusInternalServerError))
+		g.Expect(err.Code).To(Equal(v3.ErrorCodeJoinFailed))
+	})
+
+	t.Run("ApprovalPending", func(t *testing.T) {
+		g := NewWithT(t)
+		apiv3, _ := newAPI(dqliteAPIServerArgs)
+		apiv3.V2.JoinQueue = approval.NewQueue(approval.Options{})
+
+		req := newRequest()
+		req.Modes = []v3.Mode{v3.ModeWorker}
+
+		resp, rc, err := apiv3.Join(context.Background(), req)
+		g.Expect(resp).To(BeNil())
+		g.Expect(rc).To(Equal(http.StatusAccepted))
+		g.Expect(err.Code).To(Equal(v3.ErrorCodeApprovalPending))
+	})
+}
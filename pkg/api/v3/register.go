@@ -0,0 +1,41 @@
+package v3
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/httputil"
+)
+
+// HTTPPrefix is the prefix for all v3 API routes.
+const HTTPPrefix = "/cluster/api/v3.0"
+
+// RegisterServer registers the Cluster API v3 endpoints on an HTTP server.
+func (a *API) RegisterServer(server *http.ServeMux, middleware func(f http.HandlerFunc) http.HandlerFunc) {
+	// POST v3/join
+	server.HandleFunc(fmt.Sprintf("%s/join", HTTPPrefix), middleware(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		req := JoinRequest{}
+		if err := httputil.UnmarshalJSON(r, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			httputil.Response(w, &Error{Code: ErrorCodeInvalidRequest, Message: fmt.Sprintf("failed to unmarshal JSON: %v", err)})
+			return
+		}
+
+		req.RemoteAddress = r.RemoteAddr
+		req.HostPort = r.Host
+
+		response, rc, err := a.Join(r.Context(), req)
+		if err != nil {
+			log.Printf("[ERROR %d] v3 join for %s failed (%s): %q", rc, req.RemoteHostName, err.Code, err)
+			w.WriteHeader(rc)
+			httputil.Response(w, err)
+			return
+		}
+		httputil.Response(w, response)
+	}))
+}
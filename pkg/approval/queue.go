@@ -0,0 +1,253 @@
+// Package approval implements a queue of join requests that must be approved by an operator.
+package approval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+)
+
+// Status is the status of a join request.
+type Status string
+
+const (
+	// StatusPending means that the join request is waiting for a decision.
+	StatusPending Status = "pending"
+	// StatusApproved means that the node is allowed to join the cluster.
+	StatusApproved Status = "approved"
+	// StatusDenied means that the node is not allowed to join the cluster.
+	StatusDenied Status = "denied"
+)
+
+var (
+	// ErrPending is returned when a join request has not been approved yet.
+	ErrPending = errors.New("pending approval")
+	// ErrDenied is returned when a join request has been denied.
+	ErrDenied = errors.New("denied")
+	// ErrNotFound is returned when deciding on an unknown join request.
+	ErrNotFound = errors.New("join request not found")
+)
+
+// Scope is the type of node a join request is for.
+type Scope string
+
+const (
+	// ScopeWorker is for worker-only nodes.
+	ScopeWorker Scope = "worker"
+	// ScopeControlPlane is for control plane nodes.
+	ScopeControlPlane Scope = "control-plane"
+)
+
+// ParseScope parses a join request scope.
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(s); scope {
+	case ScopeWorker, ScopeControlPlane:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown scope %q, must be one of %q or %q", s, ScopeWorker, ScopeControlPlane)
+	}
+}
+
+// JoinRequest is a join request held for approval.
+type JoinRequest struct {
+	// ID is a unique identifier for the join request.
+	ID string `json:"id"`
+	// Hostname is the hostname of the joining node.
+	Hostname string `json:"hostname"`
+	// RemoteIP is the IP address the join request originates from.
+	RemoteIP string `json:"remote_ip"`
+	// WorkerOnly is true when joining a worker-only node.
+	WorkerOnly bool `json:"worker"`
+	// CreatedAt is the time of the first join attempt.
+	CreatedAt time.Time `json:"created_at"`
+	// Status is the status of the join request.
+	Status Status `json:"status"`
+
+	// key identifies repeated join attempts of the same node.
+	key string
+}
+
+// Options configures a Queue.
+type Options struct {
+	// Timeout is how long undecided and denied join requests are kept. Defaults to 15 minutes.
+	Timeout time.Duration
+	// Wait is how long a join attempt waits for a decision before failing with ErrPending.
+	// Joining nodes are expected to retry until the request is decided. If zero, join attempts fail immediately.
+	Wait time.Duration
+	// AutoApproveCIDRs are networks from which join requests are approved automatically.
+	AutoApproveCIDRs []*net.IPNet
+	// AutoApproveScopes returns the types of nodes for which join requests using a cluster token are approved automatically.
+	// If nil, no join requests are approved automatically by token.
+	AutoApproveScopes func(token string) []Scope
+}
+
+// Queue holds join requests until they are approved or denied by an operator.
+// Queue is safe for concurrent use.
+type Queue struct {
+	opts Options
+
+	mu       sync.Mutex
+	requests map[string]*JoinRequest // map key to request
+	// decided is closed and replaced whenever a join request is approved or denied.
+	decided chan struct{}
+
+	now func() time.Time
+}
+
+// NewQueue creates a new queue of join requests.
+func NewQueue(opts Options) *Queue {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 15 * time.Minute
+	}
+	return &Queue{
+		opts:     opts,
+		requests: make(map[string]*JoinRequest),
+		decided:  make(chan struct{}),
+		now:      time.Now,
+	}
+}
+
+// autoApprove returns true if a join request matches any of the auto-approve rules.
+func (q *Queue) autoApprove(token string, remoteIP string, workerOnly bool) bool {
+	scope := ScopeControlPlane
+	if workerOnly {
+		scope = ScopeWorker
+	}
+	if q.opts.AutoApproveScopes != nil {
+		for _, s := range q.opts.AutoApproveScopes(token) {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	if ip := net.ParseIP(remoteIP); ip != nil {
+		for _, cidr := range q.opts.AutoApproveCIDRs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expire removes join requests older than the timeout. The caller must hold q.mu.
+func (q *Queue) expire() {
+	for key, req := range q.requests {
+		if q.now().Sub(req.CreatedAt) > q.opts.Timeout {
+			delete(q.requests, key)
+		}
+	}
+}
+
+// Request checks whether a node may join the cluster. Repeated join attempts of the same node are matched to
+// the same join request. The token is used to tell apart join attempts that use different cluster tokens, and
+// to look up its auto-approve scopes.
+// Request returns no error if the join request is approved, in which case the approval is consumed.
+// Otherwise, Request waits up to Options.Wait for a decision, and returns ErrPending or ErrDenied.
+func (q *Queue) Request(ctx context.Context, token string, hostname string, remoteAddress string, workerOnly bool) (JoinRequest, error) {
+	remoteIP, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		remoteIP = remoteAddress
+	}
+	if q.autoApprove(token, remoteIP, workerOnly) {
+		return JoinRequest{Hostname: hostname, RemoteIP: remoteIP, WorkerOnly: workerOnly, CreatedAt: q.now(), Status: StatusApproved}, nil
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+	key := fmt.Sprintf("%s|%s|%s|%v", hex.EncodeToString(tokenHash[:]), hostname, remoteIP, workerOnly)
+
+	q.mu.Lock()
+	q.expire()
+	req, ok := q.requests[key]
+	if !ok {
+		req = &JoinRequest{
+			ID:         util.NewRandomString(util.Alpha, 8),
+			Hostname:   hostname,
+			RemoteIP:   remoteIP,
+			WorkerOnly: workerOnly,
+			CreatedAt:  q.now(),
+			Status:     StatusPending,
+			key:        key,
+		}
+		q.requests[key] = req
+	}
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.opts.Wait)
+	defer timer.Stop()
+	for {
+		q.mu.Lock()
+		result := *req
+		if req.Status == StatusApproved {
+			delete(q.requests, key)
+		}
+		decided := q.decided
+		q.mu.Unlock()
+
+		switch result.Status {
+		case StatusApproved:
+			return result, nil
+		case StatusDenied:
+			return result, fmt.Errorf("join request %s: %w", result.ID, ErrDenied)
+		}
+
+		select {
+		case <-decided:
+		case <-timer.C:
+			return result, fmt.Errorf("join request %s: %w", result.ID, ErrPending)
+		case <-ctx.Done():
+			return result, fmt.Errorf("join request %s: %w", result.ID, ErrPending)
+		}
+	}
+}
+
+// List returns all known join requests, oldest first.
+func (q *Queue) List() []JoinRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+
+	requests := make([]JoinRequest, 0, len(q.requests))
+	for _, req := range q.requests {
+		requests = append(requests, *req)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+	})
+	return requests
+}
+
+// Decide approves or denies a pending join request.
+func (q *Queue) Decide(id string, approve bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire()
+
+	for _, req := range q.requests {
+		if req.ID != id {
+			continue
+		}
+		if req.Status != StatusPending {
+			return fmt.Errorf("join request %s is already %s", id, req.Status)
+		}
+		if approve {
+			req.Status = StatusApproved
+		} else {
+			req.Status = StatusDenied
+		}
+		// Keep the request until the joining node retries, even if it was created a long time ago.
+		req.CreatedAt = q.now()
+		close(q.decided)
+		q.decided = make(chan struct{})
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrNotFound, id)
+}
@@ -0,0 +1,162 @@
+package approval_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/approval"
+)
+
+func TestQueue(t *testing.T) {
+	t.Run("Pending", func(t *testing.T) {
+		g := NewWithT(t)
+		q := approval.NewQueue(approval.Options{})
+
+		req, err := q.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", true)
+		g.Expect(errors.Is(err, approval.ErrPending)).To(BeTrue())
+		g.Expect(req.ID).ToNot(BeEmpty())
+		g.Expect(req.RemoteIP).To(Equal("10.0.0.2"))
+
+		// retries of the same node are matched to the same request
+		retry, err := q.Request(context.Background(), "token", "node-2", "10.0.0.2:50212", true)
+		g.Expect(errors.Is(err, approval.ErrPending)).To(BeTrue())
+		g.Expect(retry.ID).To(Equal(req.ID))
+
+		// different token is a different request
+		other, err := q.Request(context.Background(), "other-token", "node-2", "10.0.0.2:50212", true)
+		g.Expect(errors.Is(err, approval.ErrPending)).To(BeTrue())
+		g.Expect(other.ID).ToNot(Equal(req.ID))
+
+		g.Expect(q.List()).To(HaveLen(2))
+	})
+
+	t.Run("Approve", func(t *testing.T) {
+		g := NewWithT(t)
+		q := approval.NewQueue(approval.Options{})
+
+		req, err := q.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", false)
+		g.Expect(errors.Is(err, approval.ErrPending)).To(BeTrue())
+
+		g.Expect(q.Decide(req.ID, true)).To(Succeed())
+		g.Expect(q.Decide(req.ID, false)).ToNot(Succeed())
+
+		req, err = q.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", false)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(req.Status).To(Equal(approval.StatusApproved))
+
+		// approval is consumed
+		g.Expect(q.List()).To(BeEmpty())
+	})
+
+	t.Run("Deny", func(t *testing.T) {
+		g := NewWithT(t)
+		q := approval.NewQueue(approval.Options{})
+
+		req, _ := q.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", false)
+		g.Expect(q.Decide(req.ID, false)).To(Succeed())
+
+		_, err := q.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", false)
+		g.Expect(errors.Is(err, approval.ErrDenied)).To(BeTrue())
+		g.Expect(q.List()).To(ConsistOf(HaveField("Status", approval.StatusDenied)))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		g := NewWithT(t)
+		q := approval.NewQueue(approval.Options{})
+
+		err := q.Decide("unknown", true)
+		g.Expect(errors.Is(err, approval.ErrNotFound)).To(BeTrue())
+	})
+
+	t.Run("Wait", func(t *testing.T) {
+		g := NewWithT(t)
+		q := approval.NewQueue(approval.Options{Wait: 10 * time.Second})
+
+		go func() {
+			for {
+				if requests := q.List(); len(requests) > 0 {
+					_ = q.Decide(requests[0].ID, true)
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+
+		_, err := q.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", true)
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+
+	t.Run("WaitCancelled", func(t *testing.T) {
+		g := NewWithT(t)
+		q := approval.NewQueue(approval.Options{Wait: time.Hour})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := q.Request(ctx, "token", "node-2", "10.0.0.2:41532", true)
+		g.Expect(errors.Is(err, approval.ErrPending)).To(BeTrue())
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		g := NewWithT(t)
+		q := approval.NewQueue(approval.Options{Timeout: 10 * time.Millisecond})
+
+		req, _ := q.Request(context.Background(), "token", "node-2", "10.0.0.2:41532", true)
+		time.Sleep(20 * time.Millisecond)
+
+		g.Expect(q.List()).To(BeEmpty())
+		g.Expect(errors.Is(q.Decide(req.ID, true), approval.ErrNotFound)).To(BeTrue())
+	})
+
+	t.Run("AutoApprove", func(t *testing.T) {
+		_, cidr, _ := net.ParseCIDR("10.0.0.0/24")
+		tokenScopes := func(token string) []approval.Scope {
+			if token == "worker-token" {
+				return []approval.Scope{approval.ScopeWorker}
+			}
+			return nil
+		}
+		for _, tc := range []struct {
+			name       string
+			opts       approval.Options
+			token      string
+			address    string
+			workerOnly bool
+			approved   bool
+		}{
+			{name: "CIDR", opts: approval.Options{AutoApproveCIDRs: []*net.IPNet{cidr}}, address: "10.0.0.2:41532", approved: true},
+			{name: "OtherCIDR", opts: approval.Options{AutoApproveCIDRs: []*net.IPNet{cidr}}, address: "10.0.1.2:41532"},
+			{name: "WorkerScope", opts: approval.Options{AutoApproveScopes: tokenScopes}, token: "worker-token", address: "10.0.1.2:41532", workerOnly: true, approved: true},
+			{name: "ControlPlaneScope", opts: approval.Options{AutoApproveScopes: tokenScopes}, token: "worker-token", address: "10.0.1.2:41532"},
+			{name: "OtherToken", opts: approval.Options{AutoApproveScopes: tokenScopes}, token: "token", address: "10.0.1.2:41532", workerOnly: true},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				g := NewWithT(t)
+				q := approval.NewQueue(tc.opts)
+
+				_, err := q.Request(context.Background(), tc.token, "node-2", tc.address, tc.workerOnly)
+				if tc.approved {
+					g.Expect(err).ToNot(HaveOccurred())
+					g.Expect(q.List()).To(BeEmpty())
+				} else {
+					g.Expect(errors.Is(err, approval.ErrPending)).To(BeTrue())
+				}
+			})
+		}
+	})
+}
+
+func TestParseScope(t *testing.T) {
+	g := NewWithT(t)
+
+	scope, err := approval.ParseScope("worker")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(scope).To(Equal(approval.ScopeWorker))
+
+	_, err = approval.ParseScope("admin")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,87 @@
+package dqlite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CLIClient implements Client by running the dqlite shell binary shipped with the snap.
+// The dqlite shell does not support adding nodes or changing their roles.
+type CLIClient struct {
+	// BinaryPath is the path to the dqlite binary.
+	BinaryPath string
+	// ClusterYamlPath is the path to the cluster.yaml file with the list of known dqlite nodes.
+	ClusterYamlPath string
+	// CertPath is the path to the dqlite cluster certificate.
+	CertPath string
+	// KeyPath is the path to the dqlite cluster private key.
+	KeyPath string
+	// Database is the name of the dqlite database.
+	Database string
+
+	// RunCommand runs a shell command.
+	RunCommand func(ctx context.Context, command ...string) error
+	// RunCommandWithOutput runs a shell command and returns its standard output.
+	RunCommandWithOutput func(ctx context.Context, command ...string) ([]byte, error)
+}
+
+func (c *CLIClient) command(dotCommand string) []string {
+	// NOTE(Hue): The dot command (e.g. ".remove <address>") should be a single string. Otherwise Dqlite throws an error.
+	return []string{c.BinaryPath, "-s", "file://" + c.ClusterYamlPath, "-c", c.CertPath, "-k", c.KeyPath, "-f", "json", c.Database, dotCommand}
+}
+
+// Cluster implements Client.
+func (c *CLIClient) Cluster(ctx context.Context) ([]NodeInfo, error) {
+	out, err := c.RunCommandWithOutput(ctx, c.command(".cluster")...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run cluster command: %w", err)
+	}
+	var nodes []NodeInfo
+	if err := json.Unmarshal(out, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster command output: %w", err)
+	}
+	return nodes, nil
+}
+
+// Leader implements Client.
+func (c *CLIClient) Leader(ctx context.Context) (NodeInfo, error) {
+	out, err := c.RunCommandWithOutput(ctx, c.command(".leader")...)
+	if err != nil {
+		return NodeInfo{}, fmt.Errorf("failed to run leader command: %w", err)
+	}
+	address := strings.TrimSpace(string(out))
+	if address == "" {
+		return NodeInfo{}, ErrNoLeader
+	}
+	nodes, err := c.Cluster(ctx)
+	if err != nil {
+		return NodeInfo{Address: address}, nil
+	}
+	if node, err := FindNode(nodes, address); err == nil {
+		return node, nil
+	}
+	return NodeInfo{Address: address}, nil
+}
+
+// Add implements Client.
+func (c *CLIClient) Add(ctx context.Context, node NodeInfo) error {
+	return fmt.Errorf("adding nodes with the dqlite shell: %w", errors.ErrUnsupported)
+}
+
+// Assign implements Client.
+func (c *CLIClient) Assign(ctx context.Context, address string, role NodeRole) error {
+	return fmt.Errorf("assigning roles with the dqlite shell: %w", errors.ErrUnsupported)
+}
+
+// Remove implements Client.
+func (c *CLIClient) Remove(ctx context.Context, address string) error {
+	if err := c.RunCommand(ctx, c.command(fmt.Sprintf(".remove %s", address))...); err != nil {
+		return fmt.Errorf("failed to run remove command: %w", err)
+	}
+	return nil
+}
+
+var _ Client = &CLIClient{}
@@ -0,0 +1,91 @@
+package dqlite_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite/mock"
+	. "github.com/onsi/gomega"
+)
+
+func TestCLIClient(t *testing.T) {
+	var calledWith [][]string
+	client := &dqlite.CLIClient{
+		BinaryPath:      "/snap/bin/dqlite",
+		ClusterYamlPath: "/data/cluster.yaml",
+		CertPath:        "/data/cluster.crt",
+		KeyPath:         "/data/cluster.key",
+		Database:        "k8s",
+		RunCommand: func(_ context.Context, command ...string) error {
+			calledWith = append(calledWith, command)
+			return nil
+		},
+		RunCommandWithOutput: func(_ context.Context, command ...string) ([]byte, error) {
+			calledWith = append(calledWith, command)
+			switch command[len(command)-1] {
+			case ".cluster":
+				return []byte(`[{"ID":1,"Address":"10.0.0.1:19001","Role":0},{"ID":2,"Address":"10.0.0.2:19001","Role":2}]`), nil
+			case ".leader":
+				return []byte("10.0.0.1:19001\n"), nil
+			}
+			return nil, errors.New("unknown command")
+		},
+	}
+
+	t.Run("Cluster", func(t *testing.T) {
+		g := NewWithT(t)
+		nodes, err := client.Cluster(context.Background())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(nodes).To(Equal([]dqlite.NodeInfo{
+			{ID: 1, Address: "10.0.0.1:19001", Role: dqlite.Voter},
+			{ID: 2, Address: "10.0.0.2:19001", Role: dqlite.Spare},
+		}))
+	})
+
+	t.Run("Leader", func(t *testing.T) {
+		g := NewWithT(t)
+		leader, err := client.Leader(context.Background())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(leader).To(Equal(dqlite.NodeInfo{ID: 1, Address: "10.0.0.1:19001", Role: dqlite.Voter}))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		g := NewWithT(t)
+		calledWith = nil
+		g.Expect(client.Remove(context.Background(), "10.0.0.2:19001")).To(Succeed())
+		g.Expect(calledWith).To(Equal([][]string{
+			{"/snap/bin/dqlite", "-s", "file:///data/cluster.yaml", "-c", "/data/cluster.crt", "-k", "/data/cluster.key", "-f", "json", "k8s", ".remove 10.0.0.2:19001"},
+		}))
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(client.Add(context.Background(), dqlite.NodeInfo{Address: "10.0.0.3:19001"})).To(MatchError(errors.ErrUnsupported))
+		g.Expect(client.Assign(context.Background(), "10.0.0.2:19001", dqlite.Voter)).To(MatchError(errors.ErrUnsupported))
+	})
+}
+
+func TestWithFallback(t *testing.T) {
+	t.Run("PrimaryUnreachable", func(t *testing.T) {
+		g := NewWithT(t)
+		primary := &mock.Client{Err: errors.New("connection refused")}
+		fallback := &mock.Client{Nodes: []dqlite.NodeInfo{{ID: 1, Address: "10.0.0.1:19001"}}}
+
+		client := dqlite.WithFallback(primary, fallback)
+		g.Expect(client.Remove(context.Background(), "10.0.0.1:19001")).To(Succeed())
+		g.Expect(primary.RemoveCalledWith).To(ConsistOf("10.0.0.1:19001"))
+		g.Expect(fallback.RemoveCalledWith).To(ConsistOf("10.0.0.1:19001"))
+	})
+
+	t.Run("DqliteError", func(t *testing.T) {
+		g := NewWithT(t)
+		primary := &mock.Client{Err: &dqlite.Error{Code: 1, Message: "not leader"}}
+		fallback := &mock.Client{}
+
+		client := dqlite.WithFallback(primary, fallback)
+		g.Expect(client.Remove(context.Background(), "10.0.0.1:19001")).ToNot(Succeed())
+		g.Expect(fallback.RemoveCalledWith).To(BeEmpty())
+	})
+}
@@ -0,0 +1,87 @@
+package dqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// NodeRole is the role of a node in the dqlite cluster.
+type NodeRole uint64
+
+const (
+	// Voter nodes replicate the database and participate in leader elections.
+	Voter NodeRole = 0
+	// StandBy nodes replicate the database, but do not participate in leader elections.
+	StandBy NodeRole = 1
+	// Spare nodes do not replicate the database and do not participate in leader elections.
+	Spare NodeRole = 2
+)
+
+// String implements fmt.Stringer.
+func (r NodeRole) String() string {
+	switch r {
+	case Voter:
+		return "voter"
+	case StandBy:
+		return "stand-by"
+	case Spare:
+		return "spare"
+	default:
+		return fmt.Sprintf("unknown (%d)", uint64(r))
+	}
+}
+
+// NodeInfo is a node in the dqlite cluster.
+type NodeInfo struct {
+	// ID is the unique identifier of the node in the cluster.
+	ID uint64 `json:"ID" yaml:"ID"`
+	// Address is the "host:port" address of the node.
+	Address string `json:"Address" yaml:"Address"`
+	// Role is the role of the node in the cluster.
+	Role NodeRole `json:"Role" yaml:"Role"`
+}
+
+// Client manages the membership of a dqlite cluster.
+// Nodes are identified by their "host:port" address.
+type Client interface {
+	// Cluster returns the list of nodes that are part of the dqlite cluster.
+	Cluster(ctx context.Context) ([]NodeInfo, error)
+	// Leader returns the current leader of the dqlite cluster.
+	Leader(ctx context.Context) (NodeInfo, error)
+	// Add adds a new node to the dqlite cluster with the specified role.
+	Add(ctx context.Context, node NodeInfo) error
+	// Assign changes the role of a node of the dqlite cluster.
+	Assign(ctx context.Context, address string, role NodeRole) error
+	// Remove removes a node from the dqlite cluster.
+	Remove(ctx context.Context, address string) error
+}
+
+// ErrNodeNotFound is returned when a node address is not part of the dqlite cluster.
+var ErrNodeNotFound = errors.New("node is not part of the dqlite cluster")
+
+// ErrNoLeader is returned when none of the known dqlite nodes know about the cluster leader.
+var ErrNoLeader = errors.New("no dqlite leader found")
+
+// Error is a failure response returned by a dqlite node.
+type Error struct {
+	// Code is the error code.
+	Code uint64
+	// Message is the error message.
+	Message string
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("dqlite error %d: %s", e.Code, e.Message)
+}
+
+// FindNode returns the node with the specified address.
+func FindNode(nodes []NodeInfo, address string) (NodeInfo, error) {
+	for _, node := range nodes {
+		if node.Address == address {
+			return node, nil
+		}
+	}
+	return NodeInfo{}, fmt.Errorf("%w: %s", ErrNodeNotFound, address)
+}
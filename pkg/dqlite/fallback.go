@@ -0,0 +1,73 @@
+package dqlite
+
+import (
+	"context"
+	"errors"
+	"log"
+)
+
+// fallbackClient implements Client by trying a primary client first, and a fallback client when
+// the primary is not able to reach the cluster.
+type fallbackClient struct {
+	primary  Client
+	fallback Client
+}
+
+// WithFallback returns a Client that uses primary, and retries with fallback if primary fails for any
+// reason other than an error response from the dqlite cluster.
+func WithFallback(primary, fallback Client) Client {
+	return &fallbackClient{primary: primary, fallback: fallback}
+}
+
+// shouldFallback returns true if err is not a definitive answer from the dqlite cluster.
+func shouldFallback(err error) bool {
+	var dqliteErr *Error
+	return err != nil && !errors.As(err, &dqliteErr) && !errors.Is(err, ErrNodeNotFound) && !errors.Is(err, errors.ErrUnsupported)
+}
+
+func (c *fallbackClient) Cluster(ctx context.Context) ([]NodeInfo, error) {
+	nodes, err := c.primary.Cluster(ctx)
+	if shouldFallback(err) {
+		log.Printf("[WARNING] failed to list dqlite nodes, will retry with fallback client: %v", err)
+		return c.fallback.Cluster(ctx)
+	}
+	return nodes, err
+}
+
+func (c *fallbackClient) Leader(ctx context.Context) (NodeInfo, error) {
+	leader, err := c.primary.Leader(ctx)
+	if shouldFallback(err) {
+		log.Printf("[WARNING] failed to find dqlite leader, will retry with fallback client: %v", err)
+		return c.fallback.Leader(ctx)
+	}
+	return leader, err
+}
+
+func (c *fallbackClient) Add(ctx context.Context, node NodeInfo) error {
+	err := c.primary.Add(ctx, node)
+	if shouldFallback(err) {
+		log.Printf("[WARNING] failed to add dqlite node, will retry with fallback client: %v", err)
+		return c.fallback.Add(ctx, node)
+	}
+	return err
+}
+
+func (c *fallbackClient) Assign(ctx context.Context, address string, role NodeRole) error {
+	err := c.primary.Assign(ctx, address, role)
+	if shouldFallback(err) {
+		log.Printf("[WARNING] failed to assign dqlite node role, will retry with fallback client: %v", err)
+		return c.fallback.Assign(ctx, address, role)
+	}
+	return err
+}
+
+func (c *fallbackClient) Remove(ctx context.Context, address string) error {
+	err := c.primary.Remove(ctx, address)
+	if shouldFallback(err) {
+		log.Printf("[WARNING] failed to remove dqlite node, will retry with fallback client: %v", err)
+		return c.fallback.Remove(ctx, address)
+	}
+	return err
+}
+
+var _ Client = &fallbackClient{}
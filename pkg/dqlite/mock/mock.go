@@ -0,0 +1,94 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
+)
+
+// AssignCall contains the arguments passed to a specific call of the Assign method.
+type AssignCall struct {
+	Address string
+	Role    dqlite.NodeRole
+}
+
+// Client is a fake in-memory implementation of the dqlite.Client interface.
+// Membership changes are applied to Nodes, so that subsequent calls observe them.
+type Client struct {
+	// Nodes is the current list of dqlite cluster nodes.
+	Nodes []dqlite.NodeInfo
+	// LeaderAddress is the address of the current leader.
+	LeaderAddress string
+
+	// Err is returned by all methods, if set.
+	Err error
+
+	AddCalledWith    []dqlite.NodeInfo
+	AssignCalledWith []AssignCall
+	RemoveCalledWith []string
+}
+
+// Cluster is a fake implementation for the dqlite.Client interface.
+func (c *Client) Cluster(_ context.Context) ([]dqlite.NodeInfo, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	return append([]dqlite.NodeInfo(nil), c.Nodes...), nil
+}
+
+// Leader is a fake implementation for the dqlite.Client interface.
+func (c *Client) Leader(_ context.Context) (dqlite.NodeInfo, error) {
+	if c.Err != nil {
+		return dqlite.NodeInfo{}, c.Err
+	}
+	if c.LeaderAddress == "" {
+		return dqlite.NodeInfo{}, dqlite.ErrNoLeader
+	}
+	return dqlite.FindNode(c.Nodes, c.LeaderAddress)
+}
+
+// Add is a fake implementation for the dqlite.Client interface.
+func (c *Client) Add(_ context.Context, node dqlite.NodeInfo) error {
+	c.AddCalledWith = append(c.AddCalledWith, node)
+	if c.Err != nil {
+		return c.Err
+	}
+	if _, err := dqlite.FindNode(c.Nodes, node.Address); err == nil {
+		return fmt.Errorf("node %s already exists", node.Address)
+	}
+	c.Nodes = append(c.Nodes, node)
+	return nil
+}
+
+// Assign is a fake implementation for the dqlite.Client interface.
+func (c *Client) Assign(_ context.Context, address string, role dqlite.NodeRole) error {
+	c.AssignCalledWith = append(c.AssignCalledWith, AssignCall{Address: address, Role: role})
+	if c.Err != nil {
+		return c.Err
+	}
+	for i := range c.Nodes {
+		if c.Nodes[i].Address == address {
+			c.Nodes[i].Role = role
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", dqlite.ErrNodeNotFound, address)
+}
+
+// Remove is a fake implementation for the dqlite.Client interface.
+func (c *Client) Remove(_ context.Context, address string) error {
+	c.RemoveCalledWith = append(c.RemoveCalledWith, address)
+	if c.Err != nil {
+		return c.Err
+	}
+	for i := range c.Nodes {
+		if c.Nodes[i].Address == address {
+			c.Nodes = append(c.Nodes[:i], c.Nodes[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", dqlite.ErrNodeNotFound, address)
+}
+
+var _ dqlite.Client = &Client{}
@@ -0,0 +1,312 @@
+package dqlite
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// NodeStore returns the "host:port" addresses of known dqlite nodes.
+// The addresses are used to discover the cluster leader.
+type NodeStore func(ctx context.Context) ([]string, error)
+
+// nativeClient implements Client using the dqlite wire protocol.
+type nativeClient struct {
+	store       NodeStore
+	tlsConfig   *tls.Config
+	dialTimeout time.Duration
+}
+
+// NewClient creates a new Client that talks to the dqlite nodes using the dqlite wire protocol.
+// store is used to discover the known dqlite nodes. tlsConfig may be nil, in which case plain TCP is used.
+func NewClient(store NodeStore, tlsConfig *tls.Config) Client {
+	return &nativeClient{
+		store:       store,
+		tlsConfig:   tlsConfig,
+		dialTimeout: 5 * time.Second,
+	}
+}
+
+// NewTLSConfig creates the TLS configuration for connecting to a dqlite cluster.
+// certPEM and keyPEM are the shared cluster certificate and private key. The certificate is also used as the
+// root of trust, since dqlite nodes all share the same self-signed certificate.
+func NewTLSConfig(certPEM, keyPEM []byte) (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cluster certificate: %w", err)
+	}
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cluster certificate: %w", err)
+	}
+	if len(x509Cert.DNSNames) == 0 {
+		return nil, fmt.Errorf("cluster certificate has no DNS names")
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(x509Cert)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   x509Cert.DNSNames[0],
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// conn is a connection to a dqlite node.
+type conn struct {
+	net.Conn
+}
+
+func (c *nativeClient) dial(ctx context.Context, address string) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	if c.tlsConfig != nil {
+		tlsConn := tls.Client(netConn, c.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("TLS handshake with %s failed: %w", address, err)
+		}
+		netConn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+
+	var handshake [8]byte
+	binary.LittleEndian.PutUint64(handshake[:], protocolVersion)
+	if _, err := netConn.Write(handshake[:]); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("protocol handshake with %s failed: %w", address, err)
+	}
+	return &conn{Conn: netConn}, nil
+}
+
+// call sends a request and reads the response.
+func (c *conn) call(req *message) (*message, error) {
+	if err := req.writeTo(c); err != nil {
+		return nil, err
+	}
+	return readMessage(c)
+}
+
+func (c *conn) leader() (NodeInfo, error) {
+	req := newMessage(requestLeader)
+	req.putUint64(0)
+	resp, err := c.call(req)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	if err := resp.expect(responseNode); err != nil {
+		return NodeInfo{}, err
+	}
+	id, err := resp.getUint64()
+	if err != nil {
+		return NodeInfo{}, fmt.Errorf("failed to decode leader id: %w", err)
+	}
+	address, err := resp.getString()
+	if err != nil {
+		return NodeInfo{}, fmt.Errorf("failed to decode leader address: %w", err)
+	}
+	return NodeInfo{ID: id, Address: address}, nil
+}
+
+func (c *conn) cluster() ([]NodeInfo, error) {
+	req := newMessage(requestCluster)
+	req.putUint64(clusterFormatV1)
+	resp, err := c.call(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := resp.expect(responseNodes); err != nil {
+		return nil, err
+	}
+	n, err := resp.getUint64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode number of nodes: %w", err)
+	}
+	nodes := make([]NodeInfo, 0, n)
+	for i := uint64(0); i < n; i++ {
+		var node NodeInfo
+		if node.ID, err = resp.getUint64(); err != nil {
+			return nil, fmt.Errorf("failed to decode node id: %w", err)
+		}
+		if node.Address, err = resp.getString(); err != nil {
+			return nil, fmt.Errorf("failed to decode node address: %w", err)
+		}
+		role, err := resp.getUint64()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode node role: %w", err)
+		}
+		node.Role = NodeRole(role)
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// exec sends a request that expects an empty response.
+func (c *conn) exec(req *message) error {
+	resp, err := c.call(req)
+	if err != nil {
+		return err
+	}
+	return resp.expect(responseEmpty)
+}
+
+// connectToLeader finds the current cluster leader and returns a connection to it.
+func (c *nativeClient) connectToLeader(ctx context.Context) (*conn, error) {
+	addresses, err := c.store(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve known dqlite nodes: %w", err)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("empty list of known dqlite nodes")
+	}
+
+	var errs []error
+	for _, address := range addresses {
+		conn, err := c.dial(ctx, address)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		leader, err := conn.leader()
+		switch {
+		case err != nil:
+			conn.Close()
+			errs = append(errs, fmt.Errorf("failed to query leader from %s: %w", address, err))
+			continue
+		case leader.Address == "":
+			conn.Close()
+			continue
+		case leader.Address == address:
+			return conn, nil
+		}
+		conn.Close()
+
+		if conn, err = c.dial(ctx, leader.Address); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return conn, nil
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrNoLeader, errors.Join(errs...))
+	}
+	return nil, ErrNoLeader
+}
+
+func (c *nativeClient) Cluster(ctx context.Context) ([]NodeInfo, error) {
+	conn, err := c.connectToLeader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.cluster()
+}
+
+func (c *nativeClient) Leader(ctx context.Context) (NodeInfo, error) {
+	conn, err := c.connectToLeader(ctx)
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	defer conn.Close()
+	leader, err := conn.leader()
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	nodes, err := conn.cluster()
+	if err != nil {
+		log.Printf("[WARNING] failed to retrieve role of dqlite leader: %v", err)
+		return leader, nil
+	}
+	if node, err := FindNode(nodes, leader.Address); err == nil {
+		return node, nil
+	}
+	return leader, nil
+}
+
+func (c *nativeClient) Add(ctx context.Context, node NodeInfo) error {
+	conn, err := c.connectToLeader(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := newMessage(requestAdd)
+	req.putUint64(node.ID)
+	req.putString(node.Address)
+	if err := conn.exec(req); err != nil {
+		return fmt.Errorf("failed to add node %s: %w", node.Address, err)
+	}
+
+	// nodes are always added as spare, promote them if needed
+	if node.Role == Spare {
+		return nil
+	}
+	return assign(conn, node.ID, node.Role)
+}
+
+func (c *nativeClient) Assign(ctx context.Context, address string, role NodeRole) error {
+	conn, err := c.connectToLeader(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	nodes, err := conn.cluster()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve cluster nodes: %w", err)
+	}
+	node, err := FindNode(nodes, address)
+	if err != nil {
+		return err
+	}
+	return assign(conn, node.ID, role)
+}
+
+func assign(conn *conn, id uint64, role NodeRole) error {
+	req := newMessage(requestAssign)
+	req.putUint64(id)
+	req.putUint64(uint64(role))
+	if err := conn.exec(req); err != nil {
+		return fmt.Errorf("failed to assign role %v to node %d: %w", role, id, err)
+	}
+	return nil
+}
+
+func (c *nativeClient) Remove(ctx context.Context, address string) error {
+	conn, err := c.connectToLeader(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	nodes, err := conn.cluster()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve cluster nodes: %w", err)
+	}
+	node, err := FindNode(nodes, address)
+	if err != nil {
+		return err
+	}
+
+	req := newMessage(requestRemove)
+	req.putUint64(node.ID)
+	if err := conn.exec(req); err != nil {
+		return fmt.Errorf("failed to remove node %s: %w", address, err)
+	}
+	return nil
+}
+
+var _ Client = &nativeClient{}
@@ -0,0 +1,212 @@
+package dqlite
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+// testServer is a minimal in-process dqlite node that implements the membership messages.
+type testServer struct {
+	listener net.Listener
+
+	mu     sync.Mutex
+	leader string
+	nodes  []NodeInfo
+}
+
+func newTestServer(t *testing.T) *testServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start listener: %v", err)
+	}
+	srv := &testServer{listener: l}
+	t.Cleanup(func() { l.Close() })
+	go srv.serve()
+	return srv
+}
+
+func (s *testServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+	var handshake [8]byte
+	if _, err := io.ReadFull(conn, handshake[:]); err != nil || binary.LittleEndian.Uint64(handshake[:]) != protocolVersion {
+		return
+	}
+	for {
+		req, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		if err := s.response(req).writeTo(conn); err != nil {
+			return
+		}
+	}
+}
+
+func (s *testServer) response(req *message) *message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failure := func(message string) *message {
+		resp := newMessage(responseFailure)
+		resp.putUint64(1)
+		resp.putString(message)
+		return resp
+	}
+	empty := func() *message {
+		resp := newMessage(responseEmpty)
+		resp.putUint64(0)
+		return resp
+	}
+
+	switch req.mtype {
+	case requestLeader:
+		resp := newMessage(responseNode)
+		var id uint64
+		if node, err := FindNode(s.nodes, s.leader); err == nil {
+			id = node.ID
+		}
+		resp.putUint64(id)
+		resp.putString(s.leader)
+		return resp
+	case requestCluster:
+		resp := newMessage(responseNodes)
+		resp.putUint64(uint64(len(s.nodes)))
+		for _, node := range s.nodes {
+			resp.putUint64(node.ID)
+			resp.putString(node.Address)
+			resp.putUint64(uint64(node.Role))
+		}
+		return resp
+	case requestAdd:
+		id, _ := req.getUint64()
+		address, _ := req.getString()
+		s.nodes = append(s.nodes, NodeInfo{ID: id, Address: address, Role: Spare})
+		return empty()
+	case requestAssign:
+		id, _ := req.getUint64()
+		role, _ := req.getUint64()
+		for i := range s.nodes {
+			if s.nodes[i].ID == id {
+				s.nodes[i].Role = NodeRole(role)
+				return empty()
+			}
+		}
+		return failure("no such node")
+	case requestRemove:
+		id, _ := req.getUint64()
+		for i := range s.nodes {
+			if s.nodes[i].ID == id {
+				s.nodes = append(s.nodes[:i], s.nodes[i+1:]...)
+				return empty()
+			}
+		}
+		return failure("no such node")
+	default:
+		return failure("unknown request")
+	}
+}
+
+func TestNativeClient(t *testing.T) {
+	leader := newTestServer(t)
+	follower := newTestServer(t)
+	nodes := []NodeInfo{
+		{ID: 1, Address: leader.address(), Role: Voter},
+		{ID: 2, Address: follower.address(), Role: Voter},
+		{ID: 3, Address: "10.0.0.3:19001", Role: Spare},
+	}
+	for _, srv := range []*testServer{leader, follower} {
+		srv.leader = leader.address()
+		srv.nodes = append([]NodeInfo(nil), nodes...)
+	}
+
+	// only know about the follower, to ensure requests are redirected to the leader.
+	client := NewClient(func(context.Context) ([]string, error) {
+		return []string{"127.0.0.1:1", follower.address()}, nil
+	}, nil)
+
+	t.Run("Cluster", func(t *testing.T) {
+		g := NewWithT(t)
+		cluster, err := client.Cluster(context.Background())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cluster).To(Equal(nodes))
+	})
+
+	t.Run("Leader", func(t *testing.T) {
+		g := NewWithT(t)
+		node, err := client.Leader(context.Background())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(node).To(Equal(nodes[0]))
+	})
+
+	t.Run("Add", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(client.Add(context.Background(), NodeInfo{ID: 4, Address: "10.0.0.4:19001", Role: StandBy})).To(Succeed())
+		g.Expect(leader.nodes).To(ContainElement(NodeInfo{ID: 4, Address: "10.0.0.4:19001", Role: StandBy}))
+		g.Expect(follower.nodes).To(HaveLen(3))
+	})
+
+	t.Run("Assign", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(client.Assign(context.Background(), "10.0.0.3:19001", Voter)).To(Succeed())
+		g.Expect(leader.nodes).To(ContainElement(NodeInfo{ID: 3, Address: "10.0.0.3:19001", Role: Voter}))
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(client.Remove(context.Background(), "10.0.0.3:19001")).To(Succeed())
+		g.Expect(leader.nodes).ToNot(ContainElement(HaveField("Address", "10.0.0.3:19001")))
+	})
+
+	t.Run("RemoveUnknown", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(client.Remove(context.Background(), "10.0.0.100:19001")).To(MatchError(ErrNodeNotFound))
+	})
+
+	t.Run("NoLeader", func(t *testing.T) {
+		g := NewWithT(t)
+		client := NewClient(func(context.Context) ([]string, error) {
+			return []string{"127.0.0.1:1"}, nil
+		}, nil)
+		_, err := client.Cluster(context.Background())
+		g.Expect(err).To(MatchError(ErrNoLeader))
+	})
+}
+
+func TestMessageString(t *testing.T) {
+	for _, s := range []string{"", "1234567", "12345678", "10.10.10.10:19001"} {
+		t.Run(s, func(t *testing.T) {
+			g := NewWithT(t)
+			m := newMessage(requestAdd)
+			m.putString(s)
+			m.putUint64(42)
+			g.Expect(m.body.Len() % 8).To(BeZero())
+
+			decoded, err := m.getString()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(decoded).To(Equal(s))
+			v, err := m.getUint64()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(v).To(Equal(uint64(42)))
+		})
+	}
+}
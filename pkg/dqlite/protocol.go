@@ -0,0 +1,133 @@
+package dqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The dqlite wire protocol is documented at https://dqlite.io/docs/protocol.
+// Only the subset of messages required for managing the cluster membership is implemented.
+
+const (
+	// protocolVersion is the version of the dqlite wire protocol sent during the handshake.
+	protocolVersion = uint64(1)
+
+	// clusterFormatV1 requests node roles to be included in the cluster response.
+	clusterFormatV1 = uint64(1)
+)
+
+// request message types
+const (
+	requestLeader  = uint8(0)
+	requestAdd     = uint8(12)
+	requestAssign  = uint8(13)
+	requestRemove  = uint8(14)
+	requestCluster = uint8(16)
+)
+
+// response message types
+const (
+	responseFailure = uint8(0)
+	responseNode    = uint8(1)
+	responseNodes   = uint8(3)
+	responseEmpty   = uint8(8)
+)
+
+// message is a dqlite protocol message.
+type message struct {
+	// mtype is the message type.
+	mtype uint8
+	// schema is the schema version of the message.
+	schema uint8
+	// body is the message body. The length is always a multiple of 8 bytes.
+	body bytes.Buffer
+}
+
+func newMessage(mtype uint8) *message {
+	return &message{mtype: mtype}
+}
+
+func (m *message) putUint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	m.body.Write(b[:])
+}
+
+func (m *message) putString(v string) {
+	m.body.WriteString(v)
+	// null-terminated, padded to the word boundary
+	m.body.Write(make([]byte, 8-len(v)%8))
+}
+
+func (m *message) getUint64() (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(&m.body, b[:]); err != nil {
+		return 0, fmt.Errorf("short message body: %w", err)
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func (m *message) getString() (string, error) {
+	b := m.body.Bytes()
+	end := bytes.IndexByte(b, 0)
+	if end == -1 {
+		return "", fmt.Errorf("string is not null-terminated")
+	}
+	padded := (end/8 + 1) * 8
+	if padded > len(b) {
+		return "", fmt.Errorf("short message body")
+	}
+	m.body.Next(padded)
+	return string(b[:end]), nil
+}
+
+// writeTo writes the message header and body.
+func (m *message) writeTo(w io.Writer) error {
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(m.body.Len()/8))
+	header[4] = m.mtype
+	header[5] = m.schema
+	if _, err := w.Write(append(header[:], m.body.Bytes()...)); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	return nil
+}
+
+// readMessage reads a message from r.
+func readMessage(r io.Reader) (*message, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read message header: %w", err)
+	}
+	m := &message{
+		mtype:  header[4],
+		schema: header[5],
+	}
+	words := binary.LittleEndian.Uint32(header[0:4])
+	if _, err := io.CopyN(&m.body, r, int64(words)*8); err != nil {
+		return nil, fmt.Errorf("failed to read message body: %w", err)
+	}
+	return m, nil
+}
+
+// expect checks that the response has the expected type, and decodes failure responses.
+func (m *message) expect(mtype uint8) error {
+	switch m.mtype {
+	case mtype:
+		return nil
+	case responseFailure:
+		code, err := m.getUint64()
+		if err != nil {
+			return fmt.Errorf("failed to decode failure response: %w", err)
+		}
+		message, err := m.getString()
+		if err != nil {
+			return fmt.Errorf("failed to decode failure response: %w", err)
+		}
+		return &Error{Code: code, Message: message}
+	default:
+		return fmt.Errorf("unexpected response type %d (expected %d)", m.mtype, mtype)
+	}
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// JoinMode is the kind of node that joins the cluster.
+type JoinMode string
+
+const (
+	// JoinModeWorker is a worker-only node.
+	JoinModeWorker JoinMode = "worker"
+	// JoinModeControlPlane is a control plane node in a cluster that uses dqlite as datastore.
+	JoinModeControlPlane JoinMode = "control-plane"
+	// JoinModeCustomEtcd is a control plane node in a cluster that uses a custom etcd datastore.
+	JoinModeCustomEtcd JoinMode = "custom-etcd"
+)
+
+var (
+	joinPhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "microk8s_cluster_agent",
+		Subsystem: "join",
+		Name:      "phase_duration_seconds",
+		Help:      "Duration of the phases of join requests.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	}, []string{"api", "mode", "phase"})
+
+	joinDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "microk8s_cluster_agent",
+		Subsystem: "join",
+		Name:      "duration_seconds",
+		Help:      "Duration of join requests.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	}, []string{"api", "mode", "result"})
+
+	joinRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "microk8s_cluster_agent",
+		Subsystem: "join",
+		Name:      "requests_total",
+		Help:      "Number of join requests, by outcome. For failed requests, reason is the phase that failed.",
+	}, []string{"api", "mode", "result", "reason"})
+)
+
+func init() {
+	prometheus.MustRegister(joinPhaseDuration, joinDuration, joinRequests)
+}
+
+// JoinTracker records the phases and the outcome of a join request. Phases are sequential: starting a phase ends the
+// previous one. All methods are no-ops on a nil *JoinTracker.
+type JoinTracker struct {
+	// ID identifies the join request in log lines.
+	ID string
+
+	api   string
+	mode  JoinMode
+	start time.Time
+
+	// phase is the running phase, and phaseStart is when it started.
+	phase      string
+	phaseStart time.Time
+
+	// now is time.Now. It is replaced in tests.
+	now func() time.Time
+}
+
+// NewJoinTracker starts tracking a join request handled by the given API version.
+func NewJoinTracker(api string, mode JoinMode) *JoinTracker {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	t := &JoinTracker{ID: hex.EncodeToString(b), api: api, mode: mode, now: time.Now}
+	t.start = t.now()
+	return t
+}
+
+// Phase ends the running phase, if any, and starts the next phase of the join request.
+func (t *JoinTracker) Phase(phase string) {
+	if t == nil {
+		return
+	}
+	t.endPhase()
+	t.phase = phase
+	t.phaseStart = t.now()
+}
+
+// endPhase records the duration of the running phase.
+func (t *JoinTracker) endPhase() {
+	if t.phase == "" {
+		return
+	}
+	d := t.now().Sub(t.phaseStart)
+	joinPhaseDuration.WithLabelValues(t.api, string(t.mode), t.phase).Observe(d.Seconds())
+	log.Printf("join=%s api=%s mode=%s phase=%s duration=%v", t.ID, t.api, t.mode, t.phase, d)
+}
+
+// Done ends the running phase and records the outcome of the join request. err is the error returned to the joining
+// node, if any. For failed join requests, the failure reason is the phase that was running.
+func (t *JoinTracker) Done(err error) {
+	if t == nil {
+		return
+	}
+	t.endPhase()
+	result, reason := "success", ""
+	if err != nil {
+		result, reason = "failure", t.phase
+	}
+	d := t.now().Sub(t.start)
+	joinDuration.WithLabelValues(t.api, string(t.mode), result).Observe(d.Seconds())
+	joinRequests.WithLabelValues(t.api, string(t.mode), result, reason).Inc()
+	if err != nil {
+		log.Printf("join=%s api=%s mode=%s result=%s reason=%s duration=%v error=%q", t.ID, t.api, t.mode, result, reason, d, err)
+	} else {
+		log.Printf("join=%s api=%s mode=%s result=%s duration=%v", t.ID, t.api, t.mode, result, d)
+	}
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gather returns the value of the counters, or the sample count of the histograms, of a metric. The values are keyed by
+// the label values, sorted by label name.
+func gather(g Gomega, name string) map[string]float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	g.Expect(err).ToNot(HaveOccurred())
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var key []string
+			for _, label := range m.GetLabel() {
+				key = append(key, label.GetValue())
+			}
+			if m.GetHistogram() != nil {
+				values[strings.Join(key, ",")] = float64(m.GetHistogram().GetSampleCount())
+			} else {
+				values[strings.Join(key, ",")] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+func TestJoinTracker(t *testing.T) {
+	newTracker := func(mode JoinMode) (*JoinTracker, *time.Time) {
+		now := time.Now()
+		tracker := NewJoinTracker("test", mode)
+		tracker.now = func() time.Time { return now }
+		tracker.start = now
+		return tracker, &now
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		g := NewWithT(t)
+		tracker, now := newTracker(JoinModeWorker)
+		g.Expect(tracker.ID).To(HaveLen(8))
+
+		tracker.Phase("token")
+		*now = now.Add(time.Second)
+		tracker.Phase("cni")
+		*now = now.Add(30 * time.Second)
+		tracker.Done(nil)
+
+		g.Expect(gather(g, "microk8s_cluster_agent_join_requests_total")).To(HaveKeyWithValue("test,worker,,success", 1.0))
+		g.Expect(gather(g, "microk8s_cluster_agent_join_duration_seconds")).To(HaveKeyWithValue("test,worker,success", 1.0))
+		phases := gather(g, "microk8s_cluster_agent_join_phase_duration_seconds")
+		g.Expect(phases).To(HaveKeyWithValue("test,worker,token", 1.0))
+		g.Expect(phases).To(HaveKeyWithValue("test,worker,cni", 1.0))
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		g := NewWithT(t)
+		tracker, _ := newTracker(JoinModeControlPlane)
+
+		tracker.Phase("token")
+		tracker.Phase("dqlite-wait")
+		tracker.Done(fmt.Errorf("failed to retrieve dqlite cluster nodes"))
+
+		requests := gather(g, "microk8s_cluster_agent_join_requests_total")
+		g.Expect(requests).To(HaveKeyWithValue("test,control-plane,dqlite-wait,failure", 1.0))
+		g.Expect(requests).ToNot(HaveKey("test,control-plane,token,failure"))
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		var tracker *JoinTracker
+		tracker.Phase("token")
+		tracker.Done(nil)
+	})
+}
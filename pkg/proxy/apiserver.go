@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"reflect"
+	"sync"
 	"time"
 
 	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -15,6 +18,9 @@ import (
 
 // APIServerProxy is a TCP proxy that forwards requests to the API Servers of the cluster.
 type APIServerProxy struct {
+	// ConfigFile is the path to the apiserver proxy configuration file. If it does not exist, it is created from the
+	// traefik configuration. If empty, the traefik configuration is used directly.
+	ConfigFile string
 	// TraefikConfigFile is the path to the traefik configuration file.
 	// Note that this is only to stay backwards-compatible with the initial implementation of
 	// worker nodes that was using Traefik for proxying requests to the control plane.
@@ -23,12 +29,53 @@ type APIServerProxy struct {
 	// KubeconfigFile is the path to the kubeconfig file to use for updating the list of known apiservers.
 	// The known apiservers are retrieved from `kubectl get endpoints kubernetes`.
 	KubeconfigFile string
-	// RefreshCh is used to check for updates in the list of control plane nodes in the cluster.
+	// RefreshCh is used to check for updates in the list of control plane nodes in the cluster. If WatchEndpoints is
+	// set, RefreshCh is only used while the watch fails. If nil, the list of control plane nodes is not updated.
 	RefreshCh <-chan time.Time
+	// WatchEndpoints watches the list of control plane nodes in the cluster for changes, instead of polling it.
+	WatchEndpoints bool
+	// HealthCheck configures active health checks against the "/readyz" endpoint of the control plane nodes.
+	// Health checks authenticate with the credentials from KubeconfigFile. Settings in ConfigFile take precedence.
+	HealthCheck HealthCheckConfig
+	// DrainTimeout is how long existing connections to removed control plane nodes are kept open after the list of
+	// endpoints changes. Connections still open after DrainTimeout are closed. Settings in ConfigFile take precedence.
+	DrainTimeout time.Duration
+	// Connections configures timeouts, TCP keepalives and limits of proxied connections. Settings in ConfigFile take
+	// precedence.
+	Connections ConnectionConfig
+	// L7 configures the TLS-terminating mode of the proxy. Requests with the client certificate of KubeconfigFile are
+	// forwarded with the credentials from KubeconfigFile, and requests with other client certificates with the front
+	// proxy certificate. If disabled or if it cannot be initialized (e.g. kube-proxy authenticates with a client
+	// certificate and no front proxy certificate is configured), TCP connections are forwarded. Additional services
+	// are always proxied over TCP.
+	L7 L7Config
+	// Strategy is the load-balancing strategy for new connections. If empty, StrategyRoundRobin is used.
+	// StrategyLocality looks up the topology zones of the control plane nodes with the credentials from KubeconfigFile.
+	Strategy Strategy
+	// MetricsAddress is the address to serve Prometheus metrics ("/metrics") and the proxy status ("/status") on.
+	// If empty, metrics are not served.
+	MetricsAddress string
+	// EndpointCacheFile is the path to a file that remembers all control plane endpoints retrieved from the cluster,
+	// with the time they were last seen. If none of the configured endpoints is reachable when the proxy starts, the
+	// cached endpoints are tried as well. If empty, the endpoint cache is disabled.
+	EndpointCacheFile string
+
+	mu sync.Mutex // guards the following fields
+	// proxy is the running proxy.
+	proxy *tcpproxy
+	// services are the running proxies of additional services, by name.
+	services map[string]*tcpproxy
+	// lastRefresh is the outcome of the last attempt to retrieve the list of control plane endpoints.
+	lastRefresh *RefreshStatus
+	// endpointCache is the endpoint cache, loaded from EndpointCacheFile on first use.
+	endpointCache *internal.EndpointCache
 }
 
 // Run starts the proxy.
 func (p *APIServerProxy) Run(ctx context.Context) error {
+	if p.MetricsAddress != "" {
+		go p.serveMetrics(ctx)
+	}
 	for {
 		select {
 		case <-ctx.Done():
@@ -40,82 +87,277 @@ func (p *APIServerProxy) Run(ctx context.Context) error {
 		proxyCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		cfg, err := internal.LoadConfiguration(proxyCtx, p.TraefikConfigFile)
+		cfgCtx, cfgCancel := context.WithCancel(proxyCtx)
+		cfg, err := internal.LoadConfiguration(cfgCtx, p.ConfigFile, p.TraefikConfigFile)
 		if err != nil {
+			cfgCancel()
 			return fmt.Errorf("failed to load configuration: %w", err)
 		}
 
-		go p.startProxy(proxyCtx, cancel, cfg)
-		go p.watchForNewEndpoints(proxyCtx, cancel, cfg)
-		go p.watchForConfigFileChanges(proxyCtx, cancel, cfg)
+		// endpoint changes are applied without restarting the proxy
+		updateCh := make(chan []string)
+		endpoints := p.withCachedEndpoints(proxyCtx, cfg.Endpoints)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.startProxy(proxyCtx, cancel, cfg, endpoints, updateCh)
+		}()
+		for _, svc := range cfg.Services {
+			wg.Add(1)
+			go func(svc internal.ServiceConfiguration) {
+				defer wg.Done()
+				p.runService(proxyCtx, svc)
+			}(svc)
+		}
+		go p.watchForNewEndpoints(proxyCtx, endpoints, updateCh)
+		go p.watchForConfigFileChanges(proxyCtx, cancel, cfg, cfgCancel, updateCh)
 
 		<-proxyCtx.Done()
+		// on restarts, active connections are drained in the background
+		if ctx.Err() != nil {
+			wg.Wait()
+		}
 	}
 }
 
-func (p *APIServerProxy) startProxy(ctx context.Context, cancel func(), cfg *internal.Configuration) {
-	if err := startProxy(ctx, cfg.Listen, cfg.Endpoints); err != nil {
+func (p *APIServerProxy) startProxy(ctx context.Context, cancel func(), cfg *internal.Configuration, endpoints []string, updateCh <-chan []string) {
+	var hc *healthCheck
+	if hcConfig := p.healthCheckConfig(cfg); hcConfig.Interval > 0 {
+		if check, err := newReadyzCheck(p.KubeconfigFile); err != nil {
+			log.Printf("WARNING: active health checks are disabled, failed to initialize health check: %q", err)
+		} else {
+			hc = &healthCheck{HealthCheckConfig: hcConfig, check: check}
+		}
+	}
+	drainTimeout := p.DrainTimeout
+	if cfg.Timeouts.Drain > 0 {
+		drainTimeout = cfg.Timeouts.Drain
+	}
+	opts := proxyOptions{
+		healthCheck:  hc,
+		drainTimeout: drainTimeout,
+		strategy:     p.Strategy,
+		backends:     cfg.Backends,
+		connections:  p.connectionConfig(cfg),
+	}
+	if p.L7.Enabled {
+		if l7, err := newL7Proxy(p.L7, p.KubeconfigFile); err != nil {
+			log.Printf("WARNING: forwarding TCP connections, failed to initialize TLS-terminating mode: %q", err)
+		} else {
+			opts.l7 = l7
+		}
+	}
+	switch {
+	case p.Strategy == StrategyLocality:
+		opts.locality = p.getLocality(ctx)
+	case p.Strategy == StrategyLatency && hc == nil:
+		log.Printf("WARNING: load-balancing strategy %s requires active health checks, falling back to round robin", p.Strategy)
+		opts.strategy = StrategyRoundRobin
+	}
+	tp, err := newProxy(cfg.Listeners, endpoints, opts)
+	if err != nil {
 		log.Println(fmt.Errorf("apiserver proxy failed: %w", err))
 		cancel()
+		return
 	}
+	p.setProxy(tp)
+	runProxy(ctx, tp, updateCh, cfg.Backends)
+	p.setProxy(nil)
 }
 
-func (p *APIServerProxy) watchForNewEndpoints(ctx context.Context, cancel func(), cfg *internal.Configuration) {
-	if p.RefreshCh == nil {
-		return
+// applyEndpoints records the outcome of retrieving the control plane endpoints of the cluster. If the endpoints
+// changed, they are written to the configuration file and sent to updateCh. It returns false if the context is
+// cancelled.
+func (p *APIServerProxy) applyEndpoints(ctx context.Context, endpoints []string, err error, current *[]string, updateCh chan<- []string) bool {
+	p.recordRefresh(endpoints, err)
+	switch {
+	case err != nil:
+		log.Println(fmt.Errorf("failed to retrieve kubernetes endpoints: %w", err))
+		return true
+	case len(endpoints) == 0:
+		log.Println("warning: empty list of endpoints, skipping update")
+		return true
 	}
+	p.updateEndpointCache(endpoints)
+	if len(endpoints) == len(*current) && reflect.DeepEqual(endpoints, *current) {
+		return true
+	}
+	log.Println("updating endpoints")
+
+	if err := internal.UpdateEndpoints(endpoints, p.ConfigFile, p.TraefikConfigFile); err != nil {
+		log.Printf("could not update configuration file with new endpoints: %q", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case updateCh <- endpoints:
+		*current = endpoints
+		return true
+	}
+}
+
+// watchForConfigFileChanges reloads the configuration when the config file changes on disk. New endpoints are sent
+// to updateCh. The proxy is only restarted if settings other than the endpoints change, or the configuration cannot be
+// loaded.
+// cfgCancel stops watching the config file of cfg.
+func (p *APIServerProxy) watchForConfigFileChanges(ctx context.Context, cancel func(), cfg *internal.Configuration, cfgCancel func(), updateCh chan<- []string) {
 	for {
 		select {
 		case <-ctx.Done():
+			cfgCancel()
 			return
-		case <-p.RefreshCh:
+		case <-cfg.ChangedCh:
 		}
 
-		endpoints, err := getKubernetesEndpoints(ctx, p.KubeconfigFile)
+		// The config file may have been replaced, which stops the file watch. Reload with a new file watch.
+		cfgCancel()
+		var cfgCtx context.Context
+		cfgCtx, cfgCancel = context.WithCancel(ctx)
+		newCfg, err := internal.LoadConfiguration(cfgCtx, p.ConfigFile, p.TraefikConfigFile)
 		switch {
 		case err != nil:
-			log.Println(fmt.Errorf("failed to retrieve kubernetes endpoints: %w", err))
-			continue
-		case len(endpoints) == 0:
-			log.Println("warning: empty list of endpoints, skipping update")
-			continue
-		case len(endpoints) == len(cfg.Endpoints) && reflect.DeepEqual(endpoints, cfg.Endpoints):
+			log.Printf("Config file changed on disk but could not be loaded, will restart proxy: %q", err)
+		case !newCfg.SettingsEqual(cfg):
+			log.Println("Proxy settings changed in config file, will restart proxy")
+		default:
+			log.Println("Config file changed on disk, updating endpoints")
+			cfg = newCfg
+			select {
+			case <-ctx.Done():
+			case updateCh <- cfg.Endpoints:
+			}
 			continue
 		}
-		log.Println("updating endpoints")
-
-		if err := internal.UpdateConfiguration(endpoints, p.TraefikConfigFile); err != nil {
-			log.Printf("could not update configuration file with new endpoints: %q", err)
-		}
-
-		// cancel context in order to restart the proxy
+		cfgCancel()
 		cancel()
 		return
 	}
 }
 
-func (p *APIServerProxy) watchForConfigFileChanges(ctx context.Context, cancel func(), cfg *internal.Configuration) {
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-cfg.ChangedCh:
-			log.Println("Config file changed on disk, will restart proxy")
-			cancel()
-			return
-		}
+// healthCheckConfig returns the active health check settings, with the settings of the configuration file taking
+// precedence.
+func (p *APIServerProxy) healthCheckConfig(cfg *internal.Configuration) HealthCheckConfig {
+	hcConfig := p.HealthCheck
+	if cfg.HealthCheck == nil {
+		return hcConfig
 	}
+	if cfg.HealthCheck.Disabled {
+		return HealthCheckConfig{}
+	}
+	if cfg.HealthCheck.Interval > 0 {
+		hcConfig.Interval = cfg.HealthCheck.Interval
+	}
+	if cfg.HealthCheck.Timeout > 0 {
+		hcConfig.Timeout = cfg.HealthCheck.Timeout
+	}
+	if cfg.HealthCheck.Rise > 0 {
+		hcConfig.Rise = cfg.HealthCheck.Rise
+	}
+	if cfg.HealthCheck.Fall > 0 {
+		hcConfig.Fall = cfg.HealthCheck.Fall
+	}
+	return hcConfig
 }
 
-func getKubernetesEndpoints(ctx context.Context, kubeconfigFile string) ([]string, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+// connectionConfig returns the connection settings, with the settings of the configuration file taking precedence.
+func (p *APIServerProxy) connectionConfig(cfg *internal.Configuration) ConnectionConfig {
+	return withConnectionOverrides(p.Connections, cfg.Timeouts, cfg.MaxConnections)
+}
+
+// withConnectionOverrides returns the connection settings, with the non-zero timeouts and maxConnections taking
+// precedence.
+func withConnectionOverrides(connConfig ConnectionConfig, timeouts internal.Timeouts, maxConnections int) ConnectionConfig {
+	if timeouts.Dial > 0 {
+		connConfig.DialTimeout = timeouts.Dial
+	}
+	if timeouts.Idle > 0 {
+		connConfig.IdleTimeout = timeouts.Idle
+	}
+	if timeouts.HalfClose > 0 {
+		connConfig.HalfCloseTimeout = timeouts.HalfClose
+	}
+	if timeouts.KeepAlive != 0 {
+		connConfig.KeepAlive = timeouts.KeepAlive
+	}
+	if timeouts.Shutdown > 0 {
+		connConfig.ShutdownTimeout = timeouts.Shutdown
+	}
+	if maxConnections > 0 {
+		connConfig.MaxConnections = maxConnections
+	}
+	return connConfig
+}
+
+// newClientset returns a client with the credentials from KubeconfigFile. The kubeconfig server is normally this proxy,
+// so in the TLS-terminating mode the client also trusts the serving certificate of the proxy.
+func (p *APIServerProxy) newClientset() (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", p.KubeconfigFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read load kubeconfig: %w", err)
 	}
+	if p.L7.Enabled {
+		if err := p.L7.trustServingCertificate(config); err != nil {
+			return nil, fmt.Errorf("failed to trust serving certificate: %w", err)
+		}
+	}
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize kubernetes client: %w", err)
 	}
+	return clientset, nil
+}
+
+// getLocality returns the networks of the local interfaces, and the topology zones of this node and the control plane
+// nodes. If the zones cannot be retrieved, only the networks are used.
+func (p *APIServerProxy) getLocality(ctx context.Context) *locality {
+	l := &locality{zones: make(map[string]string)}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Printf("WARNING: failed to list local interface addresses: %q", err)
+	}
+	localIPs := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+			l.networks = append(l.networks, ipNet)
+			localIPs[ipNet.IP.String()] = struct{}{}
+		}
+	}
+
+	clientset, err := p.newClientset()
+	if err != nil {
+		log.Printf("WARNING: failed to retrieve node zones: %q", err)
+		return l
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("WARNING: failed to retrieve node zones: %q", err)
+		return l
+	}
+	for _, node := range nodes.Items {
+		zone := node.Labels[corev1.LabelTopologyZone]
+		for _, addr := range node.Status.Addresses {
+			if addr.Type != corev1.NodeInternalIP || zone == "" {
+				continue
+			}
+			l.zones[addr.Address] = zone
+			if _, ok := localIPs[addr.Address]; ok {
+				l.zone = zone
+			}
+		}
+	}
+	log.Printf("local networks are %v, local zone is %q", l.networks, l.zone)
+	return l
+}
+
+func (p *APIServerProxy) getKubernetesEndpoints(ctx context.Context) ([]string, error) {
+	clientset, err := p.newClientset()
+	if err != nil {
+		return nil, err
+	}
 
 	endpointSlices, err := clientset.DiscoveryV1().EndpointSlices("default").List(ctx, metav1.ListOptions{
 		LabelSelector: "kubernetes.io/service-name=kubernetes",
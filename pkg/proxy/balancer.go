@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Strategy is a load-balancing strategy for picking the control plane endpoint of new connections.
+type Strategy string
+
+const (
+	// StrategyRoundRobin picks endpoints by SRV priority and weight. Since all endpoints have the same priority and
+	// weight, this is round robin.
+	StrategyRoundRobin Strategy = "round-robin"
+	// StrategyLeastConnections picks the endpoint with the fewest open connections.
+	StrategyLeastConnections Strategy = "least-connections"
+	// StrategyPowerOfTwoChoices picks two random endpoints, and uses the one with fewer open connections.
+	StrategyPowerOfTwoChoices Strategy = "power-of-two-choices"
+	// StrategyLatency picks the endpoint with the lowest health check round-trip time. It requires active health checks.
+	StrategyLatency Strategy = "latency"
+	// StrategyLocality picks endpoints in the same subnet or zone as this node in round robin, and only uses other
+	// endpoints if no local endpoint is available.
+	StrategyLocality Strategy = "locality"
+)
+
+// Strategies are the supported load-balancing strategies.
+var Strategies = []Strategy{StrategyRoundRobin, StrategyLeastConnections, StrategyPowerOfTwoChoices, StrategyLatency, StrategyLocality}
+
+// ParseStrategy parses a load-balancing strategy. An empty string is StrategyRoundRobin.
+func ParseStrategy(s string) (Strategy, error) {
+	if s == "" {
+		return StrategyRoundRobin, nil
+	}
+	for _, strategy := range Strategies {
+		if Strategy(s) == strategy {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown load-balancing strategy %q, supported values are %v", s, Strategies)
+}
+
+// rttSmoothing is the weight of the latest health check round-trip time in the moving average of a remote.
+const rttSmoothing = 0.3
+
+// recordRTT records the round-trip time of a successful health check.
+func (r *remote) recordRTT(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rtt == 0 {
+		r.rtt = d
+		return
+	}
+	r.rtt = time.Duration(rttSmoothing*float64(d) + (1-rttSmoothing)*float64(r.rtt))
+}
+
+// getRTT returns the moving average of the health check round-trip times of the remote, or zero if unknown.
+func (r *remote) getRTT() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rtt
+}
+
+// activeRemotes returns the active remotes, starting from the next remote in round robin order, so that ties between
+// remotes are broken in round robin. tp.mu must be held.
+func (tp *tcpproxy) activeRemotes() []*remote {
+	active := make([]*remote, 0, len(tp.remotes))
+	for i := range tp.remotes {
+		if r := tp.remotes[(tp.pickCount+i)%len(tp.remotes)]; r.isActive() {
+			active = append(active, r)
+		}
+	}
+	tp.pickCount++
+	return active
+}
+
+// pickLeastConnections returns the remote with the fewest open connections.
+func pickLeastConnections(remotes []*remote) *remote {
+	var picked *remote
+	var pickedConns int
+	for _, r := range remotes {
+		if conns := r.numConns(); picked == nil || conns < pickedConns {
+			picked, pickedConns = r, conns
+		}
+	}
+	return picked
+}
+
+// pickPowerOfTwoChoices picks two random remotes, and returns the one with fewer open connections.
+func pickPowerOfTwoChoices(remotes []*remote) *remote {
+	if len(remotes) <= 2 {
+		return pickLeastConnections(remotes)
+	}
+	i := rand.Intn(len(remotes))
+	j := rand.Intn(len(remotes) - 1)
+	if j >= i {
+		j++
+	}
+	return pickLeastConnections([]*remote{remotes[i], remotes[j]})
+}
+
+// pickLatency returns the remote with the lowest health check round-trip time. Remotes without measurements are
+// preferred, so that new endpoints receive traffic.
+func pickLatency(remotes []*remote) *remote {
+	var picked *remote
+	var pickedRTT time.Duration
+	for _, r := range remotes {
+		if rtt := r.getRTT(); picked == nil || rtt < pickedRTT {
+			picked, pickedRTT = r, rtt
+		}
+	}
+	return picked
+}
+
+// pickLocality picks active remotes in the same subnet or zone as this node in round robin. If none is available,
+// other active remotes are picked in round robin. tp.mu must be held.
+func (tp *tcpproxy) pickLocality() *remote {
+	var local, other []*remote
+	for _, r := range tp.remotes {
+		switch {
+		case !r.isActive():
+		case r.local:
+			local = append(local, r)
+		default:
+			other = append(other, r)
+		}
+	}
+	candidates := local
+	if len(candidates) == 0 {
+		candidates = other
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	picked := candidates[tp.pickCount%len(candidates)]
+	tp.pickCount++
+	return picked
+}
+
+// locality identifies control plane endpoints that are close to this node.
+type locality struct {
+	// networks are the networks of the local interfaces.
+	networks []*net.IPNet
+	// zone is the topology zone of this node. If empty, zones are not considered.
+	zone string
+	// zones are the topology zones of the control plane nodes, by IP address.
+	zones map[string]string
+}
+
+// isLocal returns true if the endpoint at addr is in the same subnet or zone as this node.
+func (l *locality) isLocal(addr string) bool {
+	if l == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if l.zone != "" && l.zones[host] == l.zone {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range l.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
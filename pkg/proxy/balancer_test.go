@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	. "github.com/onsi/gomega"
+)
+
+func TestParseStrategy(t *testing.T) {
+	for _, tc := range []struct {
+		value          string
+		expectStrategy Strategy
+		expectErr      bool
+	}{
+		{value: "", expectStrategy: StrategyRoundRobin},
+		{value: "round-robin", expectStrategy: StrategyRoundRobin},
+		{value: "least-connections", expectStrategy: StrategyLeastConnections},
+		{value: "power-of-two-choices", expectStrategy: StrategyPowerOfTwoChoices},
+		{value: "latency", expectStrategy: StrategyLatency},
+		{value: "locality", expectStrategy: StrategyLocality},
+		{value: "random", expectErr: true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			g := NewWithT(t)
+			strategy, err := ParseStrategy(tc.value)
+			if tc.expectErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(strategy).To(Equal(tc.expectStrategy))
+		})
+	}
+}
+
+// newTestRemotes returns remotes 10.0.0.1:16443, 10.0.0.2:16443, ... with the given number of open connections.
+func newTestRemotes(conns ...int) []*remote {
+	remotes := make([]*remote, 0, len(conns))
+	for i, n := range conns {
+		r := newRemote(&net.SRV{Target: net.IPv4(10, 0, 0, byte(i+1)).String(), Port: 16443})
+		for j := 0; j < n; j++ {
+			r.track(&net.TCPConn{})
+		}
+		remotes = append(remotes, r)
+	}
+	return remotes
+}
+
+func TestPick(t *testing.T) {
+	t.Run("LeastConnections", func(t *testing.T) {
+		g := NewWithT(t)
+		remotes := newTestRemotes(3, 1, 2)
+		tp := &tcpproxy{Strategy: StrategyLeastConnections, remotes: remotes}
+		g.Expect(tp.pick()).To(BeIdenticalTo(remotes[1]))
+
+		remotes[1].inactivate()
+		g.Expect(tp.pick()).To(BeIdenticalTo(remotes[2]))
+	})
+
+	t.Run("PowerOfTwoChoices", func(t *testing.T) {
+		g := NewWithT(t)
+		remotes := newTestRemotes(5, 0, 5)
+		tp := &tcpproxy{Strategy: StrategyPowerOfTwoChoices, remotes: remotes}
+		// the idle remote is picked whenever it is one of the two choices
+		picked := map[*remote]int{}
+		for i := 0; i < 300; i++ {
+			picked[tp.pick()]++
+		}
+		g.Expect(picked[remotes[1]]).To(BeNumerically(">", picked[remotes[0]]+picked[remotes[2]]))
+		remotes = newTestRemotes(1, 0)
+		tp = &tcpproxy{Strategy: StrategyPowerOfTwoChoices, remotes: remotes}
+		g.Expect(tp.pick()).To(BeIdenticalTo(remotes[1]))
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		g := NewWithT(t)
+		remotes := newTestRemotes(0, 0, 0)
+		remotes[0].recordRTT(30 * time.Millisecond)
+		remotes[1].recordRTT(5 * time.Millisecond)
+		remotes[2].recordRTT(10 * time.Millisecond)
+		tp := &tcpproxy{Strategy: StrategyLatency, remotes: remotes}
+		g.Expect(tp.pick()).To(BeIdenticalTo(remotes[1]))
+
+		// the moving average follows the latest measurements
+		for i := 0; i < 10; i++ {
+			remotes[1].recordRTT(50 * time.Millisecond)
+		}
+		g.Expect(tp.pick()).To(BeIdenticalTo(remotes[2]))
+	})
+
+	t.Run("Locality", func(t *testing.T) {
+		g := NewWithT(t)
+		remotes := newTestRemotes(0, 0, 0)
+		remotes[1].local = true
+		remotes[2].local = true
+		tp := &tcpproxy{Strategy: StrategyLocality, remotes: remotes}
+		picked := map[*remote]int{}
+		for i := 0; i < 6; i++ {
+			picked[tp.pick()]++
+		}
+		g.Expect(picked).To(Equal(map[*remote]int{remotes[1]: 3, remotes[2]: 3}))
+
+		remotes[1].inactivate()
+		remotes[2].inactivate()
+		g.Expect(tp.pick()).To(BeIdenticalTo(remotes[0]))
+	})
+
+	t.Run("NoActiveRemotes", func(t *testing.T) {
+		g := NewWithT(t)
+		for _, strategy := range Strategies {
+			remotes := newTestRemotes(0)
+			remotes[0].inactivate()
+			tp := &tcpproxy{Strategy: strategy, remotes: remotes}
+			g.Expect(tp.pick()).To(BeNil(), string(strategy))
+		}
+	})
+}
+
+func TestLocality(t *testing.T) {
+	g := NewWithT(t)
+	_, network, err := net.ParseCIDR("10.0.1.0/24")
+	g.Expect(err).ToNot(HaveOccurred())
+	l := &locality{
+		networks: []*net.IPNet{network},
+		zone:     "zone-a",
+		zones:    map[string]string{"10.0.2.1": "zone-a", "10.0.3.1": "zone-b"},
+	}
+
+	g.Expect(l.isLocal("10.0.1.10:16443")).To(BeTrue())
+	g.Expect(l.isLocal("10.0.2.1:16443")).To(BeTrue())
+	g.Expect(l.isLocal("10.0.3.1:16443")).To(BeFalse())
+	g.Expect(l.isLocal("10.0.4.1:16443")).To(BeFalse())
+
+	var noLocality *locality
+	g.Expect(noLocality.isLocal("10.0.1.10:16443")).To(BeFalse())
+}
+
+func TestStartProxyLatencyWithoutHealthChecks(t *testing.T) {
+	g := NewWithT(t)
+	backend := startBackend(t, "backend")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// latency needs RTTs from active health checks, so the proxy falls back to round robin
+	p := &APIServerProxy{Strategy: StrategyLatency}
+	go p.startProxy(ctx, cancel, &internal.Configuration{Listeners: []string{"127.0.0.1:0"}}, []string{net.JoinHostPort(backend.Target, fmt.Sprint(backend.Port))}, nil)
+
+	var tp *tcpproxy
+	g.Eventually(func() *tcpproxy {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		tp = p.proxy
+		return tp
+	}, 2*time.Second, 10*time.Millisecond).ShouldNot(BeNil())
+	g.Expect(tp.strategy()).To(Equal(StrategyRoundRobin))
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+)
+
+// reachabilityTimeout is the timeout for checking whether the configured endpoints are reachable on startup.
+const reachabilityTimeout = 3 * time.Second
+
+// getEndpointCache returns the endpoint cache, loading it from EndpointCacheFile if needed. p.mu must be held.
+func (p *APIServerProxy) getEndpointCache() *internal.EndpointCache {
+	if p.endpointCache == nil {
+		cache, err := internal.LoadEndpointCache(p.EndpointCacheFile)
+		if err != nil {
+			log.Printf("WARNING: failed to load endpoint cache, starting with an empty cache: %q", err)
+			cache = &internal.EndpointCache{}
+		}
+		p.endpointCache = cache
+	}
+	return p.endpointCache
+}
+
+// updateEndpointCache records endpoints retrieved from the cluster in the endpoint cache.
+func (p *APIServerProxy) updateEndpointCache(endpoints []string) {
+	if p.EndpointCacheFile == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cache := p.getEndpointCache()
+	if !cache.Record(endpoints, time.Now()) {
+		return
+	}
+	if err := internal.WriteEndpointCache(p.EndpointCacheFile, cache); err != nil {
+		log.Printf("WARNING: could not update endpoint cache: %q", err)
+	}
+}
+
+// withCachedEndpoints returns the endpoints the proxy should start with. If none of the configured endpoints is
+// reachable, e.g. because the control plane nodes were renumbered while this node was offline, the cached endpoints
+// are added, most recently seen first.
+func (p *APIServerProxy) withCachedEndpoints(ctx context.Context, endpoints []string) []string {
+	if p.EndpointCacheFile == "" || anyReachable(ctx, endpoints, reachabilityTimeout) {
+		return endpoints
+	}
+	p.mu.Lock()
+	cached := p.getEndpointCache().Addresses()
+	p.mu.Unlock()
+
+	known := make(map[string]struct{}, len(endpoints))
+	for _, endpoint := range endpoints {
+		known[endpoint] = struct{}{}
+	}
+	result := append([]string{}, endpoints...)
+	for _, endpoint := range cached {
+		if _, ok := known[endpoint]; !ok {
+			result = append(result, endpoint)
+		}
+	}
+	if len(result) > len(endpoints) {
+		log.Printf("None of the configured endpoints %v is reachable, also trying cached endpoints %v", endpoints, result[len(endpoints):])
+	}
+	return result
+}
+
+// anyReachable returns true if a TCP connection can be established to any of the endpoints within timeout.
+func anyReachable(ctx context.Context, endpoints []string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	srvs, err := parseEndpointURLs(endpoints, nil)
+	if err != nil {
+		return false
+	}
+	reachableCh := make(chan struct{}, len(srvs))
+	var wg sync.WaitGroup
+	for _, srv := range srvs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return
+			}
+			conn.Close()
+			reachableCh <- struct{}{}
+		}(net.JoinHostPort(srv.Target, strconv.Itoa(int(srv.Port))))
+	}
+	go func() {
+		wg.Wait()
+		close(reachableCh)
+	}()
+	_, ok := <-reachableCh
+	return ok
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestWithCachedEndpoints(t *testing.T) {
+	backend := startBackend(t, "backend")
+	reachable := net.JoinHostPort(backend.Target, fmt.Sprint(backend.Port))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	unreachable := l.Addr().String()
+	l.Close()
+
+	for _, tc := range []struct {
+		name            string
+		disabled        bool
+		endpoints       []string
+		cached          []string
+		expectEndpoints []string
+	}{
+		{name: "Reachable", endpoints: []string{reachable}, cached: []string{"10.0.0.1:16443"}, expectEndpoints: []string{reachable}},
+		{name: "Unreachable", endpoints: []string{unreachable}, cached: []string{reachable, unreachable}, expectEndpoints: []string{unreachable, reachable}},
+		{name: "EmptyCache", endpoints: []string{unreachable}, expectEndpoints: []string{unreachable}},
+		{name: "Disabled", disabled: true, endpoints: []string{unreachable}, cached: []string{reachable}, expectEndpoints: []string{unreachable}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &APIServerProxy{EndpointCacheFile: filepath.Join(t.TempDir(), "endpoints.yaml")}
+			p.updateEndpointCache(tc.cached)
+
+			// the cache is read from disk on startup
+			p = &APIServerProxy{EndpointCacheFile: p.EndpointCacheFile}
+			if tc.disabled {
+				p.EndpointCacheFile = ""
+			}
+			g.Expect(p.withCachedEndpoints(context.Background(), tc.endpoints)).To(Equal(tc.expectEndpoints))
+		})
+	}
+}
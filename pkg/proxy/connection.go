@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConnectionConfig configures the handling of proxied connections.
+type ConnectionConfig struct {
+	// DialTimeout is the timeout for connecting to a control plane endpoint. If zero, there is no timeout.
+	DialTimeout time.Duration
+	// IdleTimeout closes connections without traffic in either direction for the duration. If zero, idle connections
+	// are kept open.
+	IdleTimeout time.Duration
+	// HalfCloseTimeout is how long a connection is kept open in the other direction after one side closes its write
+	// half. If zero, both directions are closed immediately.
+	HalfCloseTimeout time.Duration
+	// KeepAlive is the TCP keepalive period of client and endpoint connections. If zero, the Go default is used. If
+	// negative, TCP keepalives are disabled.
+	KeepAlive time.Duration
+	// MaxConnections is the maximum number of concurrent client connections. New connections over the limit are
+	// rejected. If zero, there is no limit.
+	MaxConnections int
+	// ShutdownTimeout is how long the proxy waits for active connections to be closed when it stops. Connections still
+	// open after ShutdownTimeout are closed.
+	ShutdownTimeout time.Duration
+}
+
+// dial connects to the control plane endpoint at addr.
+func (c ConnectionConfig) dial(addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}
+	return d.Dial("tcp", addr)
+}
+
+// setKeepAlive configures TCP keepalives of an accepted client connection.
+func (c ConnectionConfig) setKeepAlive(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if c.KeepAlive < 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	if c.KeepAlive > 0 {
+		tcpConn.SetKeepAlivePeriod(c.KeepAlive)
+	}
+}
+
+// pipe copies data between a client connection and an endpoint connection until both directions are closed, the
+// connection is idle for IdleTimeout, or one direction is closed for HalfCloseTimeout. Both connections are closed
+// when pipe returns.
+func (c ConnectionConfig) pipe(in net.Conn, out net.Conn, sent prometheus.Counter, received prometheus.Counter) {
+	defer in.Close()
+	defer out.Close()
+
+	var extend func()
+	if c.IdleTimeout > 0 {
+		extend = func() {
+			deadline := time.Now().Add(c.IdleTimeout)
+			in.SetReadDeadline(deadline)
+			out.SetReadDeadline(deadline)
+		}
+		extend()
+	}
+
+	donec := make(chan struct{}, 2)
+	copyHalf := func(dst net.Conn, src net.Conn, counter prometheus.Counter) {
+		io.Copy(countingWriter{w: dst, counter: counter}, idleReader{r: src, extend: extend})
+		closeWrite(dst)
+		donec <- struct{}{}
+	}
+	go copyHalf(out, in, sent)
+	go copyHalf(in, out, received)
+
+	<-donec
+	if c.HalfCloseTimeout <= 0 {
+		return
+	}
+	select {
+	case <-donec:
+	case <-time.After(c.HalfCloseTimeout):
+	}
+}
+
+// closeWrite closes the write half of conn, if supported.
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// idleReader calls extend after every successful read.
+type idleReader struct {
+	r      io.Reader
+	extend func()
+}
+
+// Read implements io.Reader.
+func (r idleReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 && r.extend != nil {
+		r.extend()
+	}
+	return n, err
+}
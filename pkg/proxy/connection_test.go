@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	. "github.com/onsi/gomega"
+)
+
+// startTestProxy starts a proxy to backends on a local port.
+func startTestProxy(t *testing.T, connections ConnectionConfig, backends ...*net.SRV) *tcpproxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start listener: %v", err)
+	}
+	tp := &tcpproxy{Listener: l, Endpoints: backends, Connections: connections}
+	go tp.Run()
+	return tp
+}
+
+// startHalfCloseBackend starts a TCP server that reads until the client closes its write half, then replies with the
+// number of bytes received. It returns the SRV record of the server.
+func startHalfCloseBackend(t *testing.T) *net.SRV {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start backend: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b, _ := io.ReadAll(conn)
+				time.Sleep(100 * time.Millisecond)
+				conn.Write([]byte{byte(len(b))})
+			}()
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return &net.SRV{Target: addr.IP.String(), Port: uint16(addr.Port)}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	g := NewWithT(t)
+	tp := startTestProxy(t, ConnectionConfig{IdleTimeout: 300 * time.Millisecond}, startBackend(t, "backend"))
+	defer tp.Stop()
+
+	conn, err := net.Dial("tcp", tp.Listener.Addr().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// active connections are kept open past the idle timeout
+	for i := 0; i < 5; i++ {
+		g.Expect(request(conn, reader)).To(Equal("backend\n"))
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// idle connections are closed
+	time.Sleep(500 * time.Millisecond)
+	_, err = request(conn, reader)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestHalfCloseTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		timeout     time.Duration
+		expectReply bool
+	}{
+		{name: "KeepOpen", timeout: time.Second, expectReply: true},
+		{name: "CloseImmediately"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			tp := startTestProxy(t, ConnectionConfig{HalfCloseTimeout: tc.timeout}, startHalfCloseBackend(t))
+			defer tp.Stop()
+
+			conn, err := net.Dial("tcp", tp.Listener.Addr().String())
+			g.Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = conn.Write([]byte("hello"))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(conn.(*net.TCPConn).CloseWrite()).To(Succeed())
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			reply, err := io.ReadAll(conn)
+			if tc.expectReply {
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(reply).To(Equal([]byte{5}))
+			} else {
+				g.Expect(reply).To(BeEmpty())
+			}
+		})
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	g := NewWithT(t)
+	tp := startTestProxy(t, ConnectionConfig{MaxConnections: 1}, startBackend(t, "backend"))
+	defer tp.Stop()
+
+	conn, err := net.Dial("tcp", tp.Listener.Addr().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(request(conn, bufio.NewReader(conn))).To(Equal("backend\n"))
+
+	// connections over the limit are rejected
+	rejected, err := net.Dial("tcp", tp.Listener.Addr().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer rejected.Close()
+	_, err = request(rejected, bufio.NewReader(rejected))
+	g.Expect(err).To(HaveOccurred())
+
+	// closed connections free their slot
+	conn.Close()
+	g.Eventually(func() (string, error) {
+		conn, err := net.Dial("tcp", tp.Listener.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return request(conn, bufio.NewReader(conn))
+	}, 2*time.Second, 50*time.Millisecond).Should(Equal("backend\n"))
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		timeout     time.Duration
+		closeClient bool
+	}{
+		{name: "ClientCloses", timeout: 5 * time.Second, closeClient: true},
+		{name: "Timeout", timeout: 300 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			tp := startTestProxy(t, ConnectionConfig{ShutdownTimeout: tc.timeout}, startBackend(t, "backend"))
+
+			conn, err := net.Dial("tcp", tp.Listener.Addr().String())
+			g.Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			reader := bufio.NewReader(conn)
+			g.Expect(request(conn, reader)).To(Equal("backend\n"))
+
+			stopped := make(chan struct{})
+			go func() {
+				tp.Stop()
+				close(stopped)
+			}()
+
+			// new connections are refused, active connections keep working
+			g.Eventually(func() error {
+				conn, err := net.Dial("tcp", tp.Listener.Addr().String())
+				if err == nil {
+					conn.Close()
+				}
+				return err
+			}, time.Second, 50*time.Millisecond).Should(HaveOccurred())
+			g.Expect(request(conn, reader)).To(Equal("backend\n"))
+			g.Consistently(stopped, 100*time.Millisecond).ShouldNot(BeClosed())
+
+			if tc.closeClient {
+				conn.Close()
+			}
+			g.Eventually(stopped, time.Second).Should(BeClosed())
+			if !tc.closeClient {
+				_, err := request(conn, reader)
+				g.Expect(err).To(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestConnectionConfig(t *testing.T) {
+	flags := ConnectionConfig{DialTimeout: 10 * time.Second, KeepAlive: 15 * time.Second, ShutdownTimeout: 10 * time.Second}
+	for _, tc := range []struct {
+		name   string
+		cfg    internal.Configuration
+		expect ConnectionConfig
+	}{
+		{name: "Flags", expect: flags},
+		{
+			name: "Override",
+			cfg: internal.Configuration{
+				Timeouts:       internal.Timeouts{Dial: time.Second, Idle: time.Hour, HalfClose: time.Minute, KeepAlive: -1, Shutdown: time.Minute},
+				MaxConnections: 100,
+			},
+			expect: ConnectionConfig{DialTimeout: time.Second, IdleTimeout: time.Hour, HalfCloseTimeout: time.Minute, KeepAlive: -1, MaxConnections: 100, ShutdownTimeout: time.Minute},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &APIServerProxy{Connections: flags}
+			g.Expect(p.connectionConfig(&tc.cfg)).To(Equal(tc.expect))
+		})
+	}
+}
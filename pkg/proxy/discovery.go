@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// minWatchDuration is the minimum duration of a healthy watch. Watches that end sooner are considered failed, and
+// the endpoints are polled until the next watch can be established.
+const minWatchDuration = 5 * time.Second
+
+// endpointWatcher watches the endpoints of a Kubernetes Service with a long-lived client. The endpoints are retrieved
+// from the EndpointSlices of the Service, falling back to the Endpoints.
+type endpointWatcher struct {
+	clientset kubernetes.Interface
+	namespace string
+	service   string
+	// portName is the name of the port of the Service. If empty, the first port is used.
+	portName string
+	// defaultPort is used for endpoints without a port named portName. If zero, such endpoints are skipped.
+	defaultPort int
+
+	// slices are the EndpointSlices of the Service, by name. If nil, the Endpoints are watched.
+	slices map[string]discoveryv1.EndpointSlice
+}
+
+// newKubernetesEndpointWatcher returns a watcher for the control plane endpoints of the cluster.
+func newKubernetesEndpointWatcher(clientset kubernetes.Interface) *endpointWatcher {
+	return &endpointWatcher{clientset: clientset, namespace: "default", service: "kubernetes", portName: "https", defaultPort: 16443}
+}
+
+// listAndWatch lists the endpoints, and starts a watch for changes. If the watch cannot be started, the returned watch
+// is nil.
+func (w *endpointWatcher) listAndWatch(ctx context.Context) ([]string, watch.Interface, error) {
+	selector := "kubernetes.io/service-name=" + w.service
+	endpointSlices, err := w.clientset.DiscoveryV1().EndpointSlices(w.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err == nil {
+		w.slices = make(map[string]discoveryv1.EndpointSlice, len(endpointSlices.Items))
+		for _, slice := range endpointSlices.Items {
+			w.slices[slice.Name] = slice
+		}
+		watcher, err := w.clientset.DiscoveryV1().EndpointSlices(w.namespace).Watch(ctx, metav1.ListOptions{
+			LabelSelector:   selector,
+			ResourceVersion: endpointSlices.ResourceVersion,
+		})
+		if err != nil {
+			log.Printf("failed to watch EndpointSlices: %v", err)
+		}
+		return parseServiceEndpointSlices(endpointSlices, w.portName, w.defaultPort), watcher, nil
+	}
+	log.Printf("Failed to get EndpointSlices, falling back to Endpoints api: %v", err)
+
+	w.slices = nil
+	endpoints, err := w.clientset.CoreV1().Endpoints(w.namespace).Get(ctx, w.service, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve endpoints for %s service: %w", w.service, err)
+	}
+	watcher, err := w.clientset.CoreV1().Endpoints(w.namespace).Watch(ctx, metav1.ListOptions{
+		FieldSelector:   "metadata.name=" + w.service,
+		ResourceVersion: endpoints.ResourceVersion,
+	})
+	if err != nil {
+		log.Printf("failed to watch Endpoints: %v", err)
+	}
+	return parseServiceEndpoints(endpoints, w.portName, w.defaultPort), watcher, nil
+}
+
+// handle applies a watch event, and returns the new list of endpoints. It returns false if the event does not change
+// the endpoints.
+func (w *endpointWatcher) handle(event watch.Event) ([]string, bool, error) {
+	switch event.Type {
+	case watch.Error:
+		return nil, false, fmt.Errorf("watch failed: %v", event.Object)
+	case watch.Bookmark:
+		return nil, false, nil
+	}
+
+	if w.slices == nil {
+		endpoints, ok := event.Object.(*corev1.Endpoints)
+		if !ok {
+			return nil, false, fmt.Errorf("unexpected object %T in watch event", event.Object)
+		}
+		if event.Type == watch.Deleted {
+			return nil, true, nil
+		}
+		return parseServiceEndpoints(endpoints, w.portName, w.defaultPort), true, nil
+	}
+
+	slice, ok := event.Object.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected object %T in watch event", event.Object)
+	}
+	if event.Type == watch.Deleted {
+		delete(w.slices, slice.Name)
+	} else {
+		w.slices[slice.Name] = *slice
+	}
+	list := &discoveryv1.EndpointSliceList{Items: make([]discoveryv1.EndpointSlice, 0, len(w.slices))}
+	for _, slice := range w.slices {
+		list.Items = append(list.Items, slice)
+	}
+	return parseServiceEndpointSlices(list, w.portName, w.defaultPort), true, nil
+}
+
+// watchEndpoints watches endpoints, and calls apply with every new list of endpoints, or the error if the endpoints
+// cannot be retrieved. If the watch fails, the endpoints are polled on every refreshCh tick until a new watch can be
+// established. watchEndpoints returns when the context is cancelled, or apply returns false.
+func watchEndpoints(ctx context.Context, newWatcher func() (*endpointWatcher, error), refreshCh <-chan time.Time, apply func([]string, error) bool) {
+	var w *endpointWatcher
+	for {
+		if w == nil {
+			var err error
+			if w, err = newWatcher(); err != nil && !apply(nil, err) {
+				return
+			}
+		}
+
+		if w != nil {
+			endpoints, watcher, err := w.listAndWatch(ctx)
+			if !apply(endpoints, err) {
+				return
+			}
+			if watcher != nil {
+				start := time.Now()
+				if !consumeWatch(ctx, w, watcher, apply) {
+					return
+				}
+				if time.Since(start) >= minWatchDuration {
+					continue
+				}
+			}
+			log.Printf("failed to watch %s endpoints, will poll every refresh interval", w.service)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-refreshCh:
+		}
+	}
+}
+
+// consumeWatch applies watch events until the watch is closed. It returns false if the context is cancelled, or apply
+// returns false.
+func consumeWatch(ctx context.Context, w *endpointWatcher, watcher watch.Interface, apply func([]string, error) bool) bool {
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return true
+			}
+			endpoints, changed, err := w.handle(event)
+			if err != nil {
+				log.Println(fmt.Errorf("error while watching %s endpoints: %w", w.service, err))
+				return true
+			}
+			if changed && !apply(endpoints, nil) {
+				return false
+			}
+		}
+	}
+}
+
+// watchForNewEndpoints watches the control plane endpoints of the cluster, and sends changes to updateCh. If the
+// watch fails, the endpoints are polled on every RefreshCh tick until a new watch can be established. If RefreshCh is
+// nil, the endpoints are not updated.
+func (p *APIServerProxy) watchForNewEndpoints(ctx context.Context, current []string, updateCh chan<- []string) {
+	if p.RefreshCh == nil {
+		return
+	}
+	if !p.WatchEndpoints {
+		p.pollForNewEndpoints(ctx, current, updateCh)
+		return
+	}
+
+	newWatcher := func() (*endpointWatcher, error) {
+		clientset, err := p.newClientset()
+		if err != nil {
+			return nil, err
+		}
+		return newKubernetesEndpointWatcher(clientset), nil
+	}
+	watchEndpoints(ctx, newWatcher, p.RefreshCh, func(endpoints []string, err error) bool {
+		return p.applyEndpoints(ctx, endpoints, err, &current, updateCh)
+	})
+}
+
+// pollForNewEndpoints retrieves the control plane endpoints of the cluster on every RefreshCh tick, and sends changes
+// to updateCh.
+func (p *APIServerProxy) pollForNewEndpoints(ctx context.Context, current []string, updateCh chan<- []string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.RefreshCh:
+		}
+
+		endpoints, err := p.getKubernetesEndpoints(ctx)
+		if !p.applyEndpoints(ctx, endpoints, err, &current, updateCh) {
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// kubernetesEndpointSlice returns an EndpointSlice of the kubernetes service.
+func kubernetesEndpointSlice(name string, addresses ...string) *discoveryv1.EndpointSlice {
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"kubernetes.io/service-name": "kubernetes"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+	}
+	for _, address := range addresses {
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{Addresses: []string{address}})
+	}
+	return slice
+}
+
+func TestEndpointWatcher(t *testing.T) {
+	t.Run("EndpointSlices", func(t *testing.T) {
+		g := NewWithT(t)
+		clientset := fake.NewSimpleClientset(kubernetesEndpointSlice("kubernetes", "10.0.0.1"))
+		w := newKubernetesEndpointWatcher(clientset)
+
+		endpoints, watcher, err := w.listAndWatch(context.Background())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(endpoints).To(Equal([]string{"10.0.0.1:16443"}))
+		g.Expect(watcher).ToNot(BeNil())
+		defer watcher.Stop()
+
+		slices := clientset.DiscoveryV1().EndpointSlices("default")
+		_, err = slices.Create(context.Background(), kubernetesEndpointSlice("kubernetes-2", "10.0.0.2"), metav1.CreateOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		endpoints, changed, err := w.handle(<-watcher.ResultChan())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(changed).To(BeTrue())
+		g.Expect(endpoints).To(Equal([]string{"10.0.0.1:16443", "10.0.0.2:16443"}))
+
+		g.Expect(slices.Delete(context.Background(), "kubernetes", metav1.DeleteOptions{})).To(Succeed())
+		endpoints, changed, err = w.handle(<-watcher.ResultChan())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(changed).To(BeTrue())
+		g.Expect(endpoints).To(Equal([]string{"10.0.0.2:16443"}))
+	})
+
+	t.Run("Endpoints", func(t *testing.T) {
+		g := NewWithT(t)
+		clientset := fake.NewSimpleClientset(&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+		})
+		clientset.PrependReactor("list", "endpointslices", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("the server could not find the requested resource")
+		})
+		w := newKubernetesEndpointWatcher(clientset)
+
+		endpoints, watcher, err := w.listAndWatch(context.Background())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(endpoints).To(Equal([]string{"10.0.0.1:16443"}))
+		g.Expect(watcher).ToNot(BeNil())
+		defer watcher.Stop()
+
+		_, err = clientset.CoreV1().Endpoints("default").Update(context.Background(), &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}}}},
+		}, metav1.UpdateOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		endpoints, changed, err := w.handle(<-watcher.ResultChan())
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(changed).To(BeTrue())
+		g.Expect(endpoints).To(Equal([]string{"10.0.0.1:16443", "10.0.0.2:16443"}))
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		g := NewWithT(t)
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependReactor("*", "*", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("connection refused")
+		})
+		w := newKubernetesEndpointWatcher(clientset)
+		_, _, err := w.listAndWatch(context.Background())
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestConsumeWatch(t *testing.T) {
+	g := NewWithT(t)
+	configFile := filepath.Join(t.TempDir(), "apiserver-proxy.yaml")
+	g.Expect(os.WriteFile(configFile, []byte("backends:\n- address: 10.0.0.1:16443\n"), 0600)).To(Succeed())
+
+	clientset := fake.NewSimpleClientset(kubernetesEndpointSlice("kubernetes", "10.0.0.1"))
+	w := newKubernetesEndpointWatcher(clientset)
+	endpoints, watcher, err := w.listAndWatch(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := &APIServerProxy{ConfigFile: configFile}
+	updateCh := make(chan []string)
+	resultCh := make(chan bool)
+	go func() {
+		resultCh <- consumeWatch(ctx, w, watcher, func(newEndpoints []string, err error) bool {
+			return p.applyEndpoints(ctx, newEndpoints, err, &endpoints, updateCh)
+		})
+	}()
+
+	// changes are reflected immediately
+	_, err = clientset.DiscoveryV1().EndpointSlices("default").Update(context.Background(), kubernetesEndpointSlice("kubernetes", "10.0.0.1", "10.0.0.2"), metav1.UpdateOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Eventually(updateCh, time.Second).Should(Receive(Equal([]string{"10.0.0.1:16443", "10.0.0.2:16443"})))
+	g.Expect(os.ReadFile(configFile)).To(ContainSubstring("10.0.0.2:16443"))
+
+	// an empty list of endpoints is ignored
+	_, err = clientset.DiscoveryV1().EndpointSlices("default").Update(context.Background(), kubernetesEndpointSlice("kubernetes"), metav1.UpdateOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Consistently(updateCh, 200*time.Millisecond).ShouldNot(Receive())
+
+	// a closed watch is reported, so that it can be restarted
+	watcher.Stop()
+	g.Eventually(resultCh, time.Second).Should(Receive(BeTrue()))
+	g.Expect(p.Status().LastRefresh).ToNot(BeNil())
+}
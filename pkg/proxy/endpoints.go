@@ -10,18 +10,27 @@ import (
 )
 
 func parseEndpoints(endpoint *v1.Endpoints) []string {
+	return parseServiceEndpoints(endpoint, "https", 16443)
+}
+
+// parseServiceEndpoints returns the addresses of the port named portName, or the first port if portName is empty.
+// Subsets without a matching port use defaultPort, or are skipped if defaultPort is zero.
+func parseServiceEndpoints(endpoint *v1.Endpoints, portName string, defaultPort int) []string {
 	if endpoint == nil {
 		return nil
 	}
 	addresses := make([]string, 0, len(endpoint.Subsets))
 	for _, subset := range endpoint.Subsets {
-		portNumber := 16443
+		portNumber := defaultPort
 		for _, port := range subset.Ports {
-			if port.Name == "https" {
+			if portName == "" || port.Name == portName {
 				portNumber = int(port.Port)
 				break
 			}
 		}
+		if portNumber == 0 {
+			continue
+		}
 
 		for _, addr := range subset.Addresses {
 			addresses = append(addresses, fmt.Sprintf("%s:%d", addr.IP, portNumber))
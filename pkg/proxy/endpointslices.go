@@ -8,6 +8,12 @@ import (
 )
 
 func parseEndpointSlices(endpointSlices *discoveryv1.EndpointSliceList) []string {
+	return parseServiceEndpointSlices(endpointSlices, "https", 16443)
+}
+
+// parseServiceEndpointSlices returns the addresses of the port named portName, or the first port if portName is
+// empty. EndpointSlices without a matching port use defaultPort, or are skipped if defaultPort is zero.
+func parseServiceEndpointSlices(endpointSlices *discoveryv1.EndpointSliceList, portName string, defaultPort int) []string {
 	if endpointSlices == nil {
 		return nil
 	}
@@ -15,15 +21,18 @@ func parseEndpointSlices(endpointSlices *discoveryv1.EndpointSliceList) []string
 	addresses := make([]string, 0, len(endpointSlices.Items))
 
 	for _, endpointSlice := range endpointSlices.Items {
-		portNumber := 16443
+		portNumber := defaultPort
 		for _, port := range endpointSlice.Ports {
-			if port.Name != nil && *port.Name == "https" {
+			if portName == "" || (port.Name != nil && *port.Name == portName) {
 				if port.Port != nil {
 					portNumber = int(*port.Port)
 					break
 				}
 			}
 		}
+		if portNumber == 0 {
+			continue
+		}
 
 		for _, endpoint := range endpointSlice.Endpoints {
 			for _, addr := range endpoint.Addresses {
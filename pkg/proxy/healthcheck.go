@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// HealthCheckConfig configures active health checks of the control plane endpoints.
+type HealthCheckConfig struct {
+	// Interval is the time between health checks of each endpoint. If zero, active health checks are disabled, and
+	// endpoints are only marked inactive after a failed connection attempt.
+	Interval time.Duration
+	// Timeout is the timeout of each health check.
+	Timeout time.Duration
+	// Rise is the number of consecutive successful health checks before an inactive endpoint is added back.
+	Rise int
+	// Fall is the number of consecutive failed health checks before an active endpoint is ejected.
+	Fall int
+}
+
+// healthCheck runs active health checks against the endpoints of the proxy.
+type healthCheck struct {
+	HealthCheckConfig
+
+	// check checks the health of the endpoint at addr.
+	check func(ctx context.Context, addr string) error
+}
+
+// newReadyzCheck returns a health check function that queries the "/readyz" endpoint of kube-apiserver over TLS,
+// authenticating with the credentials from a kubeconfig file.
+func newReadyzCheck(kubeconfigFile string) (func(ctx context.Context, addr string) error, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	// Clients reach kube-apiserver through the proxy, so they verify its certificate against the kubeconfig server.
+	// Health checks connect to the endpoints directly, and must verify the certificate against the same name.
+	if config.TLSClientConfig.ServerName == "" {
+		if u, err := url.Parse(config.Host); err == nil {
+			config.TLSClientConfig.ServerName = u.Hostname()
+		}
+	}
+	transport, err := rest.TransportFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize transport: %w", err)
+	}
+	client := &http.Client{Transport: transport}
+
+	return func(ctx context.Context, addr string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://%s/readyz", addr), nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("readyz returned status %d", resp.StatusCode)
+		}
+		return nil
+	}, nil
+}
+
+// newServiceHealthCheck returns active TCP health checks for the backends of a service. If cfg is nil, disabled or
+// has no interval, it returns nil. Zero timeout, rise and fall use defaults.
+func newServiceHealthCheck(cfg *internal.HealthCheck) *healthCheck {
+	if cfg == nil || cfg.Disabled || cfg.Interval <= 0 {
+		return nil
+	}
+	hc := &healthCheck{
+		HealthCheckConfig: HealthCheckConfig{Interval: cfg.Interval, Timeout: cfg.Timeout, Rise: cfg.Rise, Fall: cfg.Fall},
+		check:             tcpCheck,
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 5 * time.Second
+	}
+	if hc.Rise <= 0 {
+		hc.Rise = 2
+	}
+	if hc.Fall <= 0 {
+		hc.Fall = 3
+	}
+	return hc
+}
+
+// tcpCheck checks that the endpoint at addr accepts TCP connections.
+func tcpCheck(ctx context.Context, addr string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	. "github.com/onsi/gomega"
+)
+
+func TestRecordHealthCheck(t *testing.T) {
+	failed := fmt.Errorf("readyz returned status 500")
+	for _, tc := range []struct {
+		name           string
+		inactive       bool
+		results        []error
+		expectChanged  []bool
+		expectInactive bool
+	}{
+		{name: "Healthy", results: []error{nil, nil, nil}, expectChanged: []bool{false, false, false}},
+		{name: "Fall", results: []error{failed, failed, failed}, expectChanged: []bool{false, false, true}, expectInactive: true},
+		{name: "FlappingStaysActive", results: []error{failed, failed, nil, failed, failed}, expectChanged: []bool{false, false, false, false, false}},
+		{name: "Rise", inactive: true, results: []error{nil, nil}, expectChanged: []bool{false, true}},
+		{name: "FlappingStaysInactive", inactive: true, results: []error{nil, failed, nil, failed}, expectChanged: []bool{false, false, false, false}, expectInactive: true},
+		{name: "RiseAfterDialFailure", inactive: true, results: []error{failed, failed, failed, nil, nil}, expectChanged: []bool{false, false, false, false, true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			r := &remote{addr: "10.0.0.1:16443", inactive: tc.inactive}
+			for i, err := range tc.results {
+				g.Expect(r.recordHealthCheck(err, 2, 3)).To(Equal(tc.expectChanged[i]), "health check %d", i)
+			}
+			g.Expect(r.isActive()).To(Equal(!tc.expectInactive))
+		})
+	}
+}
+
+func TestRecordHealthCheckAfterDialEjection(t *testing.T) {
+	g := NewWithT(t)
+	r := &remote{addr: "10.0.0.1:16443"}
+	for i := 0; i < 3; i++ {
+		g.Expect(r.recordHealthCheck(nil, 2, 3)).To(BeFalse())
+	}
+
+	// the remote is ejected by a failed dial, see tcpproxy.dialRemote
+	r.inactivate()
+	g.Expect(r.isActive()).To(BeFalse())
+
+	g.Expect(r.recordHealthCheck(nil, 2, 3)).To(BeFalse(), "remote must not be added back before rise successful health checks")
+	g.Expect(r.isActive()).To(BeFalse())
+	g.Expect(r.recordHealthCheck(nil, 2, 3)).To(BeTrue())
+	g.Expect(r.isActive()).To(BeTrue())
+}
+
+func TestReadyzCheck(t *testing.T) {
+	g := NewWithT(t)
+	var status int
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/readyz":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Header.Get("Authorization") != "Bearer kubelet-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(status)
+		}
+	}))
+	defer srv.Close()
+
+	// the kubeconfig server is the local proxy, the health check connects to the endpoint directly
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	kubeconfig := filepath.Join(t.TempDir(), "kubelet.config")
+	g.Expect(os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: https://127.0.0.1:16443
+  name: microk8s-cluster
+contexts:
+- context:
+    cluster: microk8s-cluster
+    user: kubelet
+  name: microk8s
+current-context: microk8s
+users:
+- name: kubelet
+  user:
+    token: kubelet-token
+`, base64.StdEncoding.EncodeToString(ca))), 0600)).To(Succeed())
+
+	check, err := newReadyzCheck(kubeconfig)
+	g.Expect(err).ToNot(HaveOccurred())
+	addr := strings.TrimPrefix(srv.URL, "https://")
+
+	status = http.StatusOK
+	g.Expect(check(context.Background(), addr)).To(Succeed())
+
+	status = http.StatusInternalServerError
+	g.Expect(check(context.Background(), addr)).ToNot(Succeed())
+
+	_, err = newReadyzCheck(filepath.Join(t.TempDir(), "missing.config"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestHealthCheckConfig(t *testing.T) {
+	flags := HealthCheckConfig{Interval: 10 * time.Second, Timeout: 5 * time.Second, Rise: 2, Fall: 3}
+	for _, tc := range []struct {
+		name        string
+		healthCheck *internal.HealthCheck
+		expect      HealthCheckConfig
+	}{
+		{name: "Flags", expect: flags},
+		{name: "Override", healthCheck: &internal.HealthCheck{Interval: time.Second, Fall: 1}, expect: HealthCheckConfig{Interval: time.Second, Timeout: 5 * time.Second, Rise: 2, Fall: 1}},
+		{name: "Disabled", healthCheck: &internal.HealthCheck{Disabled: true, Interval: time.Second}, expect: HealthCheckConfig{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			p := &APIServerProxy{HealthCheck: flags}
+			g.Expect(p.healthCheckConfig(&internal.Configuration{HealthCheck: tc.healthCheck})).To(Equal(tc.expect))
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// endpointCacheResolution is the resolution of the last-seen timestamps of the endpoint cache. The cache file is not
+// rewritten on every refresh if the list of endpoints has not changed.
+const endpointCacheResolution = time.Hour
+
+// EndpointCache is the history of control plane endpoints of the cluster.
+type EndpointCache struct {
+	Endpoints []CachedEndpoint `yaml:"endpoints"`
+}
+
+// CachedEndpoint is a control plane endpoint in the endpoint cache.
+type CachedEndpoint struct {
+	Address  string    `yaml:"address"`
+	LastSeen time.Time `yaml:"lastSeen"`
+}
+
+// LoadEndpointCache loads the endpoint cache from a file. A missing file is an empty cache.
+func LoadEndpointCache(file string) (*EndpointCache, error) {
+	cache := &EndpointCache{}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := yaml.Unmarshal(b, cache); err != nil {
+		return nil, fmt.Errorf("unmarshal endpoint cache failed: %w", err)
+	}
+	return cache, nil
+}
+
+// WriteEndpointCache atomically writes the endpoint cache to a file.
+func WriteEndpointCache(file string, cache *EndpointCache) error {
+	if err := writeYaml(file, cache); err != nil {
+		return fmt.Errorf("failed to write endpoint cache: %w", err)
+	}
+	return nil
+}
+
+// Record records that endpoints were seen at now. It returns true if the cache changed and should be written.
+// Endpoints that are no longer seen are kept in the cache.
+func (c *EndpointCache) Record(endpoints []string, now time.Time) bool {
+	changed := false
+	for _, endpoint := range endpoints {
+		idx := -1
+		for i := range c.Endpoints {
+			if c.Endpoints[i].Address == endpoint {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case idx == -1:
+			c.Endpoints = append(c.Endpoints, CachedEndpoint{Address: endpoint, LastSeen: now})
+			changed = true
+		case now.Sub(c.Endpoints[idx].LastSeen) >= endpointCacheResolution:
+			c.Endpoints[idx].LastSeen = now
+			changed = true
+		}
+	}
+	if changed {
+		sort.SliceStable(c.Endpoints, func(i, j int) bool {
+			return c.Endpoints[i].LastSeen.After(c.Endpoints[j].LastSeen)
+		})
+	}
+	return changed
+}
+
+// Addresses returns the addresses of the cached endpoints, most recently seen first.
+func (c *EndpointCache) Addresses() []string {
+	addresses := make([]string, 0, len(c.Endpoints))
+	for _, endpoint := range c.Endpoints {
+		addresses = append(addresses, endpoint.Address)
+	}
+	return addresses
+}
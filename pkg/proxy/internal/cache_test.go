@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestEndpointCache(t *testing.T) {
+	g := NewWithT(t)
+	file := filepath.Join(t.TempDir(), "endpoints.yaml")
+
+	cache, err := LoadEndpointCache(file)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cache.Addresses()).To(BeEmpty())
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	g.Expect(cache.Record([]string{"10.0.0.1:16443", "10.0.0.2:16443"}, now)).To(BeTrue())
+
+	// unchanged endpoints within the resolution are not rewritten
+	g.Expect(cache.Record([]string{"10.0.0.1:16443", "10.0.0.2:16443"}, now.Add(time.Minute))).To(BeFalse())
+
+	// renumbered endpoints are added, and old endpoints are kept
+	g.Expect(cache.Record([]string{"10.0.1.1:16443"}, now.Add(2*time.Hour))).To(BeTrue())
+	g.Expect(cache.Addresses()).To(Equal([]string{"10.0.1.1:16443", "10.0.0.1:16443", "10.0.0.2:16443"}))
+
+	g.Expect(WriteEndpointCache(file, cache)).To(Succeed())
+	loaded, err := LoadEndpointCache(file)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(loaded.Addresses()).To(Equal(cache.Addresses()))
+	g.Expect(loaded.Endpoints[0].LastSeen.Equal(now.Add(2 * time.Hour))).To(BeTrue())
+
+	g.Expect(os.WriteFile(file, []byte("endpoints: {"), 0600)).To(Succeed())
+	_, err = LoadEndpointCache(file)
+	g.Expect(err).To(HaveOccurred())
+}
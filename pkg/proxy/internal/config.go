@@ -4,61 +4,284 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"reflect"
 	"sort"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 )
 
 // Configuration is configuration for the apiserver proxy
 type Configuration struct {
-	Listen    string
+	// Listeners are the addresses the proxy listens on.
+	Listeners []string
+	// Endpoints are the addresses of the control plane endpoints, sorted.
 	Endpoints []string
+	// Backends are the control plane endpoints with a non-default priority or weight, by address.
+	Backends map[string]Backend
+	// Timeouts configures the timeouts of the proxy.
+	Timeouts Timeouts
+	// MaxConnections is the maximum number of concurrent client connections, if not zero.
+	MaxConnections int
+	// HealthCheck overrides the active health check settings, if not nil.
+	HealthCheck *HealthCheck
+	// Services are additional control plane services that are proxied on this node.
+	Services []ServiceConfiguration
+	// ChangedCh receives a notification when the configuration files change on disk.
 	ChangedCh chan struct{}
 }
 
-// LoadConfiguration loads traefik-compatible configuration for the API server proxy.
-func LoadConfiguration(ctx context.Context, traefikConfigFile string) (*Configuration, error) {
-	cfg := &Configuration{}
+// SettingsEqual returns true if the configurations only differ in the list of control plane endpoints.
+func (c *Configuration) SettingsEqual(o *Configuration) bool {
+	return reflect.DeepEqual(c.Listeners, o.Listeners) &&
+		reflect.DeepEqual(c.Backends, o.Backends) &&
+		c.Timeouts == o.Timeouts &&
+		c.MaxConnections == o.MaxConnections &&
+		reflect.DeepEqual(c.HealthCheck, o.HealthCheck) &&
+		reflect.DeepEqual(c.Services, o.Services)
+}
+
+// LoadConfiguration loads the configuration of the API server proxy from configFile. The traefik-compatible
+// configuration in traefikConfigFile is a legacy input: it is migrated to configFile if configFile does not exist, or
+// if the traefik provider file was changed after configFile, e.g. by older tooling. If configFile is empty, the
+// traefik-compatible configuration is used directly.
+func LoadConfiguration(ctx context.Context, configFile string, traefikConfigFile string) (*Configuration, error) {
+	if configFile == "" {
+		cfg, providerFile, watch, err := loadTraefikConfiguration(traefikConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if watch {
+			cfg.watch(ctx, providerFile)
+		}
+		return cfg, nil
+	}
+
+	if err := migrateTraefikConfiguration(configFile, traefikConfigFile); err != nil {
+		return nil, err
+	}
+	var proxyConfig ProxyConfiguration
+	if err := loadYamlWarnStrict(configFile, &proxyConfig); err != nil {
+		return nil, fmt.Errorf("failed to load apiserver proxy configuration: %w", err)
+	}
+	cfg, err := newConfiguration(proxyConfig)
+	if err != nil {
+		return nil, err
+	}
+	cfg.watch(ctx, configFile)
+	if providerFile := traefikProviderFile(traefikConfigFile); providerFile != "" && util.FileExists(providerFile) {
+		cfg.watch(ctx, providerFile)
+	}
+	return cfg, nil
+}
+
+// newConfiguration validates an apiserver proxy configuration file.
+func newConfiguration(proxyConfig ProxyConfiguration) (*Configuration, error) {
+	cfg := &Configuration{
+		Timeouts:       proxyConfig.Timeouts,
+		MaxConnections: proxyConfig.MaxConnections,
+		HealthCheck:    proxyConfig.HealthCheck,
+		Services:       proxyConfig.Services,
+		Backends:       make(map[string]Backend),
+		ChangedCh:      make(chan struct{}, 1),
+	}
+	for _, listener := range proxyConfig.Listeners {
+		if listener.Address == "" {
+			return nil, fmt.Errorf("empty listen address")
+		}
+		cfg.Listeners = append(cfg.Listeners, listener.Address)
+	}
+	if len(cfg.Listeners) == 0 {
+		cfg.Listeners = []string{":16443"}
+	}
+
+	if len(proxyConfig.Backends) == 0 {
+		return nil, fmt.Errorf("empty list of control plane endpoints")
+	}
+	cfg.Endpoints = make([]string, 0, len(proxyConfig.Backends))
+	for _, backend := range proxyConfig.Backends {
+		if backend.Address == "" {
+			return nil, fmt.Errorf("empty control plane endpoint address")
+		}
+		cfg.Endpoints = append(cfg.Endpoints, backend.Address)
+		if backend.Priority != 0 || backend.Weight != 0 {
+			cfg.Backends[backend.Address] = backend
+		}
+	}
+	sort.Strings(cfg.Endpoints)
+
+	names := make(map[string]struct{}, len(cfg.Services))
+	for _, svc := range cfg.Services {
+		if err := validateService(svc); err != nil {
+			return nil, fmt.Errorf("invalid service %q: %w", svc.Name, err)
+		}
+		if _, ok := names[svc.Name]; ok {
+			return nil, fmt.Errorf("duplicate service %q", svc.Name)
+		}
+		names[svc.Name] = struct{}{}
+	}
+	return cfg, nil
+}
+
+// validateService validates the configuration of an additional service.
+func validateService(svc ServiceConfiguration) error {
+	switch {
+	case svc.Name == "":
+		return fmt.Errorf("empty service name")
+	case len(svc.Listeners) == 0:
+		return fmt.Errorf("empty list of listeners")
+	case svc.Discovery == nil && len(svc.Backends) == 0:
+		return fmt.Errorf("empty list of backends and no discovery")
+	case svc.Discovery != nil && svc.Discovery.Service == "":
+		return fmt.Errorf("empty discovery service name")
+	}
+	for _, listener := range svc.Listeners {
+		if listener.Address == "" {
+			return fmt.Errorf("empty listen address")
+		}
+	}
+	for _, backend := range svc.Backends {
+		if backend.Address == "" {
+			return fmt.Errorf("empty backend address")
+		}
+	}
+	return nil
+}
+
+// loadTraefikConfiguration loads traefik-compatible configuration for the API server proxy. It returns the provider
+// configuration file, and whether it should be watched for changes.
+func loadTraefikConfiguration(traefikConfigFile string) (*Configuration, string, bool, error) {
 	var traefikConfig TraefikConfiguration
 	if err := loadYamlWarnStrict(traefikConfigFile, &traefikConfig); err != nil {
-		return nil, fmt.Errorf("failed to load traefik configuration: %w", err)
+		return nil, "", false, fmt.Errorf("failed to load traefik configuration: %w", err)
+	}
+	var providerConfig ProviderConfiguration
+	if err := loadYamlWarnStrict(traefikConfig.Providers.File.Filename, &providerConfig); err != nil {
+		return nil, "", false, fmt.Errorf("failed to load provider configuration: %w", err)
+	}
+	cfg, err := newConfiguration(traefikToProxyConfiguration(traefikConfig, providerConfig))
+	if err != nil {
+		return nil, "", false, err
 	}
+	return cfg, traefikConfig.Providers.File.Filename, traefikConfig.Providers.File.Watch, nil
+}
+
+// traefikToProxyConfiguration converts traefik-compatible configuration to the apiserver proxy configuration format.
+func traefikToProxyConfiguration(traefikConfig TraefikConfiguration, providerConfig ProviderConfiguration) ProxyConfiguration {
+	var proxyConfig ProxyConfiguration
+	if address := traefikConfig.EntryPoints.APIServer.Address; address != "" {
+		proxyConfig.Listeners = []Listener{{Address: address}}
+	}
+	for _, server := range providerConfig.TCP.Services.APIServer.LoadBalancer.Servers {
+		proxyConfig.Backends = append(proxyConfig.Backends, Backend{Address: server.Address})
+	}
+	return proxyConfig
+}
 
-	// listen address
-	cfg.Listen = traefikConfig.EntryPoints.APIServer.Address
-	if cfg.Listen == "" {
-		cfg.Listen = ":16443"
+// traefikProviderFile returns the provider configuration file of the traefik configuration, or an empty string if the
+// traefik configuration cannot be loaded.
+func traefikProviderFile(traefikConfigFile string) string {
+	var traefikConfig TraefikConfiguration
+	if err := loadYamlWarnStrict(traefikConfigFile, &traefikConfig); err != nil {
+		return ""
 	}
+	return traefikConfig.Providers.File.Filename
+}
 
-	// endpoints
+// migrateTraefikConfiguration writes the traefik-compatible configuration to configFile if configFile does not exist.
+// If configFile exists but the traefik provider file is newer, only the list of control plane endpoints is updated.
+func migrateTraefikConfiguration(configFile string, traefikConfigFile string) error {
+	configInfo, err := os.Stat(configFile)
+	configExists := err == nil
+
+	var traefikConfig TraefikConfiguration
 	var providerConfig ProviderConfiguration
+	if err := loadYamlWarnStrict(traefikConfigFile, &traefikConfig); err != nil {
+		if configExists {
+			return nil
+		}
+		return fmt.Errorf("failed to load traefik configuration: %w", err)
+	}
+	providerInfo, err := os.Stat(traefikConfig.Providers.File.Filename)
+	if configExists && (err != nil || !providerInfo.ModTime().After(configInfo.ModTime())) {
+		return nil
+	}
 	if err := loadYamlWarnStrict(traefikConfig.Providers.File.Filename, &providerConfig); err != nil {
-		return nil, fmt.Errorf("failed to load provider configuration: %w", err)
+		if configExists {
+			log.Printf("WARNING: ignoring traefik provider configuration: %q", err)
+			return nil
+		}
+		return fmt.Errorf("failed to load provider configuration: %w", err)
 	}
+	migrated := traefikToProxyConfiguration(traefikConfig, providerConfig)
 
-	servers := providerConfig.TCP.Services.APIServer.LoadBalancer.Servers
-	if len(servers) == 0 {
-		return nil, fmt.Errorf("empty list of control plane endpoints")
+	if !configExists {
+		if err := writeYaml(configFile, migrated); err != nil {
+			return fmt.Errorf("failed to migrate traefik configuration: %w", err)
+		}
+		log.Printf("Migrated traefik configuration %s to %s", traefikConfigFile, configFile)
+		return nil
 	}
-	cfg.Endpoints = make([]string, 0, len(servers))
-	for i := 0; i < len(servers); i++ {
-		cfg.Endpoints = append(cfg.Endpoints, servers[i].Address)
+
+	endpoints := make([]string, 0, len(migrated.Backends))
+	for _, backend := range migrated.Backends {
+		endpoints = append(endpoints, backend.Address)
 	}
-	sort.Strings(cfg.Endpoints)
+	if err := UpdateProxyConfiguration(endpoints, configFile); err != nil {
+		return fmt.Errorf("failed to migrate traefik provider configuration: %w", err)
+	}
+	log.Printf("Traefik provider configuration %s changed, updated control plane endpoints in %s", traefikConfig.Providers.File.Filename, configFile)
+	return nil
+}
 
-	cfg.ChangedCh = make(chan struct{}, 1)
-	if traefikConfig.Providers.File.Watch {
-		watcher, err := newWatcher(traefikConfig.Providers.File.Filename)
-		if err != nil {
-			log.Printf("failed to setup file watch: %v", err)
+// watch notifies ChangedCh when file changes on disk.
+func (c *Configuration) watch(ctx context.Context, file string) {
+	watcher, err := newWatcher(file)
+	if err != nil {
+		log.Printf("failed to setup file watch: %v", err)
+		return
+	}
+	go func() {
+		if err := notifyOnChange(ctx, watcher, c.ChangedCh); err != nil {
+			log.Printf("error while watching %s: %v", file, err)
+		}
+	}()
+}
+
+// UpdateEndpoints updates the list of control plane endpoints in configFile. If configFile is empty or does not
+// exist, the traefik provider configuration file is updated instead.
+func UpdateEndpoints(endpoints []string, configFile string, traefikConfigFile string) error {
+	if configFile == "" || !util.FileExists(configFile) {
+		return UpdateConfiguration(endpoints, traefikConfigFile)
+	}
+	return UpdateProxyConfiguration(endpoints, configFile)
+}
+
+// UpdateProxyConfiguration updates the list of control plane endpoints in the apiserver proxy configuration file.
+// The priority and weight of existing endpoints are kept.
+func UpdateProxyConfiguration(endpoints []string, configFile string) error {
+	var proxyConfig ProxyConfiguration
+	if err := loadYamlWarnStrict(configFile, &proxyConfig); err != nil {
+		return fmt.Errorf("failed to load apiserver proxy configuration: %w", err)
+	}
+	existing := make(map[string]Backend, len(proxyConfig.Backends))
+	for _, backend := range proxyConfig.Backends {
+		existing[backend.Address] = backend
+	}
+	backends := make([]Backend, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if backend, ok := existing[endpoint]; ok {
+			backends = append(backends, backend)
 		} else {
-			go func() {
-				if err := notifyOnChange(ctx, watcher, cfg.ChangedCh); err != nil {
-					log.Printf("error while watching providers file: %v", err)
-				}
-			}()
+			backends = append(backends, Backend{Address: endpoint})
 		}
 	}
+	proxyConfig.Backends = backends
 
-	return cfg, nil
+	if err := writeYaml(configFile, proxyConfig); err != nil {
+		return fmt.Errorf("failed to update apiserver proxy config: %w", err)
+	}
+	return nil
 }
 
 // UpdateConfiguration updates the list of control plane endpoints in the traefik provider configuration file.
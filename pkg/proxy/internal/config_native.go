@@ -0,0 +1,94 @@
+package internal
+
+import "time"
+
+// ProxyConfiguration is the configuration file format of the apiserver proxy.
+type ProxyConfiguration struct {
+	// Listeners are the addresses the proxy listens on. If empty, the proxy listens on ":16443".
+	Listeners []Listener `yaml:"listeners"`
+	// Backends are the control plane endpoints. The list is updated by the proxy when the control plane nodes change.
+	Backends []Backend `yaml:"backends"`
+	// Timeouts configures the timeouts of the proxy.
+	Timeouts Timeouts `yaml:"timeouts,omitempty"`
+	// MaxConnections is the maximum number of concurrent client connections. Zero uses the command line setting.
+	MaxConnections int `yaml:"maxConnections,omitempty"`
+	// HealthCheck overrides the active health check settings of the command line, if set.
+	HealthCheck *HealthCheck `yaml:"healthCheck,omitempty"`
+	// Services are additional control plane services that are proxied on this node, e.g. the cluster agent.
+	Services []ServiceConfiguration `yaml:"services,omitempty"`
+}
+
+// ServiceConfiguration is an additional control plane service of the local proxy.
+type ServiceConfiguration struct {
+	// Name is the name of the service. It must be unique.
+	Name string `yaml:"name"`
+	// Listeners are the local addresses of the service.
+	Listeners []Listener `yaml:"listeners"`
+	// Backends is a static list of backends of the service. It is ignored if Discovery is set.
+	Backends []Backend `yaml:"backends,omitempty"`
+	// Discovery retrieves the backends of the service from the EndpointSlices of a Kubernetes Service.
+	Discovery *Discovery `yaml:"discovery,omitempty"`
+	// HealthCheck configures active TCP health checks of the backends. If not set, backends are only marked inactive
+	// after a failed connection attempt.
+	HealthCheck *HealthCheck `yaml:"healthCheck,omitempty"`
+	// Timeouts configures the timeouts of the service.
+	Timeouts Timeouts `yaml:"timeouts,omitempty"`
+	// MaxConnections is the maximum number of concurrent client connections, if not zero.
+	MaxConnections int `yaml:"maxConnections,omitempty"`
+}
+
+// Discovery retrieves the backends of a service from the EndpointSlices of a Kubernetes Service.
+type Discovery struct {
+	// Namespace is the namespace of the Kubernetes Service. If empty, "default" is used.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Service is the name of the Kubernetes Service.
+	Service string `yaml:"service"`
+	// Port is the name of the port of the Kubernetes Service. If empty, the first port is used.
+	Port string `yaml:"port,omitempty"`
+	// RefreshInterval is the interval for polling the EndpointSlices while they cannot be watched. If zero, 30s is
+	// used.
+	RefreshInterval time.Duration `yaml:"refreshInterval,omitempty"`
+}
+
+// Listener is a listen address of the apiserver proxy.
+type Listener struct {
+	Address string `yaml:"address"`
+}
+
+// Backend is a control plane endpoint of the apiserver proxy.
+type Backend struct {
+	Address string `yaml:"address"`
+	// Priority is the priority class of the backend. Backends with a lower value are preferred, and backends with a
+	// higher value are only used if no backend with a lower value is available. This is only used by the round-robin
+	// load-balancing strategy.
+	Priority uint16 `yaml:"priority,omitempty"`
+	// Weight is the relative share of connections of the backend among backends of the same priority. This is only
+	// used by the round-robin load-balancing strategy.
+	Weight uint16 `yaml:"weight,omitempty"`
+}
+
+// Timeouts configures the timeouts of the apiserver proxy. Zero values use the command line settings.
+type Timeouts struct {
+	// Drain is how long existing connections to removed backends are kept open.
+	Drain time.Duration `yaml:"drain,omitempty"`
+	// Dial is the timeout for connecting to a backend.
+	Dial time.Duration `yaml:"dial,omitempty"`
+	// Idle closes connections without traffic for the duration.
+	Idle time.Duration `yaml:"idle,omitempty"`
+	// HalfClose is how long a connection is kept open after one side closes its write half.
+	HalfClose time.Duration `yaml:"halfClose,omitempty"`
+	// KeepAlive is the TCP keepalive period. A negative value disables TCP keepalives.
+	KeepAlive time.Duration `yaml:"keepAlive,omitempty"`
+	// Shutdown is how long the proxy waits for active connections to be closed when it stops.
+	Shutdown time.Duration `yaml:"shutdown,omitempty"`
+}
+
+// HealthCheck configures active health checks of the backends. Zero values use the command line settings.
+type HealthCheck struct {
+	// Disabled disables active health checks.
+	Disabled bool          `yaml:"disabled,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+	Rise     int           `yaml:"rise,omitempty"`
+	Fall     int           `yaml:"fall,omitempty"`
+}
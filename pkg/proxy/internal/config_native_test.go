@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProxyConfiguration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(g Gomega) (string, string, string) {
+		dir := t.TempDir()
+		traefikFile := filepath.Join(dir, "traefik.yaml")
+		providerFile := filepath.Join(dir, "provider.yaml")
+		configFile := filepath.Join(dir, "apiserver-proxy.yaml")
+		traefikYaml := fmt.Sprintf("entryPoints:\n  apiserver:\n    address: \":16443\"\nproviders:\n  file:\n    filename: %s\n    watch: true\n", providerFile)
+		g.Expect(os.WriteFile(traefikFile, []byte(traefikYaml), 0644)).To(Succeed())
+		g.Expect(os.WriteFile(providerFile, []byte(testProviderYaml), 0644)).To(Succeed())
+		return configFile, traefikFile, providerFile
+	}
+
+	t.Run("Migrate", func(t *testing.T) {
+		g := NewWithT(t)
+		configFile, traefikFile, _ := setup(g)
+
+		cfg, err := LoadConfiguration(ctx, configFile, traefikFile)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cfg.Listeners).To(Equal([]string{":16443"}))
+		g.Expect(cfg.Endpoints).To(Equal([]string{"10.0.0.1:16443", "10.0.0.2:16443", "10.0.0.3:16443"}))
+		g.Expect(cfg.Backends).To(BeEmpty())
+		g.Expect(configFile).To(BeAnExistingFile())
+
+		var proxyConfig ProxyConfiguration
+		g.Expect(loadYamlWarnStrict(configFile, &proxyConfig)).To(Succeed())
+		g.Expect(proxyConfig.Backends).To(HaveLen(3))
+	})
+
+	t.Run("Native", func(t *testing.T) {
+		g := NewWithT(t)
+		configFile, traefikFile, _ := setup(g)
+		g.Expect(os.WriteFile(configFile, []byte(`
+listeners:
+- address: 127.0.0.1:16443
+- address: "[::1]:16443"
+backends:
+- address: 10.0.0.2:16443
+  priority: 1
+- address: 10.0.0.1:16443
+  weight: 10
+timeouts:
+  drain: 1m
+healthCheck:
+  interval: 5s
+  fall: 2
+`), 0644)).To(Succeed())
+
+		cfg, err := LoadConfiguration(ctx, configFile, traefikFile)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cfg.Listeners).To(Equal([]string{"127.0.0.1:16443", "[::1]:16443"}))
+		g.Expect(cfg.Endpoints).To(Equal([]string{"10.0.0.1:16443", "10.0.0.2:16443"}))
+		g.Expect(cfg.Backends).To(Equal(map[string]Backend{
+			"10.0.0.1:16443": {Address: "10.0.0.1:16443", Weight: 10},
+			"10.0.0.2:16443": {Address: "10.0.0.2:16443", Priority: 1},
+		}))
+		g.Expect(cfg.Timeouts.Drain).To(Equal(time.Minute))
+		g.Expect(cfg.HealthCheck).To(Equal(&HealthCheck{Interval: 5 * time.Second, Fall: 2}))
+
+		// updating endpoints keeps the settings of existing backends
+		g.Expect(UpdateEndpoints([]string{"10.0.0.1:16443", "10.0.0.4:16443"}, configFile, traefikFile)).To(Succeed())
+		newCfg, err := LoadConfiguration(ctx, configFile, traefikFile)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(newCfg.Endpoints).To(Equal([]string{"10.0.0.1:16443", "10.0.0.4:16443"}))
+		g.Expect(newCfg.Backends).To(HaveKeyWithValue("10.0.0.1:16443", Backend{Address: "10.0.0.1:16443", Weight: 10}))
+		g.Expect(newCfg.Timeouts.Drain).To(Equal(time.Minute))
+	})
+
+	t.Run("ProviderChanged", func(t *testing.T) {
+		g := NewWithT(t)
+		configFile, traefikFile, providerFile := setup(g)
+		g.Expect(os.WriteFile(configFile, []byte("backends:\n- address: 10.0.0.1:16443\n  weight: 10\n"), 0644)).To(Succeed())
+		past := time.Now().Add(-time.Hour)
+		g.Expect(os.Chtimes(configFile, past, past)).To(Succeed())
+
+		// older tooling updated the traefik provider file
+		cfg, err := LoadConfiguration(ctx, configFile, traefikFile)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cfg.Endpoints).To(Equal([]string{"10.0.0.1:16443", "10.0.0.2:16443", "10.0.0.3:16443"}))
+		g.Expect(cfg.Backends).To(HaveKeyWithValue("10.0.0.1:16443", Backend{Address: "10.0.0.1:16443", Weight: 10}))
+
+		// the provider file is not used again unless it changes
+		g.Expect(os.Chtimes(providerFile, past, past)).To(Succeed())
+		g.Expect(UpdateEndpoints([]string{"10.0.0.4:16443"}, configFile, traefikFile)).To(Succeed())
+		cfg, err = LoadConfiguration(ctx, configFile, traefikFile)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cfg.Endpoints).To(Equal([]string{"10.0.0.4:16443"}))
+	})
+
+	t.Run("ConfigChanged", func(t *testing.T) {
+		g := NewWithT(t)
+		configFile, traefikFile, _ := setup(g)
+		cfg, err := LoadConfiguration(ctx, configFile, traefikFile)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(UpdateEndpoints([]string{"10.0.0.4:16443"}, configFile, traefikFile)).To(Succeed())
+		g.Eventually(cfg.ChangedCh, time.Second).Should(Receive())
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		g := NewWithT(t)
+		configFile, traefikFile, _ := setup(g)
+		g.Expect(os.WriteFile(configFile, []byte("listeners:\n- address: :16443\n"), 0644)).To(Succeed())
+		_, err := LoadConfiguration(ctx, configFile, traefikFile)
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestConfigurationSettingsEqual(t *testing.T) {
+	base := func() *Configuration {
+		return &Configuration{
+			Listeners: []string{":16443"},
+			Endpoints: []string{"10.0.0.1:16443"},
+			Backends:  map[string]Backend{},
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		update func(cfg *Configuration)
+		expect bool
+	}{
+		{name: "Equal", update: func(cfg *Configuration) {}, expect: true},
+		{name: "Endpoints", update: func(cfg *Configuration) { cfg.Endpoints = []string{"10.0.0.2:16443"} }, expect: true},
+		{name: "Listeners", update: func(cfg *Configuration) { cfg.Listeners = []string{":16444"} }},
+		{name: "Backends", update: func(cfg *Configuration) { cfg.Backends["10.0.0.1:16443"] = Backend{Weight: 2} }},
+		{name: "Timeouts", update: func(cfg *Configuration) { cfg.Timeouts.Drain = time.Second }},
+		{name: "HealthCheck", update: func(cfg *Configuration) { cfg.HealthCheck = &HealthCheck{Disabled: true} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			cfg := base()
+			tc.update(cfg)
+			g.Expect(base().SettingsEqual(cfg)).To(Equal(tc.expect))
+		})
+	}
+}
+
+func TestServiceConfiguration(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		services    string
+		expectError bool
+	}{
+		{name: "Static", services: "- name: cluster-agent\n  listeners: [{address: 127.0.0.1:25000}]\n  backends: [{address: 10.0.0.1:25000}]\n"},
+		{name: "Discovery", services: "- name: registry\n  listeners: [{address: 127.0.0.1:32000}]\n  discovery: {namespace: container-registry, service: registry}\n"},
+		{name: "NoName", services: "- listeners: [{address: 127.0.0.1:25000}]\n  backends: [{address: 10.0.0.1:25000}]\n", expectError: true},
+		{name: "NoListeners", services: "- name: cluster-agent\n  backends: [{address: 10.0.0.1:25000}]\n", expectError: true},
+		{name: "NoBackends", services: "- name: cluster-agent\n  listeners: [{address: 127.0.0.1:25000}]\n", expectError: true},
+		{name: "NoDiscoveryService", services: "- name: registry\n  listeners: [{address: 127.0.0.1:32000}]\n  discovery: {namespace: container-registry}\n", expectError: true},
+		{name: "Duplicate", services: "- name: a\n  listeners: [{address: 127.0.0.1:1}]\n  backends: [{address: 10.0.0.1:1}]\n- name: a\n  listeners: [{address: 127.0.0.1:2}]\n  backends: [{address: 10.0.0.1:2}]\n", expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			configFile := filepath.Join(t.TempDir(), "apiserver-proxy.yaml")
+			g.Expect(os.WriteFile(configFile, []byte("backends:\n- address: 10.0.0.1:16443\nservices:\n"+tc.services), 0644)).To(Succeed())
+
+			cfg, err := LoadConfiguration(context.Background(), configFile, filepath.Join(t.TempDir(), "traefik.yaml"))
+			if tc.expectError {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cfg.Services).To(HaveLen(1))
+		})
+	}
+}
@@ -54,7 +54,7 @@ func TestConfiguration(t *testing.T) {
 	}
 
 	t.Run("Load", func(t *testing.T) {
-		cfg, err := LoadConfiguration(ctx, "testdata/traefik.yaml")
+		cfg, err := LoadConfiguration(ctx, "", "testdata/traefik.yaml")
 		if err != nil {
 			t.Fatalf("Expected no errors but received %q", err)
 		}
@@ -71,7 +71,7 @@ func TestConfiguration(t *testing.T) {
 			t.Fatalf("Expected no errors updating the configuration file but received %q instead", err)
 		}
 
-		newCfg, err := LoadConfiguration(ctx, "testdata/traefik.yaml")
+		newCfg, err := LoadConfiguration(ctx, "", "testdata/traefik.yaml")
 		if err != nil {
 			t.Fatalf("Expected no errors but received %q", err)
 		}
@@ -82,7 +82,7 @@ func TestConfiguration(t *testing.T) {
 	})
 
 	t.Run("ConfigChanged", func(t *testing.T) {
-		cfg, err := LoadConfiguration(ctx, "testdata/traefik.yaml")
+		cfg, err := LoadConfiguration(ctx, "", "testdata/traefik.yaml")
 		if err != nil {
 			t.Fatalf("Expected no errors but received %q", err)
 		}
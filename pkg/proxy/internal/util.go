@@ -49,10 +49,9 @@ func writeYaml(file string, data interface{}) error {
 
 	// NOTE(Hue): This is technically not a good thing to do here,
 	// because for whatever reason we might *want* to write an empty file.
-	// And we're also making this function context aware (read coupled)
-	// which is not a good thing.
-	// However, since this function is **only** used to write `provider.yaml` file and
-	// we know we don't want that file to be empty, we can safely return an error here.
+	// However, this function is only used to write the `provider.yaml` file, the native
+	// proxy configuration file and the endpoints cache, and none of them should ever be
+	// empty, so we can safely return an error here.
 	// Make sure to remove this check if the above statement is no longer true.
 	if data == nil || len(b) == 0 {
 		return fmt.Errorf("empty yaml data")
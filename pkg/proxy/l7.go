@@ -0,0 +1,610 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// L7Config configures the TLS-terminating mode of the apiserver proxy. In this mode, the proxy terminates client TLS
+// connections, and forwards HTTP requests to the control plane endpoints over new TLS connections. This allows
+// retrying requests that failed because of a broken endpoint connection. Watches and connection upgrades (e.g. exec
+// and port-forward) are supported.
+type L7Config struct {
+	// Enabled enables the TLS-terminating mode. If false, the proxy forwards TCP connections.
+	Enabled bool
+	// ServingCertFile and ServingKeyFile are the serving certificate and key of the proxy. If they do not exist, a
+	// local CA ("proxy-ca.crt" in the same directory) is created, and issues a serving certificate for the loopback
+	// addresses. Clients of the proxy must trust the local CA. The proxy trusts it for its own requests, but the
+	// kubeconfig files of other clients (e.g. kubelet and kube-proxy) must be updated.
+	ServingCertFile string
+	ServingKeyFile  string
+	// Retries is the number of times GET and HEAD requests without a body are retried after the connection to a
+	// control plane endpoint failed.
+	Retries int
+	// FrontProxyCertFile and FrontProxyKeyFile are the client certificate and key of an authenticating proxy
+	// trusted by kube-apiserver (--requestheader-client-ca-file), e.g. "front-proxy-client.crt". Requests with
+	// client certificates for other identities than the node are forwarded with it, and the identity of the client
+	// in the X-Remote-User and X-Remote-Group headers. If empty, these requests are rejected.
+	FrontProxyCertFile string
+	FrontProxyKeyFile  string
+	// ClientKubeconfigFiles are the kubeconfig files of other clients of the proxy, e.g. kube-proxy. If any of them
+	// authenticates with a client certificate for another identity than the node and no front proxy certificate is
+	// configured, the TLS-terminating mode is not enabled, as the requests of that client would be rejected. Missing
+	// files are ignored.
+	ClientKubeconfigFiles []string
+}
+
+// remoteUserHeader and remoteGroupHeader are the request headers used by kube-apiserver to authenticate requests
+// from an authenticating proxy.
+const (
+	remoteUserHeader  = "X-Remote-User"
+	remoteGroupHeader = "X-Remote-Group"
+)
+
+// remoteUserHeaders are removed from client requests, as requests may be forwarded with the node credentials or the
+// front proxy certificate.
+var remoteUserHeaders = []string{remoteUserHeader, remoteGroupHeader}
+
+// remoteExtraHeaderPrefix is the prefix of request headers with extra attributes of the user of an authenticating proxy.
+const remoteExtraHeaderPrefix = "X-Remote-Extra-"
+
+// l7proxy forwards HTTP requests of clients to the control plane endpoints of a tcpproxy.
+type l7proxy struct {
+	// tlsConfig is the TLS configuration for client connections. Client certificates are verified against the
+	// cluster CA, if given.
+	tlsConfig *tls.Config
+	// node is the subject of the client certificate of the node credentials. If nil, the node credentials do not use
+	// a client certificate, and are never used for client requests.
+	node *pkix.Name
+	// host is the kubeconfig server, used as the host of forwarded requests.
+	host string
+	// nodeConfig and anonymousConfig are the configurations of the transports to the control plane endpoints, with
+	// and without the node credentials.
+	nodeConfig      *rest.Config
+	anonymousConfig *rest.Config
+	// frontProxyConfig is the configuration of the transport to the control plane endpoints with the front proxy
+	// certificate. If nil, requests with client certificates for other identities than the node are rejected.
+	frontProxyConfig *rest.Config
+	// retries is the number of retries of idempotent requests.
+	retries int
+}
+
+// newL7Proxy prepares the TLS-terminating mode of the proxy. Connections to the control plane endpoints are
+// authenticated with the credentials from kubeconfigFile, which are normally the kubelet client certificate.
+func newL7Proxy(cfg L7Config, kubeconfigFile string) (*l7proxy, error) {
+	servingCert, err := loadOrIssueServingCertificate(cfg.ServingCertFile, cfg.ServingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load serving certificate: %w", err)
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+	}
+	u, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig server %q: %w", config.Host, err)
+	}
+	// Requests are forwarded to the endpoints directly, and must verify the certificate against the same name as
+	// clients of the proxy do.
+	if config.TLSClientConfig.ServerName == "" {
+		config.TLSClientConfig.ServerName = u.Hostname()
+	}
+	// HTTP/2 does not support connection upgrades, which are used by exec, attach and port-forward.
+	config.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	tlsConfig, err := rest.TLSConfigFor(config)
+	if err != nil || tlsConfig == nil || tlsConfig.RootCAs == nil {
+		return nil, fmt.Errorf("failed to load cluster CA from kubeconfig: %v", err)
+	}
+
+	// clients that only trust the cluster CA cannot connect to the proxy
+	if _, err := servingCert.Leaf.Verify(x509.VerifyOptions{DNSName: u.Hostname(), Roots: tlsConfig.RootCAs, Intermediates: intermediates(servingCert)}); err != nil {
+		log.Printf("WARNING: kubeconfig %s does not trust the serving certificate %s, clients of the proxy (e.g. kubelet and kube-proxy) must trust %s: %q\n", kubeconfigFile, cfg.ServingCertFile, cfg.localCAFile(), err)
+	}
+
+	node, err := clientCertificateSubject(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate from kubeconfig: %w", err)
+	}
+
+	anonymousConfig := rest.AnonymousClientConfig(config)
+	anonymousConfig.TLSClientConfig.ServerName = config.TLSClientConfig.ServerName
+	anonymousConfig.TLSClientConfig.NextProtos = config.TLSClientConfig.NextProtos
+
+	var frontProxyConfig *rest.Config
+	if cfg.FrontProxyCertFile != "" || cfg.FrontProxyKeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.FrontProxyCertFile, cfg.FrontProxyKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to load front proxy certificate: %w", err)
+		}
+		frontProxyConfig = rest.CopyConfig(anonymousConfig)
+		frontProxyConfig.TLSClientConfig.CertFile = cfg.FrontProxyCertFile
+		frontProxyConfig.TLSClientConfig.KeyFile = cfg.FrontProxyKeyFile
+	} else if err := checkClientKubeconfigs(cfg.ClientKubeconfigFiles, node); err != nil {
+		return nil, err
+	}
+
+	return &l7proxy{
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{servingCert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    tlsConfig.RootCAs,
+			MinVersion:   tls.VersionTLS12,
+		},
+		node:             node,
+		host:             u.Host,
+		nodeConfig:       config,
+		anonymousConfig:  anonymousConfig,
+		frontProxyConfig: frontProxyConfig,
+		retries:          cfg.Retries,
+	}, nil
+}
+
+// checkClientKubeconfigs returns an error if any of kubeconfigFiles authenticates with a client certificate for
+// another identity than node, as requests with that certificate cannot be forwarded without a front proxy
+// certificate.
+func checkClientKubeconfigs(kubeconfigFiles []string, node *pkix.Name) error {
+	for _, file := range kubeconfigFiles {
+		if !util.FileExists(file) {
+			continue
+		}
+		config, err := clientcmd.BuildConfigFromFlags("", file)
+		if err != nil {
+			return fmt.Errorf("failed to load kubeconfig %s: %w", file, err)
+		}
+		subject, err := clientCertificateSubject(config)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate from kubeconfig %s: %w", file, err)
+		}
+		if subject != nil && (node == nil || !sameIdentity(*subject, *node)) {
+			return fmt.Errorf("kubeconfig %s authenticates with a client certificate for %q, which requires a front proxy certificate", file, subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// newServer returns the HTTP server of the TLS-terminating mode of tp. Requests are forwarded over connections from
+// dial.
+//
+// Requests of clients with a client certificate for the node itself (e.g. the kubelet) are forwarded with the node
+// credentials. Requests with other client certificates signed by the cluster CA (e.g. kube-proxy) are forwarded with
+// the front proxy certificate, and the common name and organizations of the client certificate as the X-Remote-User
+// and X-Remote-Group headers. Without a front proxy certificate they are rejected, as the proxy cannot forward them
+// without the private key of the client. Requests with other credentials (e.g. service account tokens) and anonymous
+// requests are forwarded without the node credentials.
+func (l *l7proxy) newServer(tp *tcpproxy) (*http.Server, error) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return tp.dialL7(ctx)
+	}
+	nodeConfig := rest.CopyConfig(l.nodeConfig)
+	nodeConfig.Dial = dial
+	nodeTransport, err := rest.TransportFor(nodeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize transport: %w", err)
+	}
+	anonymousConfig := rest.CopyConfig(l.anonymousConfig)
+	anonymousConfig.Dial = dial
+	anonymousTransport, err := rest.TransportFor(anonymousConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize transport: %w", err)
+	}
+	var frontProxyTransport http.RoundTripper
+	if l.frontProxyConfig != nil {
+		frontProxyConfig := rest.CopyConfig(l.frontProxyConfig)
+		frontProxyConfig.Dial = dial
+		if frontProxyTransport, err = rest.TransportFor(frontProxyConfig); err != nil {
+			return nil, fmt.Errorf("failed to initialize transport: %w", err)
+		}
+	}
+
+	handler := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = "https"
+			pr.Out.URL.Host = l.host
+			pr.Out.Host = ""
+			pr.SetXForwarded()
+			for _, header := range remoteUserHeaders {
+				pr.Out.Header.Del(header)
+			}
+			for header := range pr.Out.Header {
+				if strings.HasPrefix(http.CanonicalHeaderKey(header), remoteExtraHeaderPrefix) {
+					pr.Out.Header.Del(header)
+				}
+			}
+			if client, ok := pr.In.Context().Value(clientSubjectKey{}).(pkix.Name); ok {
+				pr.Out.Header.Set(remoteUserHeader, client.CommonName)
+				for _, group := range client.Organization {
+					pr.Out.Header.Add(remoteGroupHeader, group)
+				}
+			}
+		},
+		Transport: &retryTransport{
+			base: credentialsTransport{
+				node:       nodeTransport,
+				anonymous:  anonymousTransport,
+				frontProxy: frontProxyTransport,
+			},
+			retries: l.retries,
+		},
+		// flush immediately, so that watches are streamed to clients
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("failed to proxy %s %s: %v\n", r.Method, r.URL.Path, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+				cert := r.TLS.VerifiedChains[0][0]
+				switch {
+				case l.isNode(cert.Subject):
+					r = r.WithContext(context.WithValue(r.Context(), nodeCredentialsKey{}, true))
+				case frontProxyTransport != nil:
+					r = r.WithContext(context.WithValue(r.Context(), clientSubjectKey{}, cert.Subject))
+				default:
+					http.Error(w, fmt.Sprintf("client certificate for %q is not accepted by the apiserver proxy without a front proxy certificate", cert.Subject.CommonName), http.StatusForbidden)
+					return
+				}
+			}
+			handler.ServeHTTP(w, r)
+		}),
+		TLSConfig:   l.tlsConfig.Clone(),
+		IdleTimeout: tp.Connections.IdleTimeout,
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				tp.Connections.setKeepAlive(conn)
+			}
+		},
+	}
+	// idle endpoint connections are not drained on shutdown
+	server.RegisterOnShutdown(func() {
+		utilnet.CloseIdleConnectionsFor(nodeTransport)
+		utilnet.CloseIdleConnectionsFor(anonymousTransport)
+		if frontProxyTransport != nil {
+			utilnet.CloseIdleConnectionsFor(frontProxyTransport)
+		}
+	})
+	return server, nil
+}
+
+// isNode returns true if subject is the subject of the client certificate of the node credentials.
+func (l *l7proxy) isNode(subject pkix.Name) bool {
+	return l.node != nil && sameIdentity(subject, *l.node)
+}
+
+// sameIdentity returns true if the client certificate subjects a and b authenticate the same user and groups.
+func sameIdentity(a, b pkix.Name) bool {
+	return a.CommonName == b.CommonName && slices.Equal(a.Organization, b.Organization)
+}
+
+// clientCertificateSubject returns the subject of the client certificate of config. If config does not use a client
+// certificate, it returns nil.
+func clientCertificateSubject(config *rest.Config) (*pkix.Name, error) {
+	certPEM := config.TLSClientConfig.CertData
+	if len(certPEM) == 0 && config.TLSClientConfig.CertFile != "" {
+		b, err := os.ReadFile(config.TLSClientConfig.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		certPEM = b
+	}
+	if len(certPEM) == 0 {
+		return nil, nil
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &cert.Subject, nil
+}
+
+// localCAFile is the path to the local CA that issues missing serving certificates.
+func (cfg L7Config) localCAFile() string {
+	return filepath.Join(filepath.Dir(cfg.ServingCertFile), "proxy-ca.crt")
+}
+
+// trustServingCertificate updates the CA of config to also trust the serving certificate of the proxy, and the local
+// CA if it exists. Missing files are ignored, e.g. before the serving certificate is issued.
+func (cfg L7Config) trustServingCertificate(config *rest.Config) error {
+	caData := config.TLSClientConfig.CAData
+	if len(caData) == 0 && config.TLSClientConfig.CAFile != "" {
+		b, err := os.ReadFile(config.TLSClientConfig.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA: %w", err)
+		}
+		caData = b
+	}
+	caData = slices.Clone(caData)
+	for _, file := range []string{cfg.localCAFile(), cfg.ServingCertFile} {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		caData = append(append(caData, '\n'), b...)
+	}
+	config.TLSClientConfig.CAData = caData
+	config.TLSClientConfig.CAFile = ""
+	return nil
+}
+
+// intermediates returns the intermediate certificates of the chain of cert.
+func intermediates(cert tls.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, der := range cert.Certificate[1:] {
+		if c, err := x509.ParseCertificate(der); err == nil {
+			pool.AddCert(c)
+		}
+	}
+	return pool
+}
+
+// serveL7 serves client requests in the TLS-terminating mode until the proxy is stopped.
+func (tp *tcpproxy) serveL7() error {
+	server, err := tp.L7.newServer(tp)
+	if err != nil {
+		return err
+	}
+	tp.mu.Lock()
+	tp.server = server
+	tp.mu.Unlock()
+	return server.ServeTLS(tp.Listener, "", "")
+}
+
+// dialL7 connects to an active control plane endpoint for forwarded requests. The connection is tracked by the remote,
+// so that it is drained when the endpoint is removed or the proxy stops.
+func (tp *tcpproxy) dialL7(ctx context.Context) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for {
+		remote, conn, err := tp.dialRemote()
+		if err != nil {
+			return nil, err
+		}
+		untrack, ok := remote.track(conn)
+		if !ok {
+			// the remote was removed while dialing, pick another one
+			conn.Close()
+			continue
+		}
+		proxyConnections.WithLabelValues(remote.addr, string(tp.strategy())).Inc()
+		return &trackedConn{
+			Conn:     conn,
+			untrack:  untrack,
+			sent:     proxyBytes.WithLabelValues(remote.addr, "sent"),
+			received: proxyBytes.WithLabelValues(remote.addr, "received"),
+		}, nil
+	}
+}
+
+// nodeCredentialsKey is the context key of requests forwarded with the node credentials.
+type nodeCredentialsKey struct{}
+
+// clientSubjectKey is the context key of the client certificate subject of requests forwarded with the front proxy
+// certificate.
+type clientSubjectKey struct{}
+
+// credentialsTransport forwards requests with the node credentials, the front proxy certificate, or without
+// credentials.
+type credentialsTransport struct {
+	node       http.RoundTripper
+	anonymous  http.RoundTripper
+	frontProxy http.RoundTripper
+}
+
+func (t credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if node, _ := req.Context().Value(nodeCredentialsKey{}).(bool); node {
+		return t.node.RoundTrip(req)
+	}
+	if _, ok := req.Context().Value(clientSubjectKey{}).(pkix.Name); ok && t.frontProxy != nil {
+		return t.frontProxy.RoundTrip(req)
+	}
+	return t.anonymous.RoundTrip(req)
+}
+
+// retryTransport retries idempotent requests that failed without a response, e.g. because the connection to the
+// control plane endpoint was reset. Each retry dials a new connection, which may pick another endpoint.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil || attempt >= t.retries || !isRetryable(req) || req.Context().Err() != nil {
+			return resp, err
+		}
+		proxyRetries.Inc()
+		log.Printf("retrying %s %s after error %q\n", req.Method, req.URL.Path, err)
+	}
+}
+
+// isRetryable returns true if req can be sent again, i.e. it is a GET or HEAD request without a body.
+func isRetryable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// trackedConn is a connection to a control plane endpoint that counts transferred bytes, and stops being tracked by
+// its remote when closed.
+type trackedConn struct {
+	net.Conn
+	untrack  func()
+	sent     prometheus.Counter
+	received prometheus.Counter
+
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(float64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(float64(n))
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.closeOnce.Do(c.untrack)
+	return c.Conn.Close()
+}
+
+// loadOrIssueServingCertificate loads the serving certificate of the proxy. If certFile or keyFile does not exist, a
+// new serving certificate for the loopback addresses is issued by the local CA.
+func loadOrIssueServingCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if util.FileExists(certFile) && util.FileExists(keyFile) {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	caCertFile := L7Config{ServingCertFile: certFile}.localCAFile()
+	caKeyFile := filepath.Join(filepath.Dir(certFile), "proxy-ca.key")
+	ca, err := loadOrIssueLocalCA(caCertFile, caKeyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load local CA: %w", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to parse local CA: %w", err)
+	}
+
+	certPEM, keyPEM, err := issueCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "microk8s-apiserver-proxy"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to issue serving certificate: %w", err)
+	}
+	if err := writeCertificate(certFile, keyFile, certPEM, keyPEM); err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Printf("issued serving certificate %s, clients of the proxy must trust the local CA %s\n", certFile, caCertFile)
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// loadOrIssueLocalCA loads the local CA of the proxy. If certFile or keyFile does not exist, a new self-signed CA is
+// created.
+func loadOrIssueLocalCA(certFile, keyFile string) (tls.Certificate, error) {
+	if util.FileExists(certFile) && util.FileExists(keyFile) {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	certPEM, keyPEM, err := issueCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "microk8s-apiserver-proxy-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}, nil, nil)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writeCertificate(certFile, keyFile, certPEM, keyPEM); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// issueCertificate creates a new key, and a certificate from template signed by parent. If parent is nil, the
+// certificate is self-signed. Certificates are valid for 10 years. It returns the PEM-encoded certificate and key.
+func issueCertificate(template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().AddDate(10, 0, 0)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// writeCertificate atomically writes a PEM-encoded certificate and key.
+func writeCertificate(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	if err := writeFileAtomic(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write key %s: %w", keyFile, err)
+	}
+	if err := writeFileAtomic(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate %s: %w", certFile, err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file, and renames it to file.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(perm); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), file)
+}
@@ -0,0 +1,551 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// testCertificate issues a certificate signed by ca. If ca is nil, the certificate is a self-signed CA.
+func testCertificate(t *testing.T, ca *tls.Certificate, template *x509.Certificate) tls.Certificate {
+	var parent *x509.Certificate
+	var parentKey crypto.Signer
+	if ca != nil {
+		parent = ca.Leaf
+		parentKey = ca.PrivateKey.(crypto.Signer)
+	}
+	certPEM, keyPEM, err := issueCertificate(template, parent, parentKey)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load certificate: %v", err)
+	}
+	return cert
+}
+
+// startAPIServer starts a TLS server with a certificate for 127.0.0.1 signed by ca. It serves "/echo", which replies
+// with the client certificate and identity headers of the request, "/watch", which streams an event, and "/upgrade",
+// which switches to a line-based echo protocol.
+func startAPIServer(t *testing.T, ca tls.Certificate) *net.SRV {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		var cn string
+		if len(r.TLS.PeerCertificates) > 0 {
+			cn = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		fmt.Fprintf(w, "cert=%s user=%s groups=%s extra=%s auth=%s", cn, r.Header.Get("X-Remote-User"), strings.Join(r.Header.Values("X-Remote-Group"), ","), r.Header.Get("X-Remote-Extra-Scopes"), r.Header.Get("Authorization"))
+	})
+	mux.HandleFunc("/watch", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "event")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/upgrade", func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			rw.WriteString("echo " + line)
+			rw.Flush()
+		}
+	})
+
+	srv := httptest.NewUnstartedServer(mux)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, &ca, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "kube-apiserver"},
+			IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "https://"))
+	portNumber, _ := strconv.Atoi(port)
+	return &net.SRV{Target: host, Port: uint16(portNumber)}
+}
+
+// startBrokenBackend starts a TCP server that closes all connections immediately.
+func startBrokenBackend(t *testing.T) *net.SRV {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start backend: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+	return &net.SRV{Target: "127.0.0.1", Port: uint16(port)}
+}
+
+// l7TestCluster is a cluster CA with a kubeconfig authenticating with a kubelet client certificate.
+type l7TestCluster struct {
+	ca         tls.Certificate
+	kubeconfig string
+	// kubelet is the client certificate of the node credentials in kubeconfig.
+	kubelet tls.Certificate
+	// client is a client certificate of a local component, e.g. kube-proxy.
+	client tls.Certificate
+	// frontProxyCertFile and frontProxyKeyFile are a front proxy client certificate trusted by the apiserver.
+	frontProxyCertFile string
+	frontProxyKeyFile  string
+}
+
+func newL7TestCluster(t *testing.T) *l7TestCluster {
+	ca := testCertificate(t, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "microk8s-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	})
+	kubelet := testCertificate(t, &ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "system:node:worker", Organization: []string{"system:nodes"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	client := testCertificate(t, &ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "system:kube-proxy", Organization: []string{"system:proxies", "system:authenticated"}},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	frontProxy := testCertificate(t, &ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "front-proxy-client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	keyDER, err := x509.MarshalPKCS8PrivateKey(frontProxy.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	cluster := &l7TestCluster{
+		ca:                 ca,
+		kubelet:            kubelet,
+		client:             client,
+		frontProxyCertFile: filepath.Join(dir, "front-proxy-client.crt"),
+		frontProxyKeyFile:  filepath.Join(dir, "front-proxy-client.key"),
+	}
+	if err := os.WriteFile(cluster.frontProxyCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: frontProxy.Certificate[0]}), 0600); err != nil {
+		t.Fatalf("failed to write front proxy certificate: %v", err)
+	}
+	if err := os.WriteFile(cluster.frontProxyKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write front proxy key: %v", err)
+	}
+	cluster.kubeconfig = cluster.writeKubeconfig(t, "127.0.0.1:16443")
+	return cluster
+}
+
+// writeKubeconfig writes a kubeconfig for server with the cluster CA and the kubelet client certificate.
+func (c *l7TestCluster) writeKubeconfig(t *testing.T, server string) string {
+	return c.writeClientKubeconfig(t, server, c.kubelet)
+}
+
+// writeClientKubeconfig writes a kubeconfig for server with the cluster CA and the client certificate cert.
+func (c *l7TestCluster) writeClientKubeconfig(t *testing.T, server string, cert tls.Certificate) string {
+	encode := func(block *pem.Block) string { return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(block)) }
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	kubeconfig := filepath.Join(t.TempDir(), "client.config")
+	if err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: https://%s
+  name: microk8s-cluster
+contexts:
+- context:
+    cluster: microk8s-cluster
+    user: kubelet
+  name: microk8s
+current-context: microk8s
+users:
+- name: kubelet
+  user:
+    client-certificate-data: %s
+    client-key-data: %s
+`, encode(&pem.Block{Type: "CERTIFICATE", Bytes: c.ca.Certificate[0]}), server, encode(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), encode(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))), 0600); err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+	return kubeconfig
+}
+
+// startL7Proxy starts a proxy in the TLS-terminating mode with cfg, using serving certificates issued by a local CA.
+// It returns the proxy, the TLS configuration of clients, which trusts the local CA of the proxy, and the
+// configuration of the TLS-terminating mode.
+func startL7Proxy(t *testing.T, cluster *l7TestCluster, cfg L7Config, backends ...*net.SRV) (*tcpproxy, *tls.Config, L7Config) {
+	dir := t.TempDir()
+	cfg.Enabled = true
+	cfg.ServingCertFile = filepath.Join(dir, "apiserver-proxy.crt")
+	cfg.ServingKeyFile = filepath.Join(dir, "apiserver-proxy.key")
+	l7, err := newL7Proxy(cfg, cluster.kubeconfig)
+	if err != nil {
+		t.Fatalf("failed to initialize TLS-terminating mode: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start listener: %v", err)
+	}
+	tp := &tcpproxy{Listener: l, Endpoints: backends, L7: l7}
+	go tp.Run()
+	t.Cleanup(tp.Stop)
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, "proxy-ca.crt"))
+	if err != nil {
+		t.Fatalf("failed to read local CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	return tp, &tls.Config{RootCAs: pool}, cfg
+}
+
+// send sends a request for path to the proxy, and returns the status code and body of the response.
+func send(client *http.Client, tp *tcpproxy, method string, path string, header http.Header) (int, string, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("https://%s%s", tp.Listener.Addr(), path), nil)
+	if err != nil {
+		return 0, "", err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+func TestL7Proxy(t *testing.T) {
+	cluster := newL7TestCluster(t)
+	tp, clientTLS, _ := startL7Proxy(t, cluster, L7Config{Retries: 2}, startAPIServer(t, cluster.ca))
+
+	t.Run("NodeCertificate", func(t *testing.T) {
+		g := NewWithT(t)
+		tlsConfig := clientTLS.Clone()
+		tlsConfig.Certificates = []tls.Certificate{cluster.kubelet}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		status, body, err := send(client, tp, http.MethodGet, "/echo", http.Header{"X-Remote-User": {"system:admin"}, "X-Remote-Extra-Scopes": {"admin"}})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(status).To(Equal(http.StatusOK))
+		g.Expect(body).To(Equal("cert=system:node:worker user= groups= extra= auth="))
+	})
+
+	t.Run("ClientCertificate", func(t *testing.T) {
+		g := NewWithT(t)
+		tlsConfig := clientTLS.Clone()
+		tlsConfig.Certificates = []tls.Certificate{cluster.client}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		// the node credentials are not lent to other identities
+		status, body, err := send(client, tp, http.MethodGet, "/echo", nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(status).To(Equal(http.StatusForbidden))
+		g.Expect(body).ToNot(ContainSubstring("cert="))
+	})
+
+	t.Run("Token", func(t *testing.T) {
+		g := NewWithT(t)
+		tlsConfig := clientTLS.Clone()
+		tlsConfig.Certificates = []tls.Certificate{cluster.client}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+		status, body, err := send(client, tp, http.MethodGet, "/echo", http.Header{"Authorization": {"Bearer token"}})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(status).To(Equal(http.StatusOK))
+		g.Expect(body).To(Equal("cert= user= groups= extra= auth=Bearer token"))
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		g := NewWithT(t)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+		status, body, err := send(client, tp, http.MethodGet, "/echo", http.Header{"X-Remote-User": {"system:admin"}, "X-Remote-Group": {"system:masters"}})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(status).To(Equal(http.StatusOK))
+		g.Expect(body).To(Equal("cert= user= groups= extra= auth="))
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		g := NewWithT(t)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		resp, err := client.Get(fmt.Sprintf("https://%s/watch", tp.Listener.Addr()))
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		// the event is received before the response is complete
+		linec := make(chan string, 1)
+		go func() {
+			line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+			linec <- line
+		}()
+		g.Eventually(linec, 2*time.Second).Should(Receive(Equal("event\n")))
+	})
+
+	t.Run("Upgrade", func(t *testing.T) {
+		g := NewWithT(t)
+		conn, err := tls.Dial("tcp", tp.Listener.Addr().String(), clientTLS)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		_, err = conn.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: 127.0.0.1\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n"))
+		g.Expect(err).ToNot(HaveOccurred())
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+
+		_, err = conn.Write([]byte("ping\n"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(reader.ReadString('\n')).To(Equal("echo ping\n"))
+	})
+}
+
+func TestL7ProxyFrontProxy(t *testing.T) {
+	cluster := newL7TestCluster(t)
+	tp, clientTLS, _ := startL7Proxy(t, cluster, L7Config{
+		Retries:            2,
+		FrontProxyCertFile: cluster.frontProxyCertFile,
+		FrontProxyKeyFile:  cluster.frontProxyKeyFile,
+	}, startAPIServer(t, cluster.ca))
+
+	for _, tc := range []struct {
+		name   string
+		cert   tls.Certificate
+		header http.Header
+		body   string
+	}{
+		{
+			name: "NodeCertificate",
+			cert: cluster.kubelet,
+			body: "cert=system:node:worker user= groups= extra= auth=",
+		},
+		{
+			name:   "ClientCertificate",
+			cert:   cluster.client,
+			header: http.Header{"X-Remote-User": {"system:admin"}, "X-Remote-Group": {"system:masters"}, "X-Remote-Extra-Scopes": {"admin"}},
+			body:   "cert=front-proxy-client user=system:kube-proxy groups=system:proxies,system:authenticated extra= auth=",
+		},
+		{
+			name:   "Token",
+			cert:   cluster.client,
+			header: http.Header{"Authorization": {"Bearer token"}},
+			body:   "cert= user= groups= extra= auth=Bearer token",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			tlsConfig := clientTLS.Clone()
+			tlsConfig.Certificates = []tls.Certificate{tc.cert}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+			status, body, err := send(client, tp, http.MethodGet, "/echo", tc.header)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(status).To(Equal(http.StatusOK))
+			g.Expect(body).To(Equal(tc.body))
+		})
+	}
+}
+
+func TestNewL7ProxyClientKubeconfigs(t *testing.T) {
+	cluster := newL7TestCluster(t)
+	kubeProxyKubeconfig := cluster.writeClientKubeconfig(t, "127.0.0.1:16443", cluster.client)
+
+	for _, tc := range []struct {
+		name        string
+		cfg         L7Config
+		expectError bool
+	}{
+		{
+			name: "NoClients",
+		},
+		{
+			name: "MissingKubeconfig",
+			cfg:  L7Config{ClientKubeconfigFiles: []string{filepath.Join(t.TempDir(), "proxy.config")}},
+		},
+		{
+			name: "NodeCertificate",
+			cfg:  L7Config{ClientKubeconfigFiles: []string{cluster.kubeconfig}},
+		},
+		{
+			name:        "ClientCertificate",
+			cfg:         L7Config{ClientKubeconfigFiles: []string{cluster.kubeconfig, kubeProxyKubeconfig}},
+			expectError: true,
+		},
+		{
+			name: "ClientCertificateWithFrontProxy",
+			cfg: L7Config{
+				ClientKubeconfigFiles: []string{kubeProxyKubeconfig},
+				FrontProxyCertFile:    cluster.frontProxyCertFile,
+				FrontProxyKeyFile:     cluster.frontProxyKeyFile,
+			},
+		},
+		{
+			name: "MissingFrontProxyKey",
+			cfg: L7Config{
+				FrontProxyCertFile: cluster.frontProxyCertFile,
+				FrontProxyKeyFile:  filepath.Join(t.TempDir(), "front-proxy-client.key"),
+			},
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			dir := t.TempDir()
+			tc.cfg.Enabled = true
+			tc.cfg.ServingCertFile = filepath.Join(dir, "apiserver-proxy.crt")
+			tc.cfg.ServingKeyFile = filepath.Join(dir, "apiserver-proxy.key")
+
+			_, err := newL7Proxy(tc.cfg, cluster.kubeconfig)
+			if tc.expectError {
+				g.Expect(err).To(HaveOccurred())
+			} else {
+				g.Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	}
+}
+
+func TestL7ProxyClientset(t *testing.T) {
+	g := NewWithT(t)
+	cluster := newL7TestCluster(t)
+	tp, _, cfg := startL7Proxy(t, cluster, L7Config{Retries: 2}, startAPIServer(t, cluster.ca))
+	p := &APIServerProxy{KubeconfigFile: cluster.writeKubeconfig(t, tp.Listener.Addr().String())}
+
+	// the kubeconfig only trusts the cluster CA
+	clientset, err := p.newClientset()
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = clientset.Discovery().RESTClient().Get().AbsPath("/echo").DoRaw(context.Background())
+	g.Expect(err).To(HaveOccurred())
+
+	// the clients of the proxy trust its serving certificate, and are forwarded with the node credentials
+	p.L7 = cfg
+	clientset, err = p.newClientset()
+	g.Expect(err).ToNot(HaveOccurred())
+	body, err := clientset.Discovery().RESTClient().Get().AbsPath("/echo").DoRaw(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(body)).To(Equal("cert=system:node:worker user= groups= extra= auth="))
+}
+
+func TestL7ProxyRetries(t *testing.T) {
+	cluster := newL7TestCluster(t)
+	broken := startBrokenBackend(t)
+	retries := func(g Gomega) float64 { return gather(g, "microk8s_apiserver_proxy_request_retries_total")[""] }
+
+	t.Run("Success", func(t *testing.T) {
+		g := NewWithT(t)
+		tp, clientTLS, _ := startL7Proxy(t, cluster, L7Config{Retries: 2}, broken, startAPIServer(t, cluster.ca))
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+		before := retries(g)
+		status, _, err := send(client, tp, http.MethodGet, "/echo", nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(status).To(Equal(http.StatusOK))
+		g.Expect(retries(g) - before).To(Equal(1.0))
+	})
+
+	for _, tc := range []struct {
+		method        string
+		expectRetries float64
+	}{
+		{method: http.MethodGet, expectRetries: 2},
+		{method: http.MethodHead, expectRetries: 2},
+		{method: http.MethodPost, expectRetries: 0},
+		{method: http.MethodDelete, expectRetries: 0},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			g := NewWithT(t)
+			tp, clientTLS, _ := startL7Proxy(t, cluster, L7Config{Retries: 2}, broken)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+			before := retries(g)
+			status, _, err := send(client, tp, tc.method, "/echo", nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(status).To(Equal(http.StatusBadGateway))
+			g.Expect(retries(g) - before).To(Equal(tc.expectRetries))
+		})
+	}
+}
+
+func TestLoadOrIssueServingCertificate(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "apiserver-proxy.crt")
+	keyFile := filepath.Join(dir, "apiserver-proxy.key")
+
+	cert, err := loadOrIssueServingCertificate(certFile, keyFile)
+	g.Expect(err).ToNot(HaveOccurred())
+	for _, file := range []string{certFile, keyFile, "proxy-ca.crt", "proxy-ca.key"} {
+		g.Expect(filepath.Join(dir, filepath.Base(file))).To(BeAnExistingFile())
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, "proxy-ca.crt"))
+	g.Expect(err).ToNot(HaveOccurred())
+	pool := x509.NewCertPool()
+	g.Expect(pool.AppendCertsFromPEM(caPEM)).To(BeTrue())
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	for _, name := range []string{"127.0.0.1", "::1", "localhost"} {
+		_, err := leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: pool})
+		g.Expect(err).ToNot(HaveOccurred(), name)
+	}
+
+	// existing certificates are loaded
+	loaded, err := loadOrIssueServingCertificate(certFile, keyFile)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(loaded.Certificate).To(Equal(cert.Certificate))
+
+	// missing serving certificates are issued by the existing local CA
+	g.Expect(os.Remove(certFile)).To(Succeed())
+	reissued, err := loadOrIssueServingCertificate(certFile, keyFile)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reissued.Certificate).ToNot(Equal(cert.Certificate))
+	leaf, err = x509.ParseCertificate(reissued.Certificate[0])
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pool})
+	g.Expect(err).ToNot(HaveOccurred())
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// multiListener accepts connections from multiple listeners.
+type multiListener struct {
+	listeners []net.Listener
+	connCh    chan net.Conn
+	errCh     chan error
+
+	closeOnce sync.Once
+	donec     chan struct{}
+}
+
+// listenAll listens on all addresses. The returned listener accepts connections from any of them.
+func listenAll(addrs []string) (net.Listener, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("empty list of listen addresses")
+	}
+	if len(addrs) == 1 {
+		return net.Listen("tcp", addrs[0])
+	}
+	ml := &multiListener{
+		connCh: make(chan net.Conn),
+		errCh:  make(chan error, len(addrs)),
+		donec:  make(chan struct{}),
+	}
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			ml.Close()
+			return nil, err
+		}
+		ml.listeners = append(ml.listeners, l)
+	}
+	for _, l := range ml.listeners {
+		go ml.accept(l)
+	}
+	return ml, nil
+}
+
+// accept forwards connections from l to Accept.
+func (ml *multiListener) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			ml.errCh <- err
+			return
+		}
+		select {
+		case ml.connCh <- conn:
+		case <-ml.donec:
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Accept implements net.Listener.
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-ml.connCh:
+		return conn, nil
+	case err := <-ml.errCh:
+		return nil, err
+	case <-ml.donec:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener.
+func (ml *multiListener) Close() error {
+	var err error
+	ml.closeOnce.Do(func() {
+		close(ml.donec)
+		for _, l := range ml.listeners {
+			if closeErr := l.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Addr implements net.Listener. It returns the addresses of all listeners.
+func (ml *multiListener) Addr() net.Addr {
+	addrs := make(multiAddr, 0, len(ml.listeners))
+	for _, l := range ml.listeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// multiAddr is the address of a multiListener.
+type multiAddr []net.Addr
+
+// Network implements net.Addr.
+func (a multiAddr) Network() string {
+	return "tcp"
+}
+
+// String implements net.Addr.
+func (a multiAddr) String() string {
+	addrs := make([]string, 0, len(a))
+	for _, addr := range a {
+		addrs = append(addrs, addr.String())
+	}
+	return strings.Join(addrs, ",")
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	. "github.com/onsi/gomega"
+)
+
+func TestMultipleListeners(t *testing.T) {
+	g := NewWithT(t)
+	backend := startBackend(t, "backend")
+
+	tp, err := newProxy([]string{"127.0.0.1:0", "127.0.0.1:0"}, []string{net.JoinHostPort(backend.Target, fmt.Sprint(backend.Port))}, proxyOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	go tp.Run()
+
+	addrs := strings.Split(tp.Listener.Addr().String(), ",")
+	g.Expect(addrs).To(HaveLen(2))
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		g.Expect(request(conn, bufio.NewReader(conn))).To(Equal("backend\n"))
+	}
+
+	tp.Stop()
+	for _, addr := range addrs {
+		_, err := net.Dial("tcp", addr)
+		g.Expect(err).To(HaveOccurred())
+	}
+}
+
+func TestParseEndpointURLs(t *testing.T) {
+	g := NewWithT(t)
+	srvs, err := parseEndpointURLs([]string{"10.0.0.1:16443", "https://10.0.0.2:16443"}, map[string]internal.Backend{
+		"10.0.0.1:16443": {Address: "10.0.0.1:16443", Priority: 1, Weight: 10},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(srvs).To(Equal([]*net.SRV{
+		{Target: "10.0.0.1", Port: 16443, Priority: 1, Weight: 10},
+		{Target: "10.0.0.2", Port: 16443},
+	}))
+
+	_, err = parseEndpointURLs([]string{"10.0.0.1"}, nil)
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	proxyInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "info",
+		Help:      "Information about each service of the apiserver proxy. The value is always 1.",
+	}, []string{"service", "strategy"})
+
+	proxyConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "connections_total",
+		Help:      "Number of connections proxied to each control plane endpoint.",
+	}, []string{"endpoint", "strategy"})
+
+	proxyActiveConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "active_connections",
+		Help:      "Number of open connections to each control plane endpoint.",
+	}, []string{"endpoint"})
+
+	proxyBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "bytes_total",
+		Help:      "Number of bytes transferred to (sent) and from (received) each control plane endpoint.",
+	}, []string{"endpoint", "direction"})
+
+	proxyRejectedConnections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "rejected_connections_total",
+		Help:      "Number of client connections rejected because the maximum number of connections was reached.",
+	})
+
+	proxyRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "request_retries_total",
+		Help:      "Number of requests retried after a connection failure in the TLS-terminating mode.",
+	})
+
+	proxyDialFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "dial_failures_total",
+		Help:      "Number of failed connection attempts to each control plane endpoint.",
+	}, []string{"endpoint"})
+
+	proxyDeactivations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "deactivations_total",
+		Help:      "Number of times each control plane endpoint was deactivated, by reason.",
+	}, []string{"endpoint", "reason"})
+
+	proxyEndpointUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "endpoint_up",
+		Help:      "Whether each known control plane endpoint is active (1) or inactive (0).",
+	}, []string{"endpoint"})
+
+	proxyRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "endpoint_refreshes_total",
+		Help:      "Number of attempts to retrieve the list of control plane endpoints from the cluster, by result.",
+	}, []string{"result"})
+
+	proxyLastRefresh = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "microk8s_apiserver_proxy",
+		Name:      "last_endpoint_refresh_timestamp_seconds",
+		Help:      "Time of the last attempt to retrieve the list of control plane endpoints from the cluster, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		proxyInfo,
+		proxyConnections,
+		proxyActiveConnections,
+		proxyBytes,
+		proxyRejectedConnections,
+		proxyRetries,
+		proxyDialFailures,
+		proxyDeactivations,
+		proxyEndpointUp,
+		proxyRefreshes,
+		proxyLastRefresh,
+	)
+}
+
+// setEndpointUp updates the endpoint_up metric of an endpoint.
+func setEndpointUp(addr string, up bool) {
+	if up {
+		proxyEndpointUp.WithLabelValues(addr).Set(1)
+	} else {
+		proxyEndpointUp.WithLabelValues(addr).Set(0)
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w       io.Writer
+	counter prometheus.Counter
+}
+
+// Write implements io.Writer.
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.counter.Add(float64(n))
+	return n, err
+}
@@ -8,11 +8,35 @@ import (
 	"net/url"
 	"strconv"
 	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
 )
 
-func startProxy(ctx context.Context, listenURL string, endpointURLs []string) error {
+// proxyOptions configures the proxy created by newProxy.
+type proxyOptions struct {
+	// name is the name of the proxied service. If empty, "kube-apiserver" is used.
+	name string
+	// healthCheck configures active health checks. If nil, active health checks are disabled.
+	healthCheck *healthCheck
+	// drainTimeout is how long existing connections to removed endpoints are kept open.
+	drainTimeout time.Duration
+	// strategy is the load-balancing strategy.
+	strategy Strategy
+	// locality identifies local endpoints for StrategyLocality.
+	locality *locality
+	// backends are the endpoints with a non-default priority or weight, by address.
+	backends map[string]internal.Backend
+	// connections configures the handling of proxied connections.
+	connections ConnectionConfig
+	// l7 enables the TLS-terminating mode. If nil, TCP connections are forwarded.
+	l7 *l7proxy
+}
+
+// parseEndpointURLs parses a list of endpoint URLs or host:port addresses. The priority and weight of endpoints are
+// looked up in backends.
+func parseEndpointURLs(endpointURLs []string, backends map[string]internal.Backend) ([]*net.SRV, error) {
 	if len(endpointURLs) == 0 {
-		return fmt.Errorf("empty list of endpoints")
+		return nil, fmt.Errorf("empty list of endpoints")
 	}
 	srvs := make([]*net.SRV, len(endpointURLs))
 	for i, endpoint := range endpointURLs {
@@ -21,35 +45,73 @@ func startProxy(ctx context.Context, listenURL string, endpointURLs []string) er
 		}
 		host, port, err := net.SplitHostPort(endpoint)
 		if err != nil {
-			return fmt.Errorf("failed to parse endpoint %q: %w", endpoint, err)
+			return nil, fmt.Errorf("failed to parse endpoint %q: %w", endpoint, err)
 		}
 		portNumber, err := strconv.ParseUint(port, 10, 16)
 		if err != nil {
-			return fmt.Errorf("failed to parse port %q: %w", port, err)
+			return nil, fmt.Errorf("failed to parse port %q: %w", port, err)
 		}
 		srvs[i] = &net.SRV{Target: host, Port: uint16(portNumber)}
+		if backend, ok := backends[endpointURLs[i]]; ok {
+			srvs[i].Priority = backend.Priority
+			srvs[i].Weight = backend.Weight
+		}
 	}
+	return srvs, nil
+}
 
-	l, err := net.Listen("tcp", listenURL)
+// newProxy creates a proxy listening on listenURLs. The proxy does not accept connections until runProxy is called.
+// If endpointURLs is empty, client connections are closed until endpoints are received by runProxy.
+func newProxy(listenURLs []string, endpointURLs []string, opts proxyOptions) (*tcpproxy, error) {
+	var srvs []*net.SRV
+	if len(endpointURLs) > 0 {
+		var err error
+		if srvs, err = parseEndpointURLs(endpointURLs, opts.backends); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := listenAll(listenURLs)
 	if err != nil {
-		return fmt.Errorf("failed to start listener: %w", err)
+		return nil, fmt.Errorf("failed to start listener: %w", err)
 	}
 
-	p := tcpproxy{
+	return &tcpproxy{
+		Name:            opts.name,
 		Listener:        l,
 		Endpoints:       srvs,
 		MonitorInterval: time.Minute,
-	}
+		HealthCheck:     opts.healthCheck,
+		DrainTimeout:    opts.drainTimeout,
+		Strategy:        opts.strategy,
+		Locality:        opts.locality,
+		Connections:     opts.connections,
+		L7:              opts.l7,
+	}, nil
+}
 
-	log.Println("Starting proxy at", listenURL)
+// runProxy runs a proxy until the context is cancelled. Lists of endpoints received from updateCh replace the
+// endpoints of the proxy without closing the listener. The priority and weight of endpoints are looked up in backends.
+func runProxy(ctx context.Context, p *tcpproxy, updateCh <-chan []string, backends map[string]internal.Backend) {
+	log.Println("Starting proxy at", p.Listener.Addr())
 	go func() {
 		if err := p.Run(); err != nil {
 			log.Printf("proxy failed: %v\n", err)
 		}
 	}()
 
-	<-ctx.Done()
-	p.Stop()
-
-	return nil
+	for {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+			return
+		case endpointURLs := <-updateCh:
+			srvs, err := parseEndpointURLs(endpointURLs, backends)
+			if err != nil {
+				log.Printf("WARNING: ignoring invalid list of endpoints: %q\n", err)
+				continue
+			}
+			p.SetEndpoints(srvs)
+		}
+	}
 }
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+)
+
+// defaultServiceRefreshInterval is the interval for polling the endpoints of a service while they cannot be watched.
+const defaultServiceRefreshInterval = 30 * time.Second
+
+// runService runs the local proxy of an additional control plane service until the context is cancelled. Services
+// use the round-robin load-balancing strategy, with the priorities and weights of their backends.
+func (p *APIServerProxy) runService(ctx context.Context, svc internal.ServiceConfiguration) {
+	listeners := make([]string, 0, len(svc.Listeners))
+	for _, listener := range svc.Listeners {
+		listeners = append(listeners, listener.Address)
+	}
+	var endpoints []string
+	backends := make(map[string]internal.Backend)
+	if svc.Discovery == nil {
+		for _, backend := range svc.Backends {
+			endpoints = append(endpoints, backend.Address)
+			backends[backend.Address] = backend
+		}
+	}
+
+	opts := proxyOptions{
+		name:         svc.Name,
+		healthCheck:  newServiceHealthCheck(svc.HealthCheck),
+		drainTimeout: p.DrainTimeout,
+		strategy:     StrategyRoundRobin,
+		backends:     backends,
+		connections:  withConnectionOverrides(p.Connections, svc.Timeouts, svc.MaxConnections),
+	}
+	if svc.Timeouts.Drain > 0 {
+		opts.drainTimeout = svc.Timeouts.Drain
+	}
+	tp, err := newProxy(listeners, endpoints, opts)
+	if err != nil {
+		log.Printf("WARNING: failed to start proxy for service %s: %q", svc.Name, err)
+		return
+	}
+	p.setServiceProxy(svc.Name, tp)
+	defer p.setServiceProxy(svc.Name, nil)
+
+	updateCh := make(chan []string)
+	if svc.Discovery != nil {
+		go p.watchServiceEndpoints(ctx, svc, updateCh)
+	}
+	runProxy(ctx, tp, updateCh, backends)
+}
+
+// watchServiceEndpoints watches the EndpointSlices of the Kubernetes Service of a service, and sends changes to
+// updateCh.
+func (p *APIServerProxy) watchServiceEndpoints(ctx context.Context, svc internal.ServiceConfiguration, updateCh chan<- []string) {
+	refreshInterval := svc.Discovery.RefreshInterval
+	if refreshInterval <= 0 {
+		refreshInterval = defaultServiceRefreshInterval
+	}
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	newWatcher := func() (*endpointWatcher, error) {
+		clientset, err := p.newClientset()
+		if err != nil {
+			return nil, err
+		}
+		namespace := svc.Discovery.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		return &endpointWatcher{clientset: clientset, namespace: namespace, service: svc.Discovery.Service, portName: svc.Discovery.Port}, nil
+	}
+	var current []string
+	watchEndpoints(ctx, newWatcher, ticker.C, func(endpoints []string, err error) bool {
+		switch {
+		case err != nil:
+			log.Println(fmt.Errorf("failed to retrieve endpoints of service %s: %w", svc.Name, err))
+			return true
+		case len(endpoints) == 0:
+			log.Printf("warning: empty list of endpoints for service %s, skipping update", svc.Name)
+			return true
+		case reflect.DeepEqual(endpoints, current):
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case updateCh <- endpoints:
+			current = endpoints
+			return true
+		}
+	})
+}
+
+// setServiceProxy records the running proxy of a service, for the status page.
+func (p *APIServerProxy) setServiceProxy(name string, tp *tcpproxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if tp == nil {
+		delete(p.services, name)
+		return
+	}
+	if p.services == nil {
+		p.services = make(map[string]*tcpproxy)
+	}
+	p.services[name] = tp
+}
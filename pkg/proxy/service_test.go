@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	. "github.com/onsi/gomega"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestRunService(t *testing.T) {
+	g := NewWithT(t)
+	backend := startBackend(t, "cluster-agent")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := &APIServerProxy{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.runService(ctx, internal.ServiceConfiguration{
+			Name:        "cluster-agent",
+			Listeners:   []internal.Listener{{Address: "127.0.0.1:0"}},
+			Backends:    []internal.Backend{{Address: net.JoinHostPort(backend.Target, fmt.Sprint(backend.Port))}},
+			HealthCheck: &internal.HealthCheck{Interval: time.Second},
+		})
+	}()
+
+	g.Eventually(func() []ServiceStatus { return p.Status().Services }, time.Second, 10*time.Millisecond).Should(HaveLen(1))
+	status := p.Status().Services[0]
+	g.Expect(status.Name).To(Equal("cluster-agent"))
+	g.Expect(status.Endpoints).To(HaveLen(1))
+
+	conn, err := net.Dial("tcp", status.Listen)
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+	g.Expect(request(conn, bufio.NewReader(conn))).To(Equal("cluster-agent\n"))
+
+	cancel()
+	g.Eventually(done, time.Second).Should(BeClosed())
+	g.Expect(p.Status().Services).To(BeEmpty())
+}
+
+func TestServiceHealthCheck(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(newServiceHealthCheck(nil)).To(BeNil())
+	g.Expect(newServiceHealthCheck(&internal.HealthCheck{})).To(BeNil())
+	g.Expect(newServiceHealthCheck(&internal.HealthCheck{Interval: time.Second, Disabled: true})).To(BeNil())
+
+	hc := newServiceHealthCheck(&internal.HealthCheck{Interval: time.Second, Fall: 1})
+	g.Expect(hc.HealthCheckConfig).To(Equal(HealthCheckConfig{Interval: time.Second, Timeout: 5 * time.Second, Rise: 2, Fall: 1}))
+
+	backend := startBackend(t, "backend")
+	g.Expect(hc.check(context.Background(), net.JoinHostPort(backend.Target, fmt.Sprint(backend.Port)))).To(Succeed())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	l.Close()
+	g.Expect(hc.check(context.Background(), l.Addr().String())).ToNot(Succeed())
+}
+
+func TestServiceEndpointWatcher(t *testing.T) {
+	g := NewWithT(t)
+	clientset := fake.NewSimpleClientset(&discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "registry-abcde",
+			Namespace: "container-registry",
+			Labels:    map[string]string{"kubernetes.io/service-name": "registry"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{"10.1.0.5"}}},
+		Ports: []discoveryv1.EndpointPort{
+			{Name: ptr.To("metrics"), Port: ptr.To(int32(5001))},
+			{Name: ptr.To("registry"), Port: ptr.To(int32(5000))},
+		},
+	})
+
+	w := &endpointWatcher{clientset: clientset, namespace: "container-registry", service: "registry", portName: "registry"}
+	endpoints, watcher, err := w.listAndWatch(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer watcher.Stop()
+	g.Expect(endpoints).To(Equal([]string{"10.1.0.5:5000"}))
+
+	w = &endpointWatcher{clientset: clientset, namespace: "container-registry", service: "registry"}
+	endpoints, watcher, err = w.listAndWatch(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer watcher.Stop()
+	g.Expect(endpoints).To(Equal([]string{"10.1.0.5:5001"}))
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/httputil"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Status is the status of the apiserver proxy, as served by the "/status" endpoint of the metrics listener.
+type Status struct {
+	// Listen is the address the proxy listens on. It is empty if the proxy is not running.
+	Listen string `json:"listen,omitempty"`
+	// Strategy is the load-balancing strategy of the running proxy, or the configured strategy if it is not running.
+	Strategy Strategy `json:"strategy"`
+	// HealthChecks is true if active health checks are enabled in the running proxy.
+	HealthChecks bool `json:"health_checks"`
+	// TLSTermination is true if the proxy terminates TLS connections and forwards HTTP requests.
+	TLSTermination bool `json:"tls_termination"`
+	// Endpoints are the control plane endpoints of the proxy.
+	Endpoints []EndpointStatus `json:"endpoints"`
+	// LastRefresh is the outcome of the last attempt to retrieve the list of control plane endpoints from the cluster.
+	LastRefresh *RefreshStatus `json:"last_refresh,omitempty"`
+	// Services are the additional control plane services proxied on this node, sorted by name.
+	Services []ServiceStatus `json:"services,omitempty"`
+}
+
+// ServiceStatus is the status of an additional control plane service.
+type ServiceStatus struct {
+	// Name is the name of the service.
+	Name string `json:"name"`
+	// Listen is the address the service listens on.
+	Listen string `json:"listen"`
+	// Endpoints are the backends of the service.
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// EndpointStatus is the status of a control plane endpoint.
+type EndpointStatus struct {
+	// Address is the address of the endpoint.
+	Address string `json:"address"`
+	// Active is false if the endpoint is not used for new connections, because of failed connection attempts or health checks.
+	Active bool `json:"active"`
+	// Local is true if the endpoint is in the same subnet or zone as this node.
+	Local bool `json:"local,omitempty"`
+	// Connections is the number of open connections to the endpoint.
+	Connections int `json:"connections"`
+	// HealthCheckRTT is the moving average of the health check round-trip times of the endpoint.
+	HealthCheckRTT string `json:"health_check_rtt,omitempty"`
+}
+
+// RefreshStatus is the outcome of an attempt to retrieve the list of control plane endpoints from the cluster.
+type RefreshStatus struct {
+	// Time is when the list of endpoints was retrieved.
+	Time time.Time `json:"time"`
+	// Endpoints is the list of retrieved endpoints.
+	Endpoints []string `json:"endpoints,omitempty"`
+	// Error is the reason the list of endpoints could not be retrieved, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// status returns the status of the endpoints of the proxy.
+func (tp *tcpproxy) status() []EndpointStatus {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	endpoints := make([]EndpointStatus, 0, len(tp.remotes))
+	for _, r := range tp.remotes {
+		status := EndpointStatus{
+			Address:     r.addr,
+			Active:      r.isActive(),
+			Local:       r.local,
+			Connections: r.numConns(),
+		}
+		if rtt := r.getRTT(); rtt > 0 {
+			status.HealthCheckRTT = rtt.String()
+		}
+		endpoints = append(endpoints, status)
+	}
+	return endpoints
+}
+
+// Status returns the status of the apiserver proxy.
+func (p *APIServerProxy) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := Status{
+		Strategy:    p.Strategy,
+		LastRefresh: p.lastRefresh,
+		Endpoints:   []EndpointStatus{},
+	}
+	if status.Strategy == "" {
+		status.Strategy = StrategyRoundRobin
+	}
+	if p.proxy != nil {
+		// the running proxy may differ from the command line, e.g. if health checks are disabled in the config file
+		status.Strategy = p.proxy.strategy()
+		status.HealthChecks = p.proxy.HealthCheck != nil
+		status.Listen = p.proxy.Listener.Addr().String()
+		status.TLSTermination = p.proxy.L7 != nil
+		status.Endpoints = p.proxy.status()
+	}
+	for name, tp := range p.services {
+		status.Services = append(status.Services, ServiceStatus{Name: name, Listen: tp.Listener.Addr().String(), Endpoints: tp.status()})
+	}
+	sort.Slice(status.Services, func(i, j int) bool { return status.Services[i].Name < status.Services[j].Name })
+	return status
+}
+
+// recordRefresh records the outcome of an attempt to retrieve the list of control plane endpoints from the cluster.
+func (p *APIServerProxy) recordRefresh(endpoints []string, err error) {
+	refresh := &RefreshStatus{Time: time.Now(), Endpoints: endpoints}
+	result := "success"
+	if err != nil {
+		refresh.Error = err.Error()
+		result = "failure"
+	}
+	proxyRefreshes.WithLabelValues(result).Inc()
+	proxyLastRefresh.WithLabelValues(result).Set(float64(refresh.Time.Unix()))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastRefresh = refresh
+}
+
+// setProxy records the running proxy, for the status page.
+func (p *APIServerProxy) setProxy(tp *tcpproxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.proxy = tp
+}
+
+// serveMetrics serves Prometheus metrics on "/metrics" and the proxy status on "/status" until the context is cancelled.
+func (p *APIServerProxy) serveMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		httputil.Response(w, p.Status())
+	})
+	srv := &http.Server{Addr: p.MetricsAddress, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	log.Printf("Serving metrics and status on http://%s\n", p.MetricsAddress)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("WARNING: metrics listener failed: %q", err)
+	}
+}
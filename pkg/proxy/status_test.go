@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	internal "github.com/canonical/microk8s-cluster-agent/pkg/proxy/internal"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gather returns the values of the counters and gauges of a metric. The values are keyed by the label values, sorted by
+// label name.
+func gather(g Gomega, name string) map[string]float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	g.Expect(err).ToNot(HaveOccurred())
+	values := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			var key []string
+			for _, label := range m.GetLabel() {
+				key = append(key, label.GetValue())
+			}
+			if m.GetGauge() != nil {
+				values[strings.Join(key, ",")] = m.GetGauge().GetValue()
+			} else {
+				values[strings.Join(key, ",")] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+func TestStatus(t *testing.T) {
+	g := NewWithT(t)
+	backend := startBackend(t, "backend")
+	backendAddr := net.JoinHostPort(backend.Target, fmt.Sprint(backend.Port))
+
+	p := &APIServerProxy{Strategy: StrategyLeastConnections}
+	g.Expect(p.Status()).To(Equal(Status{Strategy: StrategyLeastConnections, Endpoints: []EndpointStatus{}}))
+
+	tp, err := newProxy([]string{"127.0.0.1:0"}, []string{backendAddr}, proxyOptions{strategy: StrategyLeastConnections})
+	g.Expect(err).ToNot(HaveOccurred())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p.setProxy(tp)
+	go runProxy(ctx, tp, nil, nil)
+
+	conn, err := net.Dial("tcp", tp.Listener.Addr().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+	g.Expect(request(conn, bufio.NewReader(conn))).To(Equal("backend\n"))
+
+	failures := gather(g, "microk8s_apiserver_proxy_endpoint_refreshes_total")["failure"]
+	p.recordRefresh(nil, fmt.Errorf("connection refused"))
+
+	status := p.Status()
+	g.Expect(status.Listen).To(Equal(tp.Listener.Addr().String()))
+	g.Expect(status.Endpoints).To(ConsistOf(EndpointStatus{Address: backendAddr, Active: true, Connections: 1}))
+	g.Expect(status.LastRefresh).ToNot(BeNil())
+	g.Expect(status.LastRefresh.Error).To(Equal("connection refused"))
+
+	g.Expect(gather(g, "microk8s_apiserver_proxy_active_connections")).To(HaveKeyWithValue(backendAddr, 1.0))
+	g.Expect(gather(g, "microk8s_apiserver_proxy_endpoint_up")).To(HaveKeyWithValue(backendAddr, 1.0))
+	g.Expect(gather(g, "microk8s_apiserver_proxy_bytes_total")).To(And(
+		HaveKeyWithValue("sent,"+backendAddr, float64(len("ping\n"))),
+		HaveKeyWithValue("received,"+backendAddr, float64(len("backend\n"))),
+	))
+	g.Expect(gather(g, "microk8s_apiserver_proxy_endpoint_refreshes_total")).To(HaveKeyWithValue("failure", failures+1))
+
+	conn.Close()
+	g.Eventually(func() float64 {
+		return gather(g, "microk8s_apiserver_proxy_active_connections")[backendAddr]
+	}, time.Second, 10*time.Millisecond).Should(BeZero())
+}
+
+func TestStatusRunningProxy(t *testing.T) {
+	g := NewWithT(t)
+	backend := startBackend(t, "backend")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// health checks are disabled in the config file, so the latency strategy falls back to round robin
+	p := &APIServerProxy{Strategy: StrategyLatency, HealthCheck: HealthCheckConfig{Interval: time.Second, Timeout: time.Second, Rise: 1, Fall: 1}}
+	g.Expect(p.Status().Strategy).To(Equal(StrategyLatency))
+	cfg := &internal.Configuration{Listeners: []string{"127.0.0.1:0"}, HealthCheck: &internal.HealthCheck{Disabled: true}}
+	go p.startProxy(ctx, cancel, cfg, []string{net.JoinHostPort(backend.Target, fmt.Sprint(backend.Port))}, nil)
+
+	g.Eventually(func() string { return p.Status().Listen }, 2*time.Second, 10*time.Millisecond).ShouldNot(BeEmpty())
+	status := p.Status()
+	g.Expect(status.Strategy).To(Equal(StrategyRoundRobin))
+	g.Expect(status.HealthChecks).To(BeFalse())
+}
+
+func TestServeMetrics(t *testing.T) {
+	g := NewWithT(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := &APIServerProxy{MetricsAddress: addr}
+	go p.serveMetrics(ctx)
+
+	var resp *http.Response
+	g.Eventually(func() error {
+		resp, err = http.Get(fmt.Sprintf("http://%s/status", addr))
+		return err
+	}, 2*time.Second, 50*time.Millisecond).Should(Succeed())
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	g.Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+	var status Status
+	g.Expect(json.NewDecoder(resp.Body).Decode(&status)).To(Succeed())
+	g.Expect(status.Strategy).To(Equal(StrategyRoundRobin))
+
+	resp, err = http.Get(fmt.Sprintf("http://%s/metrics", addr))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+}
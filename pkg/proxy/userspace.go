@@ -20,13 +20,17 @@
 package proxy
 
 import (
-	"fmt"
-	"io"
+	"context"
+	"errors"
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type remote struct {
@@ -34,16 +38,42 @@ type remote struct {
 	srv      *net.SRV
 	addr     string
 	inactive bool
+
+	// rises and falls are the number of consecutive successful and failed health checks.
+	rises int
+	falls int
+	// rtt is the moving average of the health check round-trip times.
+	rtt time.Duration
+	// local is true if the remote is in the same subnet or zone as this node. It is guarded by tcpproxy.mu.
+	local bool
+
+	// conns are the client connections proxied to the remote.
+	conns map[net.Conn]struct{}
+	// removedc is closed when the remote is removed from the proxy.
+	removedc chan struct{}
 }
 
+func newRemote(srv *net.SRV) *remote {
+	return &remote{
+		srv:      srv,
+		addr:     net.JoinHostPort(srv.Target, strconv.Itoa(int(srv.Port))),
+		conns:    make(map[net.Conn]struct{}),
+		removedc: make(chan struct{}),
+	}
+}
+
+// inactivate ejects the remote, e.g. after a failed dial. The health check counters are reset, so that the remote is
+// only added back after rise consecutive successful health checks.
 func (r *remote) inactivate() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.inactive = true
+	r.rises = 0
+	r.falls = 0
 }
 
-func (r *remote) tryReactivate() error {
-	conn, err := net.Dial("tcp", r.addr)
+func (r *remote) tryReactivate(timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", r.addr, timeout)
 	if err != nil {
 		return err
 	}
@@ -60,46 +90,224 @@ func (r *remote) isActive() bool {
 	return !r.inactive
 }
 
+// track records a client connection proxied to the remote. The returned function must be called once the connection
+// is closed. track returns false if the remote was removed, e.g. by SetEndpoints while the connection was dialed,
+// in which case the connection would never be drained and must not be used.
+func (r *remote) track(in net.Conn) (func(), bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.removedc:
+		return nil, false
+	default:
+	}
+	r.conns[in] = struct{}{}
+	proxyActiveConnections.WithLabelValues(r.addr).Inc()
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.conns, in)
+		proxyActiveConnections.WithLabelValues(r.addr).Dec()
+	}, true
+}
+
+// numConns returns the number of client connections proxied to the remote.
+func (r *remote) numConns() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+// drain waits for the client connections proxied to a removed or stopped remote to be closed. Connections that are
+// still open after timeout are closed.
+func (r *remote) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for r.numConns() > 0 && time.Now().Before(deadline) {
+		time.Sleep(min(100*time.Millisecond, time.Until(deadline)))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.conns) > 0 {
+		log.Printf("closing %d connections to endpoint %v after timeout %v\n", len(r.conns), r.addr, timeout)
+	}
+	for conn := range r.conns {
+		conn.Close()
+	}
+}
+
+// recordHealthCheck records the result of a health check. An active remote is ejected after fall consecutive failed
+// health checks, and an inactive remote is added back after rise consecutive successful health checks.
+// recordHealthCheck returns true if the remote was ejected or added back.
+func (r *remote) recordHealthCheck(err error, rise int, fall int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.rises = 0
+		r.falls++
+	} else {
+		r.falls = 0
+		r.rises++
+	}
+	switch {
+	case !r.inactive && r.falls >= fall:
+		r.inactive = true
+		return true
+	case r.inactive && r.rises >= rise:
+		r.inactive = false
+		return true
+	}
+	return false
+}
+
 type tcpproxy struct {
+	// Name is the name of the proxied service, used in logs and metrics. If empty, "kube-apiserver" is used.
+	Name            string
 	Listener        net.Listener
 	Endpoints       []*net.SRV
 	MonitorInterval time.Duration
+	// HealthCheck configures active health checks. If nil, inactive remotes are reactivated after MonitorInterval
+	// if they accept TCP connections.
+	HealthCheck *healthCheck
+	// DrainTimeout is how long existing connections to endpoints removed by SetEndpoints are kept open.
+	DrainTimeout time.Duration
+	// Strategy is the load-balancing strategy. If empty, StrategyRoundRobin is used.
+	Strategy Strategy
+	// Locality identifies local endpoints for StrategyLocality.
+	Locality *locality
+	// Connections configures the handling of proxied connections.
+	Connections ConnectionConfig
+	// L7 enables the TLS-terminating mode. If nil, TCP connections are forwarded. Connections.MaxConnections and
+	// Connections.HalfCloseTimeout do not apply to the TLS-terminating mode.
+	L7 *l7proxy
 
-	donec chan struct{}
+	initOnce sync.Once
+	donec    chan struct{}
+	// connSem limits the number of concurrent client connections, if Connections.MaxConnections is set.
+	connSem chan struct{}
 
 	mu        sync.Mutex // guards the following fields
 	remotes   []*remote
 	pickCount int // for round robin
+	// server is the HTTP server of the TLS-terminating mode, once started.
+	server *http.Server
 }
 
-func (tp *tcpproxy) Run() error {
+// init initializes the remotes of the proxy. It is called once, before the proxy is used.
+func (tp *tcpproxy) init() {
 	tp.donec = make(chan struct{})
+	if tp.Connections.MaxConnections > 0 {
+		tp.connSem = make(chan struct{}, tp.Connections.MaxConnections)
+	}
 	if tp.MonitorInterval == 0 {
 		tp.MonitorInterval = 5 * time.Minute
 	}
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
 	for _, srv := range tp.Endpoints {
-		addr := fmt.Sprintf("%s:%d", srv.Target, srv.Port)
-		tp.remotes = append(tp.remotes, &remote{srv: srv, addr: addr})
+		r := newRemote(srv)
+		r.local = tp.Locality.isLocal(r.addr)
+		setEndpointUp(r.addr, true)
+		tp.remotes = append(tp.remotes, r)
+		if tp.HealthCheck != nil {
+			go tp.runHealthCheck(r)
+		}
 	}
+}
 
+func (tp *tcpproxy) Run() error {
+	tp.initOnce.Do(tp.init)
+
+	tp.mu.Lock()
 	eps := []string{}
-	for _, ep := range tp.Endpoints {
-		eps = append(eps, fmt.Sprintf("%s:%d", ep.Target, ep.Port))
+	for _, r := range tp.remotes {
+		eps = append(eps, r.addr)
 	}
-	log.Printf("ready to proxy client requests to %v\n", eps)
+	tp.mu.Unlock()
+	log.Printf("ready to proxy client requests for %s to %v using load-balancing strategy %s\n", tp.name(), eps, tp.strategy())
+	proxyInfo.DeletePartialMatch(prometheus.Labels{"service": tp.name()})
+	proxyInfo.WithLabelValues(tp.name(), string(tp.strategy())).Set(1)
 
-	go tp.runMonitor()
+	if tp.HealthCheck == nil {
+		go tp.runMonitor()
+	}
+	if tp.L7 != nil {
+		return tp.serveL7()
+	}
 	for {
 		in, err := tp.Listener.Accept()
 		if err != nil {
 			return err
 		}
 
-		go tp.serve(in)
+		if !tp.acquire() {
+			proxyRejectedConnections.Inc()
+			log.Printf("rejected connection from %v, reached maximum of %d connections\n", in.RemoteAddr(), tp.Connections.MaxConnections)
+			in.Close()
+			continue
+		}
+		go func() {
+			defer tp.release()
+			tp.serve(in)
+		}()
+	}
+}
+
+// acquire reserves a slot for a new client connection. It returns false if the maximum number of concurrent
+// connections is reached.
+func (tp *tcpproxy) acquire() bool {
+	if tp.connSem == nil {
+		return true
+	}
+	select {
+	case tp.connSem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees the slot of a closed client connection.
+func (tp *tcpproxy) release() {
+	if tp.connSem != nil {
+		<-tp.connSem
+	}
+}
+
+// name returns the name of the proxied service.
+func (tp *tcpproxy) name() string {
+	if tp.Name == "" {
+		return "kube-apiserver"
+	}
+	return tp.Name
+}
+
+// strategy returns the load-balancing strategy of the proxy.
+func (tp *tcpproxy) strategy() Strategy {
+	if tp.Strategy == "" {
+		return StrategyRoundRobin
 	}
+	return tp.Strategy
 }
 
+// pick returns an active remote for a new connection, or nil if no remote is active. tp.mu must be held.
 func (tp *tcpproxy) pick() *remote {
+	switch tp.strategy() {
+	case StrategyLeastConnections:
+		return pickLeastConnections(tp.activeRemotes())
+	case StrategyPowerOfTwoChoices:
+		return pickPowerOfTwoChoices(tp.activeRemotes())
+	case StrategyLatency:
+		return pickLatency(tp.activeRemotes())
+	case StrategyLocality:
+		return tp.pickLocality()
+	default:
+		return tp.pickRoundRobin()
+	}
+}
+
+// pickRoundRobin picks a remote by SRV priority and weight.
+func (tp *tcpproxy) pickRoundRobin() *remote {
 	var weighted []*remote
 	var unweighted []*remote
 
@@ -157,41 +365,60 @@ func (tp *tcpproxy) pick() *remote {
 }
 
 func (tp *tcpproxy) serve(in net.Conn) {
+	tp.Connections.setKeepAlive(in)
+
 	var (
-		err error
-		out net.Conn
+		remote  *remote
+		out     net.Conn
+		untrack func()
 	)
+	for {
+		var err error
+		remote, out, err = tp.dialRemote()
+		if err != nil {
+			in.Close()
+			return
+		}
+		var ok bool
+		if untrack, ok = remote.track(in); ok {
+			break
+		}
+		// the remote was removed while dialing, pick another one
+		out.Close()
+	}
+	defer untrack()
+	proxyConnections.WithLabelValues(remote.addr, string(tp.strategy())).Inc()
+
+	tp.Connections.pipe(in, out, proxyBytes.WithLabelValues(remote.addr, "sent"), proxyBytes.WithLabelValues(remote.addr, "received"))
+}
+
+// errNoEndpoints is returned by dialRemote if no remote is active.
+var errNoEndpoints = errors.New("no active endpoints")
 
+// dialRemote connects to an active remote picked by the load-balancing strategy. Remotes that cannot be reached are
+// deactivated, and the next remote is tried.
+func (tp *tcpproxy) dialRemote() (*remote, net.Conn, error) {
 	for {
 		tp.mu.Lock()
 		remote := tp.pick()
 		tp.mu.Unlock()
 		if remote == nil {
-			break
+			return nil, nil, errNoEndpoints
 		}
-		// TODO: add timeout
-		out, err = net.Dial("tcp", remote.addr)
+		out, err := tp.Connections.dial(remote.addr)
 		if err == nil {
-			break
+			return remote, out, nil
 		}
 		remote.inactivate()
-		log.Printf("deactivated endpoint %v for interval %v, error was %q", remote.addr, tp.MonitorInterval, err)
-	}
-
-	if out == nil {
-		in.Close()
-		return
+		proxyDialFailures.WithLabelValues(remote.addr).Inc()
+		proxyDeactivations.WithLabelValues(remote.addr, "dial").Inc()
+		setEndpointUp(remote.addr, false)
+		if tp.HealthCheck != nil {
+			log.Printf("deactivated endpoint %v until %d successful health checks, error was %q", remote.addr, tp.HealthCheck.Rise, err)
+		} else {
+			log.Printf("deactivated endpoint %v for interval %v, error was %q", remote.addr, tp.MonitorInterval, err)
+		}
 	}
-
-	go func() {
-		io.Copy(in, out)
-		in.Close()
-		out.Close()
-	}()
-
-	io.Copy(out, in)
-	out.Close()
-	in.Close()
 }
 
 func (tp *tcpproxy) runMonitor() {
@@ -204,9 +431,10 @@ func (tp *tcpproxy) runMonitor() {
 					continue
 				}
 				go func(r *remote) {
-					if err := r.tryReactivate(); err != nil {
+					if err := r.tryReactivate(tp.Connections.DialTimeout); err != nil {
 						log.Printf("failed to activate endpoint %v (stay inactive for another interval %v)\n", r.addr, tp.MonitorInterval)
 					} else {
+						setEndpointUp(r.addr, true)
 						log.Printf("activated endpoint %v\n", r.addr)
 					}
 				}(rem)
@@ -218,9 +446,108 @@ func (tp *tcpproxy) runMonitor() {
 	}
 }
 
+// runHealthCheck periodically checks the health of a remote, and ejects or adds it back based on the results.
+func (tp *tcpproxy) runHealthCheck(r *remote) {
+	ticker := time.NewTicker(tp.HealthCheck.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.removedc:
+			return
+		case <-tp.donec:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), tp.HealthCheck.Timeout)
+		start := time.Now()
+		err := tp.HealthCheck.check(ctx, r.addr)
+		cancel()
+		if err == nil {
+			r.recordRTT(time.Since(start))
+		}
+		if !r.recordHealthCheck(err, tp.HealthCheck.Rise, tp.HealthCheck.Fall) {
+			continue
+		}
+		active := r.isActive()
+		setEndpointUp(r.addr, active)
+		if active {
+			log.Printf("activated endpoint %v after %d successful health checks\n", r.addr, tp.HealthCheck.Rise)
+		} else {
+			proxyDeactivations.WithLabelValues(r.addr, "health-check").Inc()
+			log.Printf("deactivated endpoint %v after %d failed health checks, error was %q", r.addr, tp.HealthCheck.Fall, err)
+		}
+	}
+}
+
+// SetEndpoints atomically replaces the endpoints of the proxy, without closing the listener. The state of endpoints
+// that are kept is preserved. Connections to removed endpoints are drained, and closed after DrainTimeout.
+func (tp *tcpproxy) SetEndpoints(endpoints []*net.SRV) {
+	tp.initOnce.Do(tp.init)
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	existing := make(map[string]*remote, len(tp.remotes))
+	for _, r := range tp.remotes {
+		existing[r.addr] = r
+	}
+	remotes := make([]*remote, 0, len(endpoints))
+	for _, srv := range endpoints {
+		r := newRemote(srv)
+		if old, ok := existing[r.addr]; ok {
+			old.srv = srv
+			old.local = tp.Locality.isLocal(old.addr)
+			remotes = append(remotes, old)
+			delete(existing, r.addr)
+			continue
+		}
+		r.local = tp.Locality.isLocal(r.addr)
+		setEndpointUp(r.addr, true)
+		log.Printf("added endpoint %v\n", r.addr)
+		remotes = append(remotes, r)
+		if tp.HealthCheck != nil {
+			go tp.runHealthCheck(r)
+		}
+	}
+	for _, r := range existing {
+		log.Printf("removed endpoint %v, draining %d connections\n", r.addr, r.numConns())
+		close(r.removedc)
+		proxyEndpointUp.DeleteLabelValues(r.addr)
+		go r.drain(tp.DrainTimeout)
+	}
+	tp.remotes = remotes
+	tp.Endpoints = endpoints
+}
+
+// Stop closes the listener, and waits for active connections to be closed. Connections still open after
+// Connections.ShutdownTimeout are closed.
 func (tp *tcpproxy) Stop() {
-	// graceful shutdown?
-	// shutdown current connections?
+	tp.initOnce.Do(tp.init)
 	tp.Listener.Close()
 	close(tp.donec)
+
+	tp.mu.Lock()
+	remotes := tp.remotes
+	server := tp.server
+	tp.mu.Unlock()
+
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tp.Connections.ShutdownTimeout)
+		server.Shutdown(ctx)
+		cancel()
+		server.Close()
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range remotes {
+		if n := r.numConns(); n > 0 {
+			log.Printf("waiting up to %v for %d connections to endpoint %v to close\n", tp.Connections.ShutdownTimeout, n, r.addr)
+		}
+		wg.Add(1)
+		go func(r *remote) {
+			defer wg.Done()
+			r.drain(tp.Connections.ShutdownTimeout)
+		}(r)
+	}
+	wg.Wait()
 }
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// startBackend starts a TCP server that replies to each line with its name. It returns the SRV record of the server.
+func startBackend(t *testing.T, name string) *net.SRV {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start backend: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					if _, err := conn.Write([]byte(name + "\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return &net.SRV{Target: host, Port: uint16(portNumber)}
+}
+
+// request sends a line over conn and returns the reply.
+func request(conn net.Conn, reader *bufio.Reader) (string, error) {
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		return "", err
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	return reader.ReadString('\n')
+}
+
+func TestSetEndpoints(t *testing.T) {
+	g := NewWithT(t)
+	backend1 := startBackend(t, "backend1")
+	backend2 := startBackend(t, "backend2")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	tp := &tcpproxy{
+		Listener:     l,
+		Endpoints:    []*net.SRV{backend1},
+		DrainTimeout: 500 * time.Millisecond,
+	}
+	go tp.Run()
+	defer tp.Stop()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	g.Expect(request(conn, reader)).To(Equal("backend1\n"))
+
+	tp.SetEndpoints([]*net.SRV{backend2})
+
+	// existing connections are drained
+	g.Expect(request(conn, reader)).To(Equal("backend1\n"))
+
+	// new connections use the new endpoints, and the listener is not restarted
+	newConn, err := net.Dial("tcp", l.Addr().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer newConn.Close()
+	g.Expect(request(newConn, bufio.NewReader(newConn))).To(Equal("backend2\n"))
+
+	// existing connections are closed after the drain timeout
+	g.Eventually(func() error {
+		_, err := request(conn, reader)
+		return err
+	}, 2*time.Second, 100*time.Millisecond).Should(HaveOccurred())
+}
+
+func TestSetEndpointsKeepsState(t *testing.T) {
+	g := NewWithT(t)
+	backend1 := startBackend(t, "backend1")
+	backend2 := startBackend(t, "backend2")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	tp := &tcpproxy{Listener: l, Endpoints: []*net.SRV{backend1}}
+	go tp.Run()
+	defer tp.Stop()
+
+	tp.SetEndpoints([]*net.SRV{backend1})
+	tp.mu.Lock()
+	r := tp.remotes[0]
+	tp.mu.Unlock()
+	r.inactivate()
+
+	tp.SetEndpoints([]*net.SRV{backend1, backend2})
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	g.Expect(tp.remotes).To(HaveLen(2))
+	g.Expect(tp.remotes[0]).To(BeIdenticalTo(r))
+	g.Expect(tp.remotes[0].isActive()).To(BeFalse())
+	g.Expect(tp.remotes[1].isActive()).To(BeTrue())
+}
+
+func TestTrackRemovedRemote(t *testing.T) {
+	g := NewWithT(t)
+	backend1 := startBackend(t, "backend1")
+	backend2 := startBackend(t, "backend2")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+	tp := &tcpproxy{Listener: l, Endpoints: []*net.SRV{backend1}, DrainTimeout: 100 * time.Millisecond}
+	tp.initOnce.Do(tp.init)
+	defer tp.Stop()
+
+	// the remote is removed after it was picked, but before the connection is tracked
+	remote, out, err := tp.dialRemote()
+	g.Expect(err).ToNot(HaveOccurred())
+	defer out.Close()
+	tp.SetEndpoints([]*net.SRV{backend2})
+
+	untrack, ok := remote.track(out)
+	g.Expect(ok).To(BeFalse())
+	g.Expect(untrack).To(BeNil())
+	g.Expect(remote.numConns()).To(BeZero())
+
+	// new connections use the new endpoints
+	conn, err := tp.dialL7(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+	g.Expect(request(conn, bufio.NewReader(conn))).To(Equal("backend2\n"))
+}
@@ -7,13 +7,14 @@ import (
 
 	v1 "github.com/canonical/microk8s-cluster-agent/pkg/api/v1"
 	v2 "github.com/canonical/microk8s-cluster-agent/pkg/api/v2"
+	v3 "github.com/canonical/microk8s-cluster-agent/pkg/api/v3"
 	"github.com/canonical/microk8s-cluster-agent/pkg/httputil"
 	"github.com/canonical/microk8s-cluster-agent/pkg/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // NewServeMux creates a new *http.ServeMux and registers the MicroK8s cluster agent API endpoints.
-func NewServeMux(timeout time.Duration, enableMetrics bool, apiv1 *v1.API, apiv2 *v2.API) *http.ServeMux {
+func NewServeMux(timeout time.Duration, enableMetrics bool, apiv1 *v1.API, apiv2 *v2.API, apiv3 *v3.API) *http.ServeMux {
 	server := http.NewServeMux()
 
 	withMiddleware := func(f http.HandlerFunc) http.HandlerFunc {
@@ -44,6 +45,7 @@ func NewServeMux(timeout time.Duration, enableMetrics bool, apiv1 *v1.API, apiv2
 	// Cluster Agent API
 	apiv1.RegisterServer(server, withMiddleware)
 	apiv2.RegisterServer(server, withMiddleware)
+	apiv3.RegisterServer(server, withMiddleware)
 
 	return server
 }
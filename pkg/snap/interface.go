@@ -68,7 +68,16 @@ type Snap interface {
 	HasNoCertsReissueLock() bool
 	// CreateNoCertsReissueLock creates the lock file to prevent reissue of CA certificates in this MicroK8s instance.
 	CreateNoCertsReissueLock() error
-
+	// RemoveNoCertsReissueLock removes the lock file to prevent reissue of CA certificates in this MicroK8s instance.
+	RemoveNoCertsReissueLock() error
+	// HasJoinWithCSRLock returns true if joining control plane nodes must send certificate signing requests instead of
+	// receiving the CA key. This is enabled by creating the $SNAP_DATA/var/lock/join-with-csr lock file.
+	HasJoinWithCSRLock() bool
+
+	// GetKubeletProfile returns a named kubelet profile, used to customize the kubelet arguments of joining nodes.
+	// Kubelet profiles are read from the $SNAP_DATA/args/kubelet-profiles.yaml file, which maps names to profiles.
+	// An error wrapping os.ErrNotExist is returned if the profile does not exist.
+	GetKubeletProfile(name string) (KubeletProfile, error)
 	// ReadServiceArguments reads the arguments file for a particular service.
 	ReadServiceArguments(serviceName string) (string, error)
 	// WriteServiceArguments updates the arguments file a particular service.
@@ -77,6 +86,16 @@ type Snap interface {
 	// ConsumeClusterToken returns true if token is a valid token for authenticating join requests.
 	// Tokens with a TTL may be consumed multiple times until they expire. One-time tokens may only be consumed once.
 	ConsumeClusterToken(token string) bool
+	// IsValidClusterToken returns true if token is a valid token for authenticating join requests.
+	// Unlike ConsumeClusterToken, IsValidClusterToken never consumes one-time tokens.
+	IsValidClusterToken(token string) bool
+	// FindClusterToken returns the valid cluster token with a token ID, for tokens in "<id>.<secret>" format.
+	// See util.SplitClusterToken. Like IsValidClusterToken, FindClusterToken never consumes one-time tokens.
+	FindClusterToken(id string) (string, bool)
+	// GetJoinPolicy returns the policy for the node labels, taints and roles that nodes joining with a cluster token may request.
+	// Join policies are read from the $SNAP_DATA/credentials/join-policies.yaml file, which maps cluster tokens to policies.
+	// The policy of the "*" entry applies to tokens without a policy. If no policy applies, an empty policy is returned.
+	GetJoinPolicy(token string) (JoinPolicy, error)
 	// ConsumeCertificateRequestToken returns true if token is a valid token for authenticating certificate signing requests.
 	// Certificate request tokens may only be consumed once.
 	ConsumeCertificateRequestToken(token string) bool
@@ -90,7 +109,24 @@ type Snap interface {
 	AddCertificateRequestToken(token string) error
 	// AddCallbackToken adds a new token that can be used to authenticate requests to a remote cluster agent endpoint.
 	AddCallbackToken(clusterAgentEndpoint, token string) error
-
+	// RemoveCallbackToken removes the token used to authenticate requests to a remote cluster agent endpoint.
+	// RemoveCallbackToken is a no-op if no token exists for the endpoint.
+	RemoveCallbackToken(clusterAgentEndpoint string) error
+
+	// TrackCertificateRequestTokens records the certificate request tokens that were issued to a node joining from
+	// remoteIP, so that they can be revoked when the node leaves the cluster. Tokens may be shared by multiple joins,
+	// therefore each record is keyed by both the token and the remote IP of the join.
+	TrackCertificateRequestTokens(nodeName string, remoteIP string, tokens ...string) error
+	// RevokeCertificateRequestTokens removes any certificate request tokens issued to a node that have not yet been consumed.
+	// RevokeCertificateRequestTokens returns the list of revoked tokens.
+	RevokeCertificateRequestTokens(nodeName string) ([]string, error)
+
+	// GetOrCreateAdminToken creates and returns the token used to authenticate administrative requests to this cluster agent.
+	// Unlike the self callback token, the admin token is never shared with other nodes, and is only readable by root.
+	// Subsequent calls should return the same token.
+	GetOrCreateAdminToken() (string, error)
+	// IsAdminTokenValid returns true if token is the admin token of this cluster agent. See GetOrCreateAdminToken.
+	IsAdminTokenValid(token string) bool
 	// GetOrCreateSelfCallbackToken creates and returns the callback token that can be used for configure and upgrade requests to this cluster agent.
 	// Subsequent calls should return the same token.
 	GetOrCreateSelfCallbackToken() (string, error)
@@ -99,12 +135,25 @@ type Snap interface {
 	GetOrCreateKubeletToken(hostname string) (string, error)
 	// GetKnownToken returns the token for a known user from the known_users.csv file.
 	GetKnownToken(username string) (string, error)
+	// RevokeKubeletToken removes the token used to authenticate the kubelet of a node from the known_tokens.csv file.
+	// RevokeKubeletToken is a no-op if no token exists for the node.
+	RevokeKubeletToken(hostname string) error
 
 	// IsCAPIAuthTokenValid returns true if token is a valid CAPI auth token.
 	IsCAPIAuthTokenValid(token string) (bool, error)
 
 	// SignCertificate signs the certificate signing request, and returns the certificate in PEM format.
 	SignCertificate(ctx context.Context, csrPEM []byte) ([]byte, error)
+	// TrackIssuedCertificate records the serial number of a certificate that was signed using a certificate request token
+	// from remoteIP. The certificate is associated with the node the token was issued to when it joined from remoteIP, if any.
+	// See TrackCertificateRequestTokens.
+	TrackIssuedCertificate(token string, remoteIP string, serial string) error
+	// TrackNodeCertificates records the serial numbers of certificates that were issued to a node.
+	TrackNodeCertificates(nodeName string, serials ...string) error
+	// ForgetNodeCertificates removes the records of all certificates issued to a node.
+	// ForgetNodeCertificates returns the list of serial numbers. Note that the certificates are not revoked, they remain
+	// valid until they expire or the cluster CA is rotated.
+	ForgetNodeCertificates(nodeName string) ([]string, error)
 
 	// ImportImage imports an OCI image from raw bytes.
 	ImportImage(ctx context.Context, reader io.Reader) error
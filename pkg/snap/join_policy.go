@@ -0,0 +1,66 @@
+package snap
+
+import (
+	"strings"
+)
+
+// JoinPolicy restricts the node labels, taints and roles that joining nodes may request, and which join requests are
+// approved automatically.
+// Join policies are tied to cluster tokens, see Snap.GetJoinPolicy.
+type JoinPolicy struct {
+	// Labels are the node labels that joining nodes may request.
+	// Each entry is either a label key, which allows any value, or "key=value", which allows a single value.
+	Labels []string `yaml:"labels"`
+	// Taints are the node taints that joining nodes may request.
+	// Each entry is "key", "key=value" or "key=value:Effect". Omitted parts match any value.
+	Taints []string `yaml:"taints"`
+	// Roles are the node roles that joining nodes may request.
+	Roles []string `yaml:"roles"`
+	// AutoApprove are the types of nodes ("worker" or "control-plane") for which join requests are approved
+	// automatically when join approval is enabled.
+	AutoApprove []string `yaml:"auto_approve"`
+}
+
+// AllowsLabel returns true if the policy allows joining nodes to set a node label.
+func (p JoinPolicy) AllowsLabel(key, value string) bool {
+	for _, allowed := range p.Labels {
+		allowedKey, allowedValue, hasValue := strings.Cut(allowed, "=")
+		if allowedKey == key && (!hasValue || allowedValue == value) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsTaint returns true if the policy allows joining nodes to register with a taint.
+// taint is in "key=value:Effect" format.
+func (p JoinPolicy) AllowsTaint(taint string) bool {
+	key, value, effect := ParseTaint(taint)
+	for _, allowed := range p.Taints {
+		allowedKey, allowedValue, allowedEffect := ParseTaint(allowed)
+		if allowedKey != key {
+			continue
+		}
+		if (allowedValue == "" || allowedValue == value) && (allowedEffect == "" || allowedEffect == effect) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsRole returns true if the policy allows joining nodes to request a node role.
+func (p JoinPolicy) AllowsRole(role string) bool {
+	for _, allowed := range p.Roles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTaint splits a taint in "key=value:Effect" format into its parts. Value and effect are optional.
+func ParseTaint(taint string) (key, value, effect string) {
+	taint, effect, _ = strings.Cut(taint, ":")
+	key, value, _ = strings.Cut(taint, "=")
+	return key, value, effect
+}
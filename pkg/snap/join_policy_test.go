@@ -0,0 +1,91 @@
+package snap_test
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+)
+
+func TestJoinPolicy(t *testing.T) {
+	policy := snap.JoinPolicy{
+		Labels: []string{"topology.kubernetes.io/zone", "pool=gpu-less"},
+		Taints: []string{"dedicated=gpu:NoSchedule", "maintenance"},
+		Roles:  []string{"ingress"},
+	}
+
+	t.Run("Labels", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(policy.AllowsLabel("topology.kubernetes.io/zone", "zone-a")).To(BeTrue())
+		g.Expect(policy.AllowsLabel("pool", "gpu-less")).To(BeTrue())
+		g.Expect(policy.AllowsLabel("pool", "gpu")).To(BeFalse())
+		g.Expect(policy.AllowsLabel("other", "")).To(BeFalse())
+	})
+
+	t.Run("Taints", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(policy.AllowsTaint("dedicated=gpu:NoSchedule")).To(BeTrue())
+		g.Expect(policy.AllowsTaint("dedicated=gpu:NoExecute")).To(BeFalse())
+		g.Expect(policy.AllowsTaint("dedicated=cpu:NoSchedule")).To(BeFalse())
+		g.Expect(policy.AllowsTaint("maintenance=true:NoExecute")).To(BeTrue())
+		g.Expect(policy.AllowsTaint("other:NoSchedule")).To(BeFalse())
+	})
+
+	t.Run("Roles", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(policy.AllowsRole("ingress")).To(BeTrue())
+		g.Expect(policy.AllowsRole("control-plane")).To(BeFalse())
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(snap.JoinPolicy{}.AllowsLabel("pool", "gpu-less")).To(BeFalse())
+		g.Expect(snap.JoinPolicy{}.AllowsTaint("maintenance:NoSchedule")).To(BeFalse())
+		g.Expect(snap.JoinPolicy{}.AllowsRole("ingress")).To(BeFalse())
+	})
+}
+
+func TestGetJoinPolicy(t *testing.T) {
+	os.RemoveAll("testdata/credentials")
+	s := snap.NewSnap("testdata", "testdata", "testdata")
+
+	t.Run("MissingFile", func(t *testing.T) {
+		g := NewWithT(t)
+		policy, err := s.GetJoinPolicy("token")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(policy).To(Equal(snap.JoinPolicy{}))
+	})
+
+	if err := os.MkdirAll("testdata/credentials", 0755); err != nil {
+		t.Fatal("Failed to create test directory")
+	}
+	defer os.RemoveAll("testdata/credentials")
+	if err := os.WriteFile("testdata/credentials/join-policies.yaml", []byte(`
+gpu-token:
+  labels: [pool=gpu]
+  taints: ["nvidia.com/gpu=present:NoSchedule"]
+"*":
+  roles: [worker]
+`), 0600); err != nil {
+		t.Fatalf("Failed to create test join-policies.yaml file: %s", err)
+	}
+
+	t.Run("Token", func(t *testing.T) {
+		g := NewWithT(t)
+		policy, err := s.GetJoinPolicy("gpu-token")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(policy).To(Equal(snap.JoinPolicy{
+			Labels: []string{"pool=gpu"},
+			Taints: []string{"nvidia.com/gpu=present:NoSchedule"},
+		}))
+	})
+
+	t.Run("Default", func(t *testing.T) {
+		g := NewWithT(t)
+		policy, err := s.GetJoinPolicy("other-token")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(policy).To(Equal(snap.JoinPolicy{Roles: []string{"worker"}}))
+	})
+}
@@ -0,0 +1,19 @@
+package snap
+
+// KubeletProfile is a named set of changes to the kubelet arguments of joining nodes.
+// Kubelet profiles are applied on top of the kubelet arguments of the control plane node, see UpdateServiceArguments.
+type KubeletProfile struct {
+	// Arguments are kubelet arguments to set, replacing any existing values.
+	Arguments map[string]string `yaml:"arguments"`
+	// Delete are kubelet arguments to remove.
+	Delete []string `yaml:"delete"`
+}
+
+// Apply merges the kubelet profile with the contents of a kubelet arguments file.
+func (p KubeletProfile) Apply(kubeletArgs string) string {
+	if len(p.Arguments) == 0 && len(p.Delete) == 0 {
+		return kubeletArgs
+	}
+	kubeletArgs, _ = MergeServiceArguments(kubeletArgs, []map[string]string{p.Arguments}, p.Delete)
+	return kubeletArgs
+}
@@ -0,0 +1,73 @@
+package snap_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+)
+
+func TestKubeletProfile(t *testing.T) {
+	t.Run("Apply", func(t *testing.T) {
+		g := NewWithT(t)
+		profile := snap.KubeletProfile{
+			Arguments: map[string]string{
+				"--kube-reserved":              "cpu=500m,memory=1Gi",
+				"--container-runtime-endpoint": "unix:///run/crio.sock",
+			},
+			Delete: []string{"--cgroup-driver"},
+		}
+		g.Expect(profile.Apply("--container-runtime-endpoint=${SNAP_COMMON}/run/containerd.sock\n--cgroup-driver=systemd\n--node-ip=10.0.0.1\n")).To(Equal(
+			"--container-runtime-endpoint=unix:///run/crio.sock\n--node-ip=10.0.0.1\n--kube-reserved=cpu=500m,memory=1Gi\n",
+		))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		g := NewWithT(t)
+		g.Expect(snap.KubeletProfile{}.Apply("--node-ip=10.0.0.1")).To(Equal("--node-ip=10.0.0.1"))
+	})
+}
+
+func TestGetKubeletProfile(t *testing.T) {
+	os.RemoveAll("testdata/args/kubelet-profiles.yaml")
+	s := snap.NewSnap("testdata", "testdata", "testdata")
+
+	t.Run("MissingFile", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := s.GetKubeletProfile("arm64")
+		g.Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+	})
+
+	if err := os.MkdirAll("testdata/args", 0755); err != nil {
+		t.Fatal("Failed to create test directory")
+	}
+	defer os.RemoveAll("testdata/args/kubelet-profiles.yaml")
+	if err := os.WriteFile("testdata/args/kubelet-profiles.yaml", []byte(`
+arm64:
+  arguments:
+    --system-reserved: cpu=250m,memory=512Mi
+  delete:
+    - --cpu-manager-policy
+`), 0600); err != nil {
+		t.Fatalf("Failed to create test kubelet-profiles.yaml file: %s", err)
+	}
+
+	t.Run("Exists", func(t *testing.T) {
+		g := NewWithT(t)
+		profile, err := s.GetKubeletProfile("arm64")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(profile).To(Equal(snap.KubeletProfile{
+			Arguments: map[string]string{"--system-reserved": "cpu=250m,memory=512Mi"},
+			Delete:    []string{"--cpu-manager-policy"},
+		}))
+	})
+
+	t.Run("NotExists", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := s.GetKubeletProfile("amd64")
+		g.Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+	})
+}
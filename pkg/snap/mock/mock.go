@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	"github.com/canonical/microk8s-cluster-agent/pkg/util"
@@ -59,6 +61,9 @@ type Snap struct {
 	DqliteClusterYaml string
 	DqliteInfoYaml    string
 
+	// dqliteClusterYamlMu protects DqliteClusterYaml, for tests that update it with SetDqliteClusterYaml while it is read.
+	dqliteClusterYamlMu sync.Mutex
+
 	WriteDqliteUpdateYamlCalledWith []string
 
 	KubeconfigFile string
@@ -67,31 +72,46 @@ type Snap struct {
 	DqliteLock                         bool
 	NoCertsReissueLock                 bool
 	CreateNoCertsReissueLockCalledWith []struct{}
+	RemoveNoCertsReissueLockCalledWith []struct{}
+	JoinWithCSRLock                    bool
 
 	ServiceArguments            map[string]string
+	KubeletProfiles             map[string]snap.KubeletProfile
 	WriteServiceArgumentsCalled bool
 
 	ClusterTokens            []string
+	JoinPolicies             map[string]snap.JoinPolicy // map cluster token to join policy
 	CertificateRequestTokens []string
 	SelfCallbackTokens       []string
 
 	AddPersistentClusterTokenCalledWith  []string
 	AddCertificateRequestTokenCalledWith []string
 	AddCallbackTokenCalledWith           []string // "{clusterAgentEndpoint} {token}"
+	RemoveCallbackTokenCalledWith        []string
+
+	TrackedCertificateRequestTokens          map[string][]string // map node name to "{token} {remoteIP}"
+	RevokeCertificateRequestTokensCalledWith []string
 
 	ConsumeClusterTokenCalledWith            []string
 	ConsumeCertificateRequestTokenCalledWith []string
 
 	SelfCallbackToken string
+	AdminToken        string
 	KubeletTokens     map[string]string // map hostname to token
 	KnownTokens       map[string]string // map username to token
 
+	RevokeKubeletTokenCalledWith []string
+
 	CAPIAuthTokenValid bool
 	CAPIAuthTokenError error
 
 	SignCertificateCalledWith []string // string(csrPEM)
 	SignedCertificate         string
 
+	TrackIssuedCertificateCalledWith []string            // "{token} {remoteIP} {serial}"
+	NodeCertificates                 map[string][]string // map node name to certificate serials
+	ForgetNodeCertificatesCalledWith []string
+
 	ImportImageCalledWith []string // string(io.ReadAll(reader))
 
 	CSRConfig string
@@ -203,9 +223,18 @@ func (s *Snap) ReadDqliteInfoYaml() (string, error) {
 
 // ReadDqliteClusterYaml is a mock implementation for the snap.Snap interface.
 func (s *Snap) ReadDqliteClusterYaml() (string, error) {
+	s.dqliteClusterYamlMu.Lock()
+	defer s.dqliteClusterYamlMu.Unlock()
 	return s.DqliteClusterYaml, nil
 }
 
+// SetDqliteClusterYaml updates DqliteClusterYaml, while it may be read concurrently.
+func (s *Snap) SetDqliteClusterYaml(clusterYaml string) {
+	s.dqliteClusterYamlMu.Lock()
+	defer s.dqliteClusterYamlMu.Unlock()
+	s.DqliteClusterYaml = clusterYaml
+}
+
 // WriteDqliteUpdateYaml is a mock implementation for the snap.Snap interface.
 func (s *Snap) WriteDqliteUpdateYaml(b []byte) error {
 	s.WriteDqliteUpdateYamlCalledWith = append(s.WriteDqliteUpdateYamlCalledWith, string(b))
@@ -239,6 +268,27 @@ func (s *Snap) CreateNoCertsReissueLock() error {
 	return nil
 }
 
+// RemoveNoCertsReissueLock is a mock implementation for the snap.Snap interface.
+func (s *Snap) RemoveNoCertsReissueLock() error {
+	s.NoCertsReissueLock = false
+	s.RemoveNoCertsReissueLockCalledWith = append(s.RemoveNoCertsReissueLockCalledWith, struct{}{})
+	return nil
+}
+
+// HasJoinWithCSRLock is a mock implementation for the snap.Snap interface.
+func (s *Snap) HasJoinWithCSRLock() bool {
+	return s.JoinWithCSRLock
+}
+
+// GetKubeletProfile is a mock implementation for the snap.Snap interface.
+func (s *Snap) GetKubeletProfile(name string) (snap.KubeletProfile, error) {
+	profile, ok := s.KubeletProfiles[name]
+	if !ok {
+		return snap.KubeletProfile{}, fmt.Errorf("kubelet profile %q: %w", name, os.ErrNotExist)
+	}
+	return profile, nil
+}
+
 // ReadServiceArguments is a mock implementation for the snap.Snap interface.
 func (s *Snap) ReadServiceArguments(service string) (string, error) {
 	if s.ServiceArguments == nil {
@@ -272,6 +322,21 @@ func (s *Snap) ConsumeClusterToken(token string) bool {
 	return contains(s.ClusterTokens, token)
 }
 
+// IsValidClusterToken is a mock implementation for the snap.Snap interface.
+func (s *Snap) IsValidClusterToken(token string) bool {
+	return contains(s.ClusterTokens, token)
+}
+
+// FindClusterToken is a mock implementation for the snap.Snap interface.
+func (s *Snap) FindClusterToken(id string) (string, bool) {
+	for _, token := range s.ClusterTokens {
+		if tokenID, _, ok := util.SplitClusterToken(token); ok && tokenID == id {
+			return token, true
+		}
+	}
+	return "", false
+}
+
 // ConsumeCertificateRequestToken is a mock implementation for the snap.Snap interface.
 func (s *Snap) ConsumeCertificateRequestToken(token string) bool {
 	s.ConsumeCertificateRequestTokenCalledWith = append(s.ConsumeCertificateRequestTokenCalledWith, token)
@@ -301,6 +366,49 @@ func (s *Snap) AddCallbackToken(clusterAgentEndpoint string, token string) error
 	return nil
 }
 
+// RemoveCallbackToken is a mock implementation for the snap.Snap interface.
+func (s *Snap) RemoveCallbackToken(clusterAgentEndpoint string) error {
+	s.RemoveCallbackTokenCalledWith = append(s.RemoveCallbackTokenCalledWith, clusterAgentEndpoint)
+	return nil
+}
+
+// TrackCertificateRequestTokens is a mock implementation for the snap.Snap interface.
+func (s *Snap) TrackCertificateRequestTokens(nodeName string, remoteIP string, tokens ...string) error {
+	if s.TrackedCertificateRequestTokens == nil {
+		s.TrackedCertificateRequestTokens = make(map[string][]string)
+	}
+	for _, token := range tokens {
+		s.TrackedCertificateRequestTokens[nodeName] = append(s.TrackedCertificateRequestTokens[nodeName], fmt.Sprintf("%s %s", token, remoteIP))
+	}
+	return nil
+}
+
+// RevokeCertificateRequestTokens is a mock implementation for the snap.Snap interface.
+func (s *Snap) RevokeCertificateRequestTokens(nodeName string) ([]string, error) {
+	s.RevokeCertificateRequestTokensCalledWith = append(s.RevokeCertificateRequestTokensCalledWith, nodeName)
+	var revoked []string
+	for _, tracked := range s.TrackedCertificateRequestTokens[nodeName] {
+		if token := strings.Fields(tracked)[0]; contains(s.CertificateRequestTokens, token) {
+			revoked = append(revoked, token)
+		}
+	}
+	delete(s.TrackedCertificateRequestTokens, nodeName)
+	return revoked, nil
+}
+
+// GetOrCreateAdminToken is a mock implementation for the snap.Snap interface.
+func (s *Snap) GetOrCreateAdminToken() (string, error) {
+	if s.AdminToken == "" {
+		s.AdminToken = "admin-token"
+	}
+	return s.AdminToken, nil
+}
+
+// IsAdminTokenValid is a mock implementation for the snap.Snap interface.
+func (s *Snap) IsAdminTokenValid(token string) bool {
+	return s.AdminToken != "" && token == s.AdminToken
+}
+
 // GetOrCreateSelfCallbackToken is a mock implementation for the snap.Snap interface.
 func (s *Snap) GetOrCreateSelfCallbackToken() (string, error) {
 	if s.SelfCallbackToken == "" {
@@ -329,6 +437,18 @@ func (s *Snap) GetKnownToken(username string) (string, error) {
 	return "", fmt.Errorf("no known token for user %s", username)
 }
 
+// GetJoinPolicy is a mock implementation for the snap.Snap interface.
+func (s *Snap) GetJoinPolicy(token string) (snap.JoinPolicy, error) {
+	return s.JoinPolicies[token], nil
+}
+
+// RevokeKubeletToken is a mock implementation for the snap.Snap interface.
+func (s *Snap) RevokeKubeletToken(hostname string) error {
+	s.RevokeKubeletTokenCalledWith = append(s.RevokeKubeletTokenCalledWith, hostname)
+	delete(s.KubeletTokens, hostname)
+	return nil
+}
+
 // IsCAPIAuthTokenValid is a mock implementation for the snap.Snap interface.
 func (s *Snap) IsCAPIAuthTokenValid(token string) (bool, error) {
 	return s.CAPIAuthTokenValid, s.CAPIAuthTokenError
@@ -346,6 +466,29 @@ func (s *Snap) SignCertificate(ctx context.Context, csrPEM []byte) ([]byte, erro
 	return []byte(s.SignedCertificate), nil
 }
 
+// TrackIssuedCertificate is a mock implementation for the snap.Snap interface.
+func (s *Snap) TrackIssuedCertificate(token string, remoteIP string, serial string) error {
+	s.TrackIssuedCertificateCalledWith = append(s.TrackIssuedCertificateCalledWith, fmt.Sprintf("%s %s %s", token, remoteIP, serial))
+	return nil
+}
+
+// TrackNodeCertificates is a mock implementation for the snap.Snap interface.
+func (s *Snap) TrackNodeCertificates(nodeName string, serials ...string) error {
+	if s.NodeCertificates == nil {
+		s.NodeCertificates = make(map[string][]string)
+	}
+	s.NodeCertificates[nodeName] = append(s.NodeCertificates[nodeName], serials...)
+	return nil
+}
+
+// ForgetNodeCertificates is a mock implementation for the snap.Snap interface.
+func (s *Snap) ForgetNodeCertificates(nodeName string) ([]string, error) {
+	s.ForgetNodeCertificatesCalledWith = append(s.ForgetNodeCertificatesCalledWith, nodeName)
+	serials := s.NodeCertificates[nodeName]
+	delete(s.NodeCertificates, nodeName)
+	return serials, nil
+}
+
 // ImportImage is a mock implementation for the snap.Snap interface.
 func (s *Snap) ImportImage(ctx context.Context, reader io.Reader) error {
 	b, _ := io.ReadAll(reader)
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/util"
@@ -17,7 +18,12 @@ func GetServiceArgument(s Snap, serviceName string, argument string) string {
 	if err != nil {
 		return ""
 	}
+	return GetServiceArgumentFromString(arguments, argument)
+}
 
+// GetServiceArgumentFromString retrieves the value of a specific argument from the contents of a service arguments file.
+// If the argument is not present, an empty string is returned.
+func GetServiceArgumentFromString(arguments string, argument string) string {
 	for _, line := range strings.Split(arguments, "\n") {
 		line = strings.TrimSpace(line)
 		// ignore empty lines
@@ -37,18 +43,27 @@ func GetServiceArgument(s Snap, serviceName string, argument string) string {
 // delete is a list of arguments to remove completely. The argument is removed if present.
 // Returns a boolean whether any of the arguments were changed, as well as any errors that may have occured.
 func UpdateServiceArguments(s Snap, serviceName string, updateList []map[string]string, delete []string) (bool, error) {
-	if updateList == nil {
-		updateList = []map[string]string{}
-	}
-	if delete == nil {
-		delete = []string{}
-	}
-
 	// If no updates are requested, exit early
 	if len(updateList) == 0 && len(delete) == 0 {
 		return false, nil
 	}
 
+	arguments, err := s.ReadServiceArguments(serviceName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to read arguments of service %s: %w", serviceName, err)
+	}
+
+	newArguments, changed := MergeServiceArguments(arguments, updateList, delete)
+	if err := s.WriteServiceArguments(serviceName, []byte(newArguments)); err != nil {
+		return false, fmt.Errorf("failed to update arguments for service %s: %q", serviceName, err)
+	}
+	return changed, nil
+}
+
+// MergeServiceArguments applies updates to the contents of a service arguments file, with the same semantics as
+// UpdateServiceArguments. Arguments that do not already exist are appended in sorted order.
+// Returns the new contents of the arguments file, and whether any of the arguments were changed.
+func MergeServiceArguments(arguments string, updateList []map[string]string, delete []string) (string, bool) {
 	deleteMap := make(map[string]struct{}, len(delete))
 	for _, k := range delete {
 		deleteMap[k] = struct{}{}
@@ -61,11 +76,6 @@ func UpdateServiceArguments(s Snap, serviceName string, updateList []map[string]
 		}
 	}
 
-	arguments, err := s.ReadServiceArguments(serviceName)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return false, fmt.Errorf("failed to read arguments of service %s: %w", serviceName, err)
-	}
-
 	changed := false
 	existingArguments := make(map[string]struct{}, len(arguments))
 	newArguments := make([]string, 0, len(arguments))
@@ -93,15 +103,17 @@ func UpdateServiceArguments(s Snap, serviceName string, updateList []map[string]
 		}
 	}
 
-	for key, value := range updateMap {
+	newKeys := make([]string, 0, len(updateMap))
+	for key := range updateMap {
 		if _, argExists := existingArguments[key]; !argExists {
-			changed = true
-			newArguments = append(newArguments, fmt.Sprintf("%s=%s", key, value))
+			newKeys = append(newKeys, key)
 		}
 	}
-
-	if err := s.WriteServiceArguments(serviceName, []byte(strings.Join(newArguments, "\n")+"\n")); err != nil {
-		return false, fmt.Errorf("failed to update arguments for service %s: %q", serviceName, err)
+	sort.Strings(newKeys)
+	for _, key := range newKeys {
+		changed = true
+		newArguments = append(newArguments, fmt.Sprintf("%s=%s", key, updateMap[key]))
 	}
-	return changed, nil
+
+	return strings.Join(newArguments, "\n") + "\n", changed
 }
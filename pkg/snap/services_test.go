@@ -189,3 +189,15 @@ func TestUpdateServiceArguments(t *testing.T) {
 		})
 	}
 }
+
+func TestMergeServiceArguments(t *testing.T) {
+	g := NewWithT(t)
+
+	arguments, changed := snap.MergeServiceArguments("--key=value\n--other value\n", []map[string]string{{"--new-b": "b", "--new-a": "a", "--key": "new-value"}}, []string{"--other"})
+	g.Expect(changed).To(BeTrue())
+	g.Expect(arguments).To(Equal("--key=new-value\n--new-a=a\n--new-b=b\n"))
+
+	arguments, changed = snap.MergeServiceArguments(arguments, []map[string]string{{"--new-a": "a"}}, nil)
+	g.Expect(changed).To(BeFalse())
+	g.Expect(arguments).To(Equal("--key=new-value\n--new-a=a\n--new-b=b\n"))
+}
@@ -3,6 +3,8 @@ package snap
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -229,6 +231,33 @@ func (s *snap) CreateNoCertsReissueLock() error {
 	return err
 }
 
+func (s *snap) RemoveNoCertsReissueLock() error {
+	if err := os.Remove(s.GetSnapDataPath("var", "lock", "no-cert-reissue")); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *snap) HasJoinWithCSRLock() bool {
+	return util.FileExists(s.GetSnapDataPath("var", "lock", "join-with-csr"))
+}
+
+func (s *snap) GetKubeletProfile(name string) (KubeletProfile, error) {
+	b, err := os.ReadFile(s.GetSnapDataPath("args", "kubelet-profiles.yaml"))
+	if err != nil {
+		return KubeletProfile{}, fmt.Errorf("failed to read kubelet profiles: %w", err)
+	}
+	var profiles map[string]KubeletProfile
+	if err := yaml.Unmarshal(b, &profiles); err != nil {
+		return KubeletProfile{}, fmt.Errorf("failed to parse kubelet profiles: %w", err)
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return KubeletProfile{}, fmt.Errorf("kubelet profile %q: %w", name, os.ErrNotExist)
+	}
+	return profile, nil
+}
+
 func (s *snap) ReadServiceArguments(serviceName string) (string, error) {
 	return util.ReadFile(s.GetSnapDataPath("args", serviceName))
 }
@@ -253,6 +282,43 @@ func (s *snap) ConsumeClusterToken(token string) bool {
 	return isValid
 }
 
+func (s *snap) IsValidClusterToken(token string) bool {
+	s.clusterTokensMu.Lock()
+	defer s.clusterTokensMu.Unlock()
+	if isValid, _ := util.IsValidToken(token, s.GetSnapDataPath("credentials", "persistent-cluster-tokens.txt")); isValid {
+		return true
+	}
+	isValid, _ := util.IsValidToken(token, s.GetSnapDataPath("credentials", "cluster-tokens.txt"))
+	return isValid
+}
+
+func (s *snap) FindClusterToken(id string) (string, bool) {
+	s.clusterTokensMu.Lock()
+	defer s.clusterTokensMu.Unlock()
+	if token, ok := util.FindTokenByID(id, s.GetSnapDataPath("credentials", "persistent-cluster-tokens.txt")); ok {
+		return token, true
+	}
+	return util.FindTokenByID(id, s.GetSnapDataPath("credentials", "cluster-tokens.txt"))
+}
+
+func (s *snap) GetJoinPolicy(token string) (JoinPolicy, error) {
+	b, err := os.ReadFile(s.GetSnapDataPath("credentials", "join-policies.yaml"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return JoinPolicy{}, nil
+		}
+		return JoinPolicy{}, fmt.Errorf("failed to read join policies: %w", err)
+	}
+	var policies map[string]JoinPolicy
+	if err := yaml.Unmarshal(b, &policies); err != nil {
+		return JoinPolicy{}, fmt.Errorf("failed to parse join policies: %w", err)
+	}
+	if policy, ok := policies[token]; ok {
+		return policy, nil
+	}
+	return policies["*"], nil
+}
+
 func (s *snap) ConsumeCertificateRequestToken(token string) bool {
 	s.certTokensMu.Lock()
 	defer s.certTokensMu.Unlock()
@@ -289,6 +355,64 @@ func (s *snap) AddCallbackToken(clusterAgentEndpoint string, token string) error
 	return util.AppendToken(fmt.Sprintf("%s %s", clusterAgentEndpoint, token), s.GetSnapDataPath("credentials", "callback-tokens.txt"), s.GetGroupName())
 }
 
+func (s *snap) RemoveCallbackToken(clusterAgentEndpoint string) error {
+	s.callbackTokensMu.Lock()
+	defer s.callbackTokensMu.Unlock()
+	_, err := util.RemoveMatchingTokens(s.GetSnapDataPath("credentials", "callback-tokens.txt"), s.GetGroupName(), func(line string) bool {
+		// hostnames are case-insensitive
+		return strings.HasPrefix(strings.ToLower(line), strings.ToLower(clusterAgentEndpoint)+" ")
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *snap) TrackCertificateRequestTokens(nodeName string, remoteIP string, tokens ...string) error {
+	s.certTokensMu.Lock()
+	defer s.certTokensMu.Unlock()
+	for _, token := range tokens {
+		if err := util.AppendToken(fmt.Sprintf("%s %s %s", nodeName, token, remoteIP), s.GetSnapDataPath("credentials", "node-certs-request-tokens.txt"), s.GetGroupName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *snap) RevokeCertificateRequestTokens(nodeName string) ([]string, error) {
+	s.certTokensMu.Lock()
+	defer s.certTokensMu.Unlock()
+	trackedTokens, err := util.RemoveMatchingTokens(s.GetSnapDataPath("credentials", "node-certs-request-tokens.txt"), s.GetGroupName(), func(line string) bool {
+		return strings.HasPrefix(line, nodeName+" ")
+	})
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to retrieve certificate request tokens of node %s: %w", nodeName, err)
+	case len(trackedTokens) == 0:
+		return nil, nil
+	}
+
+	issuedTokens := make(map[string]struct{}, len(trackedTokens))
+	for _, line := range trackedTokens {
+		if parts := strings.Fields(line); len(parts) >= 2 {
+			issuedTokens[parts[1]] = struct{}{}
+		}
+	}
+	revokedTokens, err := util.RemoveMatchingTokens(s.GetSnapDataPath("credentials", "certs-request-tokens.txt"), s.GetGroupName(), func(line string) bool {
+		_, ok := issuedTokens[strings.SplitN(line, "|", 2)[0]]
+		return ok
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to revoke certificate request tokens of node %s: %w", nodeName, err)
+	}
+	for i, line := range revokedTokens {
+		revokedTokens[i] = strings.SplitN(line, "|", 2)[0]
+	}
+	return revokedTokens, nil
+}
+
 func (s *snap) GetOrCreateSelfCallbackToken() (string, error) {
 	s.callbackTokensMu.Lock()
 	defer s.callbackTokensMu.Unlock()
@@ -304,6 +428,32 @@ func (s *snap) GetOrCreateSelfCallbackToken() (string, error) {
 	return strings.TrimSpace(c), nil
 }
 
+func (s *snap) GetOrCreateAdminToken() (string, error) {
+	s.callbackTokensMu.Lock()
+	defer s.callbackTokensMu.Unlock()
+	adminTokenFile := s.GetSnapDataPath("credentials", "cluster-agent-admin-token.txt")
+	c, err := util.ReadFile(adminTokenFile)
+	if err != nil {
+		token := util.NewRandomString(util.Alpha, 64)
+		if err := os.WriteFile(adminTokenFile, []byte(fmt.Sprintf("%s\n", token)), 0600); err != nil {
+			return "", fmt.Errorf("failed to create admin token file: %w", err)
+		}
+		return token, nil
+	}
+	return strings.TrimSpace(c), nil
+}
+
+func (s *snap) IsAdminTokenValid(token string) bool {
+	if token == "" {
+		return false
+	}
+	c, err := util.ReadFile(s.GetSnapDataPath("credentials", "cluster-agent-admin-token.txt"))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(c)), []byte(token)) == 1
+}
+
 func (s *snap) GetOrCreateKubeletToken(hostname string) (string, error) {
 	user := fmt.Sprintf("system:node:%s", hostname)
 	existingToken, err := s.GetKnownToken(user)
@@ -340,6 +490,21 @@ func (s *snap) GetKnownToken(username string) (string, error) {
 	return "", fmt.Errorf("no known token found for user %s", username)
 }
 
+func (s *snap) RevokeKubeletToken(hostname string) error {
+	user := fmt.Sprintf("system:node:%s", hostname)
+
+	s.knownTokensMu.Lock()
+	defer s.knownTokensMu.Unlock()
+	_, err := util.RemoveMatchingTokens(s.GetSnapDataPath("credentials", "known_tokens.csv"), s.GetGroupName(), func(line string) bool {
+		parts := strings.SplitN(line, ",", 3)
+		return len(parts) >= 2 && parts[1] == user
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to revoke kubelet token for %s: %w", user, err)
+	}
+	return nil
+}
+
 // IsCAPIAuthTokenValid checks if the given CAPI auth token is valid.
 func (s *snap) IsCAPIAuthTokenValid(token string) (bool, error) {
 	contents, err := util.ReadFile(s.GetCAPIPath("etc", "token"))
@@ -362,6 +527,54 @@ func (s *snap) SignCertificate(ctx context.Context, csrPEM []byte) ([]byte, erro
 	return stdout.Bytes(), nil
 }
 
+func (s *snap) TrackIssuedCertificate(token string, remoteIP string, serial string) error {
+	s.certTokensMu.Lock()
+	defer s.certTokensMu.Unlock()
+	// The same token may have been issued to multiple nodes, so only the record of the join from remoteIP is used.
+	var matched bool
+	trackedTokens, err := util.RemoveMatchingTokens(s.GetSnapDataPath("credentials", "node-certs-request-tokens.txt"), s.GetGroupName(), func(line string) bool {
+		if parts := strings.Fields(line); !matched && len(parts) == 3 && parts[1] == token && parts[2] == remoteIP {
+			matched = true
+			return true
+		}
+		return false
+	})
+	switch {
+	case errors.Is(err, os.ErrNotExist), err == nil && len(trackedTokens) == 0:
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to retrieve certificate request tokens: %w", err)
+	}
+	return util.AppendToken(fmt.Sprintf("%s %s", strings.Fields(trackedTokens[0])[0], serial), s.GetSnapDataPath("credentials", "node-certificates.txt"), s.GetGroupName())
+}
+
+func (s *snap) TrackNodeCertificates(nodeName string, serials ...string) error {
+	s.certTokensMu.Lock()
+	defer s.certTokensMu.Unlock()
+	for _, serial := range serials {
+		if err := util.AppendToken(fmt.Sprintf("%s %s", nodeName, serial), s.GetSnapDataPath("credentials", "node-certificates.txt"), s.GetGroupName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *snap) ForgetNodeCertificates(nodeName string) ([]string, error) {
+	s.certTokensMu.Lock()
+	defer s.certTokensMu.Unlock()
+	issuedCertificates, err := util.RemoveMatchingTokens(s.GetSnapDataPath("credentials", "node-certificates.txt"), s.GetGroupName(), func(line string) bool {
+		return strings.HasPrefix(line, nodeName+" ")
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to retrieve certificates of node %s: %w", nodeName, err)
+	}
+	serials := make([]string, 0, len(issuedCertificates))
+	for _, line := range issuedCertificates {
+		serials = append(serials, strings.TrimPrefix(line, nodeName+" "))
+	}
+	return serials, nil
+}
+
 func (s *snap) ImportImage(ctx context.Context, reader io.Reader) error {
 	importCmd := exec.CommandContext(ctx,
 		s.GetSnapPath("bin", "ctr"),
@@ -22,6 +22,7 @@ func TestLock(t *testing.T) {
 		{name: "kubelite", file: "lite.lock", hasLock: s.HasKubeliteLock},
 		{name: "dqlite", file: "ha-cluster", hasLock: s.HasDqliteLock},
 		{name: "cert-reissue", file: "no-cert-reissue", hasLock: s.HasNoCertsReissueLock},
+		{name: "join-with-csr", file: "join-with-csr", hasLock: s.HasJoinWithCSRLock},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			lockFile := filepath.Join("testdata", "var", "lock", tc.file)
@@ -41,3 +42,25 @@ func TestLock(t *testing.T) {
 		})
 	}
 }
+
+func TestNoCertsReissueLock(t *testing.T) {
+	s := snap.NewSnap("testdata", "testdata", "testdata")
+	if err := os.MkdirAll("testdata/var/lock", 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	defer os.RemoveAll("testdata/var")
+	if err := s.CreateNoCertsReissueLock(); err != nil {
+		t.Fatalf("Failed to create lock: %s", err)
+	}
+	if !s.HasNoCertsReissueLock() {
+		t.Fatal("Expected to have lock but we do not")
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.RemoveNoCertsReissueLock(); err != nil {
+			t.Fatalf("Failed to remove lock: %s", err)
+		}
+		if s.HasNoCertsReissueLock() {
+			t.Fatal("Expected not to have lock but we do")
+		}
+	}
+}
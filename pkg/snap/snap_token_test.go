@@ -29,11 +29,15 @@ one-time-token
 token-invalid-timestamp|-10a
 token-expired|%d
 token-not-expired|%d
-`, now-300, now+300)
+abcdef.one-time-secret
+ghijkl.expired-secret|%d
+mnopqr.not-expired-secret|%d
+`, now-300, now+300, now-300, now+300)
 
 	persistentClusterTokens := `
 persistent-token
 persistent-token-2
+stuvwx.persistent-secret
 `
 
 	if err := os.WriteFile("testdata/credentials/cluster-tokens.txt", []byte(clusterTokens), 0600); err != nil {
@@ -42,6 +46,32 @@ persistent-token-2
 	if err := os.WriteFile("testdata/credentials/persistent-cluster-tokens.txt", []byte(persistentClusterTokens), 0600); err != nil {
 		t.Fatalf("Failed to create test persistent-cluster-tokens.txt file: %s", err)
 	}
+	t.Run("IsValidDoesNotConsume", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			if !s.IsValidClusterToken("one-time-token") {
+				t.Fatal("Expected one-time-token to be valid, but it is not")
+			}
+		}
+		if s.IsValidClusterToken("token-expired") {
+			t.Fatal("Expected token-expired to not be valid, but it is")
+		}
+	})
+	t.Run("FindDoesNotConsume", func(t *testing.T) {
+		for id, expectedToken := range map[string]string{
+			"abcdef":         "abcdef.one-time-secret",
+			"ghijkl":         "",
+			"mnopqr":         "mnopqr.not-expired-secret",
+			"stuvwx":         "stuvwx.persistent-secret",
+			"one-time-token": "",
+			"":               "",
+		} {
+			for i := 0; i < 3; i++ {
+				if token, _ := s.FindClusterToken(id); token != expectedToken {
+					t.Fatalf("Expected token %q for token ID %q, but got %q", expectedToken, id, token)
+				}
+			}
+		}
+	})
 	for _, tc := range []struct {
 		token         string
 		expectedValid bool
@@ -164,6 +194,71 @@ func TestCallbackTokens(t *testing.T) {
 	}
 }
 
+func TestRemoveCallbackToken(t *testing.T) {
+	if err := os.MkdirAll("testdata/credentials", 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %s", err)
+	}
+	defer os.RemoveAll("testdata/credentials")
+	s := snap.NewSnap("testdata", "testdata", "testdata")
+	if err := s.RemoveCallbackToken("ip:port"); err != nil {
+		t.Fatalf("Expected no error when tokens file is missing, but got %q", err)
+	}
+	for _, endpoint := range []string{"ip:port", "ip:port2", "Node-1:port"} {
+		if err := s.AddCallbackToken(endpoint, "my-token"); err != nil {
+			t.Fatalf("Failed to add callback token: %s", err)
+		}
+	}
+	for _, endpoint := range []string{"ip:port", "node-1:port"} {
+		if err := s.RemoveCallbackToken(endpoint); err != nil {
+			t.Fatalf("Failed to remove callback token: %s", err)
+		}
+	}
+	contents, err := util.ReadFile("testdata/credentials/callback-tokens.txt")
+	if err != nil {
+		t.Fatalf("Failed to retrieve tokens: %s", err)
+	}
+	if contents != "ip:port2 my-token\n" {
+		t.Fatalf("Expected only callback token of ip:port2 to remain, but tokens file is %q", contents)
+	}
+}
+
+func TestRevokeCertificateRequestTokens(t *testing.T) {
+	if err := os.MkdirAll("testdata/credentials", 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %s", err)
+	}
+	defer os.RemoveAll("testdata/credentials")
+	s := snap.NewSnap("testdata", "testdata", "testdata")
+	if tokens, err := s.RevokeCertificateRequestTokens("node-1"); err != nil || len(tokens) > 0 {
+		t.Fatalf("Expected no tokens and no error for unknown node, but got %v and %q", tokens, err)
+	}
+	for _, token := range []string{"token-1", "token-2|12345", "token-3"} {
+		if err := s.AddCertificateRequestToken(token); err != nil {
+			t.Fatalf("Failed to add certificate request token: %s", err)
+		}
+	}
+	if err := s.TrackCertificateRequestTokens("node-1", "10.0.0.1", "token-1", "token-2"); err != nil {
+		t.Fatalf("Failed to track certificate request tokens: %s", err)
+	}
+	if err := s.TrackCertificateRequestTokens("node-2", "10.0.0.2", "token-3"); err != nil {
+		t.Fatalf("Failed to track certificate request tokens: %s", err)
+	}
+
+	tokens, err := s.RevokeCertificateRequestTokens("node-1")
+	if err != nil {
+		t.Fatalf("Failed to revoke certificate request tokens: %s", err)
+	}
+	if strings.Join(tokens, ",") != "token-1,token-2" {
+		t.Fatalf("Expected token-1 and token-2 to be revoked, but revoked %v", tokens)
+	}
+	contents, err := util.ReadFile("testdata/credentials/certs-request-tokens.txt")
+	if err != nil {
+		t.Fatalf("Failed to retrieve tokens: %s", err)
+	}
+	if contents != "token-3\n" {
+		t.Fatalf("Expected only token-3 to remain, but tokens file is %q", contents)
+	}
+}
+
 func TestSelfCallbackToken(t *testing.T) {
 	if err := os.MkdirAll("testdata/credentials", 0755); err != nil {
 		t.Fatalf("Failed to create test directory: %s", err)
@@ -189,6 +284,47 @@ func TestSelfCallbackToken(t *testing.T) {
 	}
 }
 
+func TestAdminToken(t *testing.T) {
+	if err := os.MkdirAll("testdata/credentials", 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %s", err)
+	}
+	defer os.RemoveAll("testdata/credentials")
+	s := snap.NewSnap("testdata", "testdata", "testdata")
+	if s.IsAdminTokenValid("") {
+		t.Fatal("Expected empty admin token to be invalid, but it is not")
+	}
+	token, err := s.GetOrCreateAdminToken()
+	if err != nil {
+		t.Fatalf("Failed to configure admin token: %q", err)
+	}
+	if token == "" {
+		t.Fatalf("Expected token to not be empty, but it is")
+	}
+	if !s.IsAdminTokenValid(token) {
+		t.Fatal("Expected admin token to be valid, but it is not")
+	}
+	if s.IsAdminTokenValid("other-token") {
+		t.Fatal("Expected other-token to not be a valid admin token, but it is")
+	}
+	if callbackToken, err := s.GetOrCreateSelfCallbackToken(); err != nil || s.IsAdminTokenValid(callbackToken) {
+		t.Fatalf("Expected callback token to not be a valid admin token, but it is (error %v)", err)
+	}
+	tokenAgain, err := s.GetOrCreateAdminToken()
+	if err != nil {
+		t.Fatalf("Failed to retrieve admin token: %q", err)
+	}
+	if tokenAgain != token {
+		t.Fatalf("Expected tokens to match, but they do not (%q and %q)", token, tokenAgain)
+	}
+	info, err := os.Stat("testdata/credentials/cluster-agent-admin-token.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat admin token file: %q", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected admin token file to only be readable by owner, but mode is %v", info.Mode())
+	}
+}
+
 func TestKnownTokens(t *testing.T) {
 	if err := os.MkdirAll("testdata/credentials", 0755); err != nil {
 		t.Fatalf("Failed to create test directory: %s", err)
@@ -255,9 +391,73 @@ token2,system:node:existing-host,kubelet-0123,"system:nodes"
 				t.Fatalf("Expected tokens to match, but they do not (%q != %q)", token, newToken)
 			}
 		})
+		t.Run("Revoke", func(t *testing.T) {
+			if err := s.RevokeKubeletToken("existing-host"); err != nil {
+				t.Fatalf("Expected no errors, but received %q", err)
+			}
+			if token, err := s.GetKnownToken("system:node:existing-host"); err == nil {
+				t.Fatalf("Expected kubelet token to be revoked, but found token %q", token)
+			}
+			if token, err := s.GetKnownToken("admin"); err != nil || token != "admin-token" {
+				t.Fatalf("Expected other tokens to be kept, but found token %q and error %q", token, err)
+			}
+			if err := s.RevokeKubeletToken("missing-host"); err != nil {
+				t.Fatalf("Expected no errors for missing host, but received %q", err)
+			}
+		})
 	})
 }
 
+func TestNodeCertificates(t *testing.T) {
+	if err := os.MkdirAll("testdata/credentials", 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %s", err)
+	}
+	defer os.RemoveAll("testdata/credentials")
+	s := snap.NewSnap("testdata", "testdata", "testdata")
+	if err := s.TrackIssuedCertificate("token-1", "10.0.0.1", "AA"); err != nil {
+		t.Fatalf("Expected no error when no tokens are tracked, but got %q", err)
+	}
+	// node-1 and node-2 join using the same token
+	if err := s.TrackCertificateRequestTokens("node-1", "10.0.0.1", "token-1-kubelet", "token-1-proxy"); err != nil {
+		t.Fatalf("Failed to track certificate request tokens: %s", err)
+	}
+	if err := s.TrackCertificateRequestTokens("node-2", "10.0.0.2", "token-1-kubelet", "token-1-proxy"); err != nil {
+		t.Fatalf("Failed to track certificate request tokens: %s", err)
+	}
+	for _, tc := range []struct{ token, remoteIP, serial string }{
+		{token: "token-1-kubelet", remoteIP: "10.0.0.2", serial: "1A"},
+		{token: "token-1-kubelet", remoteIP: "10.0.0.1", serial: "2B"},
+		{token: "token-1-proxy", remoteIP: "10.0.0.1", serial: "3C"},
+		{token: "token-1-proxy", remoteIP: "10.0.0.1", serial: "4D"},
+		{token: "token-1-proxy", remoteIP: "10.0.0.3", serial: "5E"},
+		{token: "untracked-token", remoteIP: "10.0.0.1", serial: "6F"},
+	} {
+		if err := s.TrackIssuedCertificate(tc.token, tc.remoteIP, tc.serial); err != nil {
+			t.Fatalf("Failed to track issued certificate: %s", err)
+		}
+	}
+	if err := s.TrackNodeCertificates("node-1", "7A"); err != nil {
+		t.Fatalf("Failed to track node certificates: %s", err)
+	}
+
+	serials, err := s.ForgetNodeCertificates("node-1")
+	if err != nil {
+		t.Fatalf("Failed to forget node certificates: %s", err)
+	}
+	if strings.Join(serials, ",") != "2B,3C,7A" {
+		t.Fatalf("Expected certificates 2B, 3C and 7A for node-1, but got %v", serials)
+	}
+	if serials, err := s.ForgetNodeCertificates("node-1"); err != nil || len(serials) > 0 {
+		t.Fatalf("Expected no more certificates for node-1, but got %v and %q", serials, err)
+	}
+	if serials, err := s.ForgetNodeCertificates("node-2"); err != nil || strings.Join(serials, ",") != "1A" {
+		t.Fatalf("Expected certificate 1A for node-2, but got %v and %q", serials, err)
+	}
+	if _, err := os.Stat("testdata/credentials/denied-certificates.txt"); !os.IsNotExist(err) {
+		t.Fatalf("Expected no deny list to be written, but got %v", err)
+	}
+}
+
 func TestStrictGroup(t *testing.T) {
 	if err := os.MkdirAll("testdata/meta", 0755); err != nil {
 		t.Fatalf("Failed to create test directory: %s", err)
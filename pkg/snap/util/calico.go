@@ -26,25 +26,39 @@ var (
 //
 // Optionally, the new manifest may be applied using the microk8s-kubectl.wrapper script.
 func MaybePatchCalicoAutoDetectionMethod(ctx context.Context, s snap.Snap, canReachHost string, apply bool) error {
+	return MaybePatchCalicoAutoDetectionMethods(ctx, s, []string{canReachHost}, apply)
+}
+
+// MaybePatchCalicoAutoDetectionMethods is like MaybePatchCalicoAutoDetectionMethod, but accepts an address of each
+// family for dual-stack clusters. IP_AUTODETECTION_METHOD is updated using the first IPv4 address, and
+// IP6_AUTODETECTION_METHOD is updated using the first IPv6 address. The manifest is applied at most once.
+func MaybePatchCalicoAutoDetectionMethods(ctx context.Context, s snap.Snap, canReachHosts []string, apply bool) error {
 	config, err := s.ReadCNIYaml()
 	if err != nil {
 		return fmt.Errorf("failed to read existing cni configuration: %w", err)
 	}
 
-	var re *regexp.Regexp
-	ip := net.ParseIP(canReachHost)
-	if ip == nil {
-		return fmt.Errorf("could not parse IP address %q", canReachHost)
-	}
-	if ip.To4() == nil {
-		// Address is in IPv6
-		re = ip6AutodetectionMethodRe
-	} else {
-		// Address is in IPv4
-		re = ipAutodetectionMethodRe
+	var patchedIPv4, patchedIPv6 bool
+	newConfig := config
+	for _, canReachHost := range canReachHosts {
+		ip := net.ParseIP(canReachHost)
+		if ip == nil {
+			return fmt.Errorf("could not parse IP address %q", canReachHost)
+		}
+		var re *regexp.Regexp
+		switch {
+		case ip.To4() != nil && !patchedIPv4:
+			// Address is in IPv4
+			re, patchedIPv4 = ipAutodetectionMethodRe, true
+		case ip.To4() == nil && !patchedIPv6:
+			// Address is in IPv6
+			re, patchedIPv6 = ip6AutodetectionMethodRe, true
+		default:
+			continue
+		}
+		newConfig = re.ReplaceAllString(newConfig, fmt.Sprintf("${1}can-reach=%s", canReachHost))
 	}
 
-	newConfig := re.ReplaceAllString(config, fmt.Sprintf("${1}can-reach=%s", canReachHost))
 	if newConfig == config {
 		return nil
 	}
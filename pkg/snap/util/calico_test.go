@@ -125,3 +125,74 @@ func TestMaybePatchCalicoAutoDetectionMethodBadIP(t *testing.T) {
 	err := snaputil.MaybePatchCalicoAutoDetectionMethod(context.Background(), snap, canReachHost, true)
 	g.Expect(err).NotTo(BeNil())
 }
+
+func TestMaybePatchCalicoAutoDetectionMethods(t *testing.T) {
+	firstFound := `
+- name: IP_AUTODETECTION_METHOD
+  value: "first-found"
+- name: IP6_AUTODETECTION_METHOD
+  value: "first-found"`
+	for _, tc := range []struct {
+		name                 string
+		canReachHosts        []string
+		expectYAML           string
+		expectApplyCNICalled int
+	}{
+		{
+			name:          "DualStack",
+			canReachHosts: []string{"10.10.10.10", "fd00::10"},
+			expectYAML: `
+- name: IP_AUTODETECTION_METHOD
+  value: "can-reach=10.10.10.10"
+- name: IP6_AUTODETECTION_METHOD
+  value: "can-reach=fd00::10"`,
+			expectApplyCNICalled: 1,
+		},
+		{
+			name:          "DualStackIPv6Primary",
+			canReachHosts: []string{"fd00::10", "10.10.10.10"},
+			expectYAML: `
+- name: IP_AUTODETECTION_METHOD
+  value: "can-reach=10.10.10.10"
+- name: IP6_AUTODETECTION_METHOD
+  value: "can-reach=fd00::10"`,
+			expectApplyCNICalled: 1,
+		},
+		{
+			name:          "FirstOfEachFamily",
+			canReachHosts: []string{"fd00::10", "fd00::11", "10.10.10.10", "10.10.10.11"},
+			expectYAML: `
+- name: IP_AUTODETECTION_METHOD
+  value: "can-reach=10.10.10.10"
+- name: IP6_AUTODETECTION_METHOD
+  value: "can-reach=fd00::10"`,
+			expectApplyCNICalled: 1,
+		},
+		{
+			name:          "IPv6Only",
+			canReachHosts: []string{"fd00::10"},
+			expectYAML: `
+- name: IP_AUTODETECTION_METHOD
+  value: "first-found"
+- name: IP6_AUTODETECTION_METHOD
+  value: "can-reach=fd00::10"`,
+			expectApplyCNICalled: 1,
+		},
+		{
+			name:       "NoAddresses",
+			expectYAML: firstFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			snap := &mock.Snap{
+				CNIYaml: firstFound,
+			}
+
+			err := snaputil.MaybePatchCalicoAutoDetectionMethods(context.Background(), snap, tc.canReachHosts, true)
+			g.Expect(err).To(BeNil())
+			g.Expect(snap.CNIYaml).To(Equal(tc.expectYAML))
+			g.Expect(snap.ApplyCNICalled).To(HaveLen(tc.expectApplyCNICalled))
+		})
+	}
+}
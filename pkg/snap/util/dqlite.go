@@ -3,11 +3,14 @@ package snaputil
 import (
 	"context"
 	"fmt"
+	"log"
 	"net"
 	"strings"
 	"time"
 
+	"github.com/canonical/microk8s-cluster-agent/pkg/dqlite"
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
 	"gopkg.in/yaml.v2"
 )
 
@@ -72,77 +75,151 @@ func UpdateDqliteIP(ctx context.Context, s snap.Snap, host string) error {
 	return nil
 }
 
-// WaitForDqliteCluster queries the dqlite cluster nodes repeatedly until f(cluster) becomes true.
+// dqliteClusterGracePeriod is how long WaitForDqliteCluster tolerates errors reading the cluster.yaml file.
+// The file may be briefly missing or partially written while k8s-dqlite restarts.
+const dqliteClusterGracePeriod = 30 * time.Second
+
+// WaitForDqliteCluster queries the dqlite cluster nodes until f(cluster) becomes true.
+// The cluster.yaml file is re-read whenever it changes on disk, and at least once every second.
 func WaitForDqliteCluster(ctx context.Context, s snap.Snap, f func(DqliteCluster) (bool, error)) (DqliteCluster, error) {
-	interval := time.NewTicker(time.Second)
-	for {
-		cluster, err := GetDqliteCluster(s)
+	var cluster DqliteCluster
+	err := util.WaitForFileCondition(ctx, util.FileWaitOptions{
+		Path:         s.GetSnapDataPath("var", "kubernetes", "backend", "cluster.yaml"),
+		PollInterval: time.Second,
+		GracePeriod:  dqliteClusterGracePeriod,
+		OnProgress: func(elapsed time.Duration, lastErr error) {
+			if lastErr != nil {
+				log.Printf("Waiting for dqlite cluster for %v, last error was %q", elapsed.Round(time.Second), lastErr)
+			} else {
+				log.Printf("Waiting for dqlite cluster for %v, current nodes are %v", elapsed.Round(time.Second), cluster)
+			}
+		},
+	}, func() (bool, error) {
+		c, err := GetDqliteCluster(s)
 		if err != nil {
-			return DqliteCluster{}, err
+			return false, err
 		}
+		cluster = c
 
-		ok, err := f(cluster)
+		ok, err := f(c)
 		if err != nil {
-			return DqliteCluster{}, fmt.Errorf("failed check for cluster condition: %w", err)
-		}
-		if ok {
-			return cluster, nil
+			return false, util.PermanentError(fmt.Errorf("failed check for cluster condition: %w", err))
 		}
+		return ok, nil
+	})
+	if err != nil {
+		return DqliteCluster{}, err
+	}
+	return cluster, nil
+}
 
-		select {
-		case <-ctx.Done():
-			return DqliteCluster{}, fmt.Errorf("timed out waiting for cluster condition: %w", ctx.Err())
-		case <-interval.C:
-		}
+// isSameHost returns true if the host of a dqlite node address (host:port) matches host.
+// IP addresses are compared in canonical form, so that different representations of IPv6 addresses match.
+func isSameHost(address string, host string) bool {
+	addressHost, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
 	}
+	if ip1, ip2 := net.ParseIP(addressHost), net.ParseIP(host); ip1 != nil && ip2 != nil {
+		return ip1.Equal(ip2)
+	}
+	return addressHost == host
 }
 
 // MaybeUpdateDqliteBindAddress checks if the node is part of a dqlite cluster and updates it if necessary.
 // It ensures the node's hostPort is included in the cluster configuration.
-func MaybeUpdateDqliteBindAddress(ctx context.Context, snap snap.Snap, hostPort string, remoteIP string, findMatchingBindAddress func(string) (string, error)) error {
+// MaybeUpdateDqliteBindAddress returns true if the dqlite bind address was changed, even if it fails afterwards.
+func MaybeUpdateDqliteBindAddress(ctx context.Context, snap snap.Snap, hostPort string, remoteIP string, findMatchingBindAddress func(string) (string, error)) (bool, error) {
 	// Check node is not in cluster already.
 	dqliteCluster, err := WaitForDqliteCluster(ctx, snap, func(c DqliteCluster) (bool, error) {
 		return len(c) >= 1, nil
 	})
 	if err != nil {
-		return fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err)
+		return false, fmt.Errorf("failed to retrieve dqlite cluster nodes: %w", err)
 	}
 	for _, node := range dqliteCluster {
-		if strings.HasPrefix(node.Address, remoteIP+":") {
-			return fmt.Errorf("the joining node (%s) is already known to dqlite", remoteIP)
+		if isSameHost(node.Address, remoteIP) {
+			return false, fmt.Errorf("the joining node (%s) is already known to dqlite", remoteIP)
 		}
 	}
 	// Update dqlite cluster if needed
 	if len(dqliteCluster) == 1 && strings.HasPrefix(dqliteCluster[0].Address, "127.0.0.1:") {
 		newDqliteBindAddress, err := findMatchingBindAddress(hostPort)
 		if err != nil {
-			return fmt.Errorf("failed to find matching dqlite bind address for %v: %w", hostPort, err)
+			return false, fmt.Errorf("failed to find matching dqlite bind address for %v: %w", hostPort, err)
 		}
 		if err := UpdateDqliteIP(ctx, snap, newDqliteBindAddress); err != nil {
-			return fmt.Errorf("failed to update dqlite address to %q: %w", newDqliteBindAddress, err)
+			return true, fmt.Errorf("failed to update dqlite address to %q: %w", newDqliteBindAddress, err)
 		}
 		// Wait for dqlite cluster to come up with new address
 		_, err = WaitForDqliteCluster(ctx, snap, func(c DqliteCluster) (bool, error) {
 			return len(c) >= 1 && !strings.HasPrefix(c[0].Address, "127.0.0.1:"), nil
 		})
 		if err != nil {
-			return fmt.Errorf("failed waiting for dqlite cluster to come up: %w", err)
+			return true, fmt.Errorf("failed waiting for dqlite cluster to come up: %w", err)
 		}
+		return true, nil
 	}
-	return nil
+	return false, nil
 }
 
-// RemoveNodeFromDqlite uses the Dqlite binary to remove a node from the Dqlite cluster.
-func RemoveNodeFromDqlite(ctx context.Context, snap snap.Snap, removeEp string) error {
-	binPath := snap.GetSnapPath("bin", "dqlite")
-	clusterYamlPath := snap.GetSnapDataPath("var", "kubernetes", "backend", "cluster.yaml")
-	clusterCrtPath := snap.GetSnapDataPath("var", "kubernetes", "backend", "cluster.crt")
-	clusterKeyPath := snap.GetSnapDataPath("var", "kubernetes", "backend", "cluster.key")
+// NewDqliteClient creates a dqlite client for the local dqlite cluster.
+// The client talks to the dqlite nodes directly using the cluster certificate, and falls back to the
+// dqlite binary shipped with the snap if that fails.
+func NewDqliteClient(s snap.Snap) dqlite.Client {
+	cliClient := &dqlite.CLIClient{
+		BinaryPath:           s.GetSnapPath("bin", "dqlite"),
+		ClusterYamlPath:      s.GetSnapDataPath("var", "kubernetes", "backend", "cluster.yaml"),
+		CertPath:             s.GetSnapDataPath("var", "kubernetes", "backend", "cluster.crt"),
+		KeyPath:              s.GetSnapDataPath("var", "kubernetes", "backend", "cluster.key"),
+		Database:             "k8s",
+		RunCommand:           s.RunCommand,
+		RunCommandWithOutput: util.RunCommandWithOutput,
+	}
+
+	cert, err := s.ReadDqliteCert()
+	if err != nil {
+		log.Printf("[WARNING] failed to read dqlite cluster certificate, will use the dqlite binary: %v", err)
+		return cliClient
+	}
+	key, err := s.ReadDqliteKey()
+	if err != nil {
+		log.Printf("[WARNING] failed to read dqlite cluster key, will use the dqlite binary: %v", err)
+		return cliClient
+	}
+	tlsConfig, err := dqlite.NewTLSConfig([]byte(cert), []byte(key))
+	if err != nil {
+		log.Printf("[WARNING] failed to load dqlite cluster certificate, will use the dqlite binary: %v", err)
+		return cliClient
+	}
+
+	return dqlite.WithFallback(dqlite.NewClient(dqliteNodeStore(s), tlsConfig), cliClient)
+}
 
-	// NOTE(Hue): The last two arguments (.remove <address>) should be a single string. Otherwise Dqlite throws an error.
-	if err := snap.RunCommand(ctx, binPath, "-s", "file://"+clusterYamlPath, "-c", clusterCrtPath, "-k", clusterKeyPath, "-f", "json", "k8s", fmt.Sprintf(".remove %s", removeEp)); err != nil {
-		return fmt.Errorf("failed to run remove command: %w", err)
+// dqliteNodeStore returns the addresses of the dqlite nodes known in the cluster.yaml file.
+func dqliteNodeStore(s snap.Snap) dqlite.NodeStore {
+	return func(ctx context.Context) ([]string, error) {
+		cluster, err := GetDqliteCluster(s)
+		if err != nil {
+			return nil, err
+		}
+		addresses := make([]string, 0, len(cluster))
+		for _, node := range cluster {
+			addresses = append(addresses, node.Address)
+		}
+		return addresses, nil
 	}
+}
 
+// RemoveNodeFromDqlite removes a node from the Dqlite cluster.
+func RemoveNodeFromDqlite(ctx context.Context, snap snap.Snap, removeEp string) error {
+	return RemoveNodeFromDqliteWithClient(ctx, NewDqliteClient(snap), removeEp)
+}
+
+// RemoveNodeFromDqliteWithClient removes a node from the Dqlite cluster using the specified client.
+func RemoveNodeFromDqliteWithClient(ctx context.Context, client dqlite.Client, removeEp string) error {
+	if err := client.Remove(ctx, removeEp); err != nil {
+		return fmt.Errorf("failed to remove %s from dqlite: %w", removeEp, err)
+	}
 	return nil
 }
@@ -107,11 +107,30 @@ Role: 0`,
 		}
 
 		g := NewWithT(t)
-		err := snaputil.MaybeUpdateDqliteBindAddress(context.Background(), s, "127.0.0.1", "127.0.0.1", findMatchingBindAddressMock)
+		updated, err := snaputil.MaybeUpdateDqliteBindAddress(context.Background(), s, "127.0.0.1", "127.0.0.1", findMatchingBindAddressMock)
 		g.Expect(err).To(MatchError("the joining node (127.0.0.1) is already known to dqlite"))
+		g.Expect(updated).To(BeFalse())
 
 	})
 
+	t.Run("MustFailIfIPv6NodeAlreadyKnown", func(t *testing.T) {
+		s := &mock.Snap{
+			DqliteClusterYaml: `
+- Address: "[fd00::11]:19001"
+  ID: 1236189235178654365
+  Role: 0`,
+			DqliteInfoYaml: `
+Address: "[fd00::11]:19001"
+ID: 1236189235178654365
+Role: 0`,
+		}
+
+		g := NewWithT(t)
+		updated, err := snaputil.MaybeUpdateDqliteBindAddress(context.Background(), s, "[fd00::10]:19001", "fd00:0::11", findMatchingBindAddressMock)
+		g.Expect(err).To(MatchError("the joining node (fd00:0::11) is already known to dqlite"))
+		g.Expect(updated).To(BeFalse())
+	})
+
 	t.Run("MustNotUpdateIfMultipleNodes", func(t *testing.T) {
 		s := &mock.Snap{
 			DqliteClusterYaml: `
@@ -128,8 +147,9 @@ Role: 0`,
 		}
 
 		g := NewWithT(t)
-		err := snaputil.MaybeUpdateDqliteBindAddress(context.Background(), s, "127.0.0.1:19001", "8.8.8.8", findMatchingBindAddressMock)
+		updated, err := snaputil.MaybeUpdateDqliteBindAddress(context.Background(), s, "127.0.0.1:19001", "8.8.8.8", findMatchingBindAddressMock)
 		g.Expect(err).To(BeNil())
+		g.Expect(updated).To(BeFalse())
 		g.Expect(s.WriteDqliteUpdateYamlCalledWith).To(BeEmpty())
 	})
 
@@ -158,8 +178,9 @@ Role: 0`,
 		}()
 
 		g := NewWithT(t)
-		err := snaputil.MaybeUpdateDqliteBindAddress(context.Background(), s, "127.0.0.1:19001", "10.10.10.10", findMatchingBindAddressMock)
+		updated, err := snaputil.MaybeUpdateDqliteBindAddress(context.Background(), s, "127.0.0.1:19001", "10.10.10.10", findMatchingBindAddressMock)
 		g.Expect(err).To(BeNil())
+		g.Expect(updated).To(BeTrue())
 		g.Expect(s.WriteDqliteUpdateYamlCalledWith).To(ConsistOf("Address: 10.10.10.10:19001\n"))
 	})
 }
@@ -3,6 +3,9 @@ package snaputil
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
+	"time"
 
 	"github.com/canonical/microk8s-cluster-agent/pkg/snap"
 	v1 "k8s.io/api/core/v1"
@@ -24,6 +27,44 @@ func parseNodeInternalIPs(nodeList []v1.Node) []string {
 	return addresses
 }
 
+// findNodeByIP returns the name of the node with an address equal to ip, or an empty string.
+func findNodeByIP(nodeList []v1.Node, ip string) string {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return ""
+	}
+	for _, node := range nodeList {
+		for _, address := range node.Status.Addresses {
+			if (address.Type == v1.NodeInternalIP || address.Type == v1.NodeExternalIP) && parsedIP.Equal(net.ParseIP(address.Address)) {
+				return node.Name
+			}
+		}
+	}
+	return ""
+}
+
+// GetNodeNameByIP returns the name of the Kubernetes Node with the internal or external IP address ip. It returns an
+// error if no such node exists.
+func GetNodeNameByIP(ctx context.Context, s snap.Snap, ip string) (string, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", s.GetKubeconfigFile())
+	if err != nil {
+		return "", fmt.Errorf("failed to read load kubeconfig: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize kubernetes client: %w", err)
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if name := findNodeByIP(nodes.Items, ip); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("no node with address %s", ip)
+}
+
 // ListControlPlaneNodeIPs returns the internal IPs of the control plane nodes of the MicroK8s cluster.
 func ListControlPlaneNodeIPs(ctx context.Context, s snap.Snap) ([]string, error) {
 	config, err := clientcmd.BuildConfigFromFlags("", s.GetKubeconfigFile())
@@ -44,3 +85,40 @@ func ListControlPlaneNodeIPs(ctx context.Context, s snap.Snap) ([]string, error)
 
 	return parseNodeInternalIPs(nodes.Items), nil
 }
+
+// DrainNode cordons a node and evicts all pods running on it, using the microk8s-kubectl.wrapper script.
+// DaemonSet pods are ignored. DrainNode gives up after timeout.
+func DrainNode(ctx context.Context, s snap.Snap, nodeName string, timeout time.Duration) error {
+	if err := s.RunCommand(ctx, s.GetSnapPath("microk8s-kubectl.wrapper"), "drain", nodeName, "--ignore-daemonsets", "--delete-emptydir-data", "--force", fmt.Sprintf("--timeout=%s", timeout)); err != nil {
+		return fmt.Errorf("failed to drain node %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+// DeleteNode deletes a Node object from the cluster, using the microk8s-kubectl.wrapper script.
+// DeleteNode does not fail if the node does not exist.
+func DeleteNode(ctx context.Context, s snap.Snap, nodeName string) error {
+	if err := s.RunCommand(ctx, s.GetSnapPath("microk8s-kubectl.wrapper"), "delete", "node", nodeName, "--ignore-not-found"); err != nil {
+		return fmt.Errorf("failed to delete node %s: %w", nodeName, err)
+	}
+	return nil
+}
+
+// LabelNode sets labels on a Node object of the cluster, using the microk8s-kubectl.wrapper script.
+// Existing labels with the same keys are overwritten. LabelNode fails if the node does not exist.
+func LabelNode(ctx context.Context, s snap.Snap, nodeName string, labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := []string{s.GetSnapPath("microk8s-kubectl.wrapper"), "label", "node", nodeName, "--overwrite"}
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("%s=%s", key, labels[key]))
+	}
+	if err := s.RunCommand(ctx, args...); err != nil {
+		return fmt.Errorf("failed to label node %s: %w", nodeName, err)
+	}
+	return nil
+}
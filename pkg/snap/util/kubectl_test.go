@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestParseNodeInternalIPs(t *testing.T) {
@@ -33,3 +34,39 @@ func TestParseNodeInternalIPs(t *testing.T) {
 		t.Fatalf("expected list of nodes to be %v but it was %v instead", expectedIPs, ips)
 	}
 }
+
+func TestFindNodeByIP(t *testing.T) {
+	nodeList := []v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeHostName, Address: "10.0.0.3"},
+					{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+					{Type: v1.NodeInternalIP, Address: "fd00::2"},
+				},
+			},
+		},
+	}
+	for ip, expectedName := range map[string]string{
+		"10.0.0.1":  "node-1",
+		"10.0.0.2":  "node-2",
+		"fd00:0::2": "node-2",
+		"10.0.0.3":  "",
+		"10.0.0.4":  "",
+		"not-an-ip": "",
+		"":          "",
+	} {
+		if name := findNodeByIP(nodeList, ip); name != expectedName {
+			t.Fatalf("expected node with IP %q to be %q but it was %q instead", ip, expectedName, name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	. "github.com/onsi/gomega"
+)
+
+func TestCertificateSerial(t *testing.T) {
+	g := NewWithT(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x1a2b3c),
+		Subject:      pkix.Name{CommonName: "system:node:node-1"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	serial, err := util.CertificateSerial(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(serial).To(Equal("1A2B3C"))
+
+	_, err = util.CertificateSerial([]byte("CERT DATA"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestParseCertificateRequest(t *testing.T) {
+	g := NewWithT(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "system:node:node-1", Organization: []string{"system:nodes"}},
+	}, key)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	csr, err := util.ParseCertificateRequest(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(csr.Subject.CommonName).To(Equal("system:node:node-1"))
+
+	_, err = util.ParseCertificateRequest([]byte("CSR DATA"))
+	g.Expect(err).To(HaveOccurred())
+
+	der[len(der)-1] ^= 0xff
+	_, err = util.ParseCertificateRequest(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestSignServingCertificate(t *testing.T) {
+	g := NewWithT(t)
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).ToNot(HaveOccurred())
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "10.152.183.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	caCertPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	caKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(caKey)})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "kube-apiserver", Organization: []string{"system:masters"}},
+		DNSNames:    []string{"node-1", "kubernetes"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}, key)
+	g.Expect(err).ToNot(HaveOccurred())
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	certPEM, err := util.SignServingCertificate(csr, caCertPEM, caKeyPEM, time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	block, _ := pem.Decode(certPEM)
+	g.Expect(block).ToNot(BeNil())
+	cert, err := x509.ParseCertificate(block.Bytes)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cert.Subject.CommonName).To(Equal("kube-apiserver"))
+	g.Expect(cert.Subject.Organization).To(BeEmpty())
+	g.Expect(cert.DNSNames).To(Equal([]string{"node-1", "kubernetes"}))
+	g.Expect(cert.IPAddresses).To(HaveLen(1))
+	g.Expect(cert.IPAddresses[0].Equal(net.ParseIP("10.0.0.1"))).To(BeTrue())
+	g.Expect(cert.ExtKeyUsage).To(Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}))
+
+	roots := x509.NewCertPool()
+	g.Expect(roots.AppendCertsFromPEM(caCertPEM)).To(BeTrue())
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "node-1"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = util.SignServingCertificate(csr, []byte("CA DATA"), caKeyPEM, time.Hour)
+	g.Expect(err).To(HaveOccurred())
+	_, err = util.SignServingCertificate(csr, caCertPEM, []byte("CA KEY DATA"), time.Hour)
+	g.Expect(err).To(HaveOccurred())
+}
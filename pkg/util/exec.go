@@ -22,3 +22,19 @@ func RunCommand(ctx context.Context, command ...string) error {
 	}
 	return nil
 }
+
+// RunCommandWithOutput executes a command with a given context and returns its standard output.
+// RunCommandWithOutput returns an error if the command does not complete successfully.
+func RunCommandWithOutput(ctx context.Context, command ...string) ([]byte, error) {
+	var args []string
+	if len(command) > 1 {
+		args = command[1:]
+	}
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("command %v failed with exit code %d: %w", command, cmd.ProcessState.ExitCode(), err)
+	}
+	return stdout, nil
+}
@@ -37,3 +37,22 @@ func TestExec(t *testing.T) {
 		}
 	})
 }
+
+func TestExecWithOutput(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		out, err := util.RunCommandWithOutput(context.Background(), "/bin/bash", "-c", "echo hello")
+		if err != nil {
+			t.Fatalf("Expected no errors, but received %q", err)
+		}
+		if string(out) != "hello\n" {
+			t.Fatalf("Expected output to be %q but it was %q instead", "hello\n", out)
+		}
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		_, err := util.RunCommandWithOutput(context.Background(), "/bin/bash", "-c", "exit 1")
+		if err == nil {
+			t.Fatal("Expected an error, but did not receive any")
+		}
+	})
+}
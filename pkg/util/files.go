@@ -41,12 +41,10 @@ func ParseArgumentLine(line string) (key string, value string) {
 	line = strings.TrimSpace(line)
 
 	// parse "--argument value" and "--argument=value" variants
-	if parts := strings.Split(line, "="); len(parts) >= 2 {
-		key = parts[0]
-		value = parts[1]
-	} else if parts := strings.Split(line, " "); len(parts) >= 2 {
-		key = parts[0]
-		value = strings.Join(parts[1:], " ")
+	// the value may contain "=" characters, e.g. "--node-labels=key=value"
+	if idx := strings.IndexAny(line, "= "); idx >= 0 {
+		key = line[:idx]
+		value = strings.TrimSpace(line[idx+1:])
 	} else {
 		key = line
 	}
@@ -55,6 +55,8 @@ func TestParseArgumentLine(t *testing.T) {
 		{line: "--key    ", key: "--key", value: ""},
 		{line: "--key=", key: "--key", value: ""},
 		{line: "--key=    ", key: "--key", value: ""},
+		{line: "--key=label=value", key: "--key", value: "label=value"},
+		{line: "--key label=value", key: "--key", value: "label=value"},
 	} {
 		t.Run(tc.line, func(t *testing.T) {
 			key, value := util.ParseArgumentLine(tc.line)
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// fingerprintPrefix is the prefix of certificate fingerprints, which identifies the hash algorithm.
+const fingerprintPrefix = "sha256:"
+
+// PublicKeyFingerprint returns the fingerprint of the public key of a PEM-encoded certificate, in "sha256:<hex>" format.
+// The fingerprint is the SHA-256 hash of the DER-encoded SubjectPublicKeyInfo, similar to the CA certificate hashes used
+// by "kubeadm join --discovery-token-ca-cert-hash". It does not change when the certificate is renewed with the same key.
+func PublicKeyFingerprint(certPEM []byte) (string, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return fingerprintPrefix + hex.EncodeToString(hash[:]), nil
+}
+
+// PinnedToken returns a cluster token pinned to a CA fingerprint, in "<token>@sha256:<hex>" format.
+// Joining nodes use the fingerprint to verify the certificate of the cluster agent before sending the token.
+func PinnedToken(token string, fingerprint string) string {
+	return fmt.Sprintf("%s@%s", token, fingerprint)
+}
+
+// SplitPinnedToken splits a cluster token created with PinnedToken into the token and the CA fingerprint.
+// For tokens that are not pinned, the fingerprint is empty.
+func SplitPinnedToken(pinnedToken string) (token string, fingerprint string) {
+	token, fingerprint, _ = strings.Cut(pinnedToken, "@")
+	return token, fingerprint
+}
+
+// IsValidFingerprint returns true if fingerprint is in "sha256:<hex>" format.
+func IsValidFingerprint(fingerprint string) bool {
+	b, err := hex.DecodeString(strings.TrimPrefix(fingerprint, fingerprintPrefix))
+	return strings.HasPrefix(fingerprint, fingerprintPrefix) && err == nil && len(b) == sha256.Size
+}
+
+// ErrInvalidFingerprint is returned by VerifyPublicKeyFingerprint if the expected fingerprint is not in "sha256:<hex>" format.
+var ErrInvalidFingerprint = errors.New("invalid fingerprint")
+
+// ErrFingerprintMismatch is returned by VerifyPublicKeyFingerprint if the certificate has a different fingerprint.
+var ErrFingerprintMismatch = errors.New("fingerprint mismatch")
+
+// VerifyPublicKeyFingerprint checks that the public key fingerprint of a PEM-encoded certificate is fingerprint.
+// See PublicKeyFingerprint.
+func VerifyPublicKeyFingerprint(certPEM []byte, fingerprint string) error {
+	if !IsValidFingerprint(fingerprint) {
+		return fmt.Errorf("%w %q", ErrInvalidFingerprint, fingerprint)
+	}
+	actual, err := PublicKeyFingerprint(certPEM)
+	if err != nil {
+		return fmt.Errorf("failed to compute fingerprint: %w", err)
+	}
+	if actual != fingerprint {
+		return fmt.Errorf("%w: expected %s, but got %s", ErrFingerprintMismatch, fingerprint, actual)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package util_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	. "github.com/onsi/gomega"
+)
+
+func TestPublicKeyFingerprint(t *testing.T) {
+	g := NewWithT(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+	newCertificate := func(serial int64) []byte {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "10.152.183.1"},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		g.Expect(err).ToNot(HaveOccurred())
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	hash := sha256.Sum256(spki)
+	expected := "sha256:" + hex.EncodeToString(hash[:])
+
+	fingerprint, err := util.PublicKeyFingerprint(newCertificate(1))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(fingerprint).To(Equal(expected))
+	g.Expect(util.IsValidFingerprint(fingerprint)).To(BeTrue())
+
+	// renewed certificates with the same key have the same fingerprint
+	fingerprint, err = util.PublicKeyFingerprint(newCertificate(2))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(fingerprint).To(Equal(expected))
+
+	_, err = util.PublicKeyFingerprint([]byte("CA CERTIFICATE DATA"))
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(util.VerifyPublicKeyFingerprint(newCertificate(3), expected)).To(Succeed())
+	g.Expect(util.VerifyPublicKeyFingerprint(newCertificate(3), "sha256:"+hex.EncodeToString(make([]byte, 32)))).To(MatchError(util.ErrFingerprintMismatch))
+	g.Expect(util.VerifyPublicKeyFingerprint(newCertificate(3), "sha256:0123")).To(MatchError(util.ErrInvalidFingerprint))
+}
+
+func TestPinnedToken(t *testing.T) {
+	for _, tc := range []struct {
+		pinnedToken       string
+		expectToken       string
+		expectFingerprint string
+	}{
+		{pinnedToken: "abcdef", expectToken: "abcdef"},
+		{pinnedToken: "abcdef@sha256:0123", expectToken: "abcdef", expectFingerprint: "sha256:0123"},
+		{pinnedToken: util.PinnedToken("abcdef", "sha256:4567"), expectToken: "abcdef", expectFingerprint: "sha256:4567"},
+	} {
+		t.Run(tc.pinnedToken, func(t *testing.T) {
+			g := NewWithT(t)
+			token, fingerprint := util.SplitPinnedToken(tc.pinnedToken)
+			g.Expect(token).To(Equal(tc.expectToken))
+			g.Expect(fingerprint).To(Equal(tc.expectFingerprint))
+		})
+	}
+}
+
+func TestIsValidFingerprint(t *testing.T) {
+	for fingerprint, valid := range map[string]bool{
+		"sha256:" + hex.EncodeToString(make([]byte, 32)): true,
+		"sha256:" + hex.EncodeToString(make([]byte, 20)): false,
+		"sha1:" + hex.EncodeToString(make([]byte, 32)):   false,
+		"sha256:not-hex": false,
+		"":               false,
+	} {
+		t.Run(fingerprint, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(util.IsValidFingerprint(fingerprint)).To(Equal(valid))
+		})
+	}
+}
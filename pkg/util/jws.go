@@ -0,0 +1,73 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jwsHeader is the protected header of the detached JSON Web Signatures created by SignDetachedJWS.
+type jwsHeader struct {
+	// Algorithm is always "HS256".
+	Algorithm string `json:"alg"`
+	// KeyID is the ID of the cluster token used to sign the payload.
+	KeyID string `json:"kid"`
+}
+
+// SignDetachedJWS signs payload with a cluster token in "<id>.<secret>" format, see SplitClusterToken.
+// The signature is a JSON Web Signature (RFC 7515) with the HS256 algorithm, keyed with the full token, in compact
+// serialization with a detached payload ("<header>..<signature>"). This matches the signatures of the cluster-info
+// ConfigMap that "kubeadm join" verifies during token discovery.
+// Joining nodes that know the token secret can verify that the payload comes from a cluster agent that knows it too.
+func SignDetachedJWS(payload []byte, token string) (string, error) {
+	id, _, ok := SplitClusterToken(token)
+	if !ok {
+		return "", fmt.Errorf("token is not in <id>.<secret> format")
+	}
+	header, err := json.Marshal(jwsHeader{Algorithm: "HS256", KeyID: id})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWS header: %w", err)
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	return encodedHeader + ".." + jwsSignature(encodedHeader, payload, token), nil
+}
+
+// VerifyDetachedJWS verifies a detached JSON Web Signature created by SignDetachedJWS for payload with a cluster token.
+func VerifyDetachedJWS(jws string, payload []byte, token string) error {
+	id, _, ok := SplitClusterToken(token)
+	if !ok {
+		return fmt.Errorf("token is not in <id>.<secret> format")
+	}
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 || parts[1] != "" {
+		return fmt.Errorf("signature is not a detached JWS in compact serialization")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("failed to decode JWS header: %w", err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(b, &header); err != nil {
+		return fmt.Errorf("failed to parse JWS header: %w", err)
+	}
+	if header.Algorithm != "HS256" {
+		return fmt.Errorf("unsupported JWS algorithm %q", header.Algorithm)
+	}
+	if header.KeyID != id {
+		return fmt.Errorf("JWS is signed with token ID %q, not %q", header.KeyID, id)
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(jwsSignature(parts[0], payload, token))) {
+		return fmt.Errorf("invalid JWS signature")
+	}
+	return nil
+}
+
+// jwsSignature returns the base64url-encoded HS256 signature of a JWS with an encoded header and payload.
+func jwsSignature(encodedHeader string, payload []byte, token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(encodedHeader + "." + base64.RawURLEncoding.EncodeToString(payload)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,41 @@
+package util_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	. "github.com/onsi/gomega"
+)
+
+func TestDetachedJWS(t *testing.T) {
+	g := NewWithT(t)
+	payload := []byte("CA CERTIFICATE DATA")
+
+	jws, err := util.SignDetachedJWS(payload, "abcdef.0123456789abcdef")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(strings.Split(jws, ".")).To(HaveLen(3))
+	g.Expect(strings.Split(jws, ".")[1]).To(BeEmpty())
+	g.Expect(util.VerifyDetachedJWS(jws, payload, "abcdef.0123456789abcdef")).To(Succeed())
+
+	for name, verify := range map[string]func() error{
+		"OtherPayload": func() error { return util.VerifyDetachedJWS(jws, []byte("OTHER DATA"), "abcdef.0123456789abcdef") },
+		"OtherSecret":  func() error { return util.VerifyDetachedJWS(jws, payload, "abcdef.fedcba9876543210") },
+		"OtherID":      func() error { return util.VerifyDetachedJWS(jws, payload, "ghijkl.0123456789abcdef") },
+		"NotDetached": func() error {
+			return util.VerifyDetachedJWS(strings.Replace(jws, "..", ".e30.", 1), payload, "abcdef.0123456789abcdef")
+		},
+		"NoneAlgorithm": func() error {
+			return util.VerifyDetachedJWS("eyJhbGciOiJub25lIiwia2lkIjoiYWJjZGVmIn0..", payload, "abcdef.0123456789abcdef")
+		},
+		"InvalidToken": func() error { return util.VerifyDetachedJWS(jws, payload, "0123456789abcdef") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(verify()).ToNot(Succeed())
+		})
+	}
+
+	_, err = util.SignDetachedJWS(payload, "0123456789abcdef")
+	g.Expect(err).To(HaveOccurred())
+}
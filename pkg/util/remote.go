@@ -1,6 +1,9 @@
 package util
 
-import "net"
+import (
+	"net"
+	"strings"
+)
 
 // GetRemoteHost returns the hostname that should be used for communicating with the joining node.
 // The endpoint is either the hostname (if it can be resolved), or the remote IP address, as read from the HTTP request.
@@ -18,3 +21,22 @@ func GetRemoteHost(lookupIP func(string) ([]net.IP, error), hostname string, rem
 	}
 	return remoteHost
 }
+
+// RemoteIP returns the IP address of a remote address, as read from the HTTP request.
+// Requests from the same join share a remote IP, so it is used to tell apart joins that use the same token.
+func RemoteIP(remoteAddress string) string {
+	host, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		host = remoteAddress
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// NodeName returns the name of the Kubernetes Node registered by a host, which is its lowercase hostname.
+// Credentials issued to a node when it joins are recorded by NodeName, so that they can be revoked when it is removed.
+func NodeName(hostname string) string {
+	return strings.ToLower(hostname)
+}
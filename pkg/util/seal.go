@@ -0,0 +1,94 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// sealInfo is the HKDF info parameter used to derive keys for sealed messages.
+const sealInfo = "microk8s-cluster-agent sealed secret v1"
+
+// sealKey derives the AES-256 key for a sealed message from the X25519 shared secret and both public keys.
+func sealKey(sharedSecret, ephemeralPublicKey, recipientPublicKey []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeralPublicKey...), recipientPublicKey...)
+	return hkdf.Key(sha256.New, sharedSecret, salt, sealInfo, 32)
+}
+
+// Seal encrypts a message so that it can only be decrypted with the private key of recipientPublicKey, which is a raw
+// X25519 public key. The message is encrypted using AES-256-GCM with a key derived from an ephemeral X25519 key
+// exchange. The sealed message is the ephemeral public key, followed by the nonce and the ciphertext.
+func Seal(recipientPublicKey []byte, message []byte) ([]byte, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(recipientPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X25519 public key: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	sharedSecret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+	key, err := sealKey(sharedSecret, ephemeral.PublicKey().Bytes(), recipientPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	sealed := append([]byte{}, ephemeral.PublicKey().Bytes()...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, message, nil), nil
+}
+
+// Open decrypts a message sealed with Seal, using the X25519 private key of the recipient.
+func Open(privateKey *ecdh.PrivateKey, sealed []byte) ([]byte, error) {
+	const publicKeySize = 32
+	if len(sealed) < publicKeySize {
+		return nil, fmt.Errorf("sealed message is too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[:publicKeySize])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral public key: %w", err)
+	}
+	sharedSecret, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+	}
+	key, err := sealKey(sharedSecret, sealed[:publicKeySize], privateKey.PublicKey().Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	sealed = sealed[publicKeySize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed message is too short")
+	}
+	message, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return message, nil
+}
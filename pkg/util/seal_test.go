@@ -0,0 +1,49 @@
+package util_test
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	. "github.com/onsi/gomega"
+)
+
+func TestSeal(t *testing.T) {
+	g := NewWithT(t)
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	sealed, err := util.Seal(key.PublicKey().Bytes(), []byte("SERVICE ACCOUNT KEY DATA"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(sealed)).ToNot(ContainSubstring("SERVICE ACCOUNT KEY DATA"))
+
+	message, err := util.Open(key, sealed)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(message)).To(Equal("SERVICE ACCOUNT KEY DATA"))
+
+	t.Run("WrongKey", func(t *testing.T) {
+		g := NewWithT(t)
+		otherKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = util.Open(otherKey, sealed)
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		g := NewWithT(t)
+		tampered := append([]byte{}, sealed...)
+		tampered[len(tampered)-1] ^= 0xff
+		_, err := util.Open(key, tampered)
+		g.Expect(err).To(HaveOccurred())
+
+		_, err = util.Open(key, sealed[:40])
+		g.Expect(err).To(HaveOccurred())
+	})
+
+	t.Run("InvalidPublicKey", func(t *testing.T) {
+		g := NewWithT(t)
+		_, err := util.Seal([]byte("short"), []byte("message"))
+		g.Expect(err).To(HaveOccurred())
+	})
+}
@@ -72,6 +72,40 @@ func IsValidToken(token string, tokensFile string) (isValidToken, hasTTL bool) {
 	return false, false
 }
 
+// SplitClusterToken splits a cluster token in "<id>.<secret>" format into the token ID and the token secret.
+// Like kubeadm bootstrap tokens, the token ID is public and may be sent to the cluster agent before the joining node
+// has verified it, whereas the token secret must only be sent to a verified cluster agent. See SignDetachedJWS.
+// SplitClusterToken returns false for tokens that are not in "<id>.<secret>" format.
+func SplitClusterToken(token string) (id string, secret string, ok bool) {
+	id, secret, ok = strings.Cut(token, ".")
+	if !ok || id == "" || secret == "" || strings.ContainsAny(token, "|@") {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// FindTokenByID returns the valid token with the given token ID from tokensFile. See SplitClusterToken and IsValidToken.
+// FindTokenByID returns false if no valid token with the token ID exists.
+func FindTokenByID(id string, tokensFile string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	b, err := os.ReadFile(tokensFile)
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		token, _, _ := strings.Cut(strings.TrimSpace(line), "|")
+		if tokenID, _, ok := SplitClusterToken(token); !ok || tokenID != id {
+			continue
+		}
+		if isValid, _ := IsValidToken(token, tokensFile); isValid {
+			return token, true
+		}
+	}
+	return "", false
+}
+
 // AppendToken appends a token to a file.
 // Token files contain a single token in each line.
 func AppendToken(token string, tokensFile string, chownGroup string) error {
@@ -114,3 +148,33 @@ func RemoveToken(token string, tokensFile string, chownGroup string) error {
 	}
 	return nil
 }
+
+// RemoveMatchingTokens removes all lines of a tokens file for which match returns true.
+// RemoveMatchingTokens returns the list of removed lines. The tokens file is not modified if no lines match.
+// RemoveMatchingTokens will return an error if it fails to read or write the tokens file.
+func RemoveMatchingTokens(tokensFile string, chownGroup string, match func(line string) bool) ([]string, error) {
+	b, err := os.ReadFile(tokensFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", tokensFile, err)
+	}
+	var (
+		keepTokens    []string
+		removedTokens []string
+	)
+	for _, line := range strings.Split(string(b), "\n") {
+		if trimmed := strings.TrimSpace(line); trimmed != "" && match(trimmed) {
+			removedTokens = append(removedTokens, trimmed)
+			continue
+		}
+		keepTokens = append(keepTokens, line)
+	}
+	if len(removedTokens) == 0 {
+		return nil, nil
+	}
+	if err := os.WriteFile(tokensFile, []byte(strings.Join(keepTokens, "\n")), 0660); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", tokensFile, err)
+	}
+	// TODO: consider whether permissions should be 0600 instead
+	SetupPermissions(tokensFile, chownGroup)
+	return removedTokens, nil
+}
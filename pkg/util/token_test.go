@@ -0,0 +1,32 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	. "github.com/onsi/gomega"
+)
+
+func TestSplitClusterToken(t *testing.T) {
+	for _, tc := range []struct {
+		token        string
+		expectID     string
+		expectSecret string
+		expectOK     bool
+	}{
+		{token: "abcdef.0123456789abcdef", expectID: "abcdef", expectSecret: "0123456789abcdef", expectOK: true},
+		{token: "0123456789abcdef"},
+		{token: ".0123456789abcdef"},
+		{token: "abcdef."},
+		{token: "abcdef.0123456789abcdef|1700000000"},
+		{token: "abcdef.0123456789abcdef@sha256:0123"},
+	} {
+		t.Run(tc.token, func(t *testing.T) {
+			g := NewWithT(t)
+			id, secret, ok := util.SplitClusterToken(tc.token)
+			g.Expect(ok).To(Equal(tc.expectOK))
+			g.Expect(id).To(Equal(tc.expectID))
+			g.Expect(secret).To(Equal(tc.expectSecret))
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// permanentError is an error that must not be retried by WaitForFileCondition.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// PermanentError wraps err so that WaitForFileCondition returns it immediately instead of retrying.
+func PermanentError(err error) error {
+	return &permanentError{err: err}
+}
+
+// FileWaitOptions configures WaitForFileCondition.
+type FileWaitOptions struct {
+	// Path is the file to watch for changes. The parent directory is watched, so the file may not exist yet.
+	Path string
+	// PollInterval is the maximum time between checks. Checks are also performed whenever the file changes.
+	// If the file cannot be watched, the condition is only checked every PollInterval. Defaults to 1 second.
+	PollInterval time.Duration
+	// GracePeriod is how long errors may persist before WaitForFileCondition gives up. This is used to tolerate
+	// transient errors, e.g. the file being briefly absent or partially written. If zero, errors are returned immediately.
+	GracePeriod time.Duration
+	// ProgressInterval is how often OnProgress is called while waiting. Defaults to 5 seconds.
+	ProgressInterval time.Duration
+	// OnProgress is called periodically while waiting, with the time elapsed and the last error returned by the
+	// condition, if any. If nil, progress is logged.
+	OnProgress func(elapsed time.Duration, lastErr error)
+}
+
+// WaitForFileCondition evaluates condition until it returns true, or ctx is cancelled.
+// The condition is evaluated immediately, whenever opts.Path changes, and at least every opts.PollInterval.
+// Errors returned by condition are retried until they persist for longer than opts.GracePeriod, unless they are
+// wrapped with PermanentError, in which case they are returned immediately.
+func WaitForFileCondition(ctx context.Context, opts FileWaitOptions, condition func() (bool, error)) error {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 5 * time.Second
+	}
+	if opts.OnProgress == nil {
+		opts.OnProgress = func(elapsed time.Duration, lastErr error) {
+			if lastErr != nil {
+				log.Printf("Still waiting for %s after %v, last error was %q", opts.Path, elapsed.Round(time.Second), lastErr)
+			} else {
+				log.Printf("Still waiting for %s after %v", opts.Path, elapsed.Round(time.Second))
+			}
+		}
+	}
+
+	var (
+		changedCh <-chan fsnotify.Event
+		errorsCh  <-chan error
+	)
+	if watcher, err := fsnotify.NewWatcher(); err != nil {
+		log.Printf("[WARNING] failed to create file watcher, will poll %s for changes: %v", opts.Path, err)
+	} else {
+		defer watcher.Close()
+		if err := watcher.Add(filepath.Dir(opts.Path)); err != nil {
+			log.Printf("[WARNING] failed to watch %s, will poll for changes: %v", opts.Path, err)
+		} else {
+			changedCh = watcher.Events
+			errorsCh = watcher.Errors
+		}
+	}
+
+	start := time.Now()
+	poll := time.NewTicker(opts.PollInterval)
+	defer poll.Stop()
+	progress := time.NewTicker(opts.ProgressInterval)
+	defer progress.Stop()
+
+	var (
+		lastErr    error
+		firstErrAt time.Time
+	)
+	for {
+		ok, err := condition()
+		var permanentErr *permanentError
+		switch {
+		case errors.As(err, &permanentErr):
+			return permanentErr.err
+		case err != nil:
+			if lastErr == nil {
+				firstErrAt = time.Now()
+			}
+			lastErr = err
+			if time.Since(firstErrAt) >= opts.GracePeriod {
+				return err
+			}
+		case ok:
+			return nil
+		default:
+			lastErr = nil
+		}
+
+	waitForChange:
+		for {
+			select {
+			case <-ctx.Done():
+				if lastErr != nil {
+					return fmt.Errorf("timed out waiting for condition: %w (last error was %v)", ctx.Err(), lastErr)
+				}
+				return fmt.Errorf("timed out waiting for condition: %w", ctx.Err())
+			case <-progress.C:
+				opts.OnProgress(time.Since(start), lastErr)
+			case <-poll.C:
+				break waitForChange
+			case event, ok := <-changedCh:
+				if !ok {
+					// watcher closed, keep polling
+					changedCh = nil
+					continue waitForChange
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(opts.Path) {
+					break waitForChange
+				}
+			case err, ok := <-errorsCh:
+				if !ok {
+					errorsCh = nil
+					continue waitForChange
+				}
+				log.Printf("[WARNING] error while watching %s: %v", opts.Path, err)
+			}
+		}
+	}
+}
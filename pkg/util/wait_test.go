@@ -0,0 +1,129 @@
+package util_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canonical/microk8s-cluster-agent/pkg/util"
+	. "github.com/onsi/gomega"
+)
+
+func TestWaitForFileCondition(t *testing.T) {
+	fileExists := func(path string) func() (bool, error) {
+		return func() (bool, error) {
+			if _, err := os.ReadFile(path); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+
+	t.Run("WatchFile", func(t *testing.T) {
+		g := NewWithT(t)
+		file := filepath.Join(t.TempDir(), "file.yaml")
+		go func() {
+			<-time.After(100 * time.Millisecond)
+			os.WriteFile(file, []byte("data"), 0600)
+		}()
+
+		start := time.Now()
+		err := util.WaitForFileCondition(context.Background(), util.FileWaitOptions{
+			Path:         file,
+			PollInterval: time.Minute,
+			GracePeriod:  time.Minute,
+		}, fileExists(file))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(time.Since(start)).To(BeNumerically("<", 10*time.Second))
+	})
+
+	t.Run("Poll", func(t *testing.T) {
+		g := NewWithT(t)
+		var ready atomic.Bool
+		go func() {
+			<-time.After(100 * time.Millisecond)
+			ready.Store(true)
+		}()
+
+		err := util.WaitForFileCondition(context.Background(), util.FileWaitOptions{
+			Path:         filepath.Join(t.TempDir(), "missing", "file.yaml"),
+			PollInterval: 50 * time.Millisecond,
+		}, func() (bool, error) { return ready.Load(), nil })
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+
+	t.Run("GracePeriodExceeded", func(t *testing.T) {
+		g := NewWithT(t)
+		file := filepath.Join(t.TempDir(), "file.yaml")
+
+		err := util.WaitForFileCondition(context.Background(), util.FileWaitOptions{
+			Path:         file,
+			PollInterval: 10 * time.Millisecond,
+			GracePeriod:  100 * time.Millisecond,
+		}, fileExists(file))
+		g.Expect(err).To(MatchError(os.ErrNotExist))
+	})
+
+	t.Run("NoGracePeriod", func(t *testing.T) {
+		g := NewWithT(t)
+		file := filepath.Join(t.TempDir(), "file.yaml")
+
+		err := util.WaitForFileCondition(context.Background(), util.FileWaitOptions{Path: file}, fileExists(file))
+		g.Expect(err).To(MatchError(os.ErrNotExist))
+	})
+
+	t.Run("PermanentError", func(t *testing.T) {
+		g := NewWithT(t)
+		checkErr := errors.New("check failed")
+		calls := 0
+
+		err := util.WaitForFileCondition(context.Background(), util.FileWaitOptions{
+			Path:         filepath.Join(t.TempDir(), "file.yaml"),
+			PollInterval: 10 * time.Millisecond,
+			GracePeriod:  time.Minute,
+		}, func() (bool, error) {
+			calls++
+			return false, util.PermanentError(checkErr)
+		})
+		g.Expect(err).To(MatchError(checkErr))
+		g.Expect(calls).To(Equal(1))
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		g := NewWithT(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := util.WaitForFileCondition(ctx, util.FileWaitOptions{
+			Path: filepath.Join(t.TempDir(), "file.yaml"),
+		}, func() (bool, error) { return false, nil })
+		g.Expect(err).To(MatchError(context.Canceled))
+	})
+
+	t.Run("Progress", func(t *testing.T) {
+		g := NewWithT(t)
+		file := filepath.Join(t.TempDir(), "file.yaml")
+		var progressErrs []error
+		go func() {
+			<-time.After(200 * time.Millisecond)
+			os.WriteFile(file, []byte("data"), 0600)
+		}()
+
+		err := util.WaitForFileCondition(context.Background(), util.FileWaitOptions{
+			Path:             file,
+			PollInterval:     10 * time.Millisecond,
+			GracePeriod:      time.Minute,
+			ProgressInterval: 50 * time.Millisecond,
+			OnProgress: func(_ time.Duration, lastErr error) {
+				progressErrs = append(progressErrs, lastErr)
+			},
+		}, fileExists(file))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(progressErrs).ToNot(BeEmpty())
+		g.Expect(progressErrs[0]).To(MatchError(os.ErrNotExist))
+	})
+}